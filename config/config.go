@@ -19,11 +19,34 @@ type MysqlConf struct {
 	Database string `yaml:"Database"`
 }
 
+// SecretKeyConf is a master key encrypting the secrets saved in db, e.g. credentials of data sources
+type SecretKeyConf struct {
+	ID string `yaml:"ID"`
+	// base64 encoded 32 bytes
+	Key string `yaml:"Key"`
+}
+
+// AssetConf configures the storage of the uploaded assets, e.g. images of apps
+type AssetConf struct {
+	// only "local" for now, which is the default
+	Storage string `yaml:"Storage"`
+	// root dir of the local storage, ./assets by default
+	Dir string `yaml:"Dir"`
+	// in bytes, 10MB by default
+	MaxSize int64 `yaml:"MaxSize"`
+	// the total size of the assets of a user in bytes, 100MB by default
+	UserQuota int64 `yaml:"UserQuota"`
+}
+
 type AppConfig struct {
 	GithubOAuth GithubOAuthConf `yaml:"GithubOAuth"`
 	JWTSecret   string          `yaml:"JWTSecret"`
 	AppRoot     string          `yaml:"AppRoot"`
 	Mysql       MysqlConf       `yaml:"Mysql"`
+	// the first key encrypts new secrets, the others are kept to decrypt the secrets
+	// encrypted before rotation, which are re-encrypted by the first key at startup
+	SecretKeys []SecretKeyConf `yaml:"SecretKeys"`
+	Asset      AssetConf       `yaml:"Asset"`
 }
 
 func LoadConfig() (AppConfig, error) {
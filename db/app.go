@@ -1,14 +1,32 @@
 package db
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+)
 
 type App struct {
 	// ID is constraint by NOT NULL AUTO_INCREMENT
 	// marked as "omitempty", so ID will be auto-generated when insert
-	ID                   uint32          `db:"id,omitempty" json:"id"`
-	OwnerID              uint32          `db:"owner_id" json:"ownerId"`
+	ID      uint32 `db:"id,omitempty" json:"id"`
+	OwnerID uint32 `db:"owner_id" json:"ownerId"`
+	// OrgID is 0 when the app belongs to the personal workspace of owner
+	OrgID                uint32          `db:"org_id" json:"orgId"`
 	Content              json.RawMessage `db:"content"`
 	LastPublishedContent json.RawMessage `db:"last_published_content"`
+	// DraftRevision increases on every change of the draft, including its pages
+	DraftRevision uint64 `db:"draft_revision" json:"draftRevision"`
+	// PublishedDraft marks the draft which LastPublishedContent is made from,
+	// it's opaque to db and nil when never published
+	PublishedDraft json.RawMessage `db:"published_draft"`
+
+	// maintained by AppService on every write
+	CreatedAt time.Time `db:"created_at" json:"createdAt"`
+	UpdatedAt time.Time `db:"updated_at" json:"updatedAt"`
+	UpdatedBy uint32    `db:"updated_by" json:"updatedBy"`
+	// nil and 0 when never published
+	PublishedAt *time.Time `db:"published_at" json:"publishedAt"`
+	PublishedBy uint32     `db:"published_by" json:"publishedBy"`
 }
 
 func NewApp(ownerId uint32) *App {
@@ -16,5 +34,6 @@ func NewApp(ownerId uint32) *App {
 		OwnerID:              ownerId,
 		Content:              []byte("{}"),
 		LastPublishedContent: []byte("{}"),
+		UpdatedBy:            ownerId,
 	}
 }
@@ -0,0 +1,40 @@
+package db
+
+// Role grants a user some kind of access to a resource.
+// Roles are ordered, a higher role implies all lower ones: viewer < editor < admin
+type Role string
+
+const (
+	RoleNone   Role = ""
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleAdmin  Role = "admin"
+)
+
+var roleLevel = map[Role]int{
+	RoleNone:   0,
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+func (r Role) Valid() bool {
+	_, ok := roleLevel[r]
+	return ok && r != RoleNone
+}
+
+// Covers reports whether r has at least the access of other
+func (r Role) Covers(other Role) bool {
+	return roleLevel[r] >= roleLevel[other]
+}
+
+// AppACL grants the user identified by UserID the Role on an app,
+// the owner of the app is implicitly an admin and never appears here
+type AppACL struct {
+	// ID is constraint by NOT NULL AUTO_INCREMENT
+	// marked as "omitempty", so ID will be auto-generated when insert
+	ID     uint32 `db:"id,omitempty" json:"id"`
+	AppID  uint32 `db:"app_id" json:"appId"`
+	UserID uint32 `db:"user_id" json:"userId"`
+	Role   Role   `db:"role" json:"role"`
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"errors"
+
+	"upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// AppACLService encapsulate the operations on the `app_acl` table
+type AppACLService interface {
+	Find(appId, userId uint32) (AppACL, error)
+	ListByApp(appId uint32) ([]AppACL, error)
+	ListByUser(userId uint32) ([]AppACL, error)
+
+	// Grant inserts or overwrites the role of user on app
+	Grant(appId, userId uint32, role Role) error
+	Revoke(appId, userId uint32) error
+}
+
+type appACLService struct {
+	table db.Collection
+}
+
+func NewAppACLService(dbConn sqlbuilder.Database) AppACLService {
+	const kTableName = "app_acl"
+	return &appACLService{
+		table: dbConn.Collection(kTableName),
+	}
+}
+
+func (s *appACLService) Find(appId, userId uint32) (AppACL, error) {
+	res := s.table.Find("app_id", appId).And("user_id", userId)
+	var acl AppACL
+	err := res.One(&acl)
+	if errors.Is(err, db.ErrNoMoreRows) {
+		return acl, ErrNotFound
+	}
+	return acl, err
+}
+
+func (s *appACLService) ListByApp(appId uint32) ([]AppACL, error) {
+	var acls []AppACL
+	err := s.table.Find("app_id", appId).OrderBy("id").All(&acls)
+	return acls, err
+}
+
+func (s *appACLService) ListByUser(userId uint32) ([]AppACL, error) {
+	var acls []AppACL
+	err := s.table.Find("user_id", userId).OrderBy("id").All(&acls)
+	return acls, err
+}
+
+func (s *appACLService) Grant(appId, userId uint32, role Role) error {
+	acl, err := s.Find(appId, userId)
+	if errors.Is(err, ErrNotFound) {
+		acl = AppACL{AppID: appId, UserID: userId, Role: role}
+		return s.table.InsertReturning(&acl)
+	}
+	if err != nil {
+		return err
+	}
+	return s.table.Find("id", acl.ID).Update(map[string]interface{}{
+		"role": role,
+	})
+}
+
+func (s *appACLService) Revoke(appId, userId uint32) error {
+	return s.table.Find("app_id", appId).And("user_id", userId).Delete()
+}
+
+type memAppACLService struct {
+	id    uint32
+	table map[uint32]*AppACL
+}
+
+// Used under unit-test enviroment
+func NewMemAppACLService() AppACLService {
+	return &memAppACLService{
+		table: make(map[uint32]*AppACL),
+	}
+}
+
+func (s *memAppACLService) find(appId, userId uint32) *AppACL {
+	for _, acl := range s.table {
+		if acl.AppID == appId && acl.UserID == userId {
+			return acl
+		}
+	}
+	return nil
+}
+
+func (s *memAppACLService) Find(appId, userId uint32) (AppACL, error) {
+	acl := s.find(appId, userId)
+	if acl == nil {
+		return AppACL{}, ErrNotFound
+	} else {
+		return *acl, nil
+	}
+}
+
+func (s *memAppACLService) list(match func(acl *AppACL) bool) []AppACL {
+	var acls []AppACL
+	for id := uint32(0); id < s.id; id++ {
+		if acl, ok := s.table[id]; ok && match(acl) {
+			acls = append(acls, *acl)
+		}
+	}
+	return acls
+}
+
+func (s *memAppACLService) ListByApp(appId uint32) ([]AppACL, error) {
+	return s.list(func(acl *AppACL) bool { return acl.AppID == appId }), nil
+}
+
+func (s *memAppACLService) ListByUser(userId uint32) ([]AppACL, error) {
+	return s.list(func(acl *AppACL) bool { return acl.UserID == userId }), nil
+}
+
+func (s *memAppACLService) Grant(appId, userId uint32, role Role) error {
+	if acl := s.find(appId, userId); acl != nil {
+		acl.Role = role
+		return nil
+	}
+	acl := &AppACL{ID: s.id, AppID: appId, UserID: userId, Role: role}
+	s.id++
+	s.table[acl.ID] = acl
+	return nil
+}
+
+func (s *memAppACLService) Revoke(appId, userId uint32) error {
+	if acl := s.find(appId, userId); acl != nil {
+		delete(s.table, acl.ID)
+	}
+	return nil
+}
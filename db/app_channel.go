@@ -0,0 +1,21 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AppChannel is a publish target of an app other than production,
+// the production channel lives in App.LastPublishedContent
+type AppChannel struct {
+	// ID is constraint by NOT NULL AUTO_INCREMENT
+	// marked as "omitempty", so ID will be auto-generated when insert
+	ID          uint32          `db:"id,omitempty" json:"id"`
+	AppID       uint32          `db:"app_id" json:"appId"`
+	Channel     string          `db:"channel" json:"channel"`
+	Content     json.RawMessage `db:"content"`
+	PublishedAt time.Time       `db:"published_at" json:"publishedAt"`
+	PublishedBy uint32          `db:"published_by" json:"publishedBy"`
+	// Draft marks the draft which Content is made from, the same as App.PublishedDraft
+	Draft json.RawMessage `db:"draft"`
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// AppChannelService encapsulate the operations on the `app_channel` table
+type AppChannelService interface {
+	Find(appId uint32, channel string) (AppChannel, error)
+	ListByApp(appId uint32) ([]AppChannel, error)
+	// Publish inserts or overwrites the content of channel, draft marks the draft it's made from
+	Publish(appId uint32, channel string, operator uint32, v, draft json.RawMessage) error
+}
+
+type appChannelService struct {
+	table db.Collection
+}
+
+func NewAppChannelService(dbConn sqlbuilder.Database) AppChannelService {
+	const kTableName = "app_channel"
+	return &appChannelService{
+		table: dbConn.Collection(kTableName),
+	}
+}
+
+func (s *appChannelService) Find(appId uint32, channel string) (AppChannel, error) {
+	res := s.table.Find("app_id", appId).And("channel", channel)
+	var ch AppChannel
+	err := res.One(&ch)
+	if errors.Is(err, db.ErrNoMoreRows) {
+		return ch, ErrNotFound
+	}
+	return ch, err
+}
+
+func (s *appChannelService) ListByApp(appId uint32) ([]AppChannel, error) {
+	var chs []AppChannel
+	err := s.table.Find("app_id", appId).OrderBy("channel").All(&chs)
+	return chs, err
+}
+
+func (s *appChannelService) Publish(appId uint32, channel string, operator uint32, v, draft json.RawMessage) error {
+	ch, err := s.Find(appId, channel)
+	if errors.Is(err, ErrNotFound) {
+		ch = AppChannel{
+			AppID:       appId,
+			Channel:     channel,
+			Content:     v,
+			Draft:       draft,
+			PublishedAt: time.Now(),
+			PublishedBy: operator,
+		}
+		return s.table.InsertReturning(&ch)
+	}
+	if err != nil {
+		return err
+	}
+	return s.table.Find("id", ch.ID).Update(map[string]interface{}{
+		"content":      v,
+		"draft":        draft,
+		"published_at": time.Now(),
+		"published_by": operator,
+	})
+}
+
+type memAppChannelService struct {
+	id    uint32
+	table map[uint32]*AppChannel
+}
+
+// Used under unit-test enviroment
+func NewMemAppChannelService() AppChannelService {
+	return &memAppChannelService{
+		table: make(map[uint32]*AppChannel),
+	}
+}
+
+func (s *memAppChannelService) find(appId uint32, channel string) *AppChannel {
+	for _, ch := range s.table {
+		if ch.AppID == appId && ch.Channel == channel {
+			return ch
+		}
+	}
+	return nil
+}
+
+func (s *memAppChannelService) Find(appId uint32, channel string) (AppChannel, error) {
+	ch := s.find(appId, channel)
+	if ch == nil {
+		return AppChannel{}, ErrNotFound
+	} else {
+		return *ch, nil
+	}
+}
+
+func (s *memAppChannelService) ListByApp(appId uint32) ([]AppChannel, error) {
+	var chs []AppChannel
+	for _, ch := range s.table {
+		if ch.AppID == appId {
+			chs = append(chs, *ch)
+		}
+	}
+	sort.Slice(chs, func(i, j int) bool { return chs[i].Channel < chs[j].Channel })
+	return chs, nil
+}
+
+func (s *memAppChannelService) Publish(appId uint32, channel string, operator uint32, v, draft json.RawMessage) error {
+	ch := s.find(appId, channel)
+	if ch == nil {
+		ch = &AppChannel{ID: s.id, AppID: appId, Channel: channel}
+		s.id++
+		s.table[ch.ID] = ch
+	}
+	ch.Content = v
+	ch.Draft = draft
+	ch.PublishedAt = time.Now()
+	ch.PublishedBy = operator
+	return nil
+}
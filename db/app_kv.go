@@ -0,0 +1,22 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AppKV is a key/value pair saved by an app for its viewers,
+// in the app-global scope when UserID is 0, otherwise in the scope of the viewer
+type AppKV struct {
+	// ID is constraint by NOT NULL AUTO_INCREMENT
+	// marked as "omitempty", so ID will be auto-generated when insert
+	ID     uint32 `db:"id,omitempty" json:"id"`
+	AppID  uint32 `db:"app_id" json:"appId"`
+	UserID uint32 `db:"user_id" json:"userId"`
+	// unique in the scope, i.e. (app_id, user_id, kv_key)
+	Key   string          `db:"kv_key" json:"key"`
+	Value json.RawMessage `db:"value"`
+	// starts from 1 and is increased on every write, for compare-and-set
+	Version   uint64    `db:"version" json:"version"`
+	UpdatedAt time.Time `db:"updated_at" json:"updatedAt"`
+}
@@ -0,0 +1,281 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+var (
+	// the key was written by others since it was read
+	ErrVersionConflict = errors.New("version conflict")
+	ErrNotNumber       = errors.New("value is not a number")
+)
+
+// AppKVService encapsulate the operations on the `app_kv` table
+type AppKVService interface {
+	Get(appId, userId uint32, key string) (AppKV, error)
+	// Set saves value no matter what the current one is, and returns the new version
+	Set(appId, userId uint32, key string, value json.RawMessage) (uint64, error)
+	// CompareAndSet saves value only if the current version is version, 0 for absent keys,
+	// and returns the new version, ErrVersionConflict otherwise
+	CompareAndSet(appId, userId uint32, key string, version uint64, value json.RawMessage) (uint64, error)
+	// Increment adds delta to the number atomically, absent keys are taken as 0
+	Increment(appId, userId uint32, key string, delta float64) (AppKV, error)
+	Delete(appId, userId uint32, key string) error
+	// Count returns the number of keys in the scope
+	Count(appId, userId uint32) (uint64, error)
+}
+
+// retries of Set and Increment of appKVService, which are compare-and-set loops
+const kKVMaxRetries = 10
+
+func currentVersion(s AppKVService, appId, userId uint32, key string) (AppKV, uint64, error) {
+	kv, err := s.Get(appId, userId, key)
+	if errors.Is(err, ErrNotFound) {
+		return kv, 0, nil
+	}
+	return kv, kv.Version, err
+}
+
+func setKV(s AppKVService, appId, userId uint32, key string, value json.RawMessage) (uint64, error) {
+	for i := 0; i < kKVMaxRetries; i++ {
+		_, version, err := currentVersion(s, appId, userId, key)
+		if err != nil {
+			return 0, err
+		}
+		version, err = s.CompareAndSet(appId, userId, key, version, value)
+		if !errors.Is(err, ErrVersionConflict) {
+			return version, err
+		}
+	}
+	return 0, ErrVersionConflict
+}
+
+// incrementedValue adds delta to the value of kv, absent when version is 0
+func incrementedValue(kv AppKV, version uint64, delta float64) (json.RawMessage, error) {
+	var n float64
+	if version > 0 {
+		if err := json.Unmarshal(kv.Value, &n); err != nil {
+			return nil, ErrNotNumber
+		}
+	}
+	value, err := json.Marshal(n + delta)
+	if err != nil {
+		// overflowed to Inf
+		return nil, ErrNotNumber
+	}
+	return value, nil
+}
+
+func incrementKV(s AppKVService, appId, userId uint32, key string, delta float64) (AppKV, error) {
+	for i := 0; i < kKVMaxRetries; i++ {
+		kv, version, err := currentVersion(s, appId, userId, key)
+		if err != nil {
+			return kv, err
+		}
+		value, err := incrementedValue(kv, version, delta)
+		if err != nil {
+			return kv, err
+		}
+		version, err = s.CompareAndSet(appId, userId, key, version, value)
+		if err == nil {
+			return AppKV{
+				AppID:     appId,
+				UserID:    userId,
+				Key:       key,
+				Value:     value,
+				Version:   version,
+				UpdatedAt: time.Now(),
+			}, nil
+		}
+		if !errors.Is(err, ErrVersionConflict) {
+			return kv, err
+		}
+	}
+	return AppKV{}, ErrVersionConflict
+}
+
+type appKVService struct {
+	sess  sqlbuilder.Database
+	table db.Collection
+}
+
+const kAppKVTableName = "app_kv"
+
+func NewAppKVService(dbConn sqlbuilder.Database) AppKVService {
+	return &appKVService{
+		sess:  dbConn,
+		table: dbConn.Collection(kAppKVTableName),
+	}
+}
+
+func kvCond(appId, userId uint32, key string) db.Cond {
+	return db.Cond{"app_id": appId, "user_id": userId, "kv_key": key}
+}
+
+func (s *appKVService) Get(appId, userId uint32, key string) (AppKV, error) {
+	var kv AppKV
+	err := s.table.Find(kvCond(appId, userId, key)).One(&kv)
+	if errors.Is(err, db.ErrNoMoreRows) {
+		return kv, ErrNotFound
+	}
+	return kv, err
+}
+
+func (s *appKVService) Set(appId, userId uint32, key string, value json.RawMessage) (uint64, error) {
+	return setKV(s, appId, userId, key, value)
+}
+
+func (s *appKVService) CompareAndSet(appId, userId uint32, key string, version uint64,
+	value json.RawMessage) (uint64, error) {
+	now := time.Now()
+	if version == 0 {
+		kv := AppKV{AppID: appId, UserID: userId, Key: key, Value: value, Version: 1, UpdatedAt: now}
+		if err := s.table.InsertReturning(&kv); err != nil {
+			// violates the unique key, i.e. inserted by others
+			if _, getErr := s.Get(appId, userId, key); getErr == nil {
+				return 0, ErrVersionConflict
+			}
+			return 0, err
+		}
+		return kv.Version, nil
+	}
+	cond := kvCond(appId, userId, key)
+	cond["version"] = version
+	res, err := s.sess.Update(kAppKVTableName).Set(map[string]interface{}{
+		"value":      value,
+		"version":    version + 1,
+		"updated_at": now,
+	}).Where(cond).Exec()
+	if err != nil {
+		return 0, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if affected != 1 {
+		return 0, ErrVersionConflict
+	}
+	return version + 1, nil
+}
+
+func (s *appKVService) Increment(appId, userId uint32, key string, delta float64) (AppKV, error) {
+	return incrementKV(s, appId, userId, key, delta)
+}
+
+func (s *appKVService) Delete(appId, userId uint32, key string) error {
+	return s.table.Find(kvCond(appId, userId, key)).Delete()
+}
+
+func (s *appKVService) Count(appId, userId uint32) (uint64, error) {
+	return s.table.Find("app_id", appId).And("user_id", userId).Count()
+}
+
+type kvScopeKey struct {
+	appId  uint32
+	userId uint32
+	key    string
+}
+
+// viewers write concurrently, so memAppKVService is guarded by mutex
+type memAppKVService struct {
+	mu    sync.Mutex
+	id    uint32
+	table map[kvScopeKey]*AppKV
+}
+
+// Used under unit-test enviroment
+func NewMemAppKVService() AppKVService {
+	return &memAppKVService{
+		table: make(map[kvScopeKey]*AppKV),
+	}
+}
+
+func (s *memAppKVService) Get(appId, userId uint32, key string) (AppKV, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kv, ok := s.table[kvScopeKey{appId, userId, key}]
+	if !ok {
+		return AppKV{}, ErrNotFound
+	}
+	return *kv, nil
+}
+
+func (s *memAppKVService) Set(appId, userId uint32, key string, value json.RawMessage) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kv := s.table[kvScopeKey{appId, userId, key}]
+	var version uint64
+	if kv != nil {
+		version = kv.Version
+	}
+	return s.compareAndSet(appId, userId, key, version, value)
+}
+
+func (s *memAppKVService) CompareAndSet(appId, userId uint32, key string, version uint64,
+	value json.RawMessage) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compareAndSet(appId, userId, key, version, value)
+}
+
+// compareAndSet is called with mu held
+func (s *memAppKVService) compareAndSet(appId, userId uint32, key string, version uint64,
+	value json.RawMessage) (uint64, error) {
+	k := kvScopeKey{appId, userId, key}
+	kv, ok := s.table[k]
+	switch {
+	case !ok && version == 0:
+		kv = &AppKV{ID: s.id, AppID: appId, UserID: userId, Key: key}
+		s.id++
+		s.table[k] = kv
+	case !ok || kv.Version != version:
+		return 0, ErrVersionConflict
+	}
+	kv.Value = value
+	kv.Version = version + 1
+	kv.UpdatedAt = time.Now()
+	return kv.Version, nil
+}
+
+func (s *memAppKVService) Increment(appId, userId uint32, key string, delta float64) (AppKV, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var kv AppKV
+	if saved, ok := s.table[kvScopeKey{appId, userId, key}]; ok {
+		kv = *saved
+	}
+	value, err := incrementedValue(kv, kv.Version, delta)
+	if err != nil {
+		return kv, err
+	}
+	if _, err := s.compareAndSet(appId, userId, key, kv.Version, value); err != nil {
+		return kv, err
+	}
+	return *s.table[kvScopeKey{appId, userId, key}], nil
+}
+
+func (s *memAppKVService) Delete(appId, userId uint32, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.table, kvScopeKey{appId, userId, key})
+	return nil
+}
+
+func (s *memAppKVService) Count(appId, userId uint32) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n uint64
+	for k := range s.table {
+		if k.appId == appId && k.userId == userId {
+			n++
+		}
+	}
+	return n, nil
+}
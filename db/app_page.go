@@ -0,0 +1,22 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AppPage is a page of an app, saved apart from App.Content so that editors load and save
+// one page at a time. App.Content keeps what is shared by all the pages, e.g. queries and forms.
+type AppPage struct {
+	// ID is constraint by NOT NULL AUTO_INCREMENT
+	// marked as "omitempty", so ID will be auto-generated when insert
+	ID    uint32 `db:"id,omitempty" json:"id"`
+	AppID uint32 `db:"app_id" json:"appId"`
+	// Name is unique in the app
+	Name string `db:"name" json:"name"`
+	// pages are ordered by Position, starting from 0
+	Position  uint32          `db:"position" json:"position"`
+	Content   json.RawMessage `db:"content"`
+	UpdatedAt time.Time       `db:"updated_at" json:"updatedAt"`
+	UpdatedBy uint32          `db:"updated_by" json:"updatedBy"`
+}
@@ -0,0 +1,186 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// AppPageService encapsulate the operations on the `app_page` table
+type AppPageService interface {
+	NewPage(page *AppPage) error
+	Find(appId, pageId uint32) (AppPage, error)
+	// ListByApp lists pages of the app in order, in a single query,
+	// so that the contents are consistent with each other
+	ListByApp(appId uint32) ([]AppPage, error)
+	Update(appId, pageId uint32, toUpdate map[string]interface{}) error
+	// Reorder sets the positions of pages as the order of pageIds in a transaction
+	Reorder(appId uint32, pageIds []uint32) error
+	Delete(appId, pageId uint32) error
+}
+
+type appPageService struct {
+	sess  sqlbuilder.Database
+	table db.Collection
+}
+
+const kAppPageTableName = "app_page"
+
+func NewAppPageService(dbConn sqlbuilder.Database) AppPageService {
+	return &appPageService{
+		sess:  dbConn,
+		table: dbConn.Collection(kAppPageTableName),
+	}
+}
+
+func (s *appPageService) NewPage(page *AppPage) error {
+	page.UpdatedAt = time.Now()
+	return s.table.InsertReturning(page)
+}
+
+func (s *appPageService) Find(appId, pageId uint32) (AppPage, error) {
+	var page AppPage
+	err := s.table.Find("app_id", appId).And("id", pageId).One(&page)
+	if errors.Is(err, db.ErrNoMoreRows) {
+		return page, ErrNotFound
+	}
+	return page, err
+}
+
+func (s *appPageService) ListByApp(appId uint32) ([]AppPage, error) {
+	var pages []AppPage
+	err := s.table.Find("app_id", appId).OrderBy("position", "id").All(&pages)
+	return pages, err
+}
+
+func (s *appPageService) Update(appId, pageId uint32, toUpdate map[string]interface{}) error {
+	return s.table.Find("app_id", appId).And("id", pageId).Update(toUpdate)
+}
+
+func (s *appPageService) Reorder(appId uint32, pageIds []uint32) error {
+	return s.sess.Tx(context.Background(), func(tx sqlbuilder.Tx) error {
+		for i, pageId := range pageIds {
+			_, err := tx.Update(kAppPageTableName).Set("position", i).
+				Where(db.Cond{"app_id": appId, "id": pageId}).Exec()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *appPageService) Delete(appId, pageId uint32) error {
+	return s.table.Find("app_id", appId).And("id", pageId).Delete()
+}
+
+// pages are edited concurrently, so memAppPageService is guarded by mutex
+type memAppPageService struct {
+	mu    sync.Mutex
+	id    uint32
+	table map[uint32]*AppPage
+}
+
+// Used under unit-test enviroment
+func NewMemAppPageService() AppPageService {
+	return &memAppPageService{
+		table: make(map[uint32]*AppPage),
+	}
+}
+
+func (s *memAppPageService) NewPage(page *AppPage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	page.UpdatedAt = time.Now()
+	page.ID = s.id
+	s.id++
+	saved := *page
+	s.table[page.ID] = &saved
+	return nil
+}
+
+func (s *memAppPageService) find(appId, pageId uint32) *AppPage {
+	page, ok := s.table[pageId]
+	if !ok || page.AppID != appId {
+		return nil
+	}
+	return page
+}
+
+func (s *memAppPageService) Find(appId, pageId uint32) (AppPage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	page := s.find(appId, pageId)
+	if page == nil {
+		return AppPage{}, ErrNotFound
+	}
+	return *page, nil
+}
+
+func (s *memAppPageService) ListByApp(appId uint32) ([]AppPage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pages []AppPage
+	for _, page := range s.table {
+		if page.AppID == appId {
+			pages = append(pages, *page)
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		if pages[i].Position != pages[j].Position {
+			return pages[i].Position < pages[j].Position
+		}
+		return pages[i].ID < pages[j].ID
+	})
+	return pages, nil
+}
+
+func (s *memAppPageService) Update(appId, pageId uint32, toUpdate map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	page := s.find(appId, pageId)
+	if page == nil {
+		return nil
+	}
+	for k, v := range toUpdate {
+		switch k {
+		case "name":
+			page.Name = v.(string)
+		case "content":
+			page.Content = v.(json.RawMessage)
+		case "updated_at":
+			page.UpdatedAt = v.(time.Time)
+		case "updated_by":
+			page.UpdatedBy = v.(uint32)
+		default:
+			panic("Not Implemented")
+		}
+	}
+	return nil
+}
+
+func (s *memAppPageService) Reorder(appId uint32, pageIds []uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, pageId := range pageIds {
+		if page := s.find(appId, pageId); page != nil {
+			page.Position = uint32(i)
+		}
+	}
+	return nil
+}
+
+func (s *memAppPageService) Delete(appId, pageId uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.find(appId, pageId) != nil {
+		delete(s.table, pageId)
+	}
+	return nil
+}
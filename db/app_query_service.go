@@ -0,0 +1,119 @@
+package db
+
+import (
+	"errors"
+
+	"upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// AppQueryService encapsulate the operations on the `app_query` table
+type AppQueryService interface {
+	Find(appId uint32, name string) (AppQuery, error)
+	ListByApp(appId uint32) ([]AppQuery, error)
+	// Save inserts or overwrites the query of app with the same name
+	Save(q *AppQuery) error
+	Delete(appId uint32, name string) error
+}
+
+type appQueryService struct {
+	table db.Collection
+}
+
+func NewAppQueryService(dbConn sqlbuilder.Database) AppQueryService {
+	const kTableName = "app_query"
+	return &appQueryService{
+		table: dbConn.Collection(kTableName),
+	}
+}
+
+func (s *appQueryService) Find(appId uint32, name string) (AppQuery, error) {
+	res := s.table.Find("app_id", appId).And("name", name)
+	var q AppQuery
+	err := res.One(&q)
+	if errors.Is(err, db.ErrNoMoreRows) {
+		return q, ErrNotFound
+	}
+	return q, err
+}
+
+func (s *appQueryService) ListByApp(appId uint32) ([]AppQuery, error) {
+	var qs []AppQuery
+	err := s.table.Find("app_id", appId).OrderBy("id").All(&qs)
+	return qs, err
+}
+
+func (s *appQueryService) Save(q *AppQuery) error {
+	old, err := s.Find(q.AppID, q.Name)
+	if errors.Is(err, ErrNotFound) {
+		return s.table.InsertReturning(q)
+	}
+	if err != nil {
+		return err
+	}
+	q.ID = old.ID
+	return s.table.Find("id", q.ID).Update(q)
+}
+
+func (s *appQueryService) Delete(appId uint32, name string) error {
+	return s.table.Find("app_id", appId).And("name", name).Delete()
+}
+
+type memAppQueryService struct {
+	id    uint32
+	table map[uint32]*AppQuery
+}
+
+// Used under unit-test enviroment
+func NewMemAppQueryService() AppQueryService {
+	return &memAppQueryService{
+		table: make(map[uint32]*AppQuery),
+	}
+}
+
+func (s *memAppQueryService) find(appId uint32, name string) *AppQuery {
+	for _, q := range s.table {
+		if q.AppID == appId && q.Name == name {
+			return q
+		}
+	}
+	return nil
+}
+
+func (s *memAppQueryService) Find(appId uint32, name string) (AppQuery, error) {
+	q := s.find(appId, name)
+	if q == nil {
+		return AppQuery{}, ErrNotFound
+	} else {
+		return *q, nil
+	}
+}
+
+func (s *memAppQueryService) ListByApp(appId uint32) ([]AppQuery, error) {
+	var qs []AppQuery
+	for id := uint32(0); id < s.id; id++ {
+		if q, ok := s.table[id]; ok && q.AppID == appId {
+			qs = append(qs, *q)
+		}
+	}
+	return qs, nil
+}
+
+func (s *memAppQueryService) Save(q *AppQuery) error {
+	if old := s.find(q.AppID, q.Name); old != nil {
+		q.ID = old.ID
+	} else {
+		q.ID = s.id
+		s.id++
+	}
+	saved := *q
+	s.table[q.ID] = &saved
+	return nil
+}
+
+func (s *memAppQueryService) Delete(appId uint32, name string) error {
+	if q := s.find(appId, name); q != nil {
+		delete(s.table, q.ID)
+	}
+	return nil
+}
@@ -3,6 +3,8 @@ package db
 import (
 	"encoding/json"
 	"errors"
+	"sync"
+	"time"
 
 	"upper.io/db.v3"
 	"upper.io/db.v3/lib/sqlbuilder"
@@ -12,9 +14,16 @@ import (
 type AppService interface {
 	NewApp(app *App) error
 	Find(ownerId, appId uint32) (App, error)
+	// Get finds app by id regardless of its owner,
+	// the caller is responsible for the authorization
+	Get(appId uint32) (App, error)
+	GetMulti(appIds []uint32) ([]App, error)
 
-	UpdateContent(ownerId, appId uint32, v json.RawMessage) error
-	UpdateLastPublishedContent(ownerId, appId uint32, v json.RawMessage) error
+	// operator is the id of user who makes the change
+	UpdateContent(appId, operator uint32, v json.RawMessage) error
+	// TouchDraft records a change of the draft saved elsewhere, e.g. its pages
+	TouchDraft(appId, operator uint32) error
+	UpdateLastPublishedContent(appId, operator uint32, v, draft json.RawMessage) error
 }
 
 type appService struct {
@@ -29,6 +38,8 @@ func NewAppService(dbConn sqlbuilder.Database) AppService {
 }
 
 func (s *appService) NewApp(app *App) error {
+	app.CreatedAt = time.Now()
+	app.UpdatedAt = app.CreatedAt
 	return s.table.InsertReturning(app)
 }
 
@@ -42,22 +53,56 @@ func (s *appService) Find(ownerId, appId uint32) (App, error) {
 	return app, err
 }
 
-func (s *appService) Update(ownerId, appId uint32, toUpdate map[string]interface{}) error {
-	res := s.table.Find("owner_id", ownerId).And("id", appId)
+func (s *appService) Get(appId uint32) (App, error) {
+	res := s.table.Find("id", appId)
+	var app App
+	err := res.One(&app)
+	if errors.Is(err, db.ErrNoMoreRows) {
+		return app, ErrNotFound
+	}
+	return app, err
+}
+
+func (s *appService) GetMulti(appIds []uint32) ([]App, error) {
+	var apps []App
+	if len(appIds) == 0 {
+		return apps, nil
+	}
+	err := s.table.Find(db.Cond{"id IN": appIds}).OrderBy("id").All(&apps)
+	return apps, err
+}
+
+func (s *appService) Update(appId uint32, toUpdate map[string]interface{}) error {
+	res := s.table.Find("id", appId)
 	return res.Update(toUpdate)
 }
-func (s *appService) UpdateContent(ownerId, appId uint32, v json.RawMessage) error {
-	return s.Update(ownerId, appId, map[string]interface{}{
-		"content": v,
+func (s *appService) UpdateContent(appId, operator uint32, v json.RawMessage) error {
+	return s.Update(appId, map[string]interface{}{
+		"content":        v,
+		"draft_revision": db.Raw("draft_revision + 1"),
+		"updated_at":     time.Now(),
+		"updated_by":     operator,
 	})
 }
-func (s *appService) UpdateLastPublishedContent(ownerId, appId uint32, v json.RawMessage) error {
-	return s.Update(ownerId, appId, map[string]interface{}{
+func (s *appService) TouchDraft(appId, operator uint32) error {
+	return s.Update(appId, map[string]interface{}{
+		"draft_revision": db.Raw("draft_revision + 1"),
+		"updated_at":     time.Now(),
+		"updated_by":     operator,
+	})
+}
+func (s *appService) UpdateLastPublishedContent(appId, operator uint32, v, draft json.RawMessage) error {
+	return s.Update(appId, map[string]interface{}{
 		"last_published_content": v,
+		"published_draft":        draft,
+		"published_at":           time.Now(),
+		"published_by":           operator,
 	})
 }
 
+// apps are edited concurrently over WebSocket, so memAppService is guarded by mutex
 type memAppService struct {
+	mu    sync.Mutex
 	id    uint32
 	table map[uint32]*App
 }
@@ -70,6 +115,10 @@ func NewMemAppService() AppService {
 }
 
 func (s *memAppService) NewApp(app *App) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	app.CreatedAt = time.Now()
+	app.UpdatedAt = app.CreatedAt
 	app.ID = s.id
 	s.id++
 	s.table[app.ID] = app
@@ -86,6 +135,8 @@ func (s *memAppService) find(ownerId, appId uint32) *App {
 }
 
 func (s *memAppService) Find(ownerId, appId uint32) (App, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	app := s.find(ownerId, appId)
 	if app == nil {
 		return App{}, ErrNotFound
@@ -94,24 +145,94 @@ func (s *memAppService) Find(ownerId, appId uint32) (App, error) {
 	}
 }
 
-func (s *memAppService) Update(ownerId, appId uint32, k string, v interface{}) error {
-	app := s.find(ownerId, appId)
-	if app == nil {
+func (s *memAppService) Get(appId uint32) (App, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	app, ok := s.table[appId]
+	if !ok {
+		return App{}, ErrNotFound
+	} else {
+		return *app, nil
+	}
+}
+
+func (s *memAppService) GetMulti(appIds []uint32) ([]App, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var apps []App
+	for id := uint32(0); id < s.id; id++ {
+		app, ok := s.table[id]
+		if !ok {
+			continue
+		}
+		for _, appId := range appIds {
+			if appId == id {
+				apps = append(apps, *app)
+				break
+			}
+		}
+	}
+	return apps, nil
+}
+
+func (s *memAppService) Update(appId uint32, toUpdate map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	app, ok := s.table[appId]
+	if !ok {
 		return ErrNotFound
 	}
-	switch k {
-	case "content":
-		app.Content = v.(json.RawMessage)
-	case "last_published_content":
-		app.LastPublishedContent = v.(json.RawMessage)
-	default:
-		panic("Not Implemented")
+	for k, v := range toUpdate {
+		switch k {
+		case "content":
+			app.Content = v.(json.RawMessage)
+		case "last_published_content":
+			app.LastPublishedContent = v.(json.RawMessage)
+		case "published_draft":
+			app.PublishedDraft = v.(json.RawMessage)
+		case "updated_at":
+			app.UpdatedAt = v.(time.Time)
+		case "updated_by":
+			app.UpdatedBy = v.(uint32)
+		case "published_at":
+			publishedAt := v.(time.Time)
+			app.PublishedAt = &publishedAt
+		case "published_by":
+			app.PublishedBy = v.(uint32)
+		default:
+			panic("Not Implemented")
+		}
 	}
 	return nil
 }
-func (s *memAppService) UpdateContent(ownerId, appId uint32, v json.RawMessage) error {
-	return s.Update(ownerId, appId, "content", v)
+func (s *memAppService) UpdateContent(appId, operator uint32, v json.RawMessage) error {
+	return s.updateDraft(appId, operator, v)
 }
-func (s *memAppService) UpdateLastPublishedContent(ownerId, appId uint32, v json.RawMessage) error {
-	return s.Update(ownerId, appId, "last_published_content", v)
+func (s *memAppService) TouchDraft(appId, operator uint32) error {
+	return s.updateDraft(appId, operator, nil)
+}
+
+// updateDraft increases the draft revision, and replaces the content unless v is nil
+func (s *memAppService) updateDraft(appId, operator uint32, v json.RawMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	app, ok := s.table[appId]
+	if !ok {
+		return ErrNotFound
+	}
+	if v != nil {
+		app.Content = v
+	}
+	app.DraftRevision++
+	app.UpdatedAt = time.Now()
+	app.UpdatedBy = operator
+	return nil
+}
+func (s *memAppService) UpdateLastPublishedContent(appId, operator uint32, v, draft json.RawMessage) error {
+	return s.Update(appId, map[string]interface{}{
+		"last_published_content": v,
+		"published_draft":        draft,
+		"published_at":           time.Now(),
+		"published_by":           operator,
+	})
 }
@@ -0,0 +1,16 @@
+package db
+
+import "encoding/json"
+
+// AppTemplate is saved by user from an existing app, new apps could be seeded from it
+type AppTemplate struct {
+	// ID is constraint by NOT NULL AUTO_INCREMENT
+	// marked as "omitempty", so ID will be auto-generated when insert
+	ID      uint32 `db:"id,omitempty" json:"id"`
+	OwnerID uint32 `db:"owner_id" json:"ownerId"`
+	// OrgID is 0 when the template belongs to the personal workspace of owner
+	OrgID       uint32          `db:"org_id" json:"orgId"`
+	Name        string          `db:"name" json:"name"`
+	Description string          `db:"description" json:"description"`
+	Content     json.RawMessage `db:"content"`
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"errors"
+
+	"upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// AppTemplateService encapsulate the operations on the `app_template` table
+type AppTemplateService interface {
+	NewTemplate(tpl *AppTemplate) error
+	Find(id uint32) (AppTemplate, error)
+	// ListByWorkspace lists templates of the org, or the personal ones of owner when orgId is 0
+	ListByWorkspace(ownerId, orgId uint32) ([]AppTemplate, error)
+	Delete(id uint32) error
+}
+
+type appTemplateService struct {
+	table db.Collection
+}
+
+func NewAppTemplateService(dbConn sqlbuilder.Database) AppTemplateService {
+	const kTableName = "app_template"
+	return &appTemplateService{
+		table: dbConn.Collection(kTableName),
+	}
+}
+
+func (s *appTemplateService) NewTemplate(tpl *AppTemplate) error {
+	return s.table.InsertReturning(tpl)
+}
+
+func (s *appTemplateService) Find(id uint32) (AppTemplate, error) {
+	res := s.table.Find("id", id)
+	var tpl AppTemplate
+	err := res.One(&tpl)
+	if errors.Is(err, db.ErrNoMoreRows) {
+		return tpl, ErrNotFound
+	}
+	return tpl, err
+}
+
+func (s *appTemplateService) ListByWorkspace(ownerId, orgId uint32) ([]AppTemplate, error) {
+	res := s.table.Find("org_id", orgId)
+	if orgId == 0 {
+		res = res.And("owner_id", ownerId)
+	}
+	var tpls []AppTemplate
+	err := res.OrderBy("id").All(&tpls)
+	return tpls, err
+}
+
+func (s *appTemplateService) Delete(id uint32) error {
+	return s.table.Find("id", id).Delete()
+}
+
+type memAppTemplateService struct {
+	id    uint32
+	table map[uint32]*AppTemplate
+}
+
+// Used under unit-test enviroment
+func NewMemAppTemplateService() AppTemplateService {
+	return &memAppTemplateService{
+		table: make(map[uint32]*AppTemplate),
+	}
+}
+
+func (s *memAppTemplateService) NewTemplate(tpl *AppTemplate) error {
+	tpl.ID = s.id
+	s.id++
+	s.table[tpl.ID] = tpl
+	return nil
+}
+
+func (s *memAppTemplateService) Find(id uint32) (AppTemplate, error) {
+	tpl, ok := s.table[id]
+	if !ok {
+		return AppTemplate{}, ErrNotFound
+	} else {
+		return *tpl, nil
+	}
+}
+
+func (s *memAppTemplateService) ListByWorkspace(ownerId, orgId uint32) ([]AppTemplate, error) {
+	var tpls []AppTemplate
+	for id := uint32(0); id < s.id; id++ {
+		tpl, ok := s.table[id]
+		if !ok || tpl.OrgID != orgId || (orgId == 0 && tpl.OwnerID != ownerId) {
+			continue
+		}
+		tpls = append(tpls, *tpl)
+	}
+	return tpls, nil
+}
+
+func (s *memAppTemplateService) Delete(id uint32) error {
+	delete(s.table, id)
+	return nil
+}
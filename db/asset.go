@@ -0,0 +1,20 @@
+package db
+
+import "time"
+
+// Asset is a file uploaded by a user, e.g. a logo of an app.
+// Assets of the same content share one object in the storage, keyed by Hash.
+type Asset struct {
+	// ID is constraint by NOT NULL AUTO_INCREMENT
+	// marked as "omitempty", so ID will be auto-generated when insert
+	ID      uint32 `db:"id,omitempty" json:"id"`
+	OwnerID uint32 `db:"owner_id" json:"ownerId"`
+	// hex encoded SHA-256 of the content, unique per owner
+	Hash string `db:"hash" json:"hash"`
+	// the original file name
+	Name string `db:"name" json:"name"`
+	// sniffed from the content
+	ContentType string    `db:"content_type" json:"contentType"`
+	Size        uint64    `db:"size" json:"size"`
+	CreatedAt   time.Time `db:"created_at" json:"createdAt"`
+}
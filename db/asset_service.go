@@ -0,0 +1,153 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// AssetService encapsulate the operations on the `asset` table
+type AssetService interface {
+	Insert(a *Asset) error
+	Find(id uint32) (Asset, error)
+	// FindByHash finds any asset with the content, no matter who owns it
+	FindByHash(hash string) (Asset, error)
+	FindByOwnerHash(ownerId uint32, hash string) (Asset, error)
+	ListByOwner(ownerId uint32) ([]Asset, error)
+	// Usage returns the total size of the assets of owner
+	Usage(ownerId uint32) (uint64, error)
+	Delete(id uint32) error
+}
+
+type assetService struct {
+	sess  sqlbuilder.Database
+	table db.Collection
+}
+
+const kAssetTableName = "asset"
+
+func NewAssetService(dbConn sqlbuilder.Database) AssetService {
+	return &assetService{
+		sess:  dbConn,
+		table: dbConn.Collection(kAssetTableName),
+	}
+}
+
+func (s *assetService) Insert(a *Asset) error {
+	a.CreatedAt = time.Now()
+	return s.table.InsertReturning(a)
+}
+
+func (s *assetService) one(res db.Result) (Asset, error) {
+	var a Asset
+	err := res.One(&a)
+	if errors.Is(err, db.ErrNoMoreRows) {
+		return a, ErrNotFound
+	}
+	return a, err
+}
+
+func (s *assetService) Find(id uint32) (Asset, error) {
+	return s.one(s.table.Find("id", id))
+}
+
+func (s *assetService) FindByHash(hash string) (Asset, error) {
+	return s.one(s.table.Find("hash", hash).OrderBy("id").Limit(1))
+}
+
+func (s *assetService) FindByOwnerHash(ownerId uint32, hash string) (Asset, error) {
+	return s.one(s.table.Find("owner_id", ownerId).And("hash", hash))
+}
+
+func (s *assetService) ListByOwner(ownerId uint32) ([]Asset, error) {
+	var as []Asset
+	err := s.table.Find("owner_id", ownerId).OrderBy("-id").All(&as)
+	return as, err
+}
+
+func (s *assetService) Usage(ownerId uint32) (uint64, error) {
+	var usage struct {
+		Size uint64 `db:"size"`
+	}
+	err := s.sess.Select(db.Raw("COALESCE(SUM(size), 0) AS size")).
+		From(kAssetTableName).Where(db.Cond{"owner_id": ownerId}).One(&usage)
+	return usage.Size, err
+}
+
+func (s *assetService) Delete(id uint32) error {
+	return s.table.Find("id", id).Delete()
+}
+
+type memAssetService struct {
+	id    uint32
+	table map[uint32]*Asset
+}
+
+// Used under unit-test enviroment
+func NewMemAssetService() AssetService {
+	return &memAssetService{
+		table: make(map[uint32]*Asset),
+	}
+}
+
+func (s *memAssetService) Insert(a *Asset) error {
+	a.CreatedAt = time.Now()
+	a.ID = s.id
+	s.id++
+	saved := *a
+	s.table[a.ID] = &saved
+	return nil
+}
+
+func (s *memAssetService) Find(id uint32) (Asset, error) {
+	a, ok := s.table[id]
+	if !ok {
+		return Asset{}, ErrNotFound
+	}
+	return *a, nil
+}
+
+func (s *memAssetService) FindByHash(hash string) (Asset, error) {
+	for id := uint32(0); id < s.id; id++ {
+		if a, ok := s.table[id]; ok && a.Hash == hash {
+			return *a, nil
+		}
+	}
+	return Asset{}, ErrNotFound
+}
+
+func (s *memAssetService) FindByOwnerHash(ownerId uint32, hash string) (Asset, error) {
+	for _, a := range s.table {
+		if a.OwnerID == ownerId && a.Hash == hash {
+			return *a, nil
+		}
+	}
+	return Asset{}, ErrNotFound
+}
+
+func (s *memAssetService) ListByOwner(ownerId uint32) ([]Asset, error) {
+	var as []Asset
+	for id := s.id; id > 0; id-- {
+		if a, ok := s.table[id-1]; ok && a.OwnerID == ownerId {
+			as = append(as, *a)
+		}
+	}
+	return as, nil
+}
+
+func (s *memAssetService) Usage(ownerId uint32) (uint64, error) {
+	var usage uint64
+	for _, a := range s.table {
+		if a.OwnerID == ownerId {
+			usage += a.Size
+		}
+	}
+	return usage, nil
+}
+
+func (s *memAssetService) Delete(id uint32) error {
+	delete(s.table, id)
+	return nil
+}
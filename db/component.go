@@ -0,0 +1,35 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Component is a fragment of app content shared by the apps in a workspace,
+// every save makes a new ComponentVersion so that apps could pin one of them
+type Component struct {
+	// ID is constraint by NOT NULL AUTO_INCREMENT
+	// marked as "omitempty", so ID will be auto-generated when insert
+	ID      uint32 `db:"id,omitempty" json:"id"`
+	OwnerID uint32 `db:"owner_id" json:"ownerId"`
+	// OrgID is 0 when the component belongs to the personal workspace of owner
+	OrgID uint32 `db:"org_id" json:"orgId"`
+	// Name is unique in the workspace
+	Name        string `db:"name" json:"name"`
+	Description string `db:"description" json:"description"`
+	// Version is the latest version, starting from 1
+	Version   uint32    `db:"version" json:"version"`
+	CreatedAt time.Time `db:"created_at" json:"createdAt"`
+	UpdatedAt time.Time `db:"updated_at" json:"updatedAt"`
+	UpdatedBy uint32    `db:"updated_by" json:"updatedBy"`
+}
+
+// ComponentVersion is the immutable content of a component at a version
+type ComponentVersion struct {
+	ID          uint32          `db:"id,omitempty" json:"id"`
+	ComponentID uint32          `db:"component_id" json:"componentId"`
+	Version     uint32          `db:"version" json:"version"`
+	Content     json.RawMessage `db:"content"`
+	CreatedAt   time.Time       `db:"created_at" json:"createdAt"`
+	CreatedBy   uint32          `db:"created_by" json:"createdBy"`
+}
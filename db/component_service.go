@@ -0,0 +1,302 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// ComponentService encapsulate the operations on the `component` and `component_version` tables
+type ComponentService interface {
+	// NewComponent saves c with content as its first version
+	NewComponent(c *Component, content json.RawMessage) error
+	Find(id uint32) (Component, error)
+	FindByName(ownerId, orgId uint32, name string) (Component, error)
+	// ListByWorkspace lists components of the org, or the personal ones of owner when orgId is 0
+	ListByWorkspace(ownerId, orgId uint32) ([]Component, error)
+	Update(id uint32, toUpdate map[string]interface{}) error
+	// AddVersion saves content as the next version of the component, and returns the version
+	AddVersion(id, operator uint32, content json.RawMessage) (uint32, error)
+	FindVersion(id, version uint32) (ComponentVersion, error)
+	// ListVersions lists versions of the component, the latest first
+	ListVersions(id uint32) ([]ComponentVersion, error)
+	// Delete deletes the component with all its versions
+	Delete(id uint32) error
+}
+
+type componentService struct {
+	sess     sqlbuilder.Database
+	table    db.Collection
+	versions db.Collection
+}
+
+const (
+	kComponentTableName        = "component"
+	kComponentVersionTableName = "component_version"
+)
+
+func NewComponentService(dbConn sqlbuilder.Database) ComponentService {
+	return &componentService{
+		sess:     dbConn,
+		table:    dbConn.Collection(kComponentTableName),
+		versions: dbConn.Collection(kComponentVersionTableName),
+	}
+}
+
+func (s *componentService) NewComponent(c *Component, content json.RawMessage) error {
+	return s.sess.Tx(context.Background(), func(tx sqlbuilder.Tx) error {
+		c.Version = 1
+		c.CreatedAt = time.Now()
+		c.UpdatedAt = c.CreatedAt
+		if err := tx.Collection(kComponentTableName).InsertReturning(c); err != nil {
+			return err
+		}
+		_, err := tx.Collection(kComponentVersionTableName).Insert(ComponentVersion{
+			ComponentID: c.ID,
+			Version:     c.Version,
+			Content:     content,
+			CreatedAt:   c.CreatedAt,
+			CreatedBy:   c.UpdatedBy,
+		})
+		return err
+	})
+}
+
+func (s *componentService) one(res db.Result) (Component, error) {
+	var c Component
+	err := res.One(&c)
+	if errors.Is(err, db.ErrNoMoreRows) {
+		return c, ErrNotFound
+	}
+	return c, err
+}
+
+func (s *componentService) Find(id uint32) (Component, error) {
+	return s.one(s.table.Find("id", id))
+}
+
+func (s *componentService) workspace(ownerId, orgId uint32) db.Result {
+	res := s.table.Find("org_id", orgId)
+	if orgId == 0 {
+		res = res.And("owner_id", ownerId)
+	}
+	return res
+}
+
+func (s *componentService) FindByName(ownerId, orgId uint32, name string) (Component, error) {
+	return s.one(s.workspace(ownerId, orgId).And("name", name))
+}
+
+func (s *componentService) ListByWorkspace(ownerId, orgId uint32) ([]Component, error) {
+	var cs []Component
+	err := s.workspace(ownerId, orgId).OrderBy("id").All(&cs)
+	return cs, err
+}
+
+func (s *componentService) Update(id uint32, toUpdate map[string]interface{}) error {
+	return s.table.Find("id", id).Update(toUpdate)
+}
+
+func (s *componentService) AddVersion(id, operator uint32, content json.RawMessage) (uint32, error) {
+	var version uint32
+	err := s.sess.Tx(context.Background(), func(tx sqlbuilder.Tx) error {
+		now := time.Now()
+		// the row is locked by the update until commit, so versions are never duplicated
+		res, err := tx.Update(kComponentTableName).Set(map[string]interface{}{
+			"version":    db.Raw("version + 1"),
+			"updated_at": now,
+			"updated_by": operator,
+		}).Where("id", id).Exec()
+		if err != nil {
+			return err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected != 1 {
+			return ErrNotFound
+		}
+		var c Component
+		if err := tx.Collection(kComponentTableName).Find("id", id).One(&c); err != nil {
+			return err
+		}
+		version = c.Version
+		_, err = tx.Collection(kComponentVersionTableName).Insert(ComponentVersion{
+			ComponentID: id,
+			Version:     version,
+			Content:     content,
+			CreatedAt:   now,
+			CreatedBy:   operator,
+		})
+		return err
+	})
+	return version, err
+}
+
+func (s *componentService) FindVersion(id, version uint32) (ComponentVersion, error) {
+	var v ComponentVersion
+	err := s.versions.Find("component_id", id).And("version", version).One(&v)
+	if errors.Is(err, db.ErrNoMoreRows) {
+		return v, ErrNotFound
+	}
+	return v, err
+}
+
+func (s *componentService) ListVersions(id uint32) ([]ComponentVersion, error) {
+	var vs []ComponentVersion
+	err := s.versions.Find("component_id", id).OrderBy("-version").All(&vs)
+	return vs, err
+}
+
+func (s *componentService) Delete(id uint32) error {
+	return s.sess.Tx(context.Background(), func(tx sqlbuilder.Tx) error {
+		if err := tx.Collection(kComponentVersionTableName).Find("component_id", id).Delete(); err != nil {
+			return err
+		}
+		return tx.Collection(kComponentTableName).Find("id", id).Delete()
+	})
+}
+
+// components are resolved by the scheduler in background, so memComponentService is guarded by mutex
+type memComponentService struct {
+	mu       sync.Mutex
+	id       uint32
+	table    map[uint32]*Component
+	versions map[uint32][]ComponentVersion
+}
+
+// Used under unit-test enviroment
+func NewMemComponentService() ComponentService {
+	return &memComponentService{
+		table:    make(map[uint32]*Component),
+		versions: make(map[uint32][]ComponentVersion),
+	}
+}
+
+func (s *memComponentService) NewComponent(c *Component, content json.RawMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.ID = s.id
+	s.id++
+	c.Version = 1
+	c.CreatedAt = time.Now()
+	c.UpdatedAt = c.CreatedAt
+	saved := *c
+	s.table[c.ID] = &saved
+	s.versions[c.ID] = []ComponentVersion{{
+		ComponentID: c.ID,
+		Version:     c.Version,
+		Content:     content,
+		CreatedAt:   c.CreatedAt,
+		CreatedBy:   c.UpdatedBy,
+	}}
+	return nil
+}
+
+func (s *memComponentService) Find(id uint32) (Component, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.table[id]
+	if !ok {
+		return Component{}, ErrNotFound
+	}
+	return *c, nil
+}
+
+func (s *memComponentService) FindByName(ownerId, orgId uint32, name string) (Component, error) {
+	cs, _ := s.ListByWorkspace(ownerId, orgId)
+	for _, c := range cs {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return Component{}, ErrNotFound
+}
+
+func (s *memComponentService) ListByWorkspace(ownerId, orgId uint32) ([]Component, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var cs []Component
+	for id := uint32(0); id < s.id; id++ {
+		c, ok := s.table[id]
+		if !ok || c.OrgID != orgId || (orgId == 0 && c.OwnerID != ownerId) {
+			continue
+		}
+		cs = append(cs, *c)
+	}
+	return cs, nil
+}
+
+func (s *memComponentService) Update(id uint32, toUpdate map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.table[id]
+	if !ok {
+		return ErrNotFound
+	}
+	for k, v := range toUpdate {
+		switch k {
+		case "description":
+			c.Description = v.(string)
+		default:
+			panic("Not Implemented")
+		}
+	}
+	return nil
+}
+
+func (s *memComponentService) AddVersion(id, operator uint32, content json.RawMessage) (uint32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.table[id]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	c.Version++
+	c.UpdatedAt = time.Now()
+	c.UpdatedBy = operator
+	s.versions[id] = append(s.versions[id], ComponentVersion{
+		ComponentID: id,
+		Version:     c.Version,
+		Content:     content,
+		CreatedAt:   c.UpdatedAt,
+		CreatedBy:   operator,
+	})
+	return c.Version, nil
+}
+
+func (s *memComponentService) FindVersion(id, version uint32) (ComponentVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.versions[id] {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return ComponentVersion{}, ErrNotFound
+}
+
+func (s *memComponentService) ListVersions(id uint32) ([]ComponentVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vs := s.versions[id]
+	result := make([]ComponentVersion, 0, len(vs))
+	for i := len(vs) - 1; i >= 0; i-- {
+		result = append(result, vs[i])
+	}
+	return result, nil
+}
+
+func (s *memComponentService) Delete(id uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.table, id)
+	delete(s.versions, id)
+	return nil
+}
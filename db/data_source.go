@@ -0,0 +1,64 @@
+package db
+
+import "encoding/json"
+
+const (
+	DataSourceTypeREST = "rest"
+	DataSourceTypeSQL  = "sql"
+)
+
+// DataSource is an external REST service or SQL database registered in a workspace,
+// apps query it through the server so that the credentials never reach the browser
+type DataSource struct {
+	// ID is constraint by NOT NULL AUTO_INCREMENT
+	// marked as "omitempty", so ID will be auto-generated when insert
+	ID      uint32 `db:"id,omitempty" json:"id"`
+	OwnerID uint32 `db:"owner_id" json:"ownerId"`
+	// OrgID is 0 when the data source belongs to the personal workspace of owner
+	OrgID uint32 `db:"org_id" json:"orgId"`
+	// Name is unique in the workspace
+	Name string `db:"name" json:"name"`
+	Type string `db:"type" json:"type"`
+
+	// fields of DataSourceTypeREST
+	BaseURL string `db:"base_url" json:"baseUrl"`
+	// Params is a JSON object of string values added to the query string of every query
+	Params json.RawMessage `db:"params"`
+
+	// fields of DataSourceTypeSQL
+	// Driver is the name registered to database/sql, e.g. mysql, postgres
+	Driver string `db:"driver" json:"driver"`
+	// ReadOnly allows read statements only, which run in read-only transactions
+	ReadOnly bool `db:"read_only" json:"readOnly"`
+	// at most MaxRows rows are returned by a query, 0 means the default
+	MaxRows uint32 `db:"max_rows" json:"maxRows"`
+	// in seconds, 0 means the default
+	QueryTimeout uint32 `db:"query_timeout" json:"queryTimeout"`
+
+	// Secrets holds the credentials, i.e. headers of REST and DSN of SQL, as a JSON object
+	// envelope-encrypted by the master key, it's never saved in plaintext
+	Secrets string `db:"secrets"`
+}
+
+// AppQuery is a request to a data source defined by editors of an app,
+// viewers could only run it by name
+type AppQuery struct {
+	ID           uint32 `db:"id,omitempty" json:"id"`
+	AppID        uint32 `db:"app_id" json:"appId"`
+	Name         string `db:"name" json:"name"`
+	DataSourceID uint32 `db:"data_source_id" json:"dataSourceId"`
+	Method       string `db:"method" json:"method"`
+	// Path is relative to DataSource.BaseURL
+	Path string `db:"path" json:"path"`
+	// Params is a JSON object of string values, which overrides DataSource.Params.
+	// For SQL data sources, they are the default values of the named params in SQL.
+	Params json.RawMessage `db:"params"`
+	// Body is sent as JSON, nil for no body
+	Body json.RawMessage `db:"body"`
+	// SQL refers to params by :name, only for SQL data sources
+	SQL string `db:"sql" json:"sql"`
+	// Transform is a JSON object describing how to reshape the response, nil for none
+	Transform json.RawMessage `db:"transform"`
+	// in seconds, results are cached by the query and its params, 0 means no cache
+	CacheTTL uint32 `db:"cache_ttl" json:"cacheTtl"`
+}
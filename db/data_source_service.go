@@ -0,0 +1,177 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+
+	"upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// DataSourceService encapsulate the operations on the `data_source` table
+type DataSourceService interface {
+	NewDataSource(ds *DataSource) error
+	Find(id uint32) (DataSource, error)
+	FindByName(ownerId, orgId uint32, name string) (DataSource, error)
+	// ListByWorkspace lists data sources of the org, or the personal ones of owner when orgId is 0
+	ListByWorkspace(ownerId, orgId uint32) ([]DataSource, error)
+	// ListAll is used to re-encrypt secrets after the master key rotated
+	ListAll() ([]DataSource, error)
+	Update(id uint32, toUpdate map[string]interface{}) error
+	Delete(id uint32) error
+}
+
+type dataSourceService struct {
+	table db.Collection
+}
+
+func NewDataSourceService(dbConn sqlbuilder.Database) DataSourceService {
+	const kTableName = "data_source"
+	return &dataSourceService{
+		table: dbConn.Collection(kTableName),
+	}
+}
+
+func (s *dataSourceService) NewDataSource(ds *DataSource) error {
+	return s.table.InsertReturning(ds)
+}
+
+func (s *dataSourceService) one(res db.Result) (DataSource, error) {
+	var ds DataSource
+	err := res.One(&ds)
+	if errors.Is(err, db.ErrNoMoreRows) {
+		return ds, ErrNotFound
+	}
+	return ds, err
+}
+
+func (s *dataSourceService) Find(id uint32) (DataSource, error) {
+	return s.one(s.table.Find("id", id))
+}
+
+func (s *dataSourceService) workspace(ownerId, orgId uint32) db.Result {
+	res := s.table.Find("org_id", orgId)
+	if orgId == 0 {
+		res = res.And("owner_id", ownerId)
+	}
+	return res
+}
+
+func (s *dataSourceService) FindByName(ownerId, orgId uint32, name string) (DataSource, error) {
+	return s.one(s.workspace(ownerId, orgId).And("name", name))
+}
+
+func (s *dataSourceService) ListByWorkspace(ownerId, orgId uint32) ([]DataSource, error) {
+	var dss []DataSource
+	err := s.workspace(ownerId, orgId).OrderBy("id").All(&dss)
+	return dss, err
+}
+
+func (s *dataSourceService) ListAll() ([]DataSource, error) {
+	var dss []DataSource
+	err := s.table.Find().OrderBy("id").All(&dss)
+	return dss, err
+}
+
+func (s *dataSourceService) Update(id uint32, toUpdate map[string]interface{}) error {
+	return s.table.Find("id", id).Update(toUpdate)
+}
+
+func (s *dataSourceService) Delete(id uint32) error {
+	return s.table.Find("id", id).Delete()
+}
+
+type memDataSourceService struct {
+	id    uint32
+	table map[uint32]*DataSource
+}
+
+// Used under unit-test enviroment
+func NewMemDataSourceService() DataSourceService {
+	return &memDataSourceService{
+		table: make(map[uint32]*DataSource),
+	}
+}
+
+func (s *memDataSourceService) NewDataSource(ds *DataSource) error {
+	ds.ID = s.id
+	s.id++
+	s.table[ds.ID] = ds
+	return nil
+}
+
+func (s *memDataSourceService) Find(id uint32) (DataSource, error) {
+	ds, ok := s.table[id]
+	if !ok {
+		return DataSource{}, ErrNotFound
+	} else {
+		return *ds, nil
+	}
+}
+
+func (s *memDataSourceService) FindByName(ownerId, orgId uint32, name string) (DataSource, error) {
+	dss, _ := s.ListByWorkspace(ownerId, orgId)
+	for _, ds := range dss {
+		if ds.Name == name {
+			return ds, nil
+		}
+	}
+	return DataSource{}, ErrNotFound
+}
+
+func (s *memDataSourceService) ListByWorkspace(ownerId, orgId uint32) ([]DataSource, error) {
+	var dss []DataSource
+	for id := uint32(0); id < s.id; id++ {
+		ds, ok := s.table[id]
+		if !ok || ds.OrgID != orgId || (orgId == 0 && ds.OwnerID != ownerId) {
+			continue
+		}
+		dss = append(dss, *ds)
+	}
+	return dss, nil
+}
+
+func (s *memDataSourceService) ListAll() ([]DataSource, error) {
+	var dss []DataSource
+	for id := uint32(0); id < s.id; id++ {
+		if ds, ok := s.table[id]; ok {
+			dss = append(dss, *ds)
+		}
+	}
+	return dss, nil
+}
+
+func (s *memDataSourceService) Update(id uint32, toUpdate map[string]interface{}) error {
+	ds, ok := s.table[id]
+	if !ok {
+		return ErrNotFound
+	}
+	for k, v := range toUpdate {
+		switch k {
+		case "name":
+			ds.Name = v.(string)
+		case "base_url":
+			ds.BaseURL = v.(string)
+		case "params":
+			ds.Params = v.(json.RawMessage)
+		case "driver":
+			ds.Driver = v.(string)
+		case "read_only":
+			ds.ReadOnly = v.(bool)
+		case "max_rows":
+			ds.MaxRows = v.(uint32)
+		case "query_timeout":
+			ds.QueryTimeout = v.(uint32)
+		case "secrets":
+			ds.Secrets = v.(string)
+		default:
+			panic("Not Implemented")
+		}
+	}
+	return nil
+}
+
+func (s *memDataSourceService) Delete(id uint32) error {
+	delete(s.table, id)
+	return nil
+}
@@ -0,0 +1,21 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// FormSubmission is the data submitted by viewers of a published app through one of its forms,
+// the forms are declared in App.LastPublishedContent
+type FormSubmission struct {
+	// ID is constraint by NOT NULL AUTO_INCREMENT
+	// marked as "omitempty", so ID will be auto-generated when insert
+	ID    uint32 `db:"id,omitempty" json:"id"`
+	AppID uint32 `db:"app_id" json:"appId"`
+	Form  string `db:"form" json:"form"`
+	// Data is a JSON object keyed by the field names
+	Data json.RawMessage `db:"data"`
+	// 0 when submitted anonymously to a public form
+	SubmittedBy uint32    `db:"submitted_by" json:"submittedBy"`
+	CreatedAt   time.Time `db:"created_at" json:"createdAt"`
+}
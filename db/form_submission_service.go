@@ -0,0 +1,80 @@
+package db
+
+import (
+	"time"
+
+	"upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// FormSubmissionService encapsulate the operations on the `form_submission` table
+type FormSubmissionService interface {
+	Insert(sub *FormSubmission) error
+	// List returns the submissions of the form in the page in the order of submission,
+	// and the number of all of them
+	List(appId uint32, form string, offset, limit uint) ([]FormSubmission, uint64, error)
+}
+
+type formSubmissionService struct {
+	table db.Collection
+}
+
+func NewFormSubmissionService(dbConn sqlbuilder.Database) FormSubmissionService {
+	const kTableName = "form_submission"
+	return &formSubmissionService{
+		table: dbConn.Collection(kTableName),
+	}
+}
+
+func (s *formSubmissionService) Insert(sub *FormSubmission) error {
+	sub.CreatedAt = time.Now()
+	return s.table.InsertReturning(sub)
+}
+
+func (s *formSubmissionService) List(appId uint32, form string, offset, limit uint) ([]FormSubmission, uint64, error) {
+	res := s.table.Find("app_id", appId).And("form", form)
+	total, err := res.Count()
+	if err != nil {
+		return nil, 0, err
+	}
+	var subs []FormSubmission
+	err = res.OrderBy("id").Offset(int(offset)).Limit(int(limit)).All(&subs)
+	return subs, total, err
+}
+
+type memFormSubmissionService struct {
+	id    uint32
+	table map[uint32]*FormSubmission
+}
+
+// Used under unit-test enviroment
+func NewMemFormSubmissionService() FormSubmissionService {
+	return &memFormSubmissionService{
+		table: make(map[uint32]*FormSubmission),
+	}
+}
+
+func (s *memFormSubmissionService) Insert(sub *FormSubmission) error {
+	sub.CreatedAt = time.Now()
+	sub.ID = s.id
+	s.id++
+	saved := *sub
+	s.table[sub.ID] = &saved
+	return nil
+}
+
+func (s *memFormSubmissionService) List(appId uint32, form string, offset, limit uint) ([]FormSubmission, uint64, error) {
+	var total uint64
+	subs := make([]FormSubmission, 0, limit)
+	for id := uint32(0); id < s.id; id++ {
+		sub, ok := s.table[id]
+		if !ok || sub.AppID != appId || sub.Form != form {
+			continue
+		}
+		if total >= uint64(offset) && uint(len(subs)) < limit {
+			subs = append(subs, *sub)
+		}
+		total++
+	}
+	return subs, total, nil
+}
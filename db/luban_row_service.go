@@ -0,0 +1,345 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// LubanRowService encapsulate the operations on the `luban_row` table
+type LubanRowService interface {
+	Insert(row *LubanRow) error
+	Find(tableId, id uint32) (LubanRow, error)
+	// Query returns the rows in the page, and the number of all the matched rows
+	Query(tableId uint32, q RowQuery) ([]LubanRow, uint64, error)
+	Update(tableId, id uint32, data json.RawMessage) error
+	Delete(tableId, id uint32) error
+	DeleteByTable(tableId uint32) error
+	// RemoveColumns removes the values of columns from all the rows of the table
+	RemoveColumns(tableId uint32, columns []string) error
+}
+
+type lubanRowService struct {
+	table db.Collection
+}
+
+func NewLubanRowService(dbConn sqlbuilder.Database) LubanRowService {
+	const kTableName = "luban_row"
+	return &lubanRowService{
+		table: dbConn.Collection(kTableName),
+	}
+}
+
+func (s *lubanRowService) Insert(row *LubanRow) error {
+	now := time.Now()
+	row.CreatedAt = now
+	row.UpdatedAt = now
+	return s.table.InsertReturning(row)
+}
+
+func (s *lubanRowService) Find(tableId, id uint32) (LubanRow, error) {
+	var row LubanRow
+	err := s.table.Find("id", id).And("table_id", tableId).One(&row)
+	if errors.Is(err, db.ErrNoMoreRows) {
+		return row, ErrNotFound
+	}
+	return row, err
+}
+
+// columnPath is the JSON path of a column, column names are identifiers checked by the caller
+func columnPath(column string) string {
+	return "$." + column
+}
+
+// columnExpr extracts a column as a JSON value, or as a string for text and date columns
+func columnExpr(column, typ string) (string, []interface{}) {
+	if typ == ColumnTypeText || typ == ColumnTypeDate {
+		return "JSON_UNQUOTE(JSON_EXTRACT(data, ?))", []interface{}{columnPath(column)}
+	}
+	return "JSON_EXTRACT(data, ?)", []interface{}{columnPath(column)}
+}
+
+var rowOpOperators = map[string]string{
+	RowOpEq:  "=",
+	RowOpNe:  "<>",
+	RowOpLt:  "<",
+	RowOpLte: "<=",
+	RowOpGt:  ">",
+	RowOpGte: ">=",
+}
+
+func rowFilterCond(f RowFilter) (db.RawValue, error) {
+	expr, args := columnExpr(f.Column, f.Type)
+	if f.Op == RowOpContains {
+		escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(fmt.Sprint(f.Value))
+		return db.Raw(expr+" LIKE ?", append(args, "%"+escaped+"%")...), nil
+	}
+	operator, ok := rowOpOperators[f.Op]
+	if !ok {
+		return db.RawValue(nil), fmt.Errorf("unsupported op(%s)", f.Op)
+	}
+	value := f.Value
+	placeholder := "?"
+	if f.Type == ColumnTypeBool {
+		// JSON true does not equal to the SQL TRUE, i.e. 1
+		placeholder = "CAST(? AS JSON)"
+		value = fmt.Sprint(f.Value)
+	}
+	return db.Raw(expr+" "+operator+" "+placeholder, append(args, value)...), nil
+}
+
+func (s *lubanRowService) Query(tableId uint32, q RowQuery) ([]LubanRow, uint64, error) {
+	res := s.table.Find("table_id", tableId)
+	for _, f := range q.Filters {
+		cond, err := rowFilterCond(f)
+		if err != nil {
+			return nil, 0, err
+		}
+		res = res.And(cond)
+	}
+	total, err := res.Count()
+	if err != nil {
+		return nil, 0, err
+	}
+	var orderBy []interface{}
+	for _, sort := range q.Sort {
+		expr, args := columnExpr(sort.Column, sort.Type)
+		if sort.Desc {
+			expr += " DESC"
+		}
+		orderBy = append(orderBy, db.Raw(expr, args...))
+	}
+	orderBy = append(orderBy, "id")
+	var rows []LubanRow
+	err = res.OrderBy(orderBy...).Offset(int(q.Offset)).Limit(int(q.Limit)).All(&rows)
+	return rows, total, err
+}
+
+func (s *lubanRowService) Update(tableId, id uint32, data json.RawMessage) error {
+	return s.table.Find("id", id).And("table_id", tableId).Update(map[string]interface{}{
+		"data":       data,
+		"updated_at": time.Now(),
+	})
+}
+
+func (s *lubanRowService) Delete(tableId, id uint32) error {
+	return s.table.Find("id", id).And("table_id", tableId).Delete()
+}
+
+func (s *lubanRowService) DeleteByTable(tableId uint32) error {
+	return s.table.Find("table_id", tableId).Delete()
+}
+
+func (s *lubanRowService) RemoveColumns(tableId uint32, columns []string) error {
+	if len(columns) == 0 {
+		return nil
+	}
+	paths := make([]interface{}, 0, len(columns))
+	for _, c := range columns {
+		paths = append(paths, columnPath(c))
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(paths)), ", ")
+	return s.table.Find("table_id", tableId).Update(map[string]interface{}{
+		"data": db.Raw("JSON_REMOVE(data, "+placeholders+")", paths...),
+	})
+}
+
+type memLubanRowService struct {
+	id    uint32
+	table map[uint32]*LubanRow
+}
+
+// Used under unit-test enviroment
+func NewMemLubanRowService() LubanRowService {
+	return &memLubanRowService{
+		table: make(map[uint32]*LubanRow),
+	}
+}
+
+func (s *memLubanRowService) Insert(row *LubanRow) error {
+	now := time.Now()
+	row.CreatedAt = now
+	row.UpdatedAt = now
+	row.ID = s.id
+	s.id++
+	saved := *row
+	s.table[row.ID] = &saved
+	return nil
+}
+
+func (s *memLubanRowService) Find(tableId, id uint32) (LubanRow, error) {
+	row, ok := s.table[id]
+	if !ok || row.TableID != tableId {
+		return LubanRow{}, ErrNotFound
+	}
+	return *row, nil
+}
+
+// compareColumn compares the column values decoded from JSON, nil is the smallest like NULL
+func compareColumn(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			switch {
+			case a < b:
+				return -1
+			case a > b:
+				return 1
+			}
+			return 0
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			switch {
+			case a == b:
+				return 0
+			case !a:
+				return -1
+			}
+			return 1
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b)
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func matchRowFilter(data map[string]interface{}, f RowFilter) bool {
+	v, ok := data[f.Column]
+	if !ok || v == nil {
+		return false
+	}
+	if f.Op == RowOpContains {
+		return strings.Contains(fmt.Sprint(v), fmt.Sprint(f.Value))
+	}
+	c := compareColumn(v, f.Value)
+	switch f.Op {
+	case RowOpEq:
+		return c == 0
+	case RowOpNe:
+		return c != 0
+	case RowOpLt:
+		return c < 0
+	case RowOpLte:
+		return c <= 0
+	case RowOpGt:
+		return c > 0
+	case RowOpGte:
+		return c >= 0
+	}
+	return false
+}
+
+func (s *memLubanRowService) Query(tableId uint32, q RowQuery) ([]LubanRow, uint64, error) {
+	for _, f := range q.Filters {
+		if _, ok := rowOpOperators[f.Op]; !ok && f.Op != RowOpContains {
+			return nil, 0, fmt.Errorf("unsupported op(%s)", f.Op)
+		}
+	}
+	var rows []LubanRow
+	var datas []map[string]interface{}
+	for id := uint32(0); id < s.id; id++ {
+		row, ok := s.table[id]
+		if !ok || row.TableID != tableId {
+			continue
+		}
+		var data map[string]interface{}
+		if err := json.Unmarshal(row.Data, &data); err != nil {
+			return nil, 0, err
+		}
+		matched := true
+		for _, f := range q.Filters {
+			matched = matched && matchRowFilter(data, f)
+		}
+		if matched {
+			rows = append(rows, *row)
+			datas = append(datas, data)
+		}
+	}
+	indexes := make([]int, len(rows))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		for _, sort := range q.Sort {
+			c := compareColumn(datas[indexes[i]][sort.Column], datas[indexes[j]][sort.Column])
+			if sort.Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		// rows are in the order of id already
+		return false
+	})
+	total := uint64(len(rows))
+	page := make([]LubanRow, 0, q.Limit)
+	for i := q.Offset; i < uint(len(indexes)) && uint(len(page)) < q.Limit; i++ {
+		page = append(page, rows[indexes[i]])
+	}
+	return page, total, nil
+}
+
+func (s *memLubanRowService) Update(tableId, id uint32, data json.RawMessage) error {
+	row, ok := s.table[id]
+	if !ok || row.TableID != tableId {
+		return ErrNotFound
+	}
+	row.Data = data
+	row.UpdatedAt = time.Now()
+	return nil
+}
+
+func (s *memLubanRowService) Delete(tableId, id uint32) error {
+	if row, ok := s.table[id]; ok && row.TableID == tableId {
+		delete(s.table, id)
+	}
+	return nil
+}
+
+func (s *memLubanRowService) DeleteByTable(tableId uint32) error {
+	for id, row := range s.table {
+		if row.TableID == tableId {
+			delete(s.table, id)
+		}
+	}
+	return nil
+}
+
+func (s *memLubanRowService) RemoveColumns(tableId uint32, columns []string) error {
+	for _, row := range s.table {
+		if row.TableID != tableId {
+			continue
+		}
+		var data map[string]json.RawMessage
+		if err := json.Unmarshal(row.Data, &data); err != nil {
+			return err
+		}
+		for _, c := range columns {
+			delete(data, c)
+		}
+		bytes, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		row.Data = bytes
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	ColumnTypeText   = "text"
+	ColumnTypeNumber = "number"
+	ColumnTypeBool   = "bool"
+	// saved as RFC3339 strings in UTC, so they are ordered as strings
+	ColumnTypeDate = "date"
+	ColumnTypeJSON = "json"
+)
+
+// LubanTable is a lightweight table in a workspace for the data of apps, e.g. form submissions,
+// its rows are saved as JSON objects in the `luban_row` table
+type LubanTable struct {
+	// ID is constraint by NOT NULL AUTO_INCREMENT
+	// marked as "omitempty", so ID will be auto-generated when insert
+	ID      uint32 `db:"id,omitempty" json:"id"`
+	OwnerID uint32 `db:"owner_id" json:"ownerId"`
+	// OrgID is 0 when the table belongs to the personal workspace of owner
+	OrgID uint32 `db:"org_id" json:"orgId"`
+	// Name is unique in the workspace
+	Name string `db:"name" json:"name"`
+	// Columns is a JSON array of {name, type, required}
+	Columns json.RawMessage `db:"columns"`
+}
+
+type LubanRow struct {
+	ID      uint32 `db:"id,omitempty" json:"id"`
+	TableID uint32 `db:"table_id" json:"tableId"`
+	// Data is a JSON object keyed by the column names
+	Data      json.RawMessage `db:"data"`
+	CreatedAt time.Time       `db:"created_at" json:"createdAt"`
+	UpdatedAt time.Time       `db:"updated_at" json:"updatedAt"`
+}
+
+const (
+	RowOpEq  = "eq"
+	RowOpNe  = "ne"
+	RowOpLt  = "lt"
+	RowOpLte = "lte"
+	RowOpGt  = "gt"
+	RowOpGte = "gte"
+	// substring of text columns
+	RowOpContains = "contains"
+)
+
+// RowFilter compares a column with Value, rows missing the column never match
+type RowFilter struct {
+	Column string
+	// Type is the column type, which decides how to compare
+	Type string
+	Op   string
+	// string for text and date, float64 for number, bool for bool
+	Value interface{}
+}
+
+type RowSort struct {
+	Column string
+	Type   string
+	Desc   bool
+}
+
+// RowQuery selects rows matching all of Filters, ordered by Sort and then id
+type RowQuery struct {
+	Filters []RowFilter
+	Sort    []RowSort
+	Offset  uint
+	Limit   uint
+}
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+
+	"upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// LubanTableService encapsulate the operations on the `luban_table` table
+type LubanTableService interface {
+	NewTable(t *LubanTable) error
+	Find(id uint32) (LubanTable, error)
+	FindByName(ownerId, orgId uint32, name string) (LubanTable, error)
+	// ListByWorkspace lists tables of the org, or the personal ones of owner when orgId is 0
+	ListByWorkspace(ownerId, orgId uint32) ([]LubanTable, error)
+	Update(id uint32, toUpdate map[string]interface{}) error
+	Delete(id uint32) error
+}
+
+type lubanTableService struct {
+	table db.Collection
+}
+
+func NewLubanTableService(dbConn sqlbuilder.Database) LubanTableService {
+	const kTableName = "luban_table"
+	return &lubanTableService{
+		table: dbConn.Collection(kTableName),
+	}
+}
+
+func (s *lubanTableService) NewTable(t *LubanTable) error {
+	return s.table.InsertReturning(t)
+}
+
+func (s *lubanTableService) one(res db.Result) (LubanTable, error) {
+	var t LubanTable
+	err := res.One(&t)
+	if errors.Is(err, db.ErrNoMoreRows) {
+		return t, ErrNotFound
+	}
+	return t, err
+}
+
+func (s *lubanTableService) Find(id uint32) (LubanTable, error) {
+	return s.one(s.table.Find("id", id))
+}
+
+func (s *lubanTableService) workspace(ownerId, orgId uint32) db.Result {
+	res := s.table.Find("org_id", orgId)
+	if orgId == 0 {
+		res = res.And("owner_id", ownerId)
+	}
+	return res
+}
+
+func (s *lubanTableService) FindByName(ownerId, orgId uint32, name string) (LubanTable, error) {
+	return s.one(s.workspace(ownerId, orgId).And("name", name))
+}
+
+func (s *lubanTableService) ListByWorkspace(ownerId, orgId uint32) ([]LubanTable, error) {
+	var ts []LubanTable
+	err := s.workspace(ownerId, orgId).OrderBy("id").All(&ts)
+	return ts, err
+}
+
+func (s *lubanTableService) Update(id uint32, toUpdate map[string]interface{}) error {
+	return s.table.Find("id", id).Update(toUpdate)
+}
+
+func (s *lubanTableService) Delete(id uint32) error {
+	return s.table.Find("id", id).Delete()
+}
+
+type memLubanTableService struct {
+	id    uint32
+	table map[uint32]*LubanTable
+}
+
+// Used under unit-test enviroment
+func NewMemLubanTableService() LubanTableService {
+	return &memLubanTableService{
+		table: make(map[uint32]*LubanTable),
+	}
+}
+
+func (s *memLubanTableService) NewTable(t *LubanTable) error {
+	t.ID = s.id
+	s.id++
+	saved := *t
+	s.table[t.ID] = &saved
+	return nil
+}
+
+func (s *memLubanTableService) Find(id uint32) (LubanTable, error) {
+	t, ok := s.table[id]
+	if !ok {
+		return LubanTable{}, ErrNotFound
+	} else {
+		return *t, nil
+	}
+}
+
+func (s *memLubanTableService) FindByName(ownerId, orgId uint32, name string) (LubanTable, error) {
+	ts, _ := s.ListByWorkspace(ownerId, orgId)
+	for _, t := range ts {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	return LubanTable{}, ErrNotFound
+}
+
+func (s *memLubanTableService) ListByWorkspace(ownerId, orgId uint32) ([]LubanTable, error) {
+	var ts []LubanTable
+	for id := uint32(0); id < s.id; id++ {
+		t, ok := s.table[id]
+		if !ok || t.OrgID != orgId || (orgId == 0 && t.OwnerID != ownerId) {
+			continue
+		}
+		ts = append(ts, *t)
+	}
+	return ts, nil
+}
+
+func (s *memLubanTableService) Update(id uint32, toUpdate map[string]interface{}) error {
+	t, ok := s.table[id]
+	if !ok {
+		return ErrNotFound
+	}
+	for k, v := range toUpdate {
+		switch k {
+		case "name":
+			t.Name = v.(string)
+		case "columns":
+			t.Columns = v.(json.RawMessage)
+		default:
+			panic("Not Implemented")
+		}
+	}
+	return nil
+}
+
+func (s *memLubanTableService) Delete(id uint32) error {
+	delete(s.table, id)
+	return nil
+}
@@ -0,0 +1,22 @@
+package db
+
+import "encoding/json"
+
+// Org is a team workspace, it owns a directory tree and apps like a User does
+type Org struct {
+	// ID is constraint by NOT NULL AUTO_INCREMENT
+	// marked as "omitempty", so ID will be auto-generated when insert
+	ID      uint32          `db:"id,omitempty" json:"id"`
+	Name    string          `db:"name" json:"name"`
+	RootDir json.RawMessage `db:"root_dir"`
+}
+
+// OrgMember grants the user identified by UserID the Role in an org
+type OrgMember struct {
+	// ID is constraint by NOT NULL AUTO_INCREMENT
+	// marked as "omitempty", so ID will be auto-generated when insert
+	ID     uint32 `db:"id,omitempty" json:"id"`
+	OrgID  uint32 `db:"org_id" json:"orgId"`
+	UserID uint32 `db:"user_id" json:"userId"`
+	Role   Role   `db:"role" json:"role"`
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"errors"
+
+	"upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// OrgMemberService encapsulate the operations on the `org_member` table
+type OrgMemberService interface {
+	Find(orgId, userId uint32) (OrgMember, error)
+	ListByOrg(orgId uint32) ([]OrgMember, error)
+	ListByUser(userId uint32) ([]OrgMember, error)
+
+	// Grant inserts or overwrites the role of user in org
+	Grant(orgId, userId uint32, role Role) error
+	Remove(orgId, userId uint32) error
+}
+
+type orgMemberService struct {
+	table db.Collection
+}
+
+func NewOrgMemberService(dbConn sqlbuilder.Database) OrgMemberService {
+	const kTableName = "org_member"
+	return &orgMemberService{
+		table: dbConn.Collection(kTableName),
+	}
+}
+
+func (s *orgMemberService) Find(orgId, userId uint32) (OrgMember, error) {
+	res := s.table.Find("org_id", orgId).And("user_id", userId)
+	var member OrgMember
+	err := res.One(&member)
+	if errors.Is(err, db.ErrNoMoreRows) {
+		return member, ErrNotFound
+	}
+	return member, err
+}
+
+func (s *orgMemberService) ListByOrg(orgId uint32) ([]OrgMember, error) {
+	var members []OrgMember
+	err := s.table.Find("org_id", orgId).OrderBy("id").All(&members)
+	return members, err
+}
+
+func (s *orgMemberService) ListByUser(userId uint32) ([]OrgMember, error) {
+	var members []OrgMember
+	err := s.table.Find("user_id", userId).OrderBy("id").All(&members)
+	return members, err
+}
+
+func (s *orgMemberService) Grant(orgId, userId uint32, role Role) error {
+	member, err := s.Find(orgId, userId)
+	if errors.Is(err, ErrNotFound) {
+		member = OrgMember{OrgID: orgId, UserID: userId, Role: role}
+		return s.table.InsertReturning(&member)
+	}
+	if err != nil {
+		return err
+	}
+	return s.table.Find("id", member.ID).Update(map[string]interface{}{
+		"role": role,
+	})
+}
+
+func (s *orgMemberService) Remove(orgId, userId uint32) error {
+	return s.table.Find("org_id", orgId).And("user_id", userId).Delete()
+}
+
+type memOrgMemberService struct {
+	id    uint32
+	table map[uint32]*OrgMember
+}
+
+// Used under unit-test enviroment
+func NewMemOrgMemberService() OrgMemberService {
+	return &memOrgMemberService{
+		table: make(map[uint32]*OrgMember),
+	}
+}
+
+func (s *memOrgMemberService) find(orgId, userId uint32) *OrgMember {
+	for _, member := range s.table {
+		if member.OrgID == orgId && member.UserID == userId {
+			return member
+		}
+	}
+	return nil
+}
+
+func (s *memOrgMemberService) Find(orgId, userId uint32) (OrgMember, error) {
+	member := s.find(orgId, userId)
+	if member == nil {
+		return OrgMember{}, ErrNotFound
+	} else {
+		return *member, nil
+	}
+}
+
+func (s *memOrgMemberService) list(match func(member *OrgMember) bool) []OrgMember {
+	var members []OrgMember
+	for id := uint32(0); id < s.id; id++ {
+		if member, ok := s.table[id]; ok && match(member) {
+			members = append(members, *member)
+		}
+	}
+	return members
+}
+
+func (s *memOrgMemberService) ListByOrg(orgId uint32) ([]OrgMember, error) {
+	return s.list(func(member *OrgMember) bool { return member.OrgID == orgId }), nil
+}
+
+func (s *memOrgMemberService) ListByUser(userId uint32) ([]OrgMember, error) {
+	return s.list(func(member *OrgMember) bool { return member.UserID == userId }), nil
+}
+
+func (s *memOrgMemberService) Grant(orgId, userId uint32, role Role) error {
+	if member := s.find(orgId, userId); member != nil {
+		member.Role = role
+		return nil
+	}
+	member := &OrgMember{ID: s.id, OrgID: orgId, UserID: userId, Role: role}
+	s.id++
+	s.table[member.ID] = member
+	return nil
+}
+
+func (s *memOrgMemberService) Remove(orgId, userId uint32) error {
+	if member := s.find(orgId, userId); member != nil {
+		delete(s.table, member.ID)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+
+	"upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// OrgService encapsulate the operations on the `org` table
+type OrgService interface {
+	NewOrg(org *Org) error
+	Find(orgId uint32) (Org, error)
+	UpdateRootDir(orgId uint32, v json.RawMessage) error
+}
+
+type orgService struct {
+	table db.Collection
+}
+
+func NewOrgService(dbConn sqlbuilder.Database) OrgService {
+	const kTableName = "org"
+	return &orgService{
+		table: dbConn.Collection(kTableName),
+	}
+}
+
+func (s *orgService) NewOrg(org *Org) error {
+	return s.table.InsertReturning(org)
+}
+
+func (s *orgService) Find(orgId uint32) (Org, error) {
+	res := s.table.Find("id", orgId)
+	var org Org
+	err := res.One(&org)
+	if errors.Is(err, db.ErrNoMoreRows) {
+		return org, ErrNotFound
+	}
+	return org, err
+}
+
+func (s *orgService) UpdateRootDir(orgId uint32, v json.RawMessage) error {
+	return s.table.Find("id", orgId).Update(map[string]interface{}{
+		"root_dir": v,
+	})
+}
+
+type memOrgService struct {
+	id    uint32
+	table map[uint32]*Org
+}
+
+// Used under unit-test enviroment
+func NewMemOrgService() OrgService {
+	return &memOrgService{
+		// 0 stands for the personal workspace, begin with 1 as AUTO_INCREMENT does
+		id:    1,
+		table: make(map[uint32]*Org),
+	}
+}
+
+func (s *memOrgService) NewOrg(org *Org) error {
+	org.ID = s.id
+	s.id++
+	s.table[org.ID] = org
+	return nil
+}
+
+func (s *memOrgService) Find(orgId uint32) (Org, error) {
+	org, ok := s.table[orgId]
+	if !ok {
+		return Org{}, ErrNotFound
+	} else {
+		return *org, nil
+	}
+}
+
+func (s *memOrgService) UpdateRootDir(orgId uint32, v json.RawMessage) error {
+	org, ok := s.table[orgId]
+	if !ok {
+		return ErrNotFound
+	}
+	org.RootDir = v
+	return nil
+}
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	ScheduleStatusPending  = "pending"
+	ScheduleStatusRunning  = "running"
+	ScheduleStatusDone     = "done"
+	ScheduleStatusFailed   = "failed"
+	ScheduleStatusCanceled = "canceled"
+)
+
+// PublishSchedule publishes an app at PublishAt
+type PublishSchedule struct {
+	// ID is constraint by NOT NULL AUTO_INCREMENT
+	// marked as "omitempty", so ID will be auto-generated when insert
+	ID        uint32    `db:"id,omitempty" json:"id"`
+	AppID     uint32    `db:"app_id" json:"appId"`
+	CreatedBy uint32    `db:"created_by" json:"createdBy"`
+	PublishAt time.Time `db:"publish_at" json:"publishAt"`
+	Channel   string    `db:"channel" json:"channel"`
+	// nil to publish the draft as it is at PublishAt,
+	// otherwise the snapshot of draft taken when scheduling
+	Content json.RawMessage `db:"content"`
+	// marks the draft which Content is made from, the same as App.PublishedDraft
+	Draft  json.RawMessage `db:"draft"`
+	Status string          `db:"status" json:"status"`
+	// the reason when Status is failed
+	Error string `db:"error" json:"error"`
+	// set when the schedule is claimed by a scheduler
+	ClaimedAt *time.Time `db:"claimed_at" json:"claimedAt"`
+}
@@ -0,0 +1,214 @@
+package db
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// PublishScheduleService encapsulate the operations on the `publish_schedule` table
+type PublishScheduleService interface {
+	NewSchedule(schedule *PublishSchedule) error
+	Find(id uint32) (PublishSchedule, error)
+	ListPendingByApp(appId uint32) ([]PublishSchedule, error)
+	// ListDue lists pending schedules whose PublishAt has come,
+	// and running ones whose claim has expired, e.g. the scheduler crashed
+	ListDue(now time.Time, lease time.Duration) ([]PublishSchedule, error)
+
+	// Claim marks the due schedule as running and reports whether the caller wins it,
+	// so that a schedule is executed by one scheduler even if many servers are running
+	Claim(id uint32, now time.Time, lease time.Duration) (bool, error)
+	// Finish marks the running schedule as done or failed
+	Finish(id uint32, status string, errMsg string) error
+	// Cancel cancels the pending schedule and reports whether it was still pending
+	Cancel(id uint32) (bool, error)
+}
+
+type publishScheduleService struct {
+	sess  sqlbuilder.Database
+	table db.Collection
+}
+
+const kPublishScheduleTableName = "publish_schedule"
+
+func NewPublishScheduleService(dbConn sqlbuilder.Database) PublishScheduleService {
+	return &publishScheduleService{
+		sess:  dbConn,
+		table: dbConn.Collection(kPublishScheduleTableName),
+	}
+}
+
+func (s *publishScheduleService) NewSchedule(schedule *PublishSchedule) error {
+	schedule.Status = ScheduleStatusPending
+	return s.table.InsertReturning(schedule)
+}
+
+func (s *publishScheduleService) Find(id uint32) (PublishSchedule, error) {
+	res := s.table.Find("id", id)
+	var schedule PublishSchedule
+	err := res.One(&schedule)
+	if errors.Is(err, db.ErrNoMoreRows) {
+		return schedule, ErrNotFound
+	}
+	return schedule, err
+}
+
+func (s *publishScheduleService) ListPendingByApp(appId uint32) ([]PublishSchedule, error) {
+	var schedules []PublishSchedule
+	err := s.table.Find("app_id", appId).And("status", ScheduleStatusPending).
+		OrderBy("publish_at").All(&schedules)
+	return schedules, err
+}
+
+func (s *publishScheduleService) ListDue(now time.Time, lease time.Duration) ([]PublishSchedule, error) {
+	var schedules []PublishSchedule
+	err := s.table.Find(db.Or(
+		db.Cond{"status": ScheduleStatusPending, "publish_at <=": now},
+		db.Cond{"status": ScheduleStatusRunning, "claimed_at <": now.Add(-lease)},
+	)).OrderBy("publish_at").All(&schedules)
+	return schedules, err
+}
+
+// transit updates the schedule matching cond and reports whether it is updated
+func (s *publishScheduleService) transit(cond db.Compound, toUpdate map[string]interface{}) (bool, error) {
+	res, err := s.sess.Update(kPublishScheduleTableName).Set(toUpdate).Where(cond).Exec()
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected == 1, err
+}
+
+func (s *publishScheduleService) Claim(id uint32, now time.Time, lease time.Duration) (bool, error) {
+	return s.transit(db.And(db.Cond{"id": id}, db.Or(
+		db.Cond{"status": ScheduleStatusPending, "publish_at <=": now},
+		db.Cond{"status": ScheduleStatusRunning, "claimed_at <": now.Add(-lease)},
+	)), map[string]interface{}{
+		"status":     ScheduleStatusRunning,
+		"claimed_at": now,
+	})
+}
+
+func (s *publishScheduleService) Finish(id uint32, status string, errMsg string) error {
+	_, err := s.transit(db.Cond{"id": id, "status": ScheduleStatusRunning}, map[string]interface{}{
+		"status": status,
+		"error":  errMsg,
+	})
+	return err
+}
+
+func (s *publishScheduleService) Cancel(id uint32) (bool, error) {
+	return s.transit(db.Cond{"id": id, "status": ScheduleStatusPending}, map[string]interface{}{
+		"status": ScheduleStatusCanceled,
+	})
+}
+
+// schedules are executed in background, so memPublishScheduleService is guarded by mutex
+type memPublishScheduleService struct {
+	mu    sync.Mutex
+	id    uint32
+	table map[uint32]*PublishSchedule
+}
+
+// Used under unit-test enviroment
+func NewMemPublishScheduleService() PublishScheduleService {
+	return &memPublishScheduleService{
+		table: make(map[uint32]*PublishSchedule),
+	}
+}
+
+func (s *memPublishScheduleService) NewSchedule(schedule *PublishSchedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	schedule.ID = s.id
+	schedule.Status = ScheduleStatusPending
+	s.id++
+	copied := *schedule
+	s.table[schedule.ID] = &copied
+	return nil
+}
+
+func (s *memPublishScheduleService) Find(id uint32) (PublishSchedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	schedule, ok := s.table[id]
+	if !ok {
+		return PublishSchedule{}, ErrNotFound
+	} else {
+		return *schedule, nil
+	}
+}
+
+func (s *memPublishScheduleService) list(match func(schedule *PublishSchedule) bool) []PublishSchedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var schedules []PublishSchedule
+	for _, schedule := range s.table {
+		if match(schedule) {
+			schedules = append(schedules, *schedule)
+		}
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].PublishAt.Before(schedules[j].PublishAt)
+	})
+	return schedules
+}
+
+func (s *memPublishScheduleService) ListPendingByApp(appId uint32) ([]PublishSchedule, error) {
+	return s.list(func(schedule *PublishSchedule) bool {
+		return schedule.AppID == appId && schedule.Status == ScheduleStatusPending
+	}), nil
+}
+
+func isDue(schedule *PublishSchedule, now time.Time, lease time.Duration) bool {
+	switch schedule.Status {
+	case ScheduleStatusPending:
+		return !schedule.PublishAt.After(now)
+	case ScheduleStatusRunning:
+		return schedule.ClaimedAt.Before(now.Add(-lease))
+	}
+	return false
+}
+
+func (s *memPublishScheduleService) ListDue(now time.Time, lease time.Duration) ([]PublishSchedule, error) {
+	return s.list(func(schedule *PublishSchedule) bool {
+		return isDue(schedule, now, lease)
+	}), nil
+}
+
+func (s *memPublishScheduleService) Claim(id uint32, now time.Time, lease time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	schedule, ok := s.table[id]
+	if !ok || !isDue(schedule, now, lease) {
+		return false, nil
+	}
+	schedule.Status = ScheduleStatusRunning
+	schedule.ClaimedAt = &now
+	return true, nil
+}
+
+func (s *memPublishScheduleService) Finish(id uint32, status string, errMsg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if schedule, ok := s.table[id]; ok && schedule.Status == ScheduleStatusRunning {
+		schedule.Status = status
+		schedule.Error = errMsg
+	}
+	return nil
+}
+
+func (s *memPublishScheduleService) Cancel(id uint32) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	schedule, ok := s.table[id]
+	if !ok || schedule.Status != ScheduleStatusPending {
+		return false, nil
+	}
+	schedule.Status = ScheduleStatusCanceled
+	return true, nil
+}
@@ -11,6 +11,7 @@ import (
 // UserService encapsulate the operations on the `user` table
 type UserService interface {
 	Find(username string) (User, error)
+	FindByID(id uint32) (User, error)
 	FindByGithubUserName(username string) (User, error)
 
 	Insert(user User) error
@@ -39,6 +40,16 @@ func (s *userService) Find(username string) (User, error) {
 	return user, err
 }
 
+func (s *userService) FindByID(id uint32) (User, error) {
+	res := s.table.Find(db.Cond{"id": id})
+	var user User
+	err := res.One(&user)
+	if errors.Is(err, db.ErrNoMoreRows) {
+		return user, ErrNotFound
+	}
+	return user, err
+}
+
 func (s *userService) FindByGithubUserName(username string) (User, error) {
 	res := s.table.Find(db.Cond{"github_username": username})
 	var user User
@@ -93,6 +104,15 @@ func (s *memUserService) Find(username string) (User, error) {
 	}
 }
 
+func (s *memUserService) FindByID(id uint32) (User, error) {
+	for _, v := range s.table {
+		if v.ID == id {
+			return *v, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
 func (s *memUserService) FindByGithubUserName(username string) (User, error) {
 	var result *User
 	for _, v := range s.table {
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/rtxu/luban-api/config"
 	"github.com/rtxu/luban-api/server"
@@ -35,5 +37,14 @@ func run() error {
 
 	svr := server.New(conf)
 	svr.SetupDBService(dbConn)
+	// re-encrypts the secrets after a new master key is added
+	n, err := svr.RotateSecrets()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("re-encrypted the secrets of %d data sources\n", n)
+	}
+	svr.StartPublishScheduler(context.Background(), 30*time.Second)
 	return http.ListenAndServe(":9090", svr)
 }
@@ -3,6 +3,8 @@ package middleware
 import (
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/middleware"
@@ -52,7 +54,7 @@ func (l *StructuredLogger) NewLogEntry(r *http.Request) middleware.LogEntry {
 	logFields["remote_addr"] = r.RemoteAddr
 	logFields["user_agent"] = r.UserAgent()
 
-	logFields["uri"] = fmt.Sprintf("%s://%s%s", scheme, r.Host, r.RequestURI)
+	logFields["uri"] = fmt.Sprintf("%s://%s%s", scheme, r.Host, RedactURI(r.RequestURI))
 
 	entry.Logger = entry.Logger.WithFields(logFields)
 
@@ -104,3 +106,30 @@ func LogEntrySetFields(r *http.Request, fields map[string]interface{}) {
 		entry.Logger = entry.Logger.WithFields(fields)
 	}
 }
+
+// sensitive query params, e.g. the jwt of WebSocket and EventSource, the code of OAuth
+var redactedParams = []string{"jwt", "code", "access_token", "client_secret", "password"}
+
+// RedactURI replaces the values of sensitive query params in uri, as log lines are not secret
+func RedactURI(uri string) string {
+	i := strings.IndexByte(uri, '?')
+	if i < 0 {
+		return uri
+	}
+	query, err := url.ParseQuery(uri[i+1:])
+	if err != nil {
+		// could not tell the sensitive ones
+		return uri[:i+1] + "REDACTED"
+	}
+	redacted := false
+	for _, name := range redactedParams {
+		if _, ok := query[name]; ok {
+			query.Set(name, "REDACTED")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return uri
+	}
+	return uri[:i+1] + query.Encode()
+}
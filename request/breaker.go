@@ -0,0 +1,118 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without sending the request while the host is considered down
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerPolicy configures the circuit breaker of each host
+type BreakerPolicy struct {
+	// the circuit opens after FailureThreshold consecutive failures
+	FailureThreshold int
+	// an open circuit lets a single probe through after OpenTimeout,
+	// it closes if the probe succeeds and opens again otherwise
+	OpenTimeout time.Duration
+}
+
+// DefaultBreakers is used unless WithBreakers is called
+var DefaultBreakers = NewBreakers(BreakerPolicy{
+	FailureThreshold: 5,
+	OpenTimeout:      30 * time.Second,
+})
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+type circuitBreaker struct {
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+// Breakers holds a circuit breaker for every host, it's safe for concurrent use
+type Breakers struct {
+	policy BreakerPolicy
+	// replaced under unit-test enviroment
+	now func() time.Time
+
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+func NewBreakers(policy BreakerPolicy) *Breakers {
+	return &Breakers{
+		policy:   policy,
+		now:      time.Now,
+		breakers: make(map[string]*circuitBreaker),
+	}
+}
+
+func (b *Breakers) get(host string) *circuitBreaker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cb, ok := b.breakers[host]
+	if !ok {
+		cb = &circuitBreaker{}
+		b.breakers[host] = cb
+	}
+	return cb
+}
+
+// allow returns ErrCircuitOpen if a request to host should not be sent
+func (b *Breakers) allow(host string) error {
+	cb := b.get(host)
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case breakerOpen:
+		if b.now().Sub(cb.openedAt) < b.policy.OpenTimeout {
+			return fmt.Errorf("%w: host(%s)", ErrCircuitOpen, host)
+		}
+		cb.state = breakerHalfOpen
+		return nil
+	case breakerHalfOpen:
+		// a probe is in flight
+		return fmt.Errorf("%w: host(%s)", ErrCircuitOpen, host)
+	}
+	return nil
+}
+
+// record records the result of a request allowed by allow
+func (b *Breakers) record(host string, success bool) {
+	cb := b.get(host)
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if success {
+		cb.state = breakerClosed
+		cb.failures = 0
+		return
+	}
+	cb.failures++
+	if cb.state == breakerHalfOpen || cb.failures >= b.policy.FailureThreshold {
+		cb.state = breakerOpen
+		cb.openedAt = b.now()
+	}
+}
+
+// release gives up a request allowed by allow without a result, e.g. canceled by the caller,
+// so that a probe in flight does not keep the circuit half-open forever
+func (b *Breakers) release(host string) {
+	cb := b.get(host)
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == breakerHalfOpen {
+		// openedAt is kept, the next request is the probe
+		cb.state = breakerOpen
+	}
+}
@@ -0,0 +1,73 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	assert := assert.New(t)
+	var healthy, count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	breakers := NewBreakers(BreakerPolicy{FailureThreshold: 2, OpenTimeout: time.Minute})
+	now := time.Now()
+	breakers.now = func() time.Time { return now }
+	get := func() error {
+		var v struct{}
+		return Get(ts.URL).WithRetry(NoRetry).WithBreakers(breakers).DecodeJSON(&v)
+	}
+
+	// opens after consecutive failures
+	assert.Error(get())
+	assert.Error(get())
+	assert.True(errors.Is(get(), ErrCircuitOpen))
+	assert.Equal(int32(2), atomic.LoadInt32(&count))
+
+	// a failed probe opens it again
+	now = now.Add(time.Minute)
+	assert.False(errors.Is(get(), ErrCircuitOpen))
+	assert.True(errors.Is(get(), ErrCircuitOpen))
+	assert.Equal(int32(3), atomic.LoadInt32(&count))
+
+	// a successful probe closes it
+	atomic.StoreInt32(&healthy, 1)
+	now = now.Add(time.Minute)
+	assert.NoError(get())
+	assert.NoError(get())
+	assert.Equal(int32(5), atomic.LoadInt32(&count))
+
+	// circuit open is not retried
+	atomic.StoreInt32(&healthy, 0)
+	get()
+	get()
+	var v struct{}
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	err := Get(ts.URL).WithRetry(policy).WithBreakers(breakers).DecodeJSON(&v)
+	assert.True(errors.Is(err, ErrCircuitOpen))
+	assert.Equal(int32(7), atomic.LoadInt32(&count))
+
+	// a canceled probe lets the next request probe again
+	now = now.Add(time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = Get(ts.URL).WithContext(ctx).WithRetry(NoRetry).WithBreakers(breakers).DecodeJSON(&v)
+	assert.True(errors.Is(err, context.Canceled))
+	atomic.StoreInt32(&healthy, 1)
+	assert.NoError(get())
+}
@@ -0,0 +1,271 @@
+package request
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Recorder 是一个可录制、回放的 http.RoundTripper，用于测试调用外部服务的代码：
+// 录制模式下转发真实请求并记录交互，回放模式下从 fixture 文件中查找匹配的交互作为响应，
+// 无需访问网络
+//
+//	rec, _ := request.NewRecorder("testdata/github.json", request.ModeReplay)
+//	request.Get(url).WithClient(rec.Client())
+type Recorder struct {
+	mode Mode
+	path string
+	// sends the requests in ModeRecord, http.DefaultTransport if nil
+	Transport http.RoundTripper
+	// DefaultMatcher if nil
+	Matcher Matcher
+	// values of these query params, form fields, JSON fields and headers are
+	// replaced by kRedacted before being recorded or matched
+	Redact []string
+
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+type Mode int
+
+const (
+	ModeReplay Mode = iota
+	ModeRecord
+)
+
+const kRedacted = "REDACTED"
+
+// DefaultRedact covers the secrets of OAuth flows
+var DefaultRedact = []string{"client_secret", "access_token", "Authorization"}
+
+// ErrNoInteraction is returned in ModeReplay when no recorded interaction matches the request
+var ErrNoInteraction = errors.New("no recorded interaction matches")
+
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// Interaction is a request and its response, both redacted
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// Matcher reports whether a redacted request matches a recorded one
+type Matcher func(req, recorded RecordedRequest) bool
+
+// DefaultMatcher matches method, URL and body
+func DefaultMatcher(req, recorded RecordedRequest) bool {
+	return req.Method == recorded.Method && req.URL == recorded.URL && req.Body == recorded.Body
+}
+
+// MethodURLMatcher ignores the body, for requests whose body varies between runs
+func MethodURLMatcher(req, recorded RecordedRequest) bool {
+	return req.Method == recorded.Method && req.URL == recorded.URL
+}
+
+// NewRecorder loads interactions from the fixture file at path in ModeReplay,
+// the file is written by Save in ModeRecord
+func NewRecorder(path string, mode Mode) (*Recorder, error) {
+	rec := &Recorder{
+		mode:   mode,
+		path:   path,
+		Redact: DefaultRedact,
+	}
+	if mode == ModeReplay {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &rec.interactions); err != nil {
+			return nil, fmt.Errorf("decode fixture(%s), err: %w", path, err)
+		}
+		rec.used = make([]bool, len(rec.interactions))
+	}
+	return rec, nil
+}
+
+// Client returns an http client which sends requests through rec
+func (rec *Recorder) Client() *http.Client {
+	return &http.Client{Transport: rec, Timeout: DefaultTimeout}
+}
+
+func (rec *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	recordedReq := RecordedRequest{
+		Method: req.Method,
+		URL:    rec.redactURL(req.URL),
+		Header: rec.redactHeader(req.Header),
+		Body:   rec.redactBody(req.Header.Get("Content-Type"), body),
+	}
+
+	if rec.mode == ModeReplay {
+		return rec.replay(req, recordedReq)
+	}
+	return rec.record(req, recordedReq)
+}
+
+func (rec *Recorder) replay(req *http.Request, recordedReq RecordedRequest) (*http.Response, error) {
+	match := rec.Matcher
+	if match == nil {
+		match = DefaultMatcher
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	for i, interaction := range rec.interactions {
+		if rec.used[i] || !match(recordedReq, interaction.Request) {
+			continue
+		}
+		// each interaction is replayed once, so that repeated requests get responses in order
+		rec.used[i] = true
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", interaction.Response.StatusCode, http.StatusText(interaction.Response.StatusCode)),
+			StatusCode:    interaction.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        interaction.Response.Header,
+			Body:          ioutil.NopCloser(bytes.NewReader([]byte(interaction.Response.Body))),
+			ContentLength: int64(len(interaction.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: %s %s", ErrNoInteraction, recordedReq.Method, recordedReq.URL)
+}
+
+func (rec *Recorder) record(req *http.Request, recordedReq RecordedRequest) (*http.Response, error) {
+	transport := rec.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	rec.mu.Lock()
+	rec.interactions = append(rec.interactions, Interaction{
+		Request: recordedReq,
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     rec.redactHeader(resp.Header),
+			Body:       rec.redactBody(resp.Header.Get("Content-Type"), body),
+		},
+	})
+	rec.mu.Unlock()
+
+	// the caller sees the real response
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// Save writes the recorded interactions to the fixture file, it's a no-op in ModeReplay
+func (rec *Recorder) Save() error {
+	if rec.mode != ModeRecord {
+		return nil
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	b, err := json.MarshalIndent(rec.interactions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(rec.path, b, 0644)
+}
+
+func (rec *Recorder) shouldRedact(key string) bool {
+	for _, k := range rec.Redact {
+		if http.CanonicalHeaderKey(k) == http.CanonicalHeaderKey(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rec *Recorder) redactValues(values url.Values) url.Values {
+	redacted := make(url.Values, len(values))
+	for key, vs := range values {
+		if rec.shouldRedact(key) {
+			vs = []string{kRedacted}
+		}
+		redacted[key] = vs
+	}
+	return redacted
+}
+
+func (rec *Recorder) redactURL(u *url.URL) string {
+	redacted := *u
+	// Encode sorts params by key, which makes matching independent of the param order
+	redacted.RawQuery = rec.redactValues(u.Query()).Encode()
+	return redacted.String()
+}
+
+func (rec *Recorder) redactHeader(header http.Header) http.Header {
+	if len(header) == 0 {
+		return nil
+	}
+	redacted := make(http.Header, len(header))
+	for key, vs := range header {
+		if rec.shouldRedact(key) {
+			vs = []string{kRedacted}
+		}
+		redacted[key] = vs
+	}
+	return redacted
+}
+
+// redactBody redacts form fields and top-level JSON fields, other bodies are kept as they are
+func (rec *Recorder) redactBody(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	switch {
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		if values, err := url.ParseQuery(string(body)); err == nil {
+			return rec.redactValues(values).Encode()
+		}
+	case strings.HasPrefix(contentType, "application/json"):
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(body, &obj); err == nil {
+			for key := range obj {
+				if rec.shouldRedact(key) {
+					obj[key] = json.RawMessage(`"` + kRedacted + `"`)
+				}
+			}
+			// Marshal sorts keys as well
+			b, _ := json.Marshal(obj)
+			return string(b)
+		}
+	}
+	return string(body)
+}
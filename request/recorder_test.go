@@ -0,0 +1,76 @@
+package request
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorder(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "recorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fixture := filepath.Join(dir, "fixture.json")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"real_token","echo":` + string(body) + `}`))
+	}))
+	type respT struct {
+		AccessToken string            `json:"access_token"`
+		Echo        map[string]string `json:"echo"`
+	}
+	call := func(client *http.Client, name string) (respT, error) {
+		var resp respT
+		err := Post(ts.URL+"/token").
+			WithClient(client).
+			WithBreakers(nil).
+			Query("client_secret", "real_secret").
+			JSON(map[string]string{"name": name}).
+			DecodeJSON(&resp)
+		return resp, err
+	}
+
+	// record
+	rec, err := NewRecorder(fixture, ModeRecord)
+	assert.NoError(err)
+	resp, err := call(rec.Client(), "a")
+	assert.NoError(err)
+	assert.Equal("real_token", resp.AccessToken)
+	assert.NoError(rec.Save())
+	ts.Close()
+
+	saved, _ := ioutil.ReadFile(fixture)
+	assert.NotContains(string(saved), "real_secret")
+	assert.NotContains(string(saved), "real_token")
+
+	// replay without the server
+	rec, err = NewRecorder(fixture, ModeReplay)
+	assert.NoError(err)
+	resp, err = call(rec.Client(), "a")
+	assert.NoError(err)
+	assert.Equal(kRedacted, resp.AccessToken)
+	assert.Equal(map[string]string{"name": "a"}, resp.Echo)
+
+	// each interaction is replayed once
+	_, err = call(rec.Client(), "a")
+	assert.True(errors.Is(err, ErrNoInteraction))
+
+	// the body is matched by default
+	rec, _ = NewRecorder(fixture, ModeReplay)
+	_, err = call(rec.Client(), "b")
+	assert.True(errors.Is(err, ErrNoInteraction))
+	rec.Matcher = MethodURLMatcher
+	_, err = call(rec.Client(), "b")
+	assert.NoError(err)
+}
@@ -1,2 +1,265 @@
 // 封装一个 http client 请求库，简化调用外部 RESTful 服务的过程
+//
+//	var user userT
+//	err := request.Get("https://api.github.com/user").
+//		WithContext(r.Context()).
+//		Header("Authorization", "token xxx").
+//		DecodeJSON(&user)
 package request
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+const (
+	// DefaultTimeout bounds the whole exchange of a request, including reading the body
+	DefaultTimeout = 10 * time.Second
+	// RequestIDHeader carries the request id of the incoming request to external services,
+	// so that logs of both sides could be correlated
+	RequestIDHeader = "X-Request-Id"
+
+	// at most kMaxErrorBodySize bytes of the body are captured in Error
+	kMaxErrorBodySize = 4096
+)
+
+// DefaultClient is used unless WithClient is called
+var DefaultClient = &http.Client{Timeout: DefaultTimeout}
+
+// Error is returned when the response status is not 2xx
+type Error struct {
+	Method string
+	// params added by Query are left out, as they may carry secrets
+	URL        string
+	StatusCode int
+	// the beginning of the response body, for diagnosis
+	Body []byte
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: unexpected status %d, body: %s",
+		e.Method, e.URL, e.StatusCode, e.Body)
+}
+
+// Request is a fluent builder of an http request, the first error while building
+// is kept and returned by Do
+type Request struct {
+	client *http.Client
+	ctx    context.Context
+	method string
+	url    string
+	query  url.Values
+	header http.Header
+	// kept as bytes to be sent again on retry
+	body     []byte
+	retry    RetryPolicy
+	breakers *Breakers
+	err      error
+}
+
+func New(method, rawurl string) *Request {
+	return &Request{
+		client:   DefaultClient,
+		ctx:      context.Background(),
+		method:   method,
+		url:      rawurl,
+		query:    make(url.Values),
+		header:   make(http.Header),
+		retry:    DefaultRetryPolicy,
+		breakers: DefaultBreakers,
+	}
+}
+
+func Get(rawurl string) *Request {
+	return New(http.MethodGet, rawurl)
+}
+
+func Post(rawurl string) *Request {
+	return New(http.MethodPost, rawurl)
+}
+
+func Put(rawurl string) *Request {
+	return New(http.MethodPut, rawurl)
+}
+
+func Delete(rawurl string) *Request {
+	return New(http.MethodDelete, rawurl)
+}
+
+// WithContext propagates the cancellation of ctx to the request,
+// and forwards the request id in ctx if any
+func (req *Request) WithContext(ctx context.Context) *Request {
+	req.ctx = ctx
+	if reqID := middleware.GetReqID(ctx); reqID != "" {
+		req.header.Set(RequestIDHeader, reqID)
+	}
+	return req
+}
+
+func (req *Request) WithClient(client *http.Client) *Request {
+	req.client = client
+	return req
+}
+
+func (req *Request) WithRetry(policy RetryPolicy) *Request {
+	req.retry = policy
+	return req
+}
+
+// WithBreakers replaces the circuit breakers, nil disables circuit breaking
+func (req *Request) WithBreakers(breakers *Breakers) *Request {
+	req.breakers = breakers
+	return req
+}
+
+// Query adds a query param, which is appended to those in the url
+func (req *Request) Query(key, value string) *Request {
+	req.query.Add(key, value)
+	return req
+}
+
+func (req *Request) Header(key, value string) *Request {
+	req.header.Set(key, value)
+	return req
+}
+
+// JSON encodes v as the request body
+func (req *Request) JSON(v interface{}) *Request {
+	b, err := json.Marshal(v)
+	if err != nil {
+		if req.err == nil {
+			req.err = fmt.Errorf("encode request body, err: %w", err)
+		}
+		return req
+	}
+	req.body = b
+	req.header.Set("Content-Type", "application/json")
+	return req
+}
+
+func (req *Request) buildURL() (*url.URL, error) {
+	u, err := url.Parse(req.url)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.query) > 0 {
+		query := u.Query()
+		for key, values := range req.query {
+			for _, value := range values {
+				query.Add(key, value)
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u, nil
+}
+
+// Do sends the request, retrying according to the retry policy.
+// The caller should close the body of the returned response.
+// A non-2xx response is consumed and returned as *Error.
+func (req *Request) Do() (*http.Response, error) {
+	if req.err != nil {
+		return nil, req.err
+	}
+	u, err := req.buildURL()
+	if err != nil {
+		return nil, err
+	}
+	maxAttempts := req.retry.maxAttempts(req.method)
+	for n := 1; ; n++ {
+		resp, err := req.send(u)
+		if n >= maxAttempts || !req.retry.shouldRetry(resp, err) {
+			return req.result(resp, err)
+		}
+		delay, ok := req.retry.delay(n, resp)
+		if !ok {
+			return req.result(resp, err)
+		}
+		if resp != nil {
+			// drain the body to reuse the connection
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, kMaxErrorBodySize))
+			resp.Body.Close()
+		}
+		if err := sleep(req.ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// send makes a single attempt guarded by the circuit breaker of the host
+func (req *Request) send(u *url.URL) (*http.Response, error) {
+	if req.breakers != nil {
+		if err := req.breakers.allow(u.Host); err != nil {
+			return nil, err
+		}
+	}
+	var body io.Reader
+	if req.body != nil {
+		body = bytes.NewReader(req.body)
+	}
+	httpReq, err := http.NewRequest(req.method, u.String(), body)
+	if err != nil {
+		if req.breakers != nil {
+			req.breakers.release(u.Host)
+		}
+		return nil, err
+	}
+	httpReq.Header = req.header
+	resp, err := req.client.Do(httpReq.WithContext(req.ctx))
+	if req.breakers != nil {
+		if req.ctx.Err() != nil {
+			// requests canceled by the caller say nothing about the host
+			req.breakers.release(u.Host)
+		} else {
+			// the host is healthy as long as it responds, except for server errors
+			req.breakers.record(u.Host, err == nil && resp.StatusCode < 500)
+		}
+	}
+	return resp, err
+}
+
+func (req *Request) result(resp *http.Response, err error) (*http.Response, error) {
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			// the same as Error, leave out params added by Query
+			urlErr.URL = req.url
+		}
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, kMaxErrorBodySize))
+		return nil, &Error{
+			Method:     req.method,
+			URL:        req.url,
+			StatusCode: resp.StatusCode,
+			Body:       body,
+		}
+	}
+	return resp, nil
+}
+
+// DecodeJSON sends the request and decodes the response body into v
+func (req *Request) DecodeJSON(v interface{}) error {
+	if req.header.Get("Accept") == "" {
+		req.header.Set("Accept", "application/json")
+	}
+	resp, err := req.Do()
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decode response body, err: %w", err)
+	}
+	return nil
+}
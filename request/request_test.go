@@ -0,0 +1,147 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequest(t *testing.T) {
+	assert := assert.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/echo":
+			body, _ := ioutil.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"query":"` + r.URL.RawQuery + `","requestId":"` +
+				r.Header.Get(RequestIDHeader) + `","body":` + string(body) + `}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("no such page"))
+		}
+	}))
+	defer ts.Close()
+
+	// query, JSON body and request id forwarding
+	ctx := context.WithValue(context.Background(), middleware.RequestIDKey, "req-1")
+	var echo struct {
+		Query     string            `json:"query"`
+		RequestId string            `json:"requestId"`
+		Body      map[string]string `json:"body"`
+	}
+	err := Post(ts.URL+"/echo?a=1").
+		WithContext(ctx).
+		Query("b", "2").
+		JSON(map[string]string{"k": "v"}).
+		DecodeJSON(&echo)
+	assert.NoError(err)
+	assert.Equal("a=1&b=2", echo.Query)
+	assert.Equal("req-1", echo.RequestId)
+	assert.Equal(map[string]string{"k": "v"}, echo.Body)
+
+	// non-2xx is reported as *Error without the query params
+	err = Get(ts.URL+"/missing").Query("secret", "s").DecodeJSON(&echo)
+	var reqErr *Error
+	assert.True(errors.As(err, &reqErr))
+	assert.Equal(http.StatusNotFound, reqErr.StatusCode)
+	assert.Equal("no such page", string(reqErr.Body))
+	assert.NotContains(err.Error(), "secret")
+
+	// canceled context
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = Get(ts.URL + "/echo").WithContext(canceled).DecodeJSON(&echo)
+	assert.True(errors.Is(err, context.Canceled))
+
+	// encode error is returned by Do
+	err = Post(ts.URL + "/echo").JSON(make(chan int)).DecodeJSON(&echo)
+	assert.Error(err)
+}
+
+func TestRequestHeader(t *testing.T) {
+	assert := assert.New(t)
+	var header http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	// no request id in ctx
+	var v struct{}
+	assert.NoError(Get(ts.URL).WithContext(context.Background()).DecodeJSON(&v))
+	assert.Empty(header.Get(RequestIDHeader))
+	assert.Equal("application/json", header.Get("Accept"))
+
+	// the request id of the incoming request is forwarded, Accept set by the caller is kept
+	ctx := context.WithValue(context.Background(), middleware.RequestIDKey, "req-2")
+	assert.NoError(Get(ts.URL).
+		WithContext(ctx).
+		Header("Accept", "application/vnd.github.v3+json").
+		Header("Authorization", "token t").
+		DecodeJSON(&v))
+	assert.Equal("req-2", header.Get(RequestIDHeader))
+	assert.Equal("application/vnd.github.v3+json", header.Get("Accept"))
+	assert.Equal("token t", header.Get("Authorization"))
+
+	// sent by the client given
+	var sent int
+	client := &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		sent++
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+	resp, err := Delete(ts.URL).WithClient(client).Do()
+	assert.NoError(err)
+	resp.Body.Close()
+	assert.Equal(1, sent)
+	assert.Equal(http.MethodDelete, resp.Request.Method)
+}
+
+type roundTripperFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestRequestError(t *testing.T) {
+	assert := assert.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(strings.Repeat("x", kMaxErrorBodySize+1)))
+	}))
+	defer ts.Close()
+
+	_, err := Put(ts.URL+"/v1").Query("token", "s3cret").WithRetry(NoRetry).Do()
+	var reqErr *Error
+	assert.True(errors.As(err, &reqErr))
+	assert.Equal(http.MethodPut, reqErr.Method)
+	assert.Equal(ts.URL+"/v1", reqErr.URL)
+	assert.Equal(http.StatusBadRequest, reqErr.StatusCode)
+	// only the beginning of the body is kept
+	assert.Len(reqErr.Body, kMaxErrorBodySize)
+	assert.True(strings.HasPrefix(err.Error(), "PUT "+ts.URL+"/v1: unexpected status 400, body: xxx"))
+
+	// the same for transport errors
+	ts.Close()
+	_, err = Get(ts.URL+"/v1").Query("token", "s3cret").WithRetry(NoRetry).WithBreakers(nil).Do()
+	assert.Error(err)
+	assert.False(errors.As(err, &reqErr))
+	assert.NotContains(err.Error(), "s3cret")
+
+	// the response is not JSON
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>"))
+	}))
+	defer ts.Close()
+	var v struct{}
+	err = Get(ts.URL).DecodeJSON(&v)
+	assert.Error(err)
+	assert.Contains(err.Error(), "decode response body")
+}
@@ -0,0 +1,129 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy decides whether and when a failed request is sent again
+type RetryPolicy struct {
+	// including the first attempt, retry is disabled when it's less than 2
+	MaxAttempts int
+	// delay before the n-th retry is BaseDelay * 2^(n-1) with jitter, capped by MaxDelay.
+	// Retry-After of the response takes precedence, and no retry happens if it exceeds MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// non-idempotent methods such as POST are not retried unless it's set,
+	// as the server may have handled the failed request
+	RetryNonIdempotent bool
+	// reports whether the result of an attempt is worth retrying, defaultShouldRetry if nil
+	ShouldRetry func(resp *http.Response, err error) bool
+}
+
+// DefaultRetryPolicy is used unless WithRetry is called
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    5 * time.Second,
+}
+
+// NoRetry sends a request only once
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// jitter spreads retries of different clients, replaced under unit-test enviroment
+var jitter = func(d time.Duration) time.Duration {
+	// randomize within [d/2, d]
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// defaultShouldRetry retries network errors and responses which indicate a transient failure
+func defaultShouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, ErrCircuitOpen) &&
+			!errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func (p RetryPolicy) maxAttempts(method string) int {
+	if p.MaxAttempts < 2 || (!p.RetryNonIdempotent && !isIdempotent(method)) {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+func (p RetryPolicy) shouldRetry(resp *http.Response, err error) bool {
+	if p.ShouldRetry != nil {
+		return p.ShouldRetry(resp, err)
+	}
+	return defaultShouldRetry(resp, err)
+}
+
+// delay returns how long to wait before the retry after the n-th attempt,
+// false means the retry should be given up
+func (p RetryPolicy) delay(n int, resp *http.Response) (time.Duration, bool) {
+	if resp != nil {
+		if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			return d, d <= p.MaxDelay
+		}
+	}
+	d := p.BaseDelay
+	for i := 1; i < n && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return jitter(d), true
+}
+
+// parseRetryAfter parses either delay-seconds or an HTTP-date
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// sleep waits for d unless ctx is done first
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,109 @@
+package request
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyServer fails the first `failures` requests with status
+func flakyServer(failures int32, status int, header http.Header) (*httptest.Server, *int32) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) <= failures {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	return ts, &count
+}
+
+// noJitter disables jitter and returns the func to restore it
+func noJitter() func() {
+	saved := jitter
+	jitter = func(d time.Duration) time.Duration { return d }
+	return func() { jitter = saved }
+}
+
+func TestRetry(t *testing.T) {
+	assert := assert.New(t)
+	defer noJitter()()
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	var v struct{}
+
+	// transient failures are retried
+	ts, count := flakyServer(2, http.StatusServiceUnavailable, nil)
+	assert.NoError(Get(ts.URL).WithRetry(policy).WithBreakers(nil).DecodeJSON(&v))
+	assert.Equal(int32(3), *count)
+	ts.Close()
+
+	// until MaxAttempts
+	ts, count = flakyServer(3, http.StatusBadGateway, nil)
+	err := Get(ts.URL).WithRetry(policy).WithBreakers(nil).DecodeJSON(&v)
+	var reqErr *Error
+	assert.True(errors.As(err, &reqErr))
+	assert.Equal(http.StatusBadGateway, reqErr.StatusCode)
+	assert.Equal(int32(3), *count)
+	ts.Close()
+
+	// non-transient failures are not retried
+	ts, count = flakyServer(1, http.StatusBadRequest, nil)
+	assert.Error(Get(ts.URL).WithRetry(policy).WithBreakers(nil).DecodeJSON(&v))
+	assert.Equal(int32(1), *count)
+	ts.Close()
+
+	// non-idempotent methods are not retried by default
+	ts, count = flakyServer(1, http.StatusServiceUnavailable, nil)
+	assert.Error(Post(ts.URL).WithRetry(policy).WithBreakers(nil).DecodeJSON(&v))
+	assert.Equal(int32(1), *count)
+	nonIdempotent := policy
+	nonIdempotent.RetryNonIdempotent = true
+	assert.NoError(Post(ts.URL).WithRetry(nonIdempotent).WithBreakers(nil).DecodeJSON(&v))
+	ts.Close()
+
+	// Retry-After exceeding MaxDelay gives up
+	ts, count = flakyServer(1, http.StatusTooManyRequests, http.Header{"Retry-After": {"60"}})
+	assert.Error(Get(ts.URL).WithRetry(policy).WithBreakers(nil).DecodeJSON(&v))
+	assert.Equal(int32(1), *count)
+	ts.Close()
+
+	// Retry-After within MaxDelay is honored
+	ts, count = flakyServer(1, http.StatusTooManyRequests, http.Header{"Retry-After": {"0"}})
+	assert.NoError(Get(ts.URL).WithRetry(policy).WithBreakers(nil).DecodeJSON(&v))
+	assert.Equal(int32(2), *count)
+	ts.Close()
+}
+
+func TestRetryDelay(t *testing.T) {
+	assert := assert.New(t)
+	defer noJitter()()
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	for n, expected := range []time.Duration{
+		100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond,
+		800 * time.Millisecond, time.Second, time.Second,
+	} {
+		d, ok := policy.delay(n+1, nil)
+		assert.True(ok)
+		assert.Equal(expected, d)
+	}
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	d, ok := parseRetryAfter("Wed, 01 Jan 2020 00:00:03 GMT", now)
+	assert.True(ok)
+	assert.Equal(3*time.Second, d)
+	d, ok = parseRetryAfter("2", now)
+	assert.True(ok)
+	assert.Equal(2*time.Second, d)
+	_, ok = parseRetryAfter("soon", now)
+	assert.False(ok)
+}
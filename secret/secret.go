@@ -0,0 +1,226 @@
+// 使用信封加密（envelope encryption）保护落库的密钥类数据：
+// 每条数据由随机生成的 data key 加密，data key 再由配置中的 master key 加密后与密文一同保存。
+// 轮换 master key 时只需重新加密 data key，无需重新加密数据本身
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	kVersion = "v1"
+	// AES-256
+	KeySize = 32
+)
+
+var (
+	ErrNoKey      = errors.New("no master key")
+	ErrUnknownKey = errors.New("unknown master key")
+	ErrMalformed  = errors.New("malformed ciphertext")
+)
+
+// Key is a master key, ID is saved along with the ciphertext to find the key when decrypting
+type Key struct {
+	ID  string
+	Key []byte
+}
+
+// Keyring holds the master keys, the first one is the primary key which encrypts new data,
+// the others are kept to decrypt data encrypted before the rotation
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+func NewKeyring(keys []Key) (*Keyring, error) {
+	kr := &Keyring{keys: make(map[string]cipher.AEAD)}
+	for i, key := range keys {
+		if key.ID == "" || strings.Contains(key.ID, ".") {
+			return nil, fmt.Errorf("invalid key id(%s), it should be non-empty without '.'", key.ID)
+		}
+		if _, ok := kr.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id(%s)", key.ID)
+		}
+		if len(key.Key) != KeySize {
+			return nil, fmt.Errorf("key(%s) should be %d bytes", key.ID, KeySize)
+		}
+		aead, err := newAEAD(key.Key)
+		if err != nil {
+			return nil, err
+		}
+		kr.keys[key.ID] = aead
+		if i == 0 {
+			kr.primary = key.ID
+		}
+	}
+	return kr, nil
+}
+
+// ParseKey decodes a base64 encoded master key
+func ParseKey(id, encoded string) (Key, error) {
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return Key{}, fmt.Errorf("key(%s) is not base64 encoded, err: %v", id, err)
+	}
+	return Key{ID: id, Key: b}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, data := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return plaintext, nil
+}
+
+type envelope struct {
+	keyID      string
+	wrappedKey []byte
+	data       []byte
+}
+
+func (e envelope) String() string {
+	return strings.Join([]string{
+		kVersion,
+		e.keyID,
+		base64.RawURLEncoding.EncodeToString(e.wrappedKey),
+		base64.RawURLEncoding.EncodeToString(e.data),
+	}, ".")
+}
+
+func parseEnvelope(ciphertext string) (envelope, error) {
+	parts := strings.Split(ciphertext, ".")
+	if len(parts) != 4 || parts[0] != kVersion {
+		return envelope{}, ErrMalformed
+	}
+	wrappedKey, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return envelope{}, ErrMalformed
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return envelope{}, ErrMalformed
+	}
+	return envelope{keyID: parts[1], wrappedKey: wrappedKey, data: data}, nil
+}
+
+func (kr *Keyring) key(id string) (cipher.AEAD, error) {
+	if kr == nil || kr.primary == "" {
+		return nil, ErrNoKey
+	}
+	aead, ok := kr.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+	return aead, nil
+}
+
+// Encrypt encrypts plaintext by a new data key, which is encrypted by the primary key
+func (kr *Keyring) Encrypt(plaintext []byte) (string, error) {
+	kek, err := kr.key(kr.primaryID())
+	if err != nil {
+		return "", err
+	}
+	dataKey := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	dek, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	data, err := seal(dek, plaintext)
+	if err != nil {
+		return "", err
+	}
+	wrappedKey, err := seal(kek, dataKey)
+	if err != nil {
+		return "", err
+	}
+	return envelope{keyID: kr.primary, wrappedKey: wrappedKey, data: data}.String(), nil
+}
+
+func (kr *Keyring) Decrypt(ciphertext string) ([]byte, error) {
+	e, err := parseEnvelope(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	kek, err := kr.key(e.keyID)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := open(kek, e.wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	dek, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return open(dek, e.data)
+}
+
+// NeedsRotation reports whether ciphertext is not encrypted by the primary key
+func (kr *Keyring) NeedsRotation(ciphertext string) bool {
+	e, err := parseEnvelope(ciphertext)
+	return err == nil && e.keyID != kr.primaryID()
+}
+
+// Rotate re-encrypts the data key of ciphertext by the primary key, the data is untouched
+func (kr *Keyring) Rotate(ciphertext string) (string, error) {
+	e, err := parseEnvelope(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	kek, err := kr.key(e.keyID)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := open(kek, e.wrappedKey)
+	if err != nil {
+		return "", err
+	}
+	primary, err := kr.key(kr.primaryID())
+	if err != nil {
+		return "", err
+	}
+	if e.wrappedKey, err = seal(primary, dataKey); err != nil {
+		return "", err
+	}
+	e.keyID = kr.primary
+	return e.String(), nil
+}
+
+func (kr *Keyring) primaryID() string {
+	if kr == nil {
+		return ""
+	}
+	return kr.primary
+}
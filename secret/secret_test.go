@@ -0,0 +1,70 @@
+package secret
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestKey(id string, b byte) Key {
+	return Key{ID: id, Key: bytes.Repeat([]byte{b}, KeySize)}
+}
+
+func TestKeyring(t *testing.T) {
+	assert := assert.New(t)
+
+	// invalid keys
+	_, err := NewKeyring([]Key{{ID: "k1", Key: []byte("short")}})
+	assert.Error(err)
+	_, err = NewKeyring([]Key{newTestKey("k.1", 1)})
+	assert.Error(err)
+	_, err = NewKeyring([]Key{newTestKey("k1", 1), newTestKey("k1", 2)})
+	assert.Error(err)
+	_, err = ParseKey("k1", "not base64")
+	assert.Error(err)
+
+	old, _ := NewKeyring([]Key{newTestKey("k1", 1)})
+	ciphertext, err := old.Encrypt([]byte("password"))
+	assert.NoError(err)
+	assert.NotContains(ciphertext, "password")
+	// a new data key every time
+	another, _ := old.Encrypt([]byte("password"))
+	assert.NotEqual(ciphertext, another)
+	plaintext, err := old.Decrypt(ciphertext)
+	assert.NoError(err)
+	assert.Equal("password", string(plaintext))
+	assert.False(old.NeedsRotation(ciphertext))
+
+	// tampered
+	parts := strings.Split(ciphertext, ".")
+	parts[3] = parts[3][:len(parts[3])-2] + "AA"
+	_, err = old.Decrypt(strings.Join(parts, "."))
+	assert.True(errors.Is(err, ErrMalformed))
+	_, err = old.Decrypt("password")
+	assert.True(errors.Is(err, ErrMalformed))
+
+	// rotation
+	rotated, _ := NewKeyring([]Key{newTestKey("k2", 2), newTestKey("k1", 1)})
+	plaintext, err = rotated.Decrypt(ciphertext)
+	assert.NoError(err)
+	assert.Equal("password", string(plaintext))
+	assert.True(rotated.NeedsRotation(ciphertext))
+	ciphertext, err = rotated.Rotate(ciphertext)
+	assert.NoError(err)
+	assert.False(rotated.NeedsRotation(ciphertext))
+	// the old key could be dropped after rotation
+	current, _ := NewKeyring([]Key{newTestKey("k2", 2)})
+	plaintext, err = current.Decrypt(ciphertext)
+	assert.NoError(err)
+	assert.Equal("password", string(plaintext))
+	_, err = current.Decrypt(another)
+	assert.True(errors.Is(err, ErrUnknownKey))
+
+	// no key configured
+	empty, _ := NewKeyring(nil)
+	_, err = empty.Encrypt([]byte("password"))
+	assert.True(errors.Is(err, ErrNoKey))
+}
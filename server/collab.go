@@ -0,0 +1,368 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/**
+协同编辑协议，每条消息均为一个 JSON 对象，以 type 区分
+
+client -> server:
+  hello: 连接建立后的第一条消息，{"type": "hello", "epoch": "...", "seq": N}
+         首次连接时 epoch 为空；重连时带上断开前最后一次收到的 epoch 和 seq，
+         server 会补发 seq 之后的 op，无法补发时则下发 snapshot
+  op:    {"type": "op", "clientSeq": K, "op": {"path": ["widgets", "w1"], "value": {...}}}
+         op.delete 为 true 时删除 path 对应的值
+
+server -> client:
+  snapshot: {"type": "snapshot", "epoch": "...", "seq": N, "content": {...}}
+            草稿被 REST 接口保存时也会下发给所有编辑者
+  op:       {"type": "op", "seq": N, "userId": U, "clientSeq": K, "op": {...}}
+            按 seq 顺序广播给所有编辑者，包括发送者本身（即 ack）
+  error:    {"type": "error", "clientSeq": K, "msg": "..."}
+            op 非法或 app 被他人锁定时只回复发送者，失去 editor 角色时回复后断开连接
+
+server 定期 ping，未及时回复 pong 或消息超过 kCollabMaxMessageSize 的连接会被断开
+*/
+const (
+	kCollabMsgHello    = "hello"
+	kCollabMsgSnapshot = "snapshot"
+	kCollabMsgOp       = "op"
+	kCollabMsgError    = "error"
+
+	// how many ops are kept for replaying to reconnected editors,
+	// it should be less than kCollabSendBufferSize to replay at once
+	kCollabLogSize = 200
+	// an editor who could not keep up with the broadcast is disconnected
+	kCollabSendBufferSize = 256
+	kCollabWriteTimeout   = 10 * time.Second
+	// editors are pinged, and disconnected if no pong arrives within kCollabPongTimeout
+	kCollabPongTimeout  = 60 * time.Second
+	kCollabPingInterval = kCollabPongTimeout * 9 / 10
+	// an op may carry the whole content
+	kCollabMaxMessageSize = 4 << 20
+)
+
+// collabOpT sets or deletes the value at path of the app content
+type collabOpT struct {
+	Path   []string        `json:"path"`
+	Value  json.RawMessage `json:"value,omitempty"`
+	Delete bool            `json:"delete,omitempty"`
+}
+
+type collabMsgT struct {
+	Type      string          `json:"type"`
+	Epoch     string          `json:"epoch,omitempty"`
+	Seq       uint64          `json:"seq"`
+	UserId    uint32          `json:"userId,omitempty"`
+	ClientSeq uint64          `json:"clientSeq,omitempty"`
+	Op        *collabOpT      `json:"op,omitempty"`
+	Content   json.RawMessage `json:"content,omitempty"`
+	Msg       string          `json:"msg,omitempty"`
+}
+
+// applyCollabOp applies op to doc in place and returns the new doc,
+// path walks through JSON objects only
+func applyCollabOp(doc interface{}, op collabOpT) (interface{}, error) {
+	var value interface{}
+	if !op.Delete {
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return doc, fmt.Errorf("invalid value, err: %v", err)
+		}
+	}
+	if len(op.Path) == 0 {
+		if op.Delete {
+			return doc, errors.New("could not delete the whole content")
+		}
+		// the same as the paths, the content is walked through as an object
+		if _, ok := value.(map[string]interface{}); !ok {
+			return doc, errors.New("content should be an object")
+		}
+		return value, nil
+	}
+
+	parent, ok := doc.(map[string]interface{})
+	if !ok {
+		return doc, errors.New("content is not an object")
+	}
+	last := len(op.Path) - 1
+	for i, key := range op.Path[:last] {
+		child, exists := parent[key]
+		if !exists && !op.Delete {
+			child = make(map[string]interface{})
+			parent[key] = child
+		}
+		childObj, ok := child.(map[string]interface{})
+		if !ok {
+			if op.Delete && !exists {
+				// nothing to delete
+				return doc, nil
+			}
+			return doc, fmt.Errorf("path(%v) is not an object", op.Path[:i+1])
+		}
+		parent = childObj
+	}
+	if op.Delete {
+		delete(parent, op.Path[last])
+	} else {
+		parent[op.Path[last]] = value
+	}
+	return doc, nil
+}
+
+type collabClient struct {
+	userId uint32
+	conn   *websocket.Conn
+	send   chan collabMsgT
+}
+
+// writePump is the only writer of conn, it pings the editor as well
+func (c *collabClient) writePump() {
+	ping := time.NewTicker(kCollabPingInterval)
+	defer func() {
+		ping.Stop()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case msg, ok := <-c.send:
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(kCollabWriteTimeout))
+			if err := c.conn.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-ping.C:
+			c.conn.SetWriteDeadline(time.Now().Add(kCollabWriteTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// collabRoom serializes the ops on one app
+type collabRoom struct {
+	appId uint32
+	// epoch changes when a room is recreated, seq of different epochs are incomparable
+	epoch string
+
+	mu      sync.Mutex
+	doc     interface{}
+	content json.RawMessage
+	seq     uint64
+	log     []collabMsgT
+	clients map[*collabClient]struct{}
+}
+
+// sendLocked sends msg to c, disconnects c if it could not keep up
+func (room *collabRoom) sendLocked(c *collabClient, msg collabMsgT) {
+	if _, ok := room.clients[c]; !ok {
+		// disconnected already
+		return
+	}
+	select {
+	case c.send <- msg:
+	default:
+		room.removeLocked(c)
+	}
+}
+
+func (room *collabRoom) removeLocked(c *collabClient) {
+	if _, ok := room.clients[c]; ok {
+		delete(room.clients, c)
+		close(c.send)
+	}
+}
+
+// join registers c and catches c up according to hello
+func (room *collabRoom) join(c *collabClient, hello collabMsgT) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	room.clients[c] = struct{}{}
+
+	oldest := room.seq - uint64(len(room.log))
+	if hello.Epoch == room.epoch && hello.Seq >= oldest && hello.Seq <= room.seq {
+		for _, msg := range room.log[hello.Seq-oldest:] {
+			room.sendLocked(c, msg)
+		}
+		return
+	}
+	room.sendLocked(c, collabMsgT{
+		Type:    kCollabMsgSnapshot,
+		Epoch:   room.epoch,
+		Seq:     room.seq,
+		Content: room.content,
+	})
+}
+
+// leave reports whether the room becomes empty
+func (room *collabRoom) leave(c *collabClient) bool {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	room.removeLocked(c)
+	return len(room.clients) == 0
+}
+
+// reject replies err to the op of c with clientSeq
+func (room *collabRoom) reject(c *collabClient, clientSeq uint64, err error) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	room.rejectLocked(c, clientSeq, err)
+}
+
+func (room *collabRoom) rejectLocked(c *collabClient, clientSeq uint64, err error) {
+	room.sendLocked(c, collabMsgT{
+		Type:      kCollabMsgError,
+		ClientSeq: clientSeq,
+		Msg:       err.Error(),
+	})
+}
+
+// apply applies the op in msg, persists the new content by save and broadcasts it.
+// It returns the error replied to c, if any.
+func (room *collabRoom) apply(c *collabClient, msg collabMsgT,
+	save func(content json.RawMessage) error) error {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	replyErr := func(err error) error {
+		room.rejectLocked(c, msg.ClientSeq, err)
+		return err
+	}
+	if msg.Op == nil {
+		return replyErr(errors.New("op is missing"))
+	}
+	doc, err := applyCollabOp(room.doc, *msg.Op)
+	if err == nil {
+		var content []byte
+		content, err = json.Marshal(doc)
+		if err == nil {
+			err = save(content)
+		}
+		if err == nil {
+			room.doc = doc
+			room.content = content
+		}
+	}
+	if err != nil {
+		// the doc may be partially changed, restore it from the last persisted content
+		room.doc = nil
+		json.Unmarshal(room.content, &room.doc)
+		return replyErr(err)
+	}
+
+	room.seq++
+	broadcast := collabMsgT{
+		Type:      kCollabMsgOp,
+		Seq:       room.seq,
+		UserId:    c.userId,
+		ClientSeq: msg.ClientSeq,
+		Op:        msg.Op,
+	}
+	room.log = append(room.log, broadcast)
+	if len(room.log) > kCollabLogSize {
+		room.log = room.log[len(room.log)-kCollabLogSize:]
+	}
+	for client := range room.clients {
+		room.sendLocked(client, broadcast)
+	}
+	return nil
+}
+
+// replaceLocked replaces the content saved by others than the room, e.g. the REST api,
+// the editors get a snapshot as the ops before could not be replayed on it
+func (room *collabRoom) replaceLocked(content json.RawMessage) error {
+	var doc interface{}
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return err
+	}
+	room.doc = doc
+	room.content = content
+	room.seq++
+	room.log = nil
+	snapshot := collabMsgT{
+		Type:    kCollabMsgSnapshot,
+		Epoch:   room.epoch,
+		Seq:     room.seq,
+		Content: content,
+	}
+	for client := range room.clients {
+		room.sendLocked(client, snapshot)
+	}
+	return nil
+}
+
+// collabHub holds a room for every app being edited
+type collabHub struct {
+	mu    sync.Mutex
+	rooms map[uint32]*collabRoom
+}
+
+func newCollabHub() *collabHub {
+	return &collabHub{
+		rooms: make(map[uint32]*collabRoom),
+	}
+}
+
+// join joins c to the room of app, load is called to create the room when absent
+func (h *collabHub) join(appId uint32, load func() (json.RawMessage, error),
+	c *collabClient, hello collabMsgT) (*collabRoom, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, ok := h.rooms[appId]
+	if !ok {
+		content, err := load()
+		if err != nil {
+			return nil, err
+		}
+		room = &collabRoom{
+			appId:   appId,
+			epoch:   strconv.FormatInt(time.Now().UnixNano(), 36),
+			content: content,
+			clients: make(map[*collabClient]struct{}),
+		}
+		if err := json.Unmarshal(content, &room.doc); err != nil {
+			return nil, err
+		}
+		h.rooms[appId] = room
+	}
+	room.join(c, hello)
+	return room, nil
+}
+
+// save persists content by save, and replaces the content of the room of app if any,
+// so that the following ops are not applied to the stale content
+func (h *collabHub) save(appId uint32, content json.RawMessage,
+	save func(content json.RawMessage) error) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, ok := h.rooms[appId]
+	if !ok {
+		return save(content)
+	}
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if err := save(content); err != nil {
+		return err
+	}
+	return room.replaceLocked(content)
+}
+
+func (h *collabHub) leave(room *collabRoom, c *collabClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if room.leave(c) {
+		delete(h.rooms, room.appId)
+	}
+}
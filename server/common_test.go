@@ -13,31 +13,61 @@ import (
 	"github.com/go-chi/jwtauth"
 	"github.com/rtxu/luban-api/config"
 	"github.com/rtxu/luban-api/db"
+	"github.com/rtxu/luban-api/storage"
 	"github.com/stretchr/testify/assert"
 )
 
 const kTestUserName = "test_user"
 const kTestUserId = 9527
 
+// base64 of 32 bytes
+const kTestSecretKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+
 func newTestServer() (*server, string) {
 	conf := config.AppConfig{
 		JWTSecret: "test_secret",
+		SecretKeys: []config.SecretKeyConf{
+			{ID: "test", Key: kTestSecretKey},
+		},
 	}
 	svr := New(conf)
 
 	svr.appService = db.NewMemAppService()
+	svr.appChannelService = db.NewMemAppChannelService()
+	svr.appACLService = db.NewMemAppACLService()
 	svr.userService = db.NewMemUserService()
+	svr.orgService = db.NewMemOrgService()
+	svr.orgMemberService = db.NewMemOrgMemberService()
+	svr.appTemplateService = db.NewMemAppTemplateService()
+	svr.publishScheduleService = db.NewMemPublishScheduleService()
+	svr.dataSourceService = db.NewMemDataSourceService()
+	svr.appQueryService = db.NewMemAppQueryService()
+	svr.lubanTableService = db.NewMemLubanTableService()
+	svr.lubanRowService = db.NewMemLubanRowService()
+	svr.formSubmissionService = db.NewMemFormSubmissionService()
+	svr.assetService = db.NewMemAssetService()
+	svr.appKVService = db.NewMemAppKVService()
+	svr.appPageService = db.NewMemAppPageService()
+	svr.componentService = db.NewMemComponentService()
+	svr.assetStorage = storage.NewMem()
+	// the data sources of tests listen on the loopback
+	svr.queryClient = &http.Client{}
+	return svr, addTestUser(svr, kTestUserId, kTestUserName)
+}
+
+// addTestUser inserts a user and returns a token signed for the user
+func addTestUser(svr *server, id uint32, username string) string {
 	svr.userService.Insert(db.User{
-		UserName: kTestUserName,
-		ID:       kTestUserId,
+		UserName: username,
+		ID:       id,
 	})
 	claims := jwt.MapClaims{
-		kTokenClaimUserName: kTestUserName,
-		kTokenClaimUserId:   kTestUserId,
+		kTokenClaimUserName: username,
+		kTokenClaimUserId:   id,
 	}
 	jwtauth.SetExpiryIn(claims, 7*24*time.Hour)
 	_, tokenString, _ := svr.tokenAuth.Encode(claims)
-	return svr, tokenString
+	return tokenString
 }
 
 func assertErrCode(t *testing.T, code int, resp *http.Response) defaultResponse {
@@ -58,8 +88,9 @@ func handleRequest(req *http.Request, svr *server) *http.Response {
 }
 
 type createRequest struct {
-	Dir   string `json:"dir"`
-	Entry EntryT `json:"entry"`
+	Dir        string `json:"dir"`
+	Entry      EntryT `json:"entry"`
+	TemplateId string `json:"templateId,omitempty"`
 }
 
 func createEntry(req createRequest, svr *server, token string) *http.Response {
@@ -72,3 +103,16 @@ func createEntry(req createRequest, svr *server, token string) *http.Response {
 	svr.ServeHTTP(w, httpReq)
 	return w.Result()
 }
+
+func doRequest(method, target string, body interface{}, svr *server, token string) *http.Response {
+	var reqBody *bytes.Reader
+	if body == nil {
+		reqBody = bytes.NewReader(nil)
+	} else {
+		reqBodyBytes, _ := json.Marshal(body)
+		reqBody = bytes.NewReader(reqBodyBytes)
+	}
+	httpReq := httptest.NewRequest(method, target, reqBody)
+	httpReq.Header.Add("Authorization", fmt.Sprintf("BEARER %s", token))
+	return handleRequest(httpReq, svr)
+}
@@ -0,0 +1,272 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// contentSchemaSources are JSON Schemas of app content by version, content declares its version by
+// the key `schemaVersion`, which is 1 when absent. A new version is added when the editor changes
+// the structure incompatibly, the old ones are kept for the apps not migrated yet.
+//
+// Only a subset of JSON Schema is supported, see jsonSchema. Keys unknown to the schema are allowed,
+// so that the editor could add features without a new version.
+var contentSchemaSources = map[int]string{
+	1: `{
+		"type": "object",
+		"properties": {
+			"schemaVersion": {"type": "integer", "minimum": 1},
+			"widgets": {"$ref": "#/$defs/widgets"},
+			"pages": {"type": "array", "items": {"$ref": "#/$defs/page"}},
+			"forms": {"type": "object", "additionalProperties": {"$ref": "#/$defs/form"}}
+		},
+		"$defs": {
+			"page": {
+				"type": "object",
+				"required": ["id", "name"],
+				"properties": {
+					"id": {"type": "integer", "minimum": 0},
+					"name": {"type": "string", "minLength": 1, "maxLength": 64},
+					"content": {"$ref": "#/$defs/pageContent"}
+				}
+			},
+			"pageContent": {
+				"type": "object",
+				"properties": {
+					"widgets": {"$ref": "#/$defs/widgets"}
+				}
+			},
+			"widgets": {
+				"type": "object",
+				"additionalProperties": {"$ref": "#/$defs/widget"}
+			},
+			"widget": {
+				"type": "object",
+				"required": ["type"],
+				"properties": {
+					"type": {"type": "string", "minLength": 1},
+					"children": {"type": "array", "items": {"type": "string"}}
+				}
+			},
+			"form": {
+				"type": "object",
+				"properties": {
+					"public": {"type": "boolean"},
+					"fields": {
+						"type": "array",
+						"items": {"type": "object", "required": ["name", "type"]}
+					}
+				}
+			}
+		}
+	}`,
+}
+
+const (
+	kContentSchemaDefault = 1
+	// definition of the content of a page in contentSchemaSources
+	kContentSchemaPage = "#/$defs/pageContent"
+	// validation stops after so many errors
+	kContentMaxErrors = 100
+)
+
+var contentSchemas = make(map[int]*jsonSchema)
+
+func init() {
+	for version, src := range contentSchemaSources {
+		var schema jsonSchema
+		if err := json.Unmarshal([]byte(src), &schema); err != nil {
+			panic(fmt.Sprintf("invalid content schema(%d): %v", version, err))
+		}
+		contentSchemas[version] = &schema
+	}
+}
+
+// jsonSchema is the subset of JSON Schema used by contentSchemaSources
+type jsonSchema struct {
+	// only refers to the definitions of the root, i.e. #/$defs/name
+	Ref                  string                 `json:"$ref"`
+	Defs                 map[string]*jsonSchema `json:"$defs"`
+	Type                 string                 `json:"type"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+}
+
+// ContentErrorT 是内容不符合 schema 的一处错误，path 为 JSON Pointer，如 /pages/0/name
+type ContentErrorT struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+type schemaValidator struct {
+	root   *jsonSchema
+	errors []ContentErrorT
+}
+
+func (sv *schemaValidator) addError(path []string, format string, a ...interface{}) {
+	if len(sv.errors) >= kContentMaxErrors {
+		return
+	}
+	var pointer strings.Builder
+	for _, token := range path {
+		pointer.WriteByte('/')
+		pointer.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(token))
+	}
+	sv.errors = append(sv.errors, ContentErrorT{Path: pointer.String(), Message: fmt.Sprintf(format, a...)})
+}
+
+func (sv *schemaValidator) deref(schema *jsonSchema) *jsonSchema {
+	for schema.Ref != "" {
+		def, ok := sv.root.Defs[strings.TrimPrefix(schema.Ref, "#/$defs/")]
+		if !ok {
+			panic(fmt.Sprintf("unknown $ref(%s) in content schema", schema.Ref))
+		}
+		schema = def
+	}
+	return schema
+}
+
+func jsonType(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if f, err := x.Float64(); err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	panic(fmt.Sprintf("unexpected JSON value of %T", v))
+}
+
+func (sv *schemaValidator) validate(schema *jsonSchema, v interface{}, path []string) {
+	schema = sv.deref(schema)
+	typ := jsonType(v)
+	if schema.Type != "" && schema.Type != typ && !(schema.Type == "number" && typ == "integer") {
+		sv.addError(path, "should be %s rather than %s", schema.Type, typ)
+		return
+	}
+	switch x := v.(type) {
+	case string:
+		n := utf8.RuneCountInString(x)
+		if schema.MinLength != nil && n < *schema.MinLength {
+			sv.addError(path, "should have at least %d characters", *schema.MinLength)
+		}
+		if schema.MaxLength != nil && n > *schema.MaxLength {
+			sv.addError(path, "should have at most %d characters", *schema.MaxLength)
+		}
+	case json.Number:
+		f, _ := x.Float64()
+		if schema.Minimum != nil && f < *schema.Minimum {
+			sv.addError(path, "should be at least %v", *schema.Minimum)
+		}
+		if schema.Maximum != nil && f > *schema.Maximum {
+			sv.addError(path, "should be at most %v", *schema.Maximum)
+		}
+	case []interface{}:
+		if schema.MinItems != nil && len(x) < *schema.MinItems {
+			sv.addError(path, "should have at least %d items", *schema.MinItems)
+		}
+		if schema.MaxItems != nil && len(x) > *schema.MaxItems {
+			sv.addError(path, "should have at most %d items", *schema.MaxItems)
+		}
+		if schema.Items != nil {
+			for i, e := range x {
+				sv.validate(schema.Items, e, append(path, fmt.Sprint(i)))
+			}
+		}
+	case map[string]interface{}:
+		// references to components are validated after resolved on publish
+		if _, ok := x[kComponentRefKey]; ok {
+			return
+		}
+		for _, name := range schema.Required {
+			if _, ok := x[name]; !ok {
+				sv.addError(path, "should have property %s", name)
+			}
+		}
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if prop, ok := schema.Properties[k]; ok {
+				sv.validate(prop, x[k], append(path, k))
+			} else if schema.AdditionalProperties != nil {
+				sv.validate(schema.AdditionalProperties, x[k], append(path, k))
+			}
+		}
+	}
+}
+
+// contentSchemaVersion returns the schema version declared by content
+func contentSchemaVersion(content json.RawMessage) (int, error) {
+	var declared struct {
+		SchemaVersion *int `json:"schemaVersion"`
+	}
+	// content which is not an object is reported by validateContent
+	if err := json.Unmarshal(content, &declared); err != nil || declared.SchemaVersion == nil {
+		return kContentSchemaDefault, nil
+	}
+	if _, ok := contentSchemas[*declared.SchemaVersion]; !ok {
+		return 0, fmt.Errorf("%w: unknown schemaVersion(%d)", errInvalidParam, *declared.SchemaVersion)
+	}
+	return *declared.SchemaVersion, nil
+}
+
+// validateContent validates content against the definition def of the schema of version,
+// def is empty for the whole app. Content that is not even JSON is an error,
+// while the mismatches are returned, which block publish but are only warned on save.
+func validateContent(version int, content json.RawMessage, def string) ([]ContentErrorT, error) {
+	if !json.Valid(content) {
+		return nil, fmt.Errorf("%w: content is not valid JSON", errInvalidParam)
+	}
+	v, err := decodeContent(content)
+	if err != nil {
+		panic(err)
+	}
+	root := contentSchemas[version]
+	sv := &schemaValidator{root: root, errors: make([]ContentErrorT, 0)}
+	schema := root
+	if def != "" {
+		schema = &jsonSchema{Ref: def}
+	}
+	sv.validate(schema, v, nil)
+	return sv.errors, nil
+}
+
+func invalidContentMsg(errs []ContentErrorT) string {
+	return fmt.Sprintf("%v: %d errors, the first is %s %s",
+		errInvalidContent, len(errs), errs[0].Path, errs[0].Message)
+}
+
+// respondInvalidContent replies the mismatches of content which could not be published
+func (s *server) respondInvalidContent(w http.ResponseWriter, r *http.Request, errs []ContentErrorT) {
+	s.respond(w, r, defaultResponse{
+		Code: errCodeMap[errInvalidContent],
+		Msg:  invalidContentMsg(errs),
+		Data: errs,
+	}, http.StatusOK)
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateContent(t *testing.T) {
+	assert := assert.New(t)
+
+	version, err := contentSchemaVersion(json.RawMessage(`{"widgets": {}}`))
+	assert.NoError(err)
+	assert.Equal(kContentSchemaDefault, version)
+	_, err = contentSchemaVersion(json.RawMessage(`{"schemaVersion": 99}`))
+	assert.Error(err)
+
+	_, err = validateContent(1, json.RawMessage(`{"widgets": `), "")
+	assert.Error(err)
+	_, err = validateContent(1, json.RawMessage(`{} {}`), "")
+	assert.Error(err)
+
+	errs, err := validateContent(1, json.RawMessage(`{
+		"custom": "kept as is",
+		"widgets": {
+			"w1": {"type": "Button", "children": ["w2"]},
+			"w/2": {"children": [1]},
+			"w3": {"$component": {"id": 1}}
+		},
+		"pages": [{"id": -1, "name": "", "content": {"widgets": {"w4": {"type": ""}}}}],
+		"forms": {"f": {"public": "yes", "fields": [{"name": "a"}]}}
+	}`), "")
+	assert.NoError(err)
+	assert.Equal([]ContentErrorT{
+		{"/forms/f/fields/0", "should have property type"},
+		{"/forms/f/public", "should be boolean rather than string"},
+		{"/pages/0/content/widgets/w4/type", "should have at least 1 characters"},
+		{"/pages/0/id", "should be at least 0"},
+		{"/pages/0/name", "should have at least 1 characters"},
+		{"/widgets/w~12", "should have property type"},
+		{"/widgets/w~12/children/0", "should be string rather than integer"},
+	}, errs)
+
+	errs, err = validateContent(1, json.RawMessage(`[]`), "")
+	assert.NoError(err)
+	assert.Equal([]ContentErrorT{{"", "should be object rather than array"}}, errs)
+	errs, err = validateContent(1, json.RawMessage(`{"widgets": {"w1": {}}}`), kContentSchemaPage)
+	assert.NoError(err)
+	assert.Equal([]ContentErrorT{{"/widgets/w1", "should have property type"}}, errs)
+}
@@ -11,10 +11,40 @@ var (
 	errBadRequest    = errors.New("bad request")
 	errInvalidParam  = errors.New("invalid param")
 	errEntryNotFound = errors.New("entry not found")
+	errOrgNotFound   = errors.New("org not found")
+
+	errTemplateNotFound = errors.New("template not found")
+	errScheduleNotFound = errors.New("pending schedule not found")
+
+	errDataSourceNotFound = errors.New("data source not found")
+	errQueryNotFound      = errors.New("query not found")
+
+	errTableNotFound = errors.New("table not found")
+	errRowNotFound   = errors.New("row not found")
+	errFormNotFound  = errors.New("form not found")
+	errAssetNotFound = errors.New("asset not found")
+	errKVNotFound    = errors.New("key not found")
+	errPageNotFound  = errors.New("page not found")
+
+	errComponentNotFound = errors.New("component not found")
+	// app content does not match the schema, see contentSchemaSources
+	errInvalidContent = errors.New("invalid content")
 
 	// user-side error, maybe triggered by end user
 	errEntryAlreadyExist = errors.New("entry already exist")
 	errDirNotEmpty       = errors.New("dir not empty")
+	errPermissionDenied  = errors.New("permission denied")
+	errUserNotFound      = errors.New("user not found")
+	errLastOrgAdmin      = errors.New("org should have at least one admin")
+	errAppLocked         = errors.New("app is locked")
+	// the data source responded with an error or could not be reached
+	errQueryFailed = errors.New("query failed")
+	// e.g. the total size of the assets of a user
+	errQuotaExceeded = errors.New("quota exceeded")
+	// the key was written by others since it was read
+	errKVConflict = errors.New("version conflict")
+	// e.g. the master key of the secrets was dropped before rotation, they should be entered again
+	errSecretsUnreadable = errors.New("secrets of data source could not be decrypted")
 
 	// server-side error, just panic
 )
@@ -24,7 +54,32 @@ var errCodeMap = map[error]int{
 	errBadRequest:    101,
 	errInvalidParam:  102,
 	errEntryNotFound: 103,
+	errOrgNotFound:   104,
+
+	errTemplateNotFound: 105,
+	errScheduleNotFound: 106,
+
+	errDataSourceNotFound: 107,
+	errQueryNotFound:      108,
+
+	errTableNotFound: 109,
+	errRowNotFound:   110,
+	errFormNotFound:  111,
+	errAssetNotFound: 112,
+	errKVNotFound:    113,
+	errPageNotFound:  114,
+
+	errComponentNotFound: 115,
+	errInvalidContent:    116,
 
 	errEntryAlreadyExist: 200,
 	errDirNotEmpty:       201,
+	errPermissionDenied:  202,
+	errUserNotFound:      203,
+	errLastOrgAdmin:      204,
+	errAppLocked:         205,
+	errQueryFailed:       206,
+	errQuotaExceeded:     207,
+	errKVConflict:        208,
+	errSecretsUnreadable: 209,
 }
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/rtxu/luban-api/db"
+)
+
+const (
+	kEventEntryCreated = "entryCreated"
+	kEventEntryDeleted = "entryDeleted"
+	kEventAppSaved     = "appSaved"
+	kEventAppPublished = "appPublished"
+
+	// a subscriber who could not keep up is disconnected, and reloads after reconnecting
+	kEventBufferSize = 64
+)
+
+// EventT 是推送给前端的变更通知，前端据此刷新目录树或 app
+type EventT struct {
+	Type string `json:"type"`
+	// workspace of the entry, 0 for the personal space
+	OrgId uint32 `json:"orgId"`
+	// only for entry events
+	Dir       string `json:"dir,omitempty"`
+	EntryName string `json:"entryName,omitempty"`
+	AppId     uint32 `json:"appId,omitempty"`
+	// only for appPublished
+	Channel  string `json:"channel,omitempty"`
+	Operator string `json:"operator"`
+}
+
+type eventSubscriber struct {
+	userId uint32
+	events chan EventT
+}
+
+// eventBus fans events out to the subscribers of their audience,
+// it's in memory only, subscribers connected to other instances miss the events
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers map[uint32]map[*eventSubscriber]struct{}
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		subscribers: make(map[uint32]map[*eventSubscriber]struct{}),
+	}
+}
+
+func (b *eventBus) subscribe(userId uint32) *eventSubscriber {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub := &eventSubscriber{
+		userId: userId,
+		events: make(chan EventT, kEventBufferSize),
+	}
+	if _, ok := b.subscribers[userId]; !ok {
+		b.subscribers[userId] = make(map[*eventSubscriber]struct{})
+	}
+	b.subscribers[userId][sub] = struct{}{}
+	return sub
+}
+
+func (b *eventBus) unsubscribe(sub *eventSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.removeLocked(sub)
+}
+
+func (b *eventBus) removeLocked(sub *eventSubscriber) {
+	subs := b.subscribers[sub.userId]
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+	delete(subs, sub)
+	close(sub.events)
+	if len(subs) == 0 {
+		delete(b.subscribers, sub.userId)
+	}
+}
+
+// publish sends event to every subscriber of audience, it never blocks
+func (b *eventBus) publish(audience []uint32, event EventT) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, userId := range audience {
+		for sub := range b.subscribers[userId] {
+			select {
+			case sub.events <- event:
+			default:
+				b.removeLocked(sub)
+			}
+		}
+	}
+}
+
+// workspaceAudience returns users who could see the directory tree of ws
+func (s *server) workspaceAudience(ws workspace) []uint32 {
+	if ws.org == nil {
+		return []uint32{ws.user.ID}
+	}
+	members, err := s.orgMemberService.ListByOrg(ws.org.ID)
+	if err != nil {
+		panic(err)
+	}
+	audience := make([]uint32, 0, len(members))
+	for _, member := range members {
+		audience = append(audience, member.UserID)
+	}
+	return audience
+}
+
+// appAudience returns users who could see app
+func (s *server) appAudience(app db.App) []uint32 {
+	seen := map[uint32]bool{app.OwnerID: true}
+	audience := []uint32{app.OwnerID}
+	add := func(userId uint32) {
+		if !seen[userId] {
+			seen[userId] = true
+			audience = append(audience, userId)
+		}
+	}
+	if app.OrgID != 0 {
+		members, err := s.orgMemberService.ListByOrg(app.OrgID)
+		if err != nil {
+			panic(err)
+		}
+		for _, member := range members {
+			add(member.UserID)
+		}
+	}
+	acls, err := s.appACLService.ListByApp(app.ID)
+	if err != nil {
+		panic(err)
+	}
+	for _, acl := range acls {
+		add(acl.UserID)
+	}
+	return audience
+}
+
+func (s *server) publishEntryEvent(ws workspace, eventType, dir string, entry *EntryT) {
+	s.eventBus.publish(s.workspaceAudience(ws), EventT{
+		Type:      eventType,
+		OrgId:     ws.orgId(),
+		Dir:       dir,
+		EntryName: entry.Name,
+		AppId:     entry.AppId,
+		Operator:  ws.user.UserName,
+	})
+}
+
+func (s *server) publishAppEvent(app db.App, eventType, channel string, operator uint32) {
+	s.eventBus.publish(s.appAudience(app), EventT{
+		Type:     eventType,
+		OrgId:    app.OrgID,
+		AppId:    app.ID,
+		Channel:  channel,
+		Operator: s.findUserById(operator).UserName,
+	})
+}
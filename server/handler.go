@@ -13,6 +13,7 @@ import (
 
 	"github.com/rtxu/luban-api/db"
 	"github.com/rtxu/luban-api/middleware"
+	"github.com/rtxu/luban-api/request"
 )
 
 /**
@@ -30,10 +31,18 @@ import (
 const kTokenClaimUserId = "user_id"
 const kTokenClaimUserName = "username"
 
+func currentUserId(r *http.Request) uint32 {
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	return uint32(claims[kTokenClaimUserId].(float64))
+}
+
 func (s *server) handleGithubLogin() http.HandlerFunc {
 	type accessTokenT struct {
 		TokenType   string `json:"token_type"`
 		AccessToken string `json:"access_token"`
+		// GitHub responds 200 with error, e.g. bad_verification_code
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
 	}
 	type userInfoT struct {
 		Login     string `json:"login"`
@@ -55,49 +64,40 @@ func (s *server) handleGithubLogin() http.HandlerFunc {
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		githubCode := r.URL.Query().Get("code")
-		params := make(url.Values)
-		params.Add("code", githubCode)
-		params.Add("client_id", s.conf.GithubOAuth.ClientID)
-		params.Add("client_secret", s.conf.GithubOAuth.ClientSecret)
-		req, _ := http.NewRequest("POST", ACCESS_TOKEN_URL+"?"+params.Encode(), nil)
-		req.Header.Add("Accept", "application/json")
-		req.Header.Add("Content-Type", "application/json")
-
-		client := &http.Client{}
-		resp, err := client.Do(req)
+		var accessToken accessTokenT
+		err := request.Post(ACCESS_TOKEN_URL).
+			WithContext(r.Context()).
+			WithClient(s.httpClient).
+			Query("code", githubCode).
+			Query("client_id", s.conf.GithubOAuth.ClientID).
+			Query("client_secret", s.conf.GithubOAuth.ClientSecret).
+			DecodeJSON(&accessToken)
 		if err != nil {
 			loginErr(w, r,
 				fmt.Errorf("error happened when POST %s, err: %w", ACCESS_TOKEN_URL, err),
 				"GitHub 登录出错")
 			return
 		}
-		defer resp.Body.Close()
-
-		var accessToken accessTokenT
-		if err := json.NewDecoder(resp.Body).Decode(&accessToken); err != nil {
+		if accessToken.Error != "" {
 			loginErr(w, r,
-				fmt.Errorf("error happened when decode(accessToken), err: %w", err),
+				fmt.Errorf("POST %s failed, error: %s, description: %s",
+					ACCESS_TOKEN_URL, accessToken.Error, accessToken.ErrorDescription),
 				"GitHub 登录出错")
 			return
 		}
 
-		req2, _ := http.NewRequest("GET", USER_INFO_URL, nil)
-		req2.Header.Add("Authorization", fmt.Sprintf("%s %s", accessToken.TokenType, accessToken.AccessToken))
-		resp2, err := client.Do(req2)
+		var userInfo userInfoT
+		err = request.Get(USER_INFO_URL).
+			WithContext(r.Context()).
+			WithClient(s.httpClient).
+			Header("Authorization", fmt.Sprintf("%s %s", accessToken.TokenType, accessToken.AccessToken)).
+			DecodeJSON(&userInfo)
 		if err != nil {
 			loginErr(w, r,
 				fmt.Errorf("error happened when GET %s, err: %w", USER_INFO_URL, err),
 				"GitHub 登录出错")
 			return
 		}
-		defer resp2.Body.Close()
-		var userInfo userInfoT
-		if err := json.NewDecoder(resp2.Body).Decode(&userInfo); err != nil {
-			loginErr(w, r,
-				fmt.Errorf("error happened when decode(userInfo), err: %w", err),
-				"GitHub 登录出错")
-			return
-		}
 
 		var user db.User
 		user, err = s.userService.FindByGithubUserName(userInfo.Login)
@@ -148,12 +148,19 @@ func (s *server) handleCurrentUserGet() http.HandlerFunc {
 		RootDir   DirectoryT `json:"rootDir"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
-		_, claims, _ := jwtauth.FromContext(r.Context())
-		var username = claims[kTokenClaimUserName].(string)
-		user, rootDir := s.getCurrentUserAndRootDirFromDB(username)
+		ws, rootDir, err := s.getWorkspaceAndRootDir(r)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		s.fillAppMeta(rootDir)
+		var avatarUrl string
+		if ws.user.AvatarUrl != nil {
+			avatarUrl = *ws.user.AvatarUrl
+		}
 		data := dataT{
-			Username:  user.UserName,
-			AvatarUrl: *user.AvatarUrl,
+			Username:  ws.user.UserName,
+			AvatarUrl: avatarUrl,
 			RootDir:   rootDir,
 		}
 		s.respond(w, r, defaultResponse{Data: data}, http.StatusOK)
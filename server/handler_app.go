@@ -1,15 +1,129 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"time"
 
-	"github.com/go-chi/jwtauth"
+	"github.com/rtxu/luban-api/db"
 )
 
+// appRole returns the role of user on app, the owner is an admin as long as they are
+// a member of the org of app. For apps in an org, the higher one of org role and app role wins.
+func (s *server) appRole(userId uint32, app db.App) db.Role {
+	role := db.RoleNone
+	if app.OrgID != 0 {
+		role = s.orgRole(userId, app.OrgID)
+	}
+	if app.OwnerID == userId && (app.OrgID == 0 || role != db.RoleNone) {
+		return db.RoleAdmin
+	}
+	acl, err := s.appACLService.Find(app.ID, userId)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return role
+		}
+		panic(err)
+	}
+	if acl.Role.Covers(role) {
+		role = acl.Role
+	}
+	return role
+}
+
+func parseAppId(appIdStr string) (uint32, error) {
+	u64, err := strconv.ParseUint(appIdStr, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%w: appId(%s) is not a number, err: %v",
+			errBadRequest, appIdStr, err)
+	}
+	return uint32(u64), nil
+}
+
+// findAppWithRole finds the app and checks that the current user has at least needRole on it.
+// Apps invisible to the current user are reported as not found.
+func (s *server) findAppWithRole(r *http.Request, appId uint32, needRole db.Role) (db.App, error) {
+	app, err := s.appService.Get(appId)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return app, fmt.Errorf("%w: appId is %d", errEntryNotFound, appId)
+		}
+		panic(err)
+	}
+	role := s.appRole(currentUserId(r), app)
+	if role == db.RoleNone {
+		return app, fmt.Errorf("%w: appId is %d", errEntryNotFound, appId)
+	}
+	if !role.Covers(needRole) {
+		return app, fmt.Errorf("%w: %s role is required on app(%d)",
+			errPermissionDenied, needRole, appId)
+	}
+	return app, nil
+}
+
+// findAppWithRoleByQuery is the same as findAppWithRole but reads appId from the query string
+func (s *server) findAppWithRoleByQuery(r *http.Request, needRole db.Role) (db.App, error) {
+	appId, err := parseAppId(r.URL.Query().Get("appId"))
+	if err != nil {
+		return db.App{}, err
+	}
+	return s.findAppWithRole(r, appId, needRole)
+}
+
+// AppMetaT 是 app 的元信息，供前端展示「5 分钟前编辑」、「有未发布的修改」等
+type AppMetaT struct {
+	CreatedAt             time.Time  `json:"createdAt"`
+	UpdatedAt             time.Time  `json:"updatedAt"`
+	UpdatedBy             string     `json:"updatedBy"`
+	PublishedAt           *time.Time `json:"publishedAt"`
+	PublishedBy           string     `json:"publishedBy"`
+	HasUnpublishedChanges bool       `json:"hasUnpublishedChanges"`
+}
+
+// usernameCache avoids finding the same user again and again within a request
+type usernameCache map[uint32]string
+
+func (s *server) username(cache usernameCache, userId uint32) string {
+	if userId == 0 {
+		return ""
+	}
+	if username, ok := cache[userId]; ok {
+		return username
+	}
+	username := s.findUserById(userId).UserName
+	cache[userId] = username
+	return username
+}
+
+func (s *server) newAppMeta(app db.App, cache usernameCache, versions componentVersions) *AppMetaT {
+	return &AppMetaT{
+		CreatedAt:             app.CreatedAt,
+		UpdatedAt:             app.UpdatedAt,
+		UpdatedBy:             s.username(cache, app.UpdatedBy),
+		PublishedAt:           app.PublishedAt,
+		PublishedBy:           s.username(cache, app.PublishedBy),
+		HasUnpublishedChanges: s.hasUnpublishedChanges(app, versions),
+	}
+}
+
+func (s *server) handleAppMetaGet() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app, err := s.findAppWithRoleByQuery(r, db.RoleViewer)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		s.respond(w, r, defaultResponse{
+			Data: s.newAppMeta(app, make(usernameCache), make(componentVersions)),
+		}, http.StatusOK)
+	}
+}
+
 const (
 	kLTView    = "view"
 	kLTPreview = "preview"
@@ -20,36 +134,50 @@ func (s *server) handleAppGet() http.HandlerFunc {
 	type request struct {
 		appId    string
 		loadType string
+		// only for loadType=view
+		channel string
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
-		_, claims, _ := jwtauth.FromContext(r.Context())
-		var owner = uint32(claims[kTokenClaimUserId].(float64))
-
 		var param request
 		query := r.URL.Query()
 		param.appId = query.Get("appId")
 		param.loadType = query.Get("loadType")
+		param.channel = query.Get("channel")
 
-		u64, err := strconv.ParseUint(param.appId, 10, 32)
+		channel, err := checkChannel(param.channel)
 		if err != nil {
-			s.respond(w, r, fmt.Errorf("%w: appId(%s) is not a number, err: %v",
-				errBadRequest, param.appId, err), http.StatusOK)
+			s.respond(w, r, err, http.StatusOK)
 			return
 		}
-		appId := uint32(u64)
-		app, err := s.appService.Find(owner, appId)
+		// viewers could only see the content published to production
+		needRole := db.RoleEditor
+		if param.loadType == kLTView && channel == kChannelProduction {
+			needRole = db.RoleViewer
+		}
+		app, err := s.findAppWithRoleByQuery(r, needRole)
 		if err != nil {
-			s.respond(w, r, fmt.Errorf("%w: appId is %s",
-				errEntryNotFound, param.appId), http.StatusOK)
+			s.respond(w, r, err, http.StatusOK)
 			return
 		}
+		appId := app.ID
 
 		var content json.RawMessage
 		switch param.loadType {
 		case kLTView:
-			content = app.LastPublishedContent
+			content = s.channelContent(app, channel)
 		case kLTEdit, kLTPreview:
-			content = app.Content
+			// editors load pages one by one, while preview shows the whole app
+			content, err = withPages(app.Content, s.listPages(appId), param.loadType == kLTPreview)
+			if err != nil {
+				panic(err)
+			}
+			if param.loadType == kLTPreview {
+				content, err = s.resolveComponents(app, content)
+				if err != nil {
+					s.respond(w, r, err, http.StatusOK)
+					return
+				}
+			}
 		default:
 			s.respond(w, r, fmt.Errorf("%w: unrecognized loadType(%s)",
 				errBadRequest, param.loadType), http.StatusOK)
@@ -59,6 +187,7 @@ func (s *server) handleAppGet() http.HandlerFunc {
 		fmt.Printf("load app success, appId: %d, loadType: %s, content: %s", appId, param.loadType, string(content))
 		s.respond(w, r, defaultResponse{
 			Data: content,
+			Meta: s.newAppMeta(app, make(usernameCache), make(componentVersions)),
 		}, http.StatusOK)
 	}
 }
@@ -72,23 +201,36 @@ func (s *server) handleAppSave() http.HandlerFunc {
 	type request struct {
 		appId string
 		op    string
+		// only for op=publish
+		channel string
+	}
+	type dataT struct {
+		// mismatches against the content schema, which are saved anyway
+		Warnings []ContentErrorT `json:"warnings"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
-		_, claims, _ := jwtauth.FromContext(r.Context())
-		var owner = uint32(claims[kTokenClaimUserId].(float64))
-
 		var param request
 		query := r.URL.Query()
 		param.appId = query.Get("appId")
 		param.op = query.Get("op")
+		param.channel = query.Get("channel")
 
-		u64, err := strconv.ParseUint(param.appId, 10, 32)
+		app, err := s.findAppWithRoleByQuery(r, db.RoleEditor)
 		if err != nil {
-			s.respond(w, r, fmt.Errorf("%w: appId(%s) is not a number, err: %v",
-				errBadRequest, param.appId, err), http.StatusOK)
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		appId := app.ID
+		if err := s.checkEditLock(r, appId); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		channel, err := checkChannel(param.channel)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
 			return
 		}
-		appId := uint32(u64)
 
 		newContentBytes, err := ioutil.ReadAll(r.Body)
 		if err != nil {
@@ -97,11 +239,68 @@ func (s *server) handleAppSave() http.HandlerFunc {
 			return
 		}
 
+		schemaVersion, err := contentSchemaVersion(newContentBytes)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		warnings, err := validateContent(schemaVersion, newContentBytes, "")
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		// newContentBytes is the rest of the draft besides pages, see withPages
+		pages := s.listPages(appId)
+		if len(pages) > 0 && !isJSONObject(newContentBytes) {
+			s.respond(w, r, fmt.Errorf("%w: content should be an object", errInvalidParam), http.StatusOK)
+			return
+		}
+		// the pages loaded for edit are stubs, which are saved by the page api instead
+		if len(pages) > 0 {
+			newContentBytes, _ = splitPages(newContentBytes)
+		}
+
 		switch param.op {
 		case kOpPublish:
-			err = s.appService.UpdateLastPublishedContent(owner, appId, newContentBytes)
+			// the content published may not be the saved draft
+			revision := app.DraftRevision
+			if !bytes.Equal(newContentBytes, app.Content) {
+				revision = kNotDraft
+			}
+			// all pages are published in a single snapshot
+			newContentBytes, err = withPages(newContentBytes, pages, true)
+			if err != nil {
+				panic(err)
+			}
+			// the published content is self-contained, whatever happens to components later
+			var components map[uint32]uint32
+			newContentBytes, components, err = s.resolveTrackedComponents(app, newContentBytes)
+			if err != nil {
+				s.respond(w, r, err, http.StatusOK)
+				return
+			}
+			// submissions are checked against the published forms
+			if _, err := contentForms(newContentBytes); err != nil {
+				s.respond(w, r, err, http.StatusOK)
+				return
+			}
+			// publish is blocked by any mismatch, including those in pages and components
+			errs, err := validateContent(schemaVersion, newContentBytes, "")
+			if err != nil {
+				panic(err)
+			}
+			if len(errs) > 0 {
+				s.respondInvalidContent(w, r, errs)
+				return
+			}
+			err = s.publishToChannel(appId, channel, currentUserId(r), newContentBytes,
+				newDraftMarker(revision, components))
 		case kOpSave:
-			err = s.appService.UpdateContent(owner, appId, newContentBytes)
+			// the editors in the collab room continue on the saved content
+			err = s.collabHub.save(appId, newContentBytes, func(content json.RawMessage) error {
+				return s.appService.UpdateContent(appId, currentUserId(r), content)
+			})
 		default:
 			s.respond(w, r, fmt.Errorf("%w: unrecognized op(%s)",
 				errBadRequest, param.op), http.StatusOK)
@@ -109,7 +308,13 @@ func (s *server) handleAppSave() http.HandlerFunc {
 		}
 
 		if err == nil {
-			s.respond(w, r, success, http.StatusOK)
+			if param.op == kOpPublish {
+				s.publishAppEvent(app, kEventAppPublished, channel, currentUserId(r))
+				s.respond(w, r, success, http.StatusOK)
+			} else {
+				s.publishAppEvent(app, kEventAppSaved, "", currentUserId(r))
+				s.respond(w, r, defaultResponse{Data: dataT{Warnings: warnings}}, http.StatusOK)
+			}
 		} else {
 			panic(err)
 		}
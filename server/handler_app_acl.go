@@ -0,0 +1,181 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rtxu/luban-api/db"
+)
+
+// CollaboratorT 代表 app 的一个协作者
+type CollaboratorT struct {
+	Username  string  `json:"username"`
+	AvatarUrl *string `json:"avatarUrl"`
+	Role      db.Role `json:"role"`
+	IsOwner   bool    `json:"isOwner"`
+}
+
+func (s *server) findUserByName(username string) (db.User, error) {
+	user, err := s.userService.Find(username)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return user, fmt.Errorf("%w: %s", errUserNotFound, username)
+		}
+		panic(err)
+	}
+	return user, nil
+}
+
+func (s *server) findUserById(id uint32) db.User {
+	user, err := s.userService.FindByID(id)
+	if err != nil {
+		panic(err)
+	}
+	return user
+}
+
+func (s *server) handleAppCollaboratorList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app, err := s.findAppWithRoleByQuery(r, db.RoleViewer)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		acls, err := s.appACLService.ListByApp(app.ID)
+		if err != nil {
+			panic(err)
+		}
+		owner := s.findUserById(app.OwnerID)
+		data := []CollaboratorT{{
+			Username:  owner.UserName,
+			AvatarUrl: owner.AvatarUrl,
+			Role:      db.RoleAdmin,
+			IsOwner:   true,
+		}}
+		for _, acl := range acls {
+			user := s.findUserById(acl.UserID)
+			data = append(data, CollaboratorT{
+				Username:  user.UserName,
+				AvatarUrl: user.AvatarUrl,
+				Role:      acl.Role,
+			})
+		}
+		s.respond(w, r, defaultResponse{Data: data}, http.StatusOK)
+	}
+}
+
+func (s *server) handleAppCollaboratorGrant() http.HandlerFunc {
+	type request struct {
+		AppId    uint32  `json:"appId"`
+		Username string  `json:"username"`
+		Role     db.Role `json:"role"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		if !param.Role.Valid() {
+			s.respond(w, r, fmt.Errorf("%w: unrecognized role(%s)",
+				errInvalidParam, param.Role), http.StatusOK)
+			return
+		}
+
+		app, err := s.findAppWithRole(r, param.AppId, db.RoleAdmin)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		user, err := s.findUserByName(param.Username)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if user.ID == app.OwnerID {
+			s.respond(w, r, fmt.Errorf("%w: the role of owner could not be changed",
+				errInvalidParam), http.StatusOK)
+			return
+		}
+
+		if err := s.appACLService.Grant(app.ID, user.ID, param.Role); err != nil {
+			panic(err)
+		}
+		s.respond(w, r, success, http.StatusOK)
+	}
+}
+
+func (s *server) handleAppCollaboratorRevoke() http.HandlerFunc {
+	type request struct {
+		AppId    uint32 `json:"appId"`
+		Username string `json:"username"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+
+		user, err := s.findUserByName(param.Username)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		// anyone could leave an app shared with them, otherwise admin is required
+		needRole := db.RoleAdmin
+		if user.ID == currentUserId(r) {
+			needRole = db.RoleViewer
+		}
+		app, err := s.findAppWithRole(r, param.AppId, needRole)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if user.ID == app.OwnerID {
+			s.respond(w, r, fmt.Errorf("%w: the owner could not be revoked",
+				errInvalidParam), http.StatusOK)
+			return
+		}
+
+		if err := s.appACLService.Revoke(app.ID, user.ID); err != nil {
+			panic(err)
+		}
+		s.respond(w, r, success, http.StatusOK)
+	}
+}
+
+func (s *server) handleSharedAppList() http.HandlerFunc {
+	type sharedAppT struct {
+		AppId uint32  `json:"appId"`
+		Owner string  `json:"owner"`
+		Role  db.Role `json:"role"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		acls, err := s.appACLService.ListByUser(currentUserId(r))
+		if err != nil {
+			panic(err)
+		}
+		appIds := make([]uint32, 0, len(acls))
+		roles := make(map[uint32]db.Role)
+		for _, acl := range acls {
+			appIds = append(appIds, acl.AppID)
+			roles[acl.AppID] = acl.Role
+		}
+		apps, err := s.appService.GetMulti(appIds)
+		if err != nil {
+			panic(err)
+		}
+		data := make([]sharedAppT, 0, len(apps))
+		for _, app := range apps {
+			data = append(data, sharedAppT{
+				AppId: app.ID,
+				Owner: s.findUserById(app.OwnerID).UserName,
+				Role:  roles[app.ID],
+			})
+		}
+		s.respond(w, r, defaultResponse{Data: data}, http.StatusOK)
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/rtxu/luban-api/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleAppCollaborator(t *testing.T) {
+	assert := assert.New(t)
+	svr, ownerToken := newTestServer()
+	const kBobId, kAliceId = 1001, 1002
+	bobToken := addTestUser(svr, kBobId, "bob")
+	aliceToken := addTestUser(svr, kAliceId, "alice")
+
+	createEntry(createRequest{
+		Dir: "/",
+		Entry: EntryT{
+			Name: "shared",
+			Type: App,
+		},
+	}, svr, ownerToken)
+	const kAppId = 0
+	appUrl := func(loadType string) string {
+		return fmt.Sprintf("/currentUser/app?appId=%d&loadType=%s", kAppId, loadType)
+	}
+	saveUrl := fmt.Sprintf("/currentUser/app?appId=%d&op=%s", kAppId, kOpSave)
+	grant := func(token, username string, role db.Role) defaultResponse {
+		return assertErrCode(t, 0, doRequest("PUT", "/currentUser/app/collaborator",
+			map[string]interface{}{"appId": kAppId, "username": username, "role": role},
+			svr, token))
+	}
+
+	// strangers could not see the app at all
+	assertErrCode(t, errCodeMap[errEntryNotFound], doRequest("GET", appUrl(kLTView), nil, svr, bobToken))
+
+	// viewer could view, but not edit nor save
+	grant(ownerToken, "bob", db.RoleViewer)
+	assertErrCode(t, success.Code, doRequest("GET", appUrl(kLTView), nil, svr, bobToken))
+	assertErrCode(t, errCodeMap[errPermissionDenied], doRequest("GET", appUrl(kLTEdit), nil, svr, bobToken))
+	assertErrCode(t, errCodeMap[errPermissionDenied], doRequest("PUT", saveUrl, map[string]interface{}{}, svr, bobToken))
+
+	// only admin could grant
+	assertErrCode(t, errCodeMap[errPermissionDenied], doRequest("PUT", "/currentUser/app/collaborator",
+		map[string]interface{}{"appId": kAppId, "username": "alice", "role": db.RoleViewer},
+		svr, bobToken))
+
+	// editor could save
+	grant(ownerToken, "bob", db.RoleEditor)
+	assertErrCode(t, success.Code, doRequest("GET", appUrl(kLTEdit), nil, svr, bobToken))
+	assertErrCode(t, success.Code, doRequest("PUT", saveUrl, map[string]interface{}{"w": 1}, svr, bobToken))
+
+	// admin could grant others
+	grant(ownerToken, "bob", db.RoleAdmin)
+	grant(bobToken, "alice", db.RoleViewer)
+
+	// invalid grants
+	assertErrCode(t, errCodeMap[errInvalidParam], doRequest("PUT", "/currentUser/app/collaborator",
+		map[string]interface{}{"appId": kAppId, "username": "alice", "role": "god"},
+		svr, ownerToken))
+	assertErrCode(t, errCodeMap[errUserNotFound], doRequest("PUT", "/currentUser/app/collaborator",
+		map[string]interface{}{"appId": kAppId, "username": "nobody", "role": db.RoleViewer},
+		svr, ownerToken))
+	assertErrCode(t, errCodeMap[errInvalidParam], doRequest("PUT", "/currentUser/app/collaborator",
+		map[string]interface{}{"appId": kAppId, "username": kTestUserName, "role": db.RoleViewer},
+		svr, bobToken))
+
+	// list collaborators
+	{
+		resp := assertErrCode(t, success.Code,
+			doRequest("GET", fmt.Sprintf("/currentUser/app/collaborator?appId=%d", kAppId), nil, svr, aliceToken))
+		collaborators := resp.Data.([]interface{})
+		assert.Len(collaborators, 3)
+		assert.Equal(kTestUserName, collaborators[0].(map[string]interface{})["username"])
+		assert.Equal(true, collaborators[0].(map[string]interface{})["isOwner"])
+	}
+
+	// shared with me
+	{
+		resp := assertErrCode(t, success.Code, doRequest("GET", "/currentUser/sharedApp", nil, svr, aliceToken))
+		sharedApps := resp.Data.([]interface{})
+		assert.Len(sharedApps, 1)
+		assert.Equal(map[string]interface{}{
+			"appId": float64(kAppId),
+			"owner": kTestUserName,
+			"role":  string(db.RoleViewer),
+		}, sharedApps[0])
+	}
+
+	// viewer could leave, but could not revoke others
+	assertErrCode(t, errCodeMap[errPermissionDenied], doRequest("DELETE", "/currentUser/app/collaborator",
+		map[string]interface{}{"appId": kAppId, "username": "bob"}, svr, aliceToken))
+	assertErrCode(t, success.Code, doRequest("DELETE", "/currentUser/app/collaborator",
+		map[string]interface{}{"appId": kAppId, "username": "alice"}, svr, aliceToken))
+	assertErrCode(t, errCodeMap[errEntryNotFound], doRequest("GET", appUrl(kLTView), nil, svr, aliceToken))
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/jwtauth"
+
+	"github.com/rtxu/luban-api/db"
+)
+
+const (
+	kFormSubmissionDefaultLimit = 50
+	kFormSubmissionMaxLimit     = 500
+)
+
+// FormT 是 app 内容中声明的表单，位于 content.forms.<name>，如：
+//
+//	{"forms": {"signup": {"public": true, "fields": [{"name": "email", "type": "text", "required": true}]}}}
+//
+// fields 的类型、校验同 luban table 的 columns
+type FormT struct {
+	// public forms accept anonymous submissions, otherwise viewers of the app are required
+	Public bool      `json:"public"`
+	Fields []ColumnT `json:"fields"`
+}
+
+// contentForms parses and checks the forms declared in app content
+func contentForms(content json.RawMessage) (map[string]FormT, error) {
+	var c struct {
+		Forms map[string]FormT `json:"forms"`
+	}
+	if err := json.Unmarshal(content, &c); err != nil {
+		return nil, fmt.Errorf("%w: content should be a JSON object with forms, err: %v", errInvalidParam, err)
+	}
+	for name, form := range c.Forms {
+		if !columnNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("%w: form name(%s) should be an identifier", errInvalidParam, name)
+		}
+		if err := checkColumns(form.Fields); err != nil {
+			return nil, fmt.Errorf("%w: form(%s), %v", errInvalidParam, name, err)
+		}
+	}
+	return c.Forms, nil
+}
+
+// findPublishedForm finds the form in the production channel, forms of other channels are not accepted
+func findPublishedForm(app db.App, name string) (FormT, error) {
+	forms, err := contentForms(app.LastPublishedContent)
+	if err != nil {
+		// published before the forms are checked, see handleAppSave and executePublishSchedule
+		return FormT{}, fmt.Errorf("%w: invalid forms of app(%d), err: %v", errFormNotFound, app.ID, err)
+	}
+	form, ok := forms[name]
+	if !ok {
+		return FormT{}, fmt.Errorf("%w: form(%s) of app(%d)", errFormNotFound, name, app.ID)
+	}
+	return form, nil
+}
+
+// optionalUserId returns the current user, or 0 for anonymous users and invalid tokens
+func optionalUserId(r *http.Request) uint32 {
+	token, claims, err := jwtauth.FromContext(r.Context())
+	if err != nil || token == nil || !token.Valid {
+		return 0
+	}
+	userId, _ := claims[kTokenClaimUserId].(float64)
+	return uint32(userId)
+}
+
+// handleFormSubmit 供已发布 app 的 viewer 提交表单，public 表单允许匿名提交
+func (s *server) handleFormSubmit() http.HandlerFunc {
+	type request struct {
+		AppId uint32                     `json:"appId"`
+		Form  string                     `json:"form"`
+		Data  map[string]json.RawMessage `json:"data"`
+	}
+	type dataT struct {
+		Id uint32 `json:"id"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, kLubanRowMaxSize)
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+
+		app, err := s.appService.Get(param.AppId)
+		if err != nil {
+			if errors.Is(err, db.ErrNotFound) {
+				s.respond(w, r, fmt.Errorf("%w: appId is %d", errEntryNotFound, param.AppId), http.StatusOK)
+				return
+			}
+			panic(err)
+		}
+		form, err := findPublishedForm(app, param.Form)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		userId := optionalUserId(r)
+		if !form.Public {
+			if userId == 0 {
+				s.respond(w, r, fmt.Errorf("%w: login is required to submit form(%s)",
+					errPermissionDenied, param.Form), http.StatusOK)
+				return
+			}
+			if _, err := s.findAppWithRole(r, app.ID, db.RoleViewer); err != nil {
+				s.respond(w, r, err, http.StatusOK)
+				return
+			}
+		}
+		data, err := rowData(form.Fields, nil, param.Data)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		sub := db.FormSubmission{AppID: app.ID, Form: param.Form, Data: data, SubmittedBy: userId}
+		if err := s.formSubmissionService.Insert(&sub); err != nil {
+			panic(err)
+		}
+		s.respond(w, r, defaultResponse{Data: dataT{Id: sub.ID}}, http.StatusOK)
+	}
+}
+
+// FormSubmissionT 是一次表单提交，data 原样返回，不随表单字段的变更而变化
+type FormSubmissionT struct {
+	Id          uint32                 `json:"id"`
+	Data        map[string]interface{} `json:"data"`
+	SubmittedBy string                 `json:"submittedBy"`
+	SubmittedAt time.Time              `json:"submittedAt"`
+}
+
+// handleFormSubmissionList 供 app 的 admin 按提交顺序分页查看提交，返回 {submissions, total}
+func (s *server) handleFormSubmissionList() http.HandlerFunc {
+	type dataT struct {
+		Submissions []FormSubmissionT `json:"submissions"`
+		Total       uint64            `json:"total"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		app, err := s.findAppWithRoleByQuery(r, db.RoleAdmin)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		query := r.URL.Query()
+		var page [2]uint64
+		for i, name := range []string{"offset", "limit"} {
+			if v := query.Get(name); v != "" {
+				if page[i], err = strconv.ParseUint(v, 10, 32); err != nil {
+					s.respond(w, r, fmt.Errorf("%w: %s(%s) is not a number", errInvalidParam, name, v),
+						http.StatusOK)
+					return
+				}
+			}
+		}
+		offset, limit := uint(page[0]), uint(page[1])
+		if limit == 0 {
+			limit = kFormSubmissionDefaultLimit
+		}
+		if limit > kFormSubmissionMaxLimit {
+			limit = kFormSubmissionMaxLimit
+		}
+
+		subs, total, err := s.formSubmissionService.List(app.ID, query.Get("form"), offset, limit)
+		if err != nil {
+			panic(err)
+		}
+		cache := make(usernameCache)
+		data := dataT{Submissions: make([]FormSubmissionT, 0, len(subs)), Total: total}
+		for _, sub := range subs {
+			data.Submissions = append(data.Submissions, FormSubmissionT{
+				Id:          sub.ID,
+				Data:        decodeRowData(sub.Data),
+				SubmittedBy: s.username(cache, sub.SubmittedBy),
+				SubmittedAt: sub.CreatedAt,
+			})
+		}
+		s.respond(w, r, defaultResponse{Data: data}, http.StatusOK)
+	}
+}
+
+// handleFormSubmissionExport 以 CSV 流式导出表单的全部提交，列为 id、submittedAt、submittedBy
+// 及已发布表单的各字段
+func (s *server) handleFormSubmissionExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app, err := s.findAppWithRoleByQuery(r, db.RoleAdmin)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		name := r.URL.Query().Get("form")
+		form, err := findPublishedForm(app, name)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition",
+			mime.FormatMediaType("attachment", map[string]string{"filename": name + ".csv"}))
+		writer := csv.NewWriter(w)
+		headers := []string{"id", "submittedAt", "submittedBy"}
+		for _, f := range form.Fields {
+			headers = append(headers, f.Name)
+		}
+		writer.Write(headers)
+
+		cache := make(usernameCache)
+		for offset := uint(0); ; offset += kFormSubmissionMaxLimit {
+			subs, _, err := s.formSubmissionService.List(app.ID, name, offset, kFormSubmissionMaxLimit)
+			if err != nil {
+				panic(err)
+			}
+			for _, sub := range subs {
+				data := decodeRowData(sub.Data)
+				record := []string{
+					strconv.FormatUint(uint64(sub.ID), 10),
+					sub.CreatedAt.UTC().Format(time.RFC3339),
+					s.username(cache, sub.SubmittedBy),
+				}
+				for _, f := range form.Fields {
+					record = append(record, csvCell(f, data[f.Name]))
+				}
+				writer.Write(record)
+			}
+			writer.Flush()
+			if err := writer.Error(); err != nil {
+				// the client has gone, nothing could be responded
+				return
+			}
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+			if len(subs) < kFormSubmissionMaxLimit {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rtxu/luban-api/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleFormSubmission(t *testing.T) {
+	assert := assert.New(t)
+	svr, token := newTestServer()
+	const kViewerId = 1001
+	viewerToken := addTestUser(svr, kViewerId, "viewer")
+	strangerToken := addTestUser(svr, 1002, "stranger")
+	createEntry(createRequest{
+		Dir:   "/",
+		Entry: EntryT{Name: "entry1", Type: App},
+	}, svr, token)
+	svr.appACLService.Grant(0, kViewerId, db.RoleViewer)
+
+	submit := func(code int, form string, data map[string]interface{}, token string) {
+		assertErrCode(t, code, doRequest("POST", "/app/form/submit",
+			map[string]interface{}{"appId": 0, "form": form, "data": data}, svr, token))
+	}
+	feedback := map[string]interface{}{"email": "a@b.c", "score": 5}
+	// not published yet
+	submit(errCodeMap[errFormNotFound], "feedback", feedback, "")
+	// published before the forms are checked
+	svr.appService.UpdateLastPublishedContent(0, kTestUserId, json.RawMessage(`{"forms":["legacy"]}`), nil)
+	submit(errCodeMap[errFormNotFound], "feedback", feedback, "")
+
+	// invalid forms could not be published
+	for _, content := range []interface{}{
+		map[string]interface{}{"forms": []int{1}},
+		map[string]interface{}{"forms": map[string]interface{}{"1st": map[string]interface{}{}}},
+		map[string]interface{}{"forms": map[string]interface{}{"f": map[string]interface{}{
+			"fields": []map[string]interface{}{{"name": "a", "type": "blob"}},
+		}}},
+	} {
+		assertErrCode(t, errCodeMap[errInvalidParam], doRequest("PUT",
+			"/currentUser/app?appId=0&op="+kOpPublish, content, svr, token))
+	}
+	forms := map[string]interface{}{
+		"feedback": map[string]interface{}{
+			"public": true,
+			"fields": []map[string]interface{}{
+				{"name": "email", "type": db.ColumnTypeText, "required": true},
+				{"name": "score", "type": db.ColumnTypeNumber},
+			},
+		},
+		"signup": map[string]interface{}{
+			"fields": []map[string]interface{}{{"name": "name", "type": db.ColumnTypeText}},
+		},
+	}
+	// forms of other channels are not accepted
+	assertErrCode(t, success.Code, doRequest("PUT",
+		"/currentUser/app?appId=0&op="+kOpPublish+"&channel="+kChannelStaging, map[string]interface{}{"forms": forms}, svr, token))
+	submit(errCodeMap[errFormNotFound], "feedback", feedback, "")
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/app?appId=0&op="+kOpPublish,
+		map[string]interface{}{"forms": forms}, svr, token))
+
+	// public forms accept anonymous submissions, checked against the fields
+	submit(success.Code, "feedback", feedback, "")
+	submit(errCodeMap[errInvalidParam], "feedback", map[string]interface{}{"score": 1}, "")
+	submit(errCodeMap[errInvalidParam], "feedback",
+		map[string]interface{}{"email": "x", "unknown": 1}, "")
+	submit(success.Code, "feedback", map[string]interface{}{"email": "=1+1"}, viewerToken)
+	submit(errCodeMap[errFormNotFound], "unknown", feedback, "")
+
+	// others require viewers of the app
+	submit(errCodeMap[errPermissionDenied], "signup", map[string]interface{}{"name": "x"}, "")
+	submit(errCodeMap[errEntryNotFound], "signup", map[string]interface{}{"name": "x"}, strangerToken)
+	submit(success.Code, "signup", map[string]interface{}{"name": "x"}, viewerToken)
+
+	// only admins could list and export
+	assertErrCode(t, errCodeMap[errPermissionDenied], doRequest("GET",
+		"/currentUser/app/form/submission?appId=0&form=feedback", nil, svr, viewerToken))
+	resp := assertErrCode(t, success.Code, doRequest("GET",
+		"/currentUser/app/form/submission?appId=0&form=feedback&offset=1", nil, svr, token))
+	data := resp.Data.(map[string]interface{})
+	assert.Equal(float64(2), data["total"])
+	subs := data["submissions"].([]interface{})
+	assert.Len(subs, 1)
+	assert.Equal(map[string]interface{}{"email": "=1+1"}, subs[0].(map[string]interface{})["data"])
+	assert.Equal("viewer", subs[0].(map[string]interface{})["submittedBy"])
+
+	assertErrCode(t, errCodeMap[errPermissionDenied], doRequest("GET",
+		"/currentUser/app/form/submission/export?appId=0&form=feedback", nil, svr, viewerToken))
+	httpReq := httptest.NewRequest("GET", "/currentUser/app/form/submission/export?appId=0&form=feedback", nil)
+	httpReq.Header.Add("Authorization", "BEARER "+token)
+	httpResp := handleRequest(httpReq, svr)
+	assert.Equal(`attachment; filename=feedback.csv`, httpResp.Header.Get("Content-Disposition"))
+	body, _ := ioutil.ReadAll(httpResp.Body)
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	assert.Len(lines, 3)
+	assert.Equal("id,submittedAt,submittedBy,email,score", lines[0])
+	assert.True(strings.HasPrefix(lines[1], "0,"))
+	assert.True(strings.HasSuffix(lines[1], ",,a@b.c,5"))
+	assert.True(strings.HasSuffix(lines[2], ",viewer,'=1+1,"))
+}
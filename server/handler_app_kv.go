@@ -0,0 +1,266 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/rtxu/luban-api/db"
+)
+
+const (
+	// shared by all the viewers of the app, e.g. a counter
+	kKVScopeApp = "app"
+	// private to the current viewer, e.g. filter choices
+	kKVScopeUser = "user"
+
+	kKVMaxValueSize    = 16 * 1024
+	kKVMaxKeysPerScope = 1000
+)
+
+var kvKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,128}$`)
+
+// KVT 是 app 保存的一个键值对
+type KVT struct {
+	Key       string          `json:"key"`
+	Value     json.RawMessage `json:"value"`
+	Version   uint64          `json:"version"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+func newKV(kv db.AppKV) KVT {
+	return KVT{
+		Key:       kv.Key,
+		Value:     kv.Value,
+		Version:   kv.Version,
+		UpdatedAt: kv.UpdatedAt,
+	}
+}
+
+// kvScope checks the scope and key, and returns the user id of the scope
+func kvScope(r *http.Request, scope, key string) (uint32, error) {
+	if !kvKeyRegexp.MatchString(key) {
+		return 0, fmt.Errorf("%w: key(%s) should be 1 to 128 letters, digits or _.:-", errInvalidParam, key)
+	}
+	switch scope {
+	case kKVScopeApp:
+		// see db.AppKV
+		return 0, nil
+	case kKVScopeUser:
+		return currentUserId(r), nil
+	}
+	return 0, fmt.Errorf("%w: unrecognized scope(%s)", errInvalidParam, scope)
+}
+
+// checkKVOverwrite checks the role to overwrite or delete key regardless of its version.
+// Keys of the app scope are shared by all the viewers, who could only change them by
+// compare-and-set or increment, otherwise any viewer could wipe out the others' writes
+func (s *server) checkKVOverwrite(r *http.Request, app db.App, scope string) error {
+	if scope != kKVScopeApp {
+		return nil
+	}
+	if !s.appRole(currentUserId(r), app).Covers(db.RoleEditor) {
+		return fmt.Errorf("%w: %s role is required to overwrite or delete keys of app(%d), use version instead",
+			errPermissionDenied, db.RoleEditor, app.ID)
+	}
+	return nil
+}
+
+// checkKVQuota checks the number of keys in the scope when key is to be added
+func (s *server) checkKVQuota(appId, userId uint32, key string) error {
+	_, err := s.appKVService.Get(appId, userId, key)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, db.ErrNotFound) {
+		panic(err)
+	}
+	n, err := s.appKVService.Count(appId, userId)
+	if err != nil {
+		panic(err)
+	}
+	if n >= kKVMaxKeysPerScope {
+		return fmt.Errorf("%w: at most %d keys in a scope", errQuotaExceeded, kKVMaxKeysPerScope)
+	}
+	return nil
+}
+
+// handleKVGet 供已发布 app 的 viewer 读取 app 级或自己的键值
+func (s *server) handleKVGet() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app, err := s.findAppWithRoleByQuery(r, db.RoleViewer)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		query := r.URL.Query()
+		key := query.Get("key")
+		userId, err := kvScope(r, query.Get("scope"), key)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		kv, err := s.appKVService.Get(app.ID, userId, key)
+		if err != nil {
+			if errors.Is(err, db.ErrNotFound) {
+				s.respond(w, r, fmt.Errorf("%w: %s", errKVNotFound, key), http.StatusOK)
+				return
+			}
+			panic(err)
+		}
+		s.respond(w, r, defaultResponse{Data: newKV(kv)}, http.StatusOK)
+	}
+}
+
+// handleKVSet 写入键值，带 version 时为 compare-and-set：仅当当前版本为 version 时写入，
+// version 为 0 表示仅当 key 不存在时写入，否则返回 errKVConflict。
+// 不带 version 覆盖 app 级的键值需要 editor 角色
+func (s *server) handleKVSet() http.HandlerFunc {
+	type request struct {
+		AppId   uint32          `json:"appId"`
+		Scope   string          `json:"scope"`
+		Key     string          `json:"key"`
+		Value   json.RawMessage `json:"value"`
+		Version *uint64         `json:"version"`
+	}
+	type dataT struct {
+		Version uint64 `json:"version"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		app, err := s.findAppWithRole(r, param.AppId, db.RoleViewer)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		userId, err := kvScope(r, param.Scope, param.Key)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if param.Value == nil {
+			s.respond(w, r, fmt.Errorf("%w: value is required", errInvalidParam), http.StatusOK)
+			return
+		}
+		if param.Version == nil {
+			if err := s.checkKVOverwrite(r, app, param.Scope); err != nil {
+				s.respond(w, r, err, http.StatusOK)
+				return
+			}
+		}
+		if len(param.Value) > kKVMaxValueSize {
+			s.respond(w, r, fmt.Errorf("%w: value should be at most %d bytes",
+				errInvalidParam, kKVMaxValueSize), http.StatusOK)
+			return
+		}
+		if err := s.checkKVQuota(app.ID, userId, param.Key); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		var version uint64
+		if param.Version != nil {
+			version, err = s.appKVService.CompareAndSet(app.ID, userId, param.Key, *param.Version, param.Value)
+		} else {
+			version, err = s.appKVService.Set(app.ID, userId, param.Key, param.Value)
+		}
+		if err != nil {
+			if errors.Is(err, db.ErrVersionConflict) {
+				s.respond(w, r, fmt.Errorf("%w: %s", errKVConflict, param.Key), http.StatusOK)
+				return
+			}
+			panic(err)
+		}
+		s.respond(w, r, defaultResponse{Data: dataT{Version: version}}, http.StatusOK)
+	}
+}
+
+// handleKVIncrement 原子地为数值加上 delta，key 不存在时视为 0
+func (s *server) handleKVIncrement() http.HandlerFunc {
+	type request struct {
+		AppId uint32  `json:"appId"`
+		Scope string  `json:"scope"`
+		Key   string  `json:"key"`
+		Delta float64 `json:"delta"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		app, err := s.findAppWithRole(r, param.AppId, db.RoleViewer)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		userId, err := kvScope(r, param.Scope, param.Key)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := s.checkKVQuota(app.ID, userId, param.Key); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		kv, err := s.appKVService.Increment(app.ID, userId, param.Key, param.Delta)
+		if err != nil {
+			switch {
+			case errors.Is(err, db.ErrNotNumber):
+				s.respond(w, r, fmt.Errorf("%w: value of key(%s) is not a number",
+					errInvalidParam, param.Key), http.StatusOK)
+			case errors.Is(err, db.ErrVersionConflict):
+				// too many concurrent writers
+				s.respond(w, r, fmt.Errorf("%w: %s", errKVConflict, param.Key), http.StatusOK)
+			default:
+				panic(err)
+			}
+			return
+		}
+		s.respond(w, r, defaultResponse{Data: newKV(kv)}, http.StatusOK)
+	}
+}
+
+// handleKVDelete 删除键值，删除 app 级的键值需要 editor 角色
+func (s *server) handleKVDelete() http.HandlerFunc {
+	type request struct {
+		AppId uint32 `json:"appId"`
+		Scope string `json:"scope"`
+		Key   string `json:"key"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		app, err := s.findAppWithRole(r, param.AppId, db.RoleViewer)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		userId, err := kvScope(r, param.Scope, param.Key)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := s.checkKVOverwrite(r, app, param.Scope); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		if err := s.appKVService.Delete(app.ID, userId, param.Key); err != nil {
+			panic(err)
+		}
+		s.respond(w, r, success, http.StatusOK)
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rtxu/luban-api/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleAppKV(t *testing.T) {
+	assert := assert.New(t)
+	svr, token := newTestServer()
+	const kViewerId = 1001
+	viewerToken := addTestUser(svr, kViewerId, "viewer")
+	strangerToken := addTestUser(svr, 1002, "stranger")
+	createEntry(createRequest{
+		Dir:   "/",
+		Entry: EntryT{Name: "entry1", Type: App},
+	}, svr, token)
+	svr.appACLService.Grant(0, kViewerId, db.RoleViewer)
+
+	get := func(scope, key, token string) *http.Response {
+		return doRequest("GET", fmt.Sprintf("/currentUser/app/kv?appId=0&scope=%s&key=%s", scope, key),
+			nil, svr, token)
+	}
+	set := func(param map[string]interface{}, token string) *http.Response {
+		param["appId"] = 0
+		return doRequest("PUT", "/currentUser/app/kv", param, svr, token)
+	}
+
+	assertErrCode(t, errCodeMap[errKVNotFound], get("user", "filter", viewerToken))
+	assertErrCode(t, errCodeMap[errEntryNotFound], get("user", "filter", strangerToken))
+	for _, param := range []map[string]interface{}{
+		{"scope": "org", "key": "filter", "value": 1},
+		{"scope": "user", "key": "a b", "value": 1},
+		{"scope": "user", "key": strings.Repeat("k", 129), "value": 1},
+		{"scope": "user", "key": "filter"},
+		{"scope": "user", "key": "filter", "value": strings.Repeat("v", kKVMaxValueSize)},
+	} {
+		assertErrCode(t, errCodeMap[errInvalidParam], set(param, viewerToken))
+	}
+
+	// per-viewer scope
+	filter := map[string]interface{}{"status": "open"}
+	resp := assertErrCode(t, success.Code, set(map[string]interface{}{
+		"scope": "user", "key": "filter", "value": filter}, viewerToken))
+	assert.Equal(float64(1), resp.Data.(map[string]interface{})["version"])
+	resp = assertErrCode(t, success.Code, get("user", "filter", viewerToken))
+	assert.Equal(filter, resp.Data.(map[string]interface{})["value"])
+	assertErrCode(t, errCodeMap[errKVNotFound], get("user", "filter", token))
+
+	// compare-and-set
+	assertErrCode(t, errCodeMap[errKVConflict], set(map[string]interface{}{
+		"scope": "user", "key": "filter", "value": 1, "version": 0}, viewerToken))
+	assertErrCode(t, errCodeMap[errKVConflict], set(map[string]interface{}{
+		"scope": "user", "key": "filter", "value": 1, "version": 2}, viewerToken))
+	resp = assertErrCode(t, success.Code, set(map[string]interface{}{
+		"scope": "user", "key": "filter", "value": 1, "version": 1}, viewerToken))
+	assert.Equal(float64(2), resp.Data.(map[string]interface{})["version"])
+	assertErrCode(t, success.Code, set(map[string]interface{}{
+		"scope": "user", "key": "new", "value": 1, "version": 0}, viewerToken))
+
+	// app-global scope, incremented concurrently
+	incr := func(key string, delta float64, token string) *http.Response {
+		return doRequest("POST", "/currentUser/app/kv/incr",
+			map[string]interface{}{"appId": 0, "scope": "app", "key": key, "delta": delta}, svr, token)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(token string) {
+			defer wg.Done()
+			incr("counter", 1, token)
+		}([]string{token, viewerToken}[i%2])
+	}
+	wg.Wait()
+	resp = assertErrCode(t, success.Code, incr("counter", 0.5, token))
+	assert.Equal(float64(20.5), resp.Data.(map[string]interface{})["value"])
+	assert.Equal(float64(21), resp.Data.(map[string]interface{})["version"])
+	resp = assertErrCode(t, success.Code, get("app", "counter", viewerToken))
+	assert.Equal(float64(20.5), resp.Data.(map[string]interface{})["value"])
+	assertErrCode(t, success.Code, set(map[string]interface{}{"scope": "app", "key": "title", "value": "hi"}, token))
+	assertErrCode(t, errCodeMap[errInvalidParam], incr("title", 1, token))
+
+	// viewers change the app scope by compare-and-set only
+	assertErrCode(t, errCodeMap[errPermissionDenied], set(map[string]interface{}{
+		"scope": "app", "key": "title", "value": "pwned"}, viewerToken))
+	resp = assertErrCode(t, success.Code, set(map[string]interface{}{
+		"scope": "app", "key": "title", "value": "hello", "version": 1}, viewerToken))
+	assert.Equal(float64(2), resp.Data.(map[string]interface{})["version"])
+	del := func(scope, key, token string) *http.Response {
+		return doRequest("DELETE", "/currentUser/app/kv",
+			map[string]interface{}{"appId": 0, "scope": scope, "key": key}, svr, token)
+	}
+	assertErrCode(t, errCodeMap[errPermissionDenied], del("app", "counter", viewerToken))
+	assertErrCode(t, success.Code, del("app", "counter", token))
+	assertErrCode(t, errCodeMap[errKVNotFound], get("app", "counter", token))
+
+	// number of keys in a scope
+	for i := 0; i < kKVMaxKeysPerScope-2; i++ {
+		svr.appKVService.Set(0, kViewerId, fmt.Sprintf("k%d", i), []byte("1"))
+	}
+	assertErrCode(t, errCodeMap[errQuotaExceeded], set(map[string]interface{}{
+		"scope": "user", "key": "more", "value": 1}, viewerToken))
+	assertErrCode(t, success.Code, set(map[string]interface{}{
+		"scope": "user", "key": "filter", "value": 2}, viewerToken))
+	assertErrCode(t, success.Code, set(map[string]interface{}{
+		"scope": "app", "key": "more", "value": 1, "version": 0}, viewerToken))
+}
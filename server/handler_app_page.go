@@ -0,0 +1,543 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rtxu/luban-api/db"
+)
+
+const (
+	kAppMaxPages       = 50
+	kPageNameMaxLength = 64
+)
+
+// PageT 是 app 的一个页面，不含页面内容
+type PageT struct {
+	Id        uint32    `json:"id"`
+	Name      string    `json:"name"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	UpdatedBy string    `json:"updatedBy"`
+}
+
+func (s *server) newPage(page db.AppPage, cache usernameCache) PageT {
+	return PageT{
+		Id:        page.ID,
+		Name:      page.Name,
+		UpdatedAt: page.UpdatedAt,
+		UpdatedBy: s.username(cache, page.UpdatedBy),
+	}
+}
+
+// pageSnapshotT is a page in the content of an app, see withPages
+type pageSnapshotT struct {
+	Id   uint32 `json:"id"`
+	Name string `json:"name"`
+	// omitted when the editor loads the app, pages are loaded one by one
+	Content json.RawMessage `json:"content,omitempty"`
+}
+
+func isJSONObject(v json.RawMessage) bool {
+	var m map[string]json.RawMessage
+	return json.Unmarshal(v, &m) == nil && m != nil
+}
+
+// withPages adds pages to content under the key `pages`.
+// Apps without pages keep their content as is, which is the single page of the app.
+func withPages(content json.RawMessage, pages []db.AppPage, withContent bool) (json.RawMessage, error) {
+	if len(pages) == 0 {
+		return content, nil
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(content, &m); err != nil {
+		return nil, err
+	}
+	if m == nil {
+		m = make(map[string]json.RawMessage)
+	}
+	snapshots := make([]pageSnapshotT, 0, len(pages))
+	for _, page := range pages {
+		snapshot := pageSnapshotT{Id: page.ID, Name: page.Name}
+		if withContent {
+			snapshot.Content = page.Content
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	var err error
+	m["pages"], err = json.Marshal(snapshots)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
+// splitPages is the reverse of withPages, e.g. for apps created from a template
+func splitPages(content json.RawMessage) (json.RawMessage, []pageSnapshotT) {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(content, &m); err != nil || m["pages"] == nil {
+		return content, nil
+	}
+	var pages []pageSnapshotT
+	if err := json.Unmarshal(m["pages"], &pages); err != nil {
+		// not made by withPages
+		return content, nil
+	}
+	delete(m, "pages")
+	rest, err := json.Marshal(m)
+	if err != nil {
+		panic(err)
+	}
+	return rest, pages
+}
+
+func (s *server) listPages(appId uint32) []db.AppPage {
+	pages, err := s.appPageService.ListByApp(appId)
+	if err != nil {
+		panic(err)
+	}
+	return pages
+}
+
+// appSnapshot returns the draft of all the pages of app, which is what publish would save
+func (s *server) appSnapshot(app db.App) json.RawMessage {
+	content, err := withPages(app.Content, s.listPages(app.ID), true)
+	if err != nil {
+		// app.Content is checked to be an object once the app has pages
+		panic(err)
+	}
+	return content
+}
+
+// kNotDraft is the draft revision of the contents published other than the saved draft
+const kNotDraft = math.MaxUint64
+
+// draftMarkerT marks the draft which a published content is made from, so that unpublished
+// changes are told without loading the pages and the components of the draft again
+type draftMarkerT struct {
+	// App.DraftRevision when published
+	Revision uint64 `json:"revision"`
+	// versions of the components tracking the latest, at any depth
+	Components map[uint32]uint32 `json:"components,omitempty"`
+}
+
+func newDraftMarker(revision uint64, components map[uint32]uint32) json.RawMessage {
+	marker, err := json.Marshal(draftMarkerT{Revision: revision, Components: components})
+	if err != nil {
+		panic(err)
+	}
+	return marker
+}
+
+// resolveDraft returns the snapshot of the draft to publish, along with its marker
+func (s *server) resolveDraft(app db.App) (content, marker json.RawMessage, err error) {
+	content, components, err := s.resolveTrackedComponents(app, s.appSnapshot(app))
+	if err != nil {
+		return nil, nil, err
+	}
+	return content, newDraftMarker(app.DraftRevision, components), nil
+}
+
+// componentVersions caches the latest versions of components within a request, 0 for the deleted
+type componentVersions map[uint32]uint32
+
+func (s *server) componentVersion(cache componentVersions, componentId uint32) uint32 {
+	if version, ok := cache[componentId]; ok {
+		return version
+	}
+	c, err := s.componentService.Find(componentId)
+	if err != nil {
+		if !errors.Is(err, db.ErrNotFound) {
+			panic(err)
+		}
+		c.Version = 0
+	}
+	cache[componentId] = c.Version
+	return c.Version
+}
+
+// hasUnpublishedChanges reports whether the draft differs from the published content,
+// new versions of the components tracked by the draft are changes as well
+func (s *server) hasUnpublishedChanges(app db.App, versions componentVersions) bool {
+	if app.PublishedDraft == nil {
+		// never published, or published before the drafts are marked
+		return app.DraftRevision != 0 || !bytes.Equal(app.Content, app.LastPublishedContent)
+	}
+	var marker draftMarkerT
+	if err := json.Unmarshal(app.PublishedDraft, &marker); err != nil {
+		panic(err)
+	}
+	if marker.Revision != app.DraftRevision {
+		return true
+	}
+	for componentId, version := range marker.Components {
+		if s.componentVersion(versions, componentId) != version {
+			return true
+		}
+	}
+	return false
+}
+
+// newAppPages saves pages of a new app, which is created from a template with pages
+func (s *server) newAppPages(appId, operator uint32, pages []pageSnapshotT) {
+	for i, snapshot := range pages {
+		page := db.AppPage{
+			AppID:     appId,
+			Name:      snapshot.Name,
+			Position:  uint32(i),
+			Content:   snapshot.Content,
+			UpdatedBy: operator,
+		}
+		if page.Content == nil {
+			page.Content = json.RawMessage("{}")
+		}
+		if err := s.appPageService.NewPage(&page); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// checkPageName checks that name is valid and not used by other pages of the app,
+// self is the page being renamed, nil for a new one
+func checkPageName(pages []db.AppPage, name string, self *db.AppPage) error {
+	if name == "" || utf8.RuneCountInString(name) > kPageNameMaxLength {
+		return fmt.Errorf("%w: page name should be 1 to %d characters", errInvalidParam, kPageNameMaxLength)
+	}
+	for _, page := range pages {
+		if page.Name == name && (self == nil || page.ID != self.ID) {
+			return fmt.Errorf("%w: page(%s) already exists", errInvalidParam, name)
+		}
+	}
+	return nil
+}
+
+func findPage(pages []db.AppPage, pageId uint32) (db.AppPage, error) {
+	for _, page := range pages {
+		if page.ID == pageId {
+			return page, nil
+		}
+	}
+	return db.AppPage{}, fmt.Errorf("%w: pageId is %d", errPageNotFound, pageId)
+}
+
+// findEditablePage finds the app and the page for editors, the edit lock is checked as well
+func (s *server) findEditablePage(r *http.Request, appId, pageId uint32) (db.App, db.AppPage, error) {
+	app, err := s.findAppWithRole(r, appId, db.RoleEditor)
+	if err != nil {
+		return app, db.AppPage{}, err
+	}
+	if err := s.checkEditLock(r, app.ID); err != nil {
+		return app, db.AppPage{}, err
+	}
+	page, err := s.appPageService.Find(app.ID, pageId)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return app, page, fmt.Errorf("%w: pageId is %d", errPageNotFound, pageId)
+		}
+		panic(err)
+	}
+	return app, page, nil
+}
+
+func parsePageId(pageIdStr string) (uint32, error) {
+	u64, err := strconv.ParseUint(pageIdStr, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%w: pageId(%s) is not a number, err: %v",
+			errBadRequest, pageIdStr, err)
+	}
+	return uint32(u64), nil
+}
+
+func (s *server) handlePageList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app, err := s.findAppWithRoleByQuery(r, db.RoleEditor)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		cache := make(usernameCache)
+		data := make([]PageT, 0)
+		for _, page := range s.listPages(app.ID) {
+			data = append(data, s.newPage(page, cache))
+		}
+		s.respond(w, r, defaultResponse{Data: data}, http.StatusOK)
+	}
+}
+
+// handlePageCreate 在 app 的最后添加一个页面，content 为空时是空白页面
+func (s *server) handlePageCreate() http.HandlerFunc {
+	type request struct {
+		AppId   uint32          `json:"appId"`
+		Name    string          `json:"name"`
+		Content json.RawMessage `json:"content"`
+	}
+	type dataT struct {
+		Id uint32 `json:"id"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		app, err := s.findAppWithRole(r, param.AppId, db.RoleEditor)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := s.checkEditLock(r, app.ID); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if param.Content == nil || string(param.Content) == "null" {
+			param.Content = json.RawMessage("{}")
+		}
+		if !isJSONObject(param.Content) {
+			s.respond(w, r, fmt.Errorf("%w: content should be an object", errInvalidParam), http.StatusOK)
+			return
+		}
+
+		pages := s.listPages(app.ID)
+		if len(pages) >= kAppMaxPages {
+			s.respond(w, r, fmt.Errorf("%w: at most %d pages in an app",
+				errInvalidParam, kAppMaxPages), http.StatusOK)
+			return
+		}
+		if err := checkPageName(pages, param.Name, nil); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		// the rest of the draft is merged with pages, see withPages
+		if len(pages) == 0 && !isJSONObject(app.Content) {
+			s.respond(w, r, fmt.Errorf("%w: content of app(%d) should be an object to add pages",
+				errInvalidParam, app.ID), http.StatusOK)
+			return
+		}
+
+		page := db.AppPage{
+			AppID:     app.ID,
+			Name:      param.Name,
+			Content:   param.Content,
+			UpdatedBy: currentUserId(r),
+		}
+		if len(pages) > 0 {
+			page.Position = pages[len(pages)-1].Position + 1
+		}
+		if err := s.appPageService.NewPage(&page); err != nil {
+			panic(err)
+		}
+		if err := s.appService.TouchDraft(app.ID, currentUserId(r)); err != nil {
+			panic(err)
+		}
+		s.publishAppEvent(app, kEventAppSaved, "", currentUserId(r))
+		s.respond(w, r, defaultResponse{Data: dataT{Id: page.ID}}, http.StatusOK)
+	}
+}
+
+func (s *server) handlePageRename() http.HandlerFunc {
+	type request struct {
+		AppId  uint32 `json:"appId"`
+		PageId uint32 `json:"pageId"`
+		Name   string `json:"name"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		app, page, err := s.findEditablePage(r, param.AppId, param.PageId)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := checkPageName(s.listPages(app.ID), param.Name, &page); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		err = s.appPageService.Update(app.ID, page.ID, map[string]interface{}{
+			"name":       param.Name,
+			"updated_at": time.Now(),
+			"updated_by": currentUserId(r),
+		})
+		if err != nil {
+			panic(err)
+		}
+		if err := s.appService.TouchDraft(app.ID, currentUserId(r)); err != nil {
+			panic(err)
+		}
+		s.publishAppEvent(app, kEventAppSaved, "", currentUserId(r))
+		s.respond(w, r, success, http.StatusOK)
+	}
+}
+
+// handlePageReorder 调整页面顺序，pageIds 须包含 app 的全部页面
+func (s *server) handlePageReorder() http.HandlerFunc {
+	type request struct {
+		AppId   uint32   `json:"appId"`
+		PageIds []uint32 `json:"pageIds"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		app, err := s.findAppWithRole(r, param.AppId, db.RoleEditor)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := s.checkEditLock(r, app.ID); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		pages := s.listPages(app.ID)
+		seen := make(map[uint32]bool)
+		for _, pageId := range param.PageIds {
+			if _, err := findPage(pages, pageId); err != nil {
+				s.respond(w, r, err, http.StatusOK)
+				return
+			}
+			seen[pageId] = true
+		}
+		if len(seen) != len(param.PageIds) || len(seen) != len(pages) {
+			s.respond(w, r, fmt.Errorf("%w: pageIds should list every page once",
+				errInvalidParam), http.StatusOK)
+			return
+		}
+
+		if err := s.appPageService.Reorder(app.ID, param.PageIds); err != nil {
+			panic(err)
+		}
+		if err := s.appService.TouchDraft(app.ID, currentUserId(r)); err != nil {
+			panic(err)
+		}
+		s.publishAppEvent(app, kEventAppSaved, "", currentUserId(r))
+		s.respond(w, r, success, http.StatusOK)
+	}
+}
+
+func (s *server) handlePageDelete() http.HandlerFunc {
+	type request struct {
+		AppId  uint32 `json:"appId"`
+		PageId uint32 `json:"pageId"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		app, page, err := s.findEditablePage(r, param.AppId, param.PageId)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		if err := s.appPageService.Delete(app.ID, page.ID); err != nil {
+			panic(err)
+		}
+		if err := s.appService.TouchDraft(app.ID, currentUserId(r)); err != nil {
+			panic(err)
+		}
+		s.publishAppEvent(app, kEventAppSaved, "", currentUserId(r))
+		s.respond(w, r, success, http.StatusOK)
+	}
+}
+
+// handlePageGet 加载一个页面的草稿
+func (s *server) handlePageGet() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app, err := s.findAppWithRoleByQuery(r, db.RoleEditor)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		pageId, err := parsePageId(r.URL.Query().Get("pageId"))
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		page, err := s.appPageService.Find(app.ID, pageId)
+		if err != nil {
+			if errors.Is(err, db.ErrNotFound) {
+				s.respond(w, r, fmt.Errorf("%w: pageId is %d", errPageNotFound, pageId), http.StatusOK)
+				return
+			}
+			panic(err)
+		}
+		s.respond(w, r, defaultResponse{
+			Data: pageSnapshotT{Id: page.ID, Name: page.Name, Content: page.Content},
+		}, http.StatusOK)
+	}
+}
+
+// handlePageSave 保存一个页面的草稿，与 handleAppSave 一样，body 即页面内容
+func (s *server) handlePageSave() http.HandlerFunc {
+	type dataT struct {
+		// see handleAppSave
+		Warnings []ContentErrorT `json:"warnings"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		appId, err := parseAppId(query.Get("appId"))
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		pageId, err := parsePageId(query.Get("pageId"))
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		app, page, err := s.findEditablePage(r, appId, pageId)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		content, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			s.respond(w, r, fmt.Errorf("%w: failed to read body, err: %v",
+				errBadRequest, err), http.StatusOK)
+			return
+		}
+		if !isJSONObject(content) {
+			s.respond(w, r, fmt.Errorf("%w: content should be an object", errInvalidParam), http.StatusOK)
+			return
+		}
+		// pages follow the schema version of the app
+		schemaVersion, err := contentSchemaVersion(app.Content)
+		if err != nil {
+			schemaVersion = kContentSchemaDefault
+		}
+		warnings, err := validateContent(schemaVersion, content, kContentSchemaPage)
+		if err != nil {
+			panic(err)
+		}
+
+		err = s.appPageService.Update(app.ID, page.ID, map[string]interface{}{
+			"content":    json.RawMessage(content),
+			"updated_at": time.Now(),
+			"updated_by": currentUserId(r),
+		})
+		if err != nil {
+			panic(err)
+		}
+		if err := s.appService.TouchDraft(app.ID, currentUserId(r)); err != nil {
+			panic(err)
+		}
+		s.publishAppEvent(app, kEventAppSaved, "", currentUserId(r))
+		s.respond(w, r, defaultResponse{Data: dataT{Warnings: warnings}}, http.StatusOK)
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleAppPage(t *testing.T) {
+	assert := assert.New(t)
+	svr, token := newTestServer()
+	createEntry(createRequest{
+		Dir:   "/",
+		Entry: EntryT{Name: "entry1", Type: App},
+	}, svr, token)
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/app?appId=0&op="+kOpSave,
+		map[string]interface{}{"queries": "q"}, svr, token))
+
+	addPage := func(name string, content interface{}) *http.Response {
+		return doRequest("POST", "/currentUser/app/page",
+			map[string]interface{}{"appId": 0, "name": name, "content": content}, svr, token)
+	}
+	savePage := func(pageId interface{}, content string) *http.Response {
+		httpReq := httptest.NewRequest("PUT", fmt.Sprintf("/currentUser/app/page/content?appId=0&pageId=%v", pageId),
+			bytes.NewReader([]byte(content)))
+		httpReq.Header.Add("Authorization", fmt.Sprintf("BEARER %s", token))
+		return handleRequest(httpReq, svr)
+	}
+	getApp := func(loadType string) map[string]interface{} {
+		resp := assertErrCode(t, success.Code, doRequest("GET",
+			"/currentUser/app?appId=0&loadType="+loadType, nil, svr, token))
+		return resp.Data.(map[string]interface{})
+	}
+	hasUnpublishedChanges := func() bool {
+		resp := assertErrCode(t, success.Code, doRequest("GET", "/currentUser/app/meta?appId=0", nil, svr, token))
+		return resp.Data.(map[string]interface{})["hasUnpublishedChanges"].(bool)
+	}
+
+	resp := assertErrCode(t, success.Code, addPage("home", nil))
+	home := resp.Data.(map[string]interface{})["id"]
+	resp = assertErrCode(t, success.Code, addPage("detail", map[string]interface{}{"w": "detail"}))
+	detail := resp.Data.(map[string]interface{})["id"]
+	assertErrCode(t, errCodeMap[errInvalidParam], addPage("home", nil))
+	assertErrCode(t, errCodeMap[errInvalidParam], addPage("", nil))
+	assertErrCode(t, errCodeMap[errInvalidParam], addPage("list", []int{1}))
+
+	// load and save a single page
+	assertErrCode(t, success.Code, savePage(home, `{"w":"home"}`))
+	assertErrCode(t, errCodeMap[errInvalidParam], savePage(home, `[]`))
+	assertErrCode(t, errCodeMap[errPageNotFound], savePage(100, `{}`))
+	resp = assertErrCode(t, success.Code, doRequest("GET",
+		fmt.Sprintf("/currentUser/app/page/content?appId=0&pageId=%v", home), nil, svr, token))
+	assert.Equal(map[string]interface{}{"w": "home"}, resp.Data.(map[string]interface{})["content"])
+
+	// the editor loads the list of pages only
+	assert.Equal(map[string]interface{}{
+		"queries": "q",
+		"pages": []interface{}{
+			map[string]interface{}{"id": home, "name": "home"},
+			map[string]interface{}{"id": detail, "name": "detail"},
+		},
+	}, getApp(kLTEdit))
+	assert.Equal(map[string]interface{}{"w": "detail"},
+		getApp(kLTPreview)["pages"].([]interface{})[1].(map[string]interface{})["content"])
+
+	// the stubs of pages are not saved back
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/app?appId=0&op="+kOpSave, getApp(kLTEdit), svr, token))
+	app, _ := svr.appService.Get(0)
+	assert.JSONEq(`{"queries":"q"}`, string(app.Content))
+
+	// rename and reorder
+	assertErrCode(t, errCodeMap[errInvalidParam], doRequest("PUT", "/currentUser/app/page/name",
+		map[string]interface{}{"appId": 0, "pageId": detail, "name": "home"}, svr, token))
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/app/page/name",
+		map[string]interface{}{"appId": 0, "pageId": detail, "name": "item"}, svr, token))
+	reorder := func(pageIds ...interface{}) *http.Response {
+		return doRequest("PUT", "/currentUser/app/page/order",
+			map[string]interface{}{"appId": 0, "pageIds": pageIds}, svr, token)
+	}
+	assertErrCode(t, errCodeMap[errInvalidParam], reorder(detail))
+	assertErrCode(t, errCodeMap[errInvalidParam], reorder(detail, detail))
+	assertErrCode(t, errCodeMap[errPageNotFound], reorder(detail, home, 100))
+	assertErrCode(t, success.Code, reorder(detail, home))
+	resp = assertErrCode(t, success.Code, doRequest("GET", "/currentUser/app/page?appId=0", nil, svr, token))
+	pages := resp.Data.([]interface{})
+	assert.Len(pages, 2)
+	assert.Equal("item", pages[0].(map[string]interface{})["name"])
+	assert.Equal(kTestUserName, pages[0].(map[string]interface{})["updatedBy"])
+
+	// all pages are published together
+	assert.True(hasUnpublishedChanges())
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/app?appId=0&op="+kOpPublish,
+		map[string]interface{}{"queries": "q"}, svr, token))
+	assert.False(hasUnpublishedChanges())
+	assert.Equal(map[string]interface{}{
+		"queries": "q",
+		"pages": []interface{}{
+			map[string]interface{}{"id": detail, "name": "item", "content": map[string]interface{}{"w": "detail"}},
+			map[string]interface{}{"id": home, "name": "home", "content": map[string]interface{}{"w": "home"}},
+		},
+	}, getApp(kLTView))
+	assertErrCode(t, success.Code, savePage(home, `{"w":"home2"}`))
+	assert.True(hasUnpublishedChanges())
+	assert.Equal(map[string]interface{}{"w": "home"},
+		getApp(kLTView)["pages"].([]interface{})[1].(map[string]interface{})["content"])
+
+	// the draft published to staging is promoted along with its marker
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/app?appId=0&op="+kOpPublish+"&channel="+kChannelStaging,
+		map[string]interface{}{"queries": "q"}, svr, token))
+	assert.True(hasUnpublishedChanges())
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/app/promote",
+		map[string]interface{}{"appId": 0, "from": kChannelStaging, "to": kChannelProduction}, svr, token))
+	assert.False(hasUnpublishedChanges())
+	// publishing other than the saved draft leaves the draft unpublished
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/app?appId=0&op="+kOpPublish,
+		map[string]interface{}{"queries": "q2"}, svr, token))
+	assert.True(hasUnpublishedChanges())
+
+	// apps created from a template get the pages back
+	resp = assertErrCode(t, success.Code, doRequest("POST", "/currentUser/template",
+		map[string]interface{}{"appId": 0, "name": "tpl"}, svr, token))
+	createEntry(createRequest{
+		Dir:        "/",
+		Entry:      EntryT{Name: "entry2", Type: App},
+		TemplateId: resp.Data.(map[string]interface{})["id"].(string),
+	}, svr, token)
+	resp = assertErrCode(t, success.Code, doRequest("GET", "/currentUser/app/page?appId=1", nil, svr, token))
+	assert.Len(resp.Data, 2)
+	resp = assertErrCode(t, success.Code, doRequest("GET", "/currentUser/app?appId=1&loadType=edit", nil, svr, token))
+	assert.Equal("q", resp.Data.(map[string]interface{})["queries"])
+	resp = assertErrCode(t, success.Code, doRequest("GET", "/currentUser/app/meta?appId=1", nil, svr, token))
+	assert.Equal(true, resp.Data.(map[string]interface{})["hasUnpublishedChanges"])
+
+	assertErrCode(t, success.Code, doRequest("DELETE", "/currentUser/app/page",
+		map[string]interface{}{"appId": 0, "pageId": detail}, svr, token))
+	assertErrCode(t, errCodeMap[errPageNotFound], doRequest("DELETE", "/currentUser/app/page",
+		map[string]interface{}{"appId": 0, "pageId": detail}, svr, token))
+	assert.Len(getApp(kLTEdit)["pages"], 1)
+}
@@ -0,0 +1,473 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rtxu/luban-api/db"
+	"github.com/rtxu/luban-api/request"
+)
+
+// QueryT 代表 app 中定义的一个数据查询，由编辑者定义，浏览者只能按名字执行
+type QueryT struct {
+	Name         string            `json:"name"`
+	DataSourceId uint32            `json:"dataSourceId"`
+	Method       string            `json:"method,omitempty"`
+	Path         string            `json:"path,omitempty"`
+	Params       map[string]string `json:"params"`
+	Body         json.RawMessage   `json:"body,omitempty"`
+	// only for SQL data sources
+	SQL string `json:"sql,omitempty"`
+	// only for REST data sources
+	Transform *TransformT `json:"transform,omitempty"`
+	// in seconds, only GET and read statements could be cached
+	CacheTTL uint32 `json:"cacheTtl,omitempty"`
+}
+
+func newQuery(q db.AppQuery) QueryT {
+	return QueryT{
+		Name:         q.Name,
+		DataSourceId: q.DataSourceID,
+		Method:       q.Method,
+		Path:         q.Path,
+		Params:       decodeStringMap(q.Params),
+		Body:         q.Body,
+		SQL:          q.SQL,
+		Transform:    decodeTransform(q.Transform),
+		CacheTTL:     q.CacheTTL,
+	}
+}
+
+var queryMethods = []string{
+	http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+}
+
+// checkQueryMethod returns GET when method is empty
+func checkQueryMethod(method string) (string, error) {
+	if method == "" {
+		return http.MethodGet, nil
+	}
+	method = strings.ToUpper(method)
+	for _, m := range queryMethods {
+		if m == method {
+			return method, nil
+		}
+	}
+	return "", fmt.Errorf("%w: unsupported method(%s)", errInvalidParam, method)
+}
+
+// escapesBase tells whether path has a ".." segment, after unescaped as the services may do,
+// backslashes are taken as slashes by some services too
+func escapesBase(path string) bool {
+	for i := 0; i < 3; i++ {
+		unescaped, err := url.PathUnescape(path)
+		if err != nil {
+			return true
+		}
+		for _, segment := range strings.FieldsFunc(unescaped, func(c rune) bool { return c == '/' || c == '\\' }) {
+			if segment == ".." {
+				return true
+			}
+		}
+		if unescaped == path {
+			return false
+		}
+		path = unescaped
+	}
+	return true
+}
+
+// queryURL joins baseURL and path, path could not escape baseURL
+func queryURL(baseURL, path string) (string, error) {
+	if escapesBase(path) || strings.Contains(path, "://") ||
+		strings.ContainsAny(path, "?#") {
+		return "", fmt.Errorf("%w: path(%s) should be relative to the base url without query",
+			errInvalidParam, path)
+	}
+	if path == "" {
+		return baseURL, nil
+	}
+	return strings.TrimRight(baseURL, "/") + "/" + strings.TrimLeft(path, "/"), nil
+}
+
+// findDataSourceOfApp finds the data source in the workspace of app
+func (s *server) findDataSourceOfApp(app db.App, id uint32) (db.DataSource, error) {
+	ds, err := s.dataSourceService.Find(id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return ds, fmt.Errorf("%w: dataSourceId is %d", errDataSourceNotFound, id)
+		}
+		panic(err)
+	}
+	if ds.OrgID != app.OrgID || (app.OrgID == 0 && ds.OwnerID != app.OwnerID) {
+		return ds, fmt.Errorf("%w: dataSourceId is %d", errDataSourceNotFound, id)
+	}
+	return ds, nil
+}
+
+func (s *server) findQuery(app db.App, name string) (db.AppQuery, error) {
+	q, err := s.appQueryService.Find(app.ID, name)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return q, fmt.Errorf("%w: query(%s) of app(%d)", errQueryNotFound, name, app.ID)
+		}
+		panic(err)
+	}
+	return q, nil
+}
+
+func (s *server) handleQueryList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app, err := s.findAppWithRoleByQuery(r, db.RoleEditor)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		qs, err := s.appQueryService.ListByApp(app.ID)
+		if err != nil {
+			panic(err)
+		}
+		data := make([]QueryT, 0, len(qs))
+		for _, q := range qs {
+			data = append(data, newQuery(q))
+		}
+		s.respond(w, r, defaultResponse{Data: data}, http.StatusOK)
+	}
+}
+
+func (s *server) handleQuerySave() http.HandlerFunc {
+	type request struct {
+		AppId uint32 `json:"appId"`
+		QueryT
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		param.Name = strings.TrimSpace(param.Name)
+		if param.Name == "" {
+			s.respond(w, r, fmt.Errorf("%w: empty query name", errInvalidParam), http.StatusOK)
+			return
+		}
+
+		app, err := s.findAppWithRole(r, param.AppId, db.RoleEditor)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		ds, err := s.findDataSourceOfApp(app, param.DataSourceId)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		q := db.AppQuery{
+			AppID:        app.ID,
+			Name:         param.Name,
+			DataSourceID: ds.ID,
+			Params:       encodeStringMap(param.Params),
+			CacheTTL:     param.CacheTTL,
+		}
+		if time.Duration(param.CacheTTL)*time.Second > kQueryCacheMaxTTL {
+			err = fmt.Errorf("%w: cacheTtl should be at most %v", errInvalidParam, kQueryCacheMaxTTL)
+		} else if ds.Type == db.DataSourceTypeSQL {
+			if strings.TrimSpace(param.SQL) == "" {
+				err = fmt.Errorf("%w: empty sql", errInvalidParam)
+			} else if hasTemplate(param.SQL) {
+				err = fmt.Errorf("%w: templates are not allowed in sql, use :name params instead", errInvalidParam)
+			} else {
+				// checks the syntax only, params are given when running
+				_, _, err = bindSQLParams(param.SQL, ds.Driver, nil)
+			}
+			if err == nil && param.Transform != nil {
+				err = fmt.Errorf("%w: transform is only for REST data sources", errInvalidParam)
+			}
+			if err == nil && param.CacheTTL > 0 && !isReadStatement(param.SQL) {
+				err = fmt.Errorf("%w: only read statements could be cached", errInvalidParam)
+			}
+			q.SQL = param.SQL
+		} else {
+			q.Method, err = checkQueryMethod(param.Method)
+			if err == nil {
+				_, err = queryURL(ds.BaseURL, param.Path)
+			}
+			if err == nil {
+				err = checkTemplate(param.Path)
+			}
+			if err == nil {
+				err = checkTemplateJSON(param.Body)
+			}
+			if err == nil && param.Transform != nil {
+				err = param.Transform.check()
+			}
+			if err == nil && param.CacheTTL > 0 && q.Method != http.MethodGet {
+				err = fmt.Errorf("%w: only GET queries could be cached", errInvalidParam)
+			}
+			q.Path = param.Path
+			q.Body = param.Body
+			q.Transform = encodeTransform(param.Transform)
+		}
+		if err == nil {
+			err = checkTemplateMap(param.Params)
+		}
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := s.appQueryService.Save(&q); err != nil {
+			panic(err)
+		}
+		s.queryCache.invalidateQuery(app.ID, q.Name)
+		s.respond(w, r, success, http.StatusOK)
+	}
+}
+
+func (s *server) handleQueryDelete() http.HandlerFunc {
+	type request struct {
+		AppId uint32 `json:"appId"`
+		Name  string `json:"name"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		app, err := s.findAppWithRole(r, param.AppId, db.RoleEditor)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if _, err := s.findQuery(app, param.Name); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := s.appQueryService.Delete(app.ID, param.Name); err != nil {
+			panic(err)
+		}
+		s.queryCache.invalidateQuery(app.ID, param.Name)
+		s.respond(w, r, success, http.StatusOK)
+	}
+}
+
+// handleQueryCacheInvalidate drops the cached results of a query, or all queries of the app
+// when name is empty, e.g. after the data behind a slow API changed
+func (s *server) handleQueryCacheInvalidate() http.HandlerFunc {
+	type request struct {
+		AppId uint32 `json:"appId"`
+		Name  string `json:"name"`
+	}
+	type dataT struct {
+		Invalidated int `json:"invalidated"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		app, err := s.findAppWithRole(r, param.AppId, db.RoleEditor)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		var n int
+		if param.Name == "" {
+			n = s.queryCache.invalidate(func(e queryCacheEntry) bool { return e.appId == app.ID })
+		} else {
+			if _, err := s.findQuery(app, param.Name); err != nil {
+				s.respond(w, r, err, http.StatusOK)
+				return
+			}
+			n = s.queryCache.invalidateQuery(app.ID, param.Name)
+		}
+		s.respond(w, r, defaultResponse{Data: dataT{Invalidated: n}}, http.StatusOK)
+	}
+}
+
+// handleQueryRun 代理执行 app 的数据查询，数据源的 header、DSN 等密钥只在服务端解密使用，
+// 错误信息中的密钥会被替换掉。查询中的模板以传入的 params 及当前用户求值。
+// REST 查询返回 RESTResultT，其 data 按 transform 裁剪，SQL 查询返回 SQLResultT。
+// 设置了 cacheTtl 的查询，结果按求值后的请求缓存，响应头 X-Query-Cache 标识是否命中
+func (s *server) handleQueryRun() http.HandlerFunc {
+	type requestT struct {
+		AppId uint32 `json:"appId"`
+		Name  string `json:"name"`
+		// overrides the params of the query and the data source except the templated ones,
+		// and are referred by input.<name> in templates
+		Params map[string]string `json:"params"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param requestT
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		app, err := s.findAppWithRole(r, param.AppId, db.RoleViewer)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		q, err := s.findQuery(app, param.Name)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		ds, err := s.findDataSourceOfApp(app, q.DataSourceID)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		tctx := newTemplateContext(r, param.Params)
+		params, err := tctx.mergeQueryParams(decodeStringMap(ds.Params), decodeStringMap(q.Params))
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		// the data source may echo the credentials back in errors, e.g. a wrong password
+		secrets, err := s.openSecrets(ds)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		transform := decodeTransform(q.Transform)
+		var cacheKey string
+		var rq restQueryT
+		if ds.Type == db.DataSourceTypeSQL {
+			cacheKey = queryCacheKey(app.ID, q.Name, q.SQL, params)
+		} else {
+			rq, err = tctx.renderRESTQuery(ds, secrets, q, params)
+			if err != nil {
+				s.respond(w, r, err, http.StatusOK)
+				return
+			}
+			cacheKey = queryCacheKey(app.ID, q.Name, rq)
+		}
+		if q.CacheTTL > 0 {
+			if result, ok := s.queryCache.get(cacheKey); ok {
+				w.Header().Set(kQueryCacheHeader, "HIT")
+				s.respond(w, r, defaultResponse{Data: result}, http.StatusOK)
+				return
+			}
+			w.Header().Set(kQueryCacheHeader, "MISS")
+		}
+
+		var result interface{}
+		if ds.Type == db.DataSourceTypeSQL {
+			result, err = s.runSQLQuery(r.Context(), ds, secrets.DSN, q, params)
+		} else {
+			result, err = s.runRESTQuery(r.Context(), rq, transform)
+		}
+		if err != nil {
+			s.respond(w, r, secrets.redactError(err), http.StatusOK)
+			return
+		}
+		if q.CacheTTL > 0 {
+			s.queryCache.set(cacheKey, queryCacheEntry{
+				appId:        app.ID,
+				name:         q.Name,
+				dataSourceId: ds.ID,
+				value:        result,
+			}, time.Duration(q.CacheTTL)*time.Second)
+		}
+		s.respond(w, r, defaultResponse{Data: result}, http.StatusOK)
+	}
+}
+
+// restQueryT is a REST query rendered with the input and the viewer
+type restQueryT struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Params  map[string]string `json:"params"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+func (c *templateContext) renderRESTQuery(ds db.DataSource, secrets dataSourceSecrets, q db.AppQuery,
+	params map[string]string) (restQueryT, error) {
+	rq := restQueryT{Method: q.Method, Params: params, Headers: make(map[string]string)}
+	path, err := c.renderPath(q.Path)
+	if err != nil {
+		return rq, err
+	}
+	// the rendered path is checked again, e.g. input.id is ".."
+	if rq.URL, err = queryURL(ds.BaseURL, path); err != nil {
+		return rq, err
+	}
+	for k, v := range secrets.Headers {
+		if rq.Headers[k], err = c.renderHeader(v); err != nil {
+			return rq, err
+		}
+	}
+	if q.Body != nil {
+		if rq.Body, err = renderTemplateJSON(q.Body, c); err != nil {
+			return rq, err
+		}
+	}
+	return rq, nil
+}
+
+// kRESTMaxResponseSize bounds the body of REST data sources, which is held in memory and cached
+const kRESTMaxResponseSize = 4 << 20
+
+// RESTResultT 是 REST 查询的结果
+type RESTResultT struct {
+	Status int `json:"status"`
+	// the response body, it's a JSON string if the body is not JSON
+	Data json.RawMessage `json:"data"`
+}
+
+// runRESTQuery sends rq, errors of the data source are reported as errQueryFailed,
+// they may contain the credentials and should be redacted before responding
+func (s *server) runRESTQuery(ctx context.Context, rq restQueryT, transform *TransformT) (RESTResultT, error) {
+	var result RESTResultT
+	req := request.New(rq.Method, rq.URL).
+		WithContext(ctx).
+		WithClient(s.queryClient)
+	for k, v := range rq.Headers {
+		req.Header(k, v)
+	}
+	for k, v := range rq.Params {
+		req.Query(k, v)
+	}
+	if rq.Body != nil {
+		req.JSON(rq.Body)
+	}
+
+	resp, err := req.Do()
+	if err != nil {
+		var reqErr *request.Error
+		if errors.As(err, &reqErr) {
+			return result, fmt.Errorf("%w: status %d, body: %s", errQueryFailed, reqErr.StatusCode, reqErr.Body)
+		}
+		return result, fmt.Errorf("%w: %v", errQueryFailed, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, kRESTMaxResponseSize+1))
+	if err != nil {
+		return result, fmt.Errorf("%w: read body, err: %v", errQueryFailed, err)
+	}
+	if len(body) > kRESTMaxResponseSize {
+		return result, fmt.Errorf("%w: response body exceeds %d bytes", errQueryFailed, kRESTMaxResponseSize)
+	}
+	result = RESTResultT{Status: resp.StatusCode, Data: body}
+	if transform != nil {
+		result.Data, err = transform.apply(body)
+		return result, err
+	}
+	if !json.Valid(body) {
+		result.Data, _ = json.Marshal(string(body))
+	}
+	return result, nil
+}
@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 
+	"github.com/rtxu/luban-api/db"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -34,7 +37,7 @@ func TestHandleApp(t *testing.T) {
 		{
 			query.Set("op", kOpPublish)
 			publishContent["widgets"] = map[string]interface{}{
-				"published_w1": map[string]interface{}{},
+				"published_w1": map[string]interface{}{"type": "Button"},
 			}
 			contentBytes, _ := json.Marshal(publishContent)
 			httpReq := httptest.NewRequest("PUT", "/currentUser/app?"+query.Encode(),
@@ -86,3 +89,102 @@ func TestHandleApp(t *testing.T) {
 		}
 	}
 }
+
+func TestHandleAppMeta(t *testing.T) {
+	assert := assert.New(t)
+	svr, token := newTestServer()
+	const kEditorId = 1001
+	editorToken := addTestUser(svr, kEditorId, "editor")
+
+	createEntry(createRequest{
+		Dir:   "/",
+		Entry: EntryT{Name: "entry1", Type: App},
+	}, svr, token)
+	svr.appACLService.Grant(0, kEditorId, db.RoleEditor)
+
+	getMeta := func() map[string]interface{} {
+		resp := assertErrCode(t, success.Code, doRequest("GET", "/currentUser/app/meta?appId=0", nil, svr, token))
+		return resp.Data.(map[string]interface{})
+	}
+
+	// a new app has nothing to publish
+	meta := getMeta()
+	assert.Equal(kTestUserName, meta["updatedBy"])
+	assert.Nil(meta["publishedAt"])
+	assert.Equal("", meta["publishedBy"])
+	assert.Equal(false, meta["hasUnpublishedChanges"])
+	createdAt := meta["createdAt"]
+
+	// save by the editor
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/app?appId=0&op="+kOpSave,
+		map[string]interface{}{"w": 1}, svr, editorToken))
+	meta = getMeta()
+	assert.Equal(createdAt, meta["createdAt"])
+	assert.Equal("editor", meta["updatedBy"])
+	assert.Equal(true, meta["hasUnpublishedChanges"])
+
+	// publish by the owner
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/app?appId=0&op="+kOpPublish,
+		map[string]interface{}{"w": 1}, svr, token))
+	meta = getMeta()
+	assert.NotNil(meta["publishedAt"])
+	assert.Equal(kTestUserName, meta["publishedBy"])
+	assert.Equal(false, meta["hasUnpublishedChanges"])
+
+	// the tree carries meta as well
+	resp := assertErrCode(t, success.Code, doRequest("GET", "/currentUser", nil, svr, token))
+	rootDir := resp.Data.(map[string]interface{})["rootDir"].([]interface{})
+	assert.Equal(meta, rootDir[0].(map[string]interface{})["meta"])
+
+	// and so does the app
+	resp = assertErrCode(t, success.Code, doRequest("GET", "/currentUser/app?appId=0&loadType="+kLTView, nil, svr, token))
+	assert.Equal(map[string]interface{}{"w": float64(1)}, resp.Data)
+	assert.Equal(meta, resp.Meta)
+}
+
+func TestHandleAppContentValidation(t *testing.T) {
+	assert := assert.New(t)
+	svr, token := newTestServer()
+	createEntry(createRequest{
+		Dir:   "/",
+		Entry: EntryT{Name: "entry1", Type: App},
+	}, svr, token)
+
+	put := func(op, content string) *http.Response {
+		httpReq := httptest.NewRequest("PUT", "/currentUser/app?appId=0&op="+op, strings.NewReader(content))
+		httpReq.Header.Add("Authorization", fmt.Sprintf("BEARER %s", token))
+		return handleRequest(httpReq, svr)
+	}
+
+	// broken content is never saved
+	assertErrCode(t, errCodeMap[errInvalidParam], put(kOpSave, `{"widgets": `))
+	assertErrCode(t, errCodeMap[errInvalidParam], put(kOpSave, `{"schemaVersion": 99}`))
+	app, _ := svr.appService.Get(0)
+	assert.Equal("{}", string(app.Content))
+
+	// mismatches are warned on save, but block publish
+	invalid := `{"widgets": {"w1": {"type": 1}}}`
+	resp := assertErrCode(t, success.Code, put(kOpSave, invalid))
+	assert.Equal([]interface{}{
+		map[string]interface{}{"path": "/widgets/w1/type", "message": "should be string rather than integer"},
+	}, resp.Data.(map[string]interface{})["warnings"])
+	app, _ = svr.appService.Get(0)
+	assert.JSONEq(invalid, string(app.Content))
+	resp = assertErrCode(t, errCodeMap[errInvalidContent], put(kOpPublish, invalid))
+	assert.Len(resp.Data, 1)
+	resp = assertErrCode(t, success.Code, put(kOpSave, `{"widgets": {"w1": {"type": "Button"}}}`))
+	assert.Equal([]interface{}{}, resp.Data.(map[string]interface{})["warnings"])
+
+	// so do mismatches in pages
+	resp = assertErrCode(t, success.Code, doRequest("POST", "/currentUser/app/page",
+		map[string]interface{}{"appId": 0, "name": "home"}, svr, token))
+	pageId := resp.Data.(map[string]interface{})["id"]
+	httpReq := httptest.NewRequest("PUT", fmt.Sprintf("/currentUser/app/page/content?appId=0&pageId=%v", pageId),
+		strings.NewReader(`{"widgets": {"w2": {}}}`))
+	httpReq.Header.Add("Authorization", fmt.Sprintf("BEARER %s", token))
+	resp = assertErrCode(t, success.Code, handleRequest(httpReq, svr))
+	assert.Len(resp.Data.(map[string]interface{})["warnings"], 1)
+	resp = assertErrCode(t, errCodeMap[errInvalidContent], put(kOpPublish, `{}`))
+	assert.Equal("/pages/0/content/widgets/w2",
+		resp.Data.([]interface{})[0].(map[string]interface{})["path"])
+}
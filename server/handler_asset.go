@@ -0,0 +1,368 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi"
+
+	"github.com/rtxu/luban-api/config"
+	"github.com/rtxu/luban-api/db"
+	"github.com/rtxu/luban-api/storage"
+)
+
+const (
+	kAssetStorageLocal     = "local"
+	kAssetDefaultDir       = "./assets"
+	kAssetDefaultMaxSize   = 10 << 20
+	kAssetDefaultUserQuota = 100 << 20
+	kAssetMaxNameLength    = 255
+	// assets are immutable, as the url is derived from the content
+	kAssetCacheControl = "public, max-age=31536000, immutable"
+)
+
+// assetContentTypes are the sniffed types accepted, HTML is not among them as it could run
+// scripts in the origin of the API. SVG is sniffed as text/plain, and served as it is.
+var assetContentTypes = map[string]bool{
+	"image/png":                true,
+	"image/jpeg":               true,
+	"image/gif":                true,
+	"image/webp":               true,
+	"image/bmp":                true,
+	"image/x-icon":             true,
+	"application/pdf":          true,
+	"application/zip":          true,
+	"text/plain":               true,
+	"application/octet-stream": true,
+}
+
+var assetHashRegexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// hashLocks serializes the uploads and deletes of the same content, otherwise an upload
+// could refer to the content being deleted with the last asset referring it
+type hashLocks struct {
+	mu    sync.Mutex
+	locks map[string]*hashLock
+}
+
+type hashLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func newHashLocks() *hashLocks {
+	return &hashLocks{
+		locks: make(map[string]*hashLock),
+	}
+}
+
+// lock locks hash and returns the func to unlock it
+func (l *hashLocks) lock(hash string) func() {
+	l.mu.Lock()
+	hl, ok := l.locks[hash]
+	if !ok {
+		hl = &hashLock{}
+		l.locks[hash] = hl
+	}
+	hl.refs++
+	l.mu.Unlock()
+
+	hl.mu.Lock()
+	return func() {
+		hl.mu.Unlock()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if hl.refs--; hl.refs == 0 {
+			delete(l.locks, hash)
+		}
+	}
+}
+
+func newAssetStorage(conf config.AssetConf) (storage.Storage, error) {
+	switch conf.Storage {
+	case "", kAssetStorageLocal:
+		dir := conf.Dir
+		if dir == "" {
+			dir = kAssetDefaultDir
+		}
+		return storage.NewLocal(dir), nil
+	}
+	return nil, fmt.Errorf("unsupported storage(%s)", conf.Storage)
+}
+
+func (s *server) assetMaxSize() int64 {
+	if s.conf.Asset.MaxSize > 0 {
+		return s.conf.Asset.MaxSize
+	}
+	return kAssetDefaultMaxSize
+}
+
+func (s *server) assetUserQuota() uint64 {
+	if s.conf.Asset.UserQuota > 0 {
+		return uint64(s.conf.Asset.UserQuota)
+	}
+	return kAssetDefaultUserQuota
+}
+
+// assetKey is the key of the content in the storage, sharded by the hash prefix
+func assetKey(hash string) string {
+	return hash[:2] + "/" + hash
+}
+
+// AssetT 是用户上传的文件，url 为相对 API 根路径的地址，无需登录即可访问，如 <img src>
+type AssetT struct {
+	Id          uint32    `json:"id"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"contentType"`
+	Size        uint64    `json:"size"`
+	Url         string    `json:"url"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+func newAsset(a db.Asset) AssetT {
+	return AssetT{
+		Id:          a.ID,
+		Name:        a.Name,
+		ContentType: a.ContentType,
+		Size:        a.Size,
+		Url:         "/asset/" + a.Hash,
+		CreatedAt:   a.CreatedAt,
+	}
+}
+
+// sniffContentType detects the type of content, errInvalidParam for the types not accepted
+func sniffContentType(content []byte) (string, error) {
+	contentType := http.DetectContentType(content)
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !assetContentTypes[mediaType] {
+		return "", fmt.Errorf("%w: content type(%s) is not accepted", errInvalidParam, contentType)
+	}
+	return contentType, nil
+}
+
+func assetName(filename string) string {
+	// browsers of Windows may send the full path
+	name := strings.TrimSpace(filepath.Base(strings.Replace(filename, "\\", "/", -1)))
+	name = strings.ToValidUTF8(name, "")
+	for len(name) > kAssetMaxNameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "" || name == "." || name == "/" {
+		name = "untitled"
+	}
+	return name
+}
+
+func (s *server) handleAssetList() http.HandlerFunc {
+	type dataT struct {
+		Assets []AssetT `json:"assets"`
+		// in bytes
+		Usage uint64 `json:"usage"`
+		Quota uint64 `json:"quota"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		as, err := s.assetService.ListByOwner(currentUserId(r))
+		if err != nil {
+			panic(err)
+		}
+		data := dataT{Assets: make([]AssetT, 0, len(as)), Quota: s.assetUserQuota()}
+		for _, a := range as {
+			data.Assets = append(data.Assets, newAsset(a))
+			data.Usage += a.Size
+		}
+		s.respond(w, r, defaultResponse{Data: data}, http.StatusOK)
+	}
+}
+
+// handleAssetUpload 上传文件，请求为 multipart/form-data，文件位于 file 字段。
+// 同一用户重复上传相同内容时返回已有的 asset，不重复占用配额
+func (s *server) handleAssetUpload() http.HandlerFunc {
+	type dataT struct {
+		AssetT
+		// the same content was uploaded before
+		Deduplicated bool `json:"deduplicated"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		maxSize := s.assetMaxSize()
+		// leaves room for the multipart headers
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize+64<<10)
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			s.respond(w, r, fmt.Errorf("%w: file should be at most %d bytes, err: %v",
+				errBadRequest, maxSize, err), http.StatusOK)
+			return
+		}
+		defer file.Close()
+		if header.Size > maxSize {
+			s.respond(w, r, fmt.Errorf("%w: file should be at most %d bytes", errInvalidParam, maxSize),
+				http.StatusOK)
+			return
+		}
+		content, err := ioutil.ReadAll(file)
+		if err != nil {
+			s.respond(w, r, fmt.Errorf("%w: failed to read file, err: %v", errBadRequest, err), http.StatusOK)
+			return
+		}
+		if len(content) == 0 {
+			s.respond(w, r, fmt.Errorf("%w: file is empty", errInvalidParam), http.StatusOK)
+			return
+		}
+		contentType, err := sniffContentType(content)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		sum := sha256.Sum256(content)
+		hash := hex.EncodeToString(sum[:])
+		userId := currentUserId(r)
+
+		existing, err := s.assetService.FindByOwnerHash(userId, hash)
+		if err == nil {
+			s.respond(w, r, defaultResponse{Data: dataT{AssetT: newAsset(existing), Deduplicated: true}},
+				http.StatusOK)
+			return
+		}
+		if !errors.Is(err, db.ErrNotFound) {
+			panic(err)
+		}
+		usage, err := s.assetService.Usage(userId)
+		if err != nil {
+			panic(err)
+		}
+		if quota := s.assetUserQuota(); usage+uint64(len(content)) > quota {
+			s.respond(w, r, fmt.Errorf("%w: %d of %d bytes used, the file is %d bytes",
+				errQuotaExceeded, usage, quota, len(content)), http.StatusOK)
+			return
+		}
+		// the content is saved once, no matter how many users upload it
+		unlock := s.assetHashLocks.lock(hash)
+		defer unlock()
+		if _, err := s.assetService.FindByHash(hash); errors.Is(err, db.ErrNotFound) {
+			err = s.assetStorage.Put(r.Context(), assetKey(hash), bytes.NewReader(content),
+				int64(len(content)), contentType)
+			if err != nil {
+				panic(err)
+			}
+		} else if err != nil {
+			panic(err)
+		}
+
+		a := db.Asset{
+			OwnerID:     userId,
+			Hash:        hash,
+			Name:        assetName(header.Filename),
+			ContentType: contentType,
+			Size:        uint64(len(content)),
+		}
+		if err := s.assetService.Insert(&a); err != nil {
+			panic(err)
+		}
+		s.respond(w, r, defaultResponse{Data: dataT{AssetT: newAsset(a)}}, http.StatusOK)
+	}
+}
+
+func (s *server) handleAssetDelete() http.HandlerFunc {
+	type request struct {
+		Id uint32 `json:"id"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		a, err := s.assetService.Find(param.Id)
+		if errors.Is(err, db.ErrNotFound) || (err == nil && a.OwnerID != currentUserId(r)) {
+			s.respond(w, r, fmt.Errorf("%w: id is %d", errAssetNotFound, param.Id), http.StatusOK)
+			return
+		}
+		if err != nil {
+			panic(err)
+		}
+
+		unlock := s.assetHashLocks.lock(a.Hash)
+		defer unlock()
+		if err := s.assetService.Delete(a.ID); err != nil {
+			panic(err)
+		}
+		// the content is deleted with the last asset referring it
+		if _, err := s.assetService.FindByHash(a.Hash); errors.Is(err, db.ErrNotFound) {
+			if err := s.assetStorage.Delete(r.Context(), assetKey(a.Hash)); err != nil {
+				panic(err)
+			}
+		} else if err != nil {
+			panic(err)
+		}
+		s.respond(w, r, success, http.StatusOK)
+	}
+}
+
+// handleAssetServe 无需登录，供 <img src> 等直接引用。内容由 hash 决定、永不改变，可被长期缓存
+func (s *server) handleAssetServe() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hash := chi.URLParam(r, "hash")
+		notFound := fmt.Errorf("%w: %s", errAssetNotFound, hash)
+		if !assetHashRegexp.MatchString(hash) {
+			s.respond(w, r, notFound, http.StatusNotFound)
+			return
+		}
+		a, err := s.assetService.FindByHash(hash)
+		if err != nil {
+			if errors.Is(err, db.ErrNotFound) {
+				s.respond(w, r, notFound, http.StatusNotFound)
+				return
+			}
+			panic(err)
+		}
+
+		etag := `"` + hash + `"`
+		if match := r.Header.Get("If-None-Match"); match == etag || match == "*" {
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Cache-Control", kAssetCacheControl)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		content, err := s.assetStorage.Get(r.Context(), assetKey(hash))
+		if err != nil {
+			// deleted with the last asset referring it after found
+			if errors.Is(err, storage.ErrNotFound) {
+				s.respond(w, r, notFound, http.StatusNotFound)
+				return
+			}
+			panic(err)
+		}
+		defer content.Close()
+
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", kAssetCacheControl)
+
+		disposition := "attachment"
+		if strings.HasPrefix(a.ContentType, "image/") {
+			disposition = "inline"
+		}
+		w.Header().Set("Content-Type", a.ContentType)
+		w.Header().Set("Content-Length", strconv.FormatUint(a.Size, 10))
+		w.Header().Set("Content-Disposition",
+			mime.FormatMediaType(disposition, map[string]string{"filename": a.Name}))
+		// never rendered as anything else, e.g. HTML
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+		w.WriteHeader(http.StatusOK)
+		io.Copy(w, content)
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/rtxu/luban-api/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssetName(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("logo.png", assetName(`C:\Users\a\logo.png`))
+	assert.Equal("passwd", assetName("../../etc/passwd"))
+	assert.Equal("untitled", assetName(" "))
+	assert.Equal(strings.Repeat("名", 85), assetName(strings.Repeat("名", 100)))
+
+	// SVG is not sniffed, and served as text never running scripts
+	contentType, err := sniffContentType([]byte(`<svg xmlns="http://www.w3.org/2000/svg"><script/></svg>`))
+	assert.NoError(err)
+	assert.Equal("text/plain; charset=utf-8", contentType)
+	_, err = sniffContentType([]byte(`<!DOCTYPE html><script>alert(1)</script>`))
+	assert.Error(err)
+}
+
+func TestHandleAsset(t *testing.T) {
+	assert := assert.New(t)
+	svr, token := newTestServer()
+	otherToken := addTestUser(svr, 1001, "bob")
+	svr.conf.Asset = config.AssetConf{MaxSize: 64, UserQuota: 100}
+
+	upload := func(filename string, content []byte, token string) *http.Response {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, _ := writer.CreateFormFile("file", filename)
+		part.Write(content)
+		writer.Close()
+		httpReq := httptest.NewRequest("POST", "/currentUser/asset", &body)
+		httpReq.Header.Add("Content-Type", writer.FormDataContentType())
+		httpReq.Header.Add("Authorization", fmt.Sprintf("BEARER %s", token))
+		return handleRequest(httpReq, svr)
+	}
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 32)...)
+
+	// size limits and sniffed content types
+	assertErrCode(t, errCodeMap[errInvalidParam], upload("big.png", bytes.Repeat(png, 2), token))
+	assertErrCode(t, errCodeMap[errInvalidParam], upload("empty.png", nil, token))
+	assertErrCode(t, errCodeMap[errInvalidParam], upload("x.png", []byte("<html><script>alert(1)</script>"), token))
+
+	resp := assertErrCode(t, success.Code, upload("logo.png", png, token))
+	asset := resp.Data.(map[string]interface{})
+	assert.Equal("logo.png", asset["name"])
+	assert.Equal("image/png", asset["contentType"])
+	assert.Equal(float64(len(png)), asset["size"])
+	assert.Equal(false, asset["deduplicated"])
+	url := asset["url"].(string)
+
+	// deduplicated by the content
+	resp = assertErrCode(t, success.Code, upload("copy.png", png, token))
+	assert.Equal(asset["id"], resp.Data.(map[string]interface{})["id"])
+	assert.Equal(true, resp.Data.(map[string]interface{})["deduplicated"])
+	resp = assertErrCode(t, success.Code, upload("bob.png", png, otherToken))
+	bobAsset := resp.Data.(map[string]interface{})
+	assert.Equal(url, bobAsset["url"])
+
+	// quota
+	assertErrCode(t, success.Code, upload("a.txt", bytes.Repeat([]byte("a"), 60), token))
+	assertErrCode(t, errCodeMap[errQuotaExceeded], upload("b.txt", bytes.Repeat([]byte("b"), 60), token))
+	resp = assertErrCode(t, success.Code, doRequest("GET", "/currentUser/asset", nil, svr, token))
+	data := resp.Data.(map[string]interface{})
+	assert.Len(data["assets"], 2)
+	assert.Equal(float64(len(png)+60), data["usage"])
+	assert.Equal(float64(100), data["quota"])
+
+	// served without login and cached
+	serve := func(url string, header map[string]string) *http.Response {
+		httpReq := httptest.NewRequest("GET", url, nil)
+		for k, v := range header {
+			httpReq.Header.Add(k, v)
+		}
+		return handleRequest(httpReq, svr)
+	}
+	httpResp := serve(url, nil)
+	assert.Equal(http.StatusOK, httpResp.StatusCode)
+	assert.Equal("image/png", httpResp.Header.Get("Content-Type"))
+	assert.Equal(kAssetCacheControl, httpResp.Header.Get("Cache-Control"))
+	assert.Equal("nosniff", httpResp.Header.Get("X-Content-Type-Options"))
+	assert.Equal("inline; filename=logo.png", httpResp.Header.Get("Content-Disposition"))
+	body, _ := ioutil.ReadAll(httpResp.Body)
+	assert.Equal(png, body)
+	httpResp = serve(url, map[string]string{"If-None-Match": httpResp.Header.Get("ETag")})
+	assert.Equal(http.StatusNotModified, httpResp.StatusCode)
+	assert.Equal(http.StatusNotFound, serve("/asset/"+strings.Repeat("0", 64), nil).StatusCode)
+	assert.Equal(http.StatusNotFound, serve("/asset/..%2Fconfig", nil).StatusCode)
+
+	// the content is kept until the last one is deleted
+	assertErrCode(t, errCodeMap[errAssetNotFound], doRequest("DELETE", "/currentUser/asset",
+		map[string]interface{}{"id": asset["id"]}, svr, otherToken))
+	assertErrCode(t, success.Code, doRequest("DELETE", "/currentUser/asset",
+		map[string]interface{}{"id": asset["id"]}, svr, token))
+	assert.Equal(http.StatusOK, serve(url, nil).StatusCode)
+	assertErrCode(t, success.Code, doRequest("DELETE", "/currentUser/asset",
+		map[string]interface{}{"id": bobAsset["id"]}, svr, otherToken))
+	assert.Equal(http.StatusNotFound, serve(url, nil).StatusCode)
+
+	// content missing from the storage is not found either
+	assertErrCode(t, success.Code, upload("logo.png", png, token))
+	svr.assetStorage.Delete(context.Background(), assetKey(path.Base(url)))
+	httpResp = serve(url, nil)
+	assert.Equal(http.StatusNotFound, httpResp.StatusCode)
+	assert.Empty(httpResp.Header.Get("Cache-Control"))
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rtxu/luban-api/db"
+)
+
+const (
+	// end users see the production channel, which is App.LastPublishedContent
+	kChannelProduction = "production"
+	// QA verifies a release in the staging channel before promoting it to production
+	kChannelStaging = "staging"
+)
+
+var publishChannels = []string{kChannelStaging, kChannelProduction}
+
+// checkChannel returns the default channel when channel is empty
+func checkChannel(channel string) (string, error) {
+	if channel == "" {
+		return kChannelProduction, nil
+	}
+	for _, ch := range publishChannels {
+		if ch == channel {
+			return channel, nil
+		}
+	}
+	return "", fmt.Errorf("%w: unrecognized channel(%s)", errInvalidParam, channel)
+}
+
+// ChannelT 代表 app 的一个发布渠道
+type ChannelT struct {
+	Channel     string     `json:"channel"`
+	PublishedAt *time.Time `json:"publishedAt"`
+	PublishedBy string     `json:"publishedBy"`
+}
+
+func (s *server) channelContent(app db.App, channel string) json.RawMessage {
+	content, _, _ := s.channelDraft(app, channel)
+	return content
+}
+
+// channelDraft returns the content of channel along with the marker of its draft, see draftMarkerT.
+// published is false when nothing was published to channel, whose content is the same as a new app
+func (s *server) channelDraft(app db.App, channel string) (content, draft json.RawMessage, published bool) {
+	if channel == kChannelProduction {
+		return app.LastPublishedContent, app.PublishedDraft, app.PublishedAt != nil
+	}
+	ch, err := s.appChannelService.Find(app.ID, channel)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return json.RawMessage("{}"), nil, false
+		}
+		panic(err)
+	}
+	return ch.Content, ch.Draft, true
+}
+
+func (s *server) publishToChannel(appId uint32, channel string, operator uint32, v, draft json.RawMessage) error {
+	if channel == kChannelProduction {
+		return s.appService.UpdateLastPublishedContent(appId, operator, v, draft)
+	}
+	return s.appChannelService.Publish(appId, channel, operator, v, draft)
+}
+
+func (s *server) handleChannelList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app, err := s.findAppWithRoleByQuery(r, db.RoleViewer)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		chs, err := s.appChannelService.ListByApp(app.ID)
+		if err != nil {
+			panic(err)
+		}
+
+		cache := make(usernameCache)
+		data := make([]ChannelT, 0, len(publishChannels))
+		for _, channel := range publishChannels {
+			item := ChannelT{Channel: channel}
+			if channel == kChannelProduction {
+				item.PublishedAt = app.PublishedAt
+				item.PublishedBy = s.username(cache, app.PublishedBy)
+			}
+			for _, ch := range chs {
+				if ch.Channel == channel {
+					publishedAt := ch.PublishedAt
+					item.PublishedAt = &publishedAt
+					item.PublishedBy = s.username(cache, ch.PublishedBy)
+				}
+			}
+			data = append(data, item)
+		}
+		s.respond(w, r, defaultResponse{Data: data}, http.StatusOK)
+	}
+}
+
+func (s *server) handleChannelPromote() http.HandlerFunc {
+	type request struct {
+		AppId uint32 `json:"appId"`
+		From  string `json:"from"`
+		To    string `json:"to"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		if param.From == "" || param.To == "" || param.From == param.To {
+			s.respond(w, r, fmt.Errorf("%w: promote from(%s) to(%s)",
+				errInvalidParam, param.From, param.To), http.StatusOK)
+			return
+		}
+		for _, channel := range []string{param.From, param.To} {
+			if _, err := checkChannel(channel); err != nil {
+				s.respond(w, r, err, http.StatusOK)
+				return
+			}
+		}
+		app, err := s.findAppWithRole(r, param.AppId, db.RoleEditor)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		content, draft, published := s.channelDraft(app, param.From)
+		// the empty content would wipe out the channel promoted to
+		if !published {
+			s.respond(w, r, fmt.Errorf("%w: nothing was published to channel(%s)",
+				errInvalidParam, param.From), http.StatusOK)
+			return
+		}
+		if err := s.publishToChannel(app.ID, param.To, currentUserId(r), content, draft); err != nil {
+			panic(err)
+		}
+		s.publishAppEvent(app, kEventAppPublished, param.To, currentUserId(r))
+		s.respond(w, r, success, http.StatusOK)
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/rtxu/luban-api/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleChannel(t *testing.T) {
+	assert := assert.New(t)
+	svr, token := newTestServer()
+
+	createEntry(createRequest{
+		Dir:   "/",
+		Entry: EntryT{Name: "entry1", Type: App},
+	}, svr, token)
+	view := func(channel string) interface{} {
+		resp := assertErrCode(t, success.Code, doRequest("GET",
+			"/currentUser/app?appId=0&loadType="+kLTView+"&channel="+channel, nil, svr, token))
+		return resp.Data
+	}
+	v1 := map[string]interface{}{"v": float64(1)}
+	v2 := map[string]interface{}{"v": float64(2)}
+
+	// unknown channel
+	assertErrCode(t, errCodeMap[errInvalidParam], doRequest("GET",
+		"/currentUser/app?appId=0&loadType="+kLTView+"&channel=dev", nil, svr, token))
+	assertErrCode(t, errCodeMap[errInvalidParam], doRequest("PUT",
+		"/currentUser/app?appId=0&op="+kOpPublish+"&channel=dev", v1, svr, token))
+
+	// publish to staging does not affect production, the default channel
+	assertErrCode(t, success.Code, doRequest("PUT",
+		"/currentUser/app?appId=0&op="+kOpPublish+"&channel="+kChannelStaging, v1, svr, token))
+	assert.Equal(v1, view(kChannelStaging))
+	assert.Equal(map[string]interface{}{}, view(kChannelProduction))
+	assert.Equal(map[string]interface{}{}, view(""))
+
+	// promote staging to production
+	assertErrCode(t, errCodeMap[errInvalidParam], doRequest("PUT", "/currentUser/app/promote",
+		map[string]interface{}{"appId": 0, "from": kChannelProduction, "to": kChannelStaging}, svr, token))
+	assertErrCode(t, errCodeMap[errInvalidParam], doRequest("PUT", "/currentUser/app/promote",
+		map[string]interface{}{"appId": 0, "from": kChannelStaging, "to": kChannelStaging}, svr, token))
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/app/promote",
+		map[string]interface{}{"appId": 0, "from": kChannelStaging, "to": kChannelProduction}, svr, token))
+	assert.Equal(v1, view(""))
+
+	// publish without channel goes to production
+	assertErrCode(t, success.Code, doRequest("PUT",
+		"/currentUser/app?appId=0&op="+kOpPublish, v2, svr, token))
+	assert.Equal(v2, view(kChannelProduction))
+	assert.Equal(v1, view(kChannelStaging))
+
+	// viewers only see production
+	const kBobId = 1001
+	bobToken := addTestUser(svr, kBobId, "bob")
+	svr.appACLService.Grant(0, kBobId, db.RoleViewer)
+	assertErrCode(t, success.Code, doRequest("GET",
+		"/currentUser/app?appId=0&loadType="+kLTView, nil, svr, bobToken))
+	assertErrCode(t, errCodeMap[errPermissionDenied], doRequest("GET",
+		"/currentUser/app?appId=0&loadType="+kLTView+"&channel="+kChannelStaging, nil, svr, bobToken))
+
+	// list
+	resp := assertErrCode(t, success.Code, doRequest("GET", "/currentUser/app/channel?appId=0", nil, svr, token))
+	channels := resp.Data.([]interface{})
+	assert.Len(channels, len(publishChannels))
+	for _, ch := range channels {
+		assert.Equal(kTestUserName, ch.(map[string]interface{})["publishedBy"])
+		assert.NotNil(ch.(map[string]interface{})["publishedAt"])
+	}
+}
+
+func TestHandleChannelPromoteUnpublished(t *testing.T) {
+	assert := assert.New(t)
+	svr, token := newTestServer()
+
+	createEntry(createRequest{
+		Dir:   "/",
+		Entry: EntryT{Name: "entry1", Type: App},
+	}, svr, token)
+	v1 := map[string]interface{}{"v": float64(1)}
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/app?appId=0&op="+kOpPublish, v1, svr, token))
+
+	// nothing was published to staging, production is kept
+	assertErrCode(t, errCodeMap[errInvalidParam], doRequest("PUT", "/currentUser/app/promote",
+		map[string]interface{}{"appId": 0, "from": kChannelStaging, "to": kChannelProduction}, svr, token))
+	resp := assertErrCode(t, success.Code, doRequest("GET",
+		"/currentUser/app?appId=0&loadType="+kLTView, nil, svr, token))
+	assert.Equal(v1, resp.Data)
+}
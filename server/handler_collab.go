@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/rtxu/luban-api/db"
+	"github.com/rtxu/luban-api/middleware"
+)
+
+const kCollabHelloTimeout = 10 * time.Second
+
+var collabUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	// the same as the CORS policy, which allows any origin
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// handleAppCollab 通过 WebSocket 协同编辑 app 的草稿，协议见 collab.go
+func (s *server) handleAppCollab() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app, err := s.findAppWithRoleByQuery(r, db.RoleEditor)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		userId := currentUserId(r)
+
+		conn, err := collabUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade has replied an error to client
+			middleware.GetLogEntry(r).Warningln(err)
+			return
+		}
+		client := &collabClient{
+			userId: userId,
+			conn:   conn,
+			send:   make(chan collabMsgT, kCollabSendBufferSize),
+		}
+
+		conn.SetReadLimit(kCollabMaxMessageSize)
+		var hello collabMsgT
+		conn.SetReadDeadline(time.Now().Add(kCollabHelloTimeout))
+		if err := conn.ReadJSON(&hello); err != nil || hello.Type != kCollabMsgHello {
+			conn.Close()
+			return
+		}
+		// dead editors are reaped once they stop answering the pings of writePump
+		conn.SetReadDeadline(time.Now().Add(kCollabPongTimeout))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(kCollabPongTimeout))
+		})
+
+		room, err := s.collabHub.join(app.ID, func() (json.RawMessage, error) {
+			// the draft may have changed since the app was found
+			app, err := s.appService.Get(app.ID)
+			return app.Content, err
+		}, client, hello)
+		if err != nil {
+			middleware.GetLogEntry(r).Warningln(err)
+			conn.Close()
+			return
+		}
+		go client.writePump()
+		defer s.collabHub.leave(room, client)
+
+		save := func(content json.RawMessage) error {
+			// the same as handleAppSave, pages are saved by the page api instead
+			if len(s.listPages(app.ID)) > 0 {
+				content, _ = splitPages(content)
+			}
+			return s.appService.UpdateContent(app.ID, userId, content)
+		}
+		for {
+			var msg collabMsgT
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			if msg.Type != kCollabMsgOp {
+				continue
+			}
+			// the editor may have been removed from the app or the org since joining
+			if !s.appRole(userId, app).Covers(db.RoleEditor) {
+				room.reject(client, msg.ClientSeq, fmt.Errorf("%w: %s role is required on app(%d)",
+					errPermissionDenied, db.RoleEditor, app.ID))
+				return
+			}
+			if err := s.checkEditLock(r, app.ID); err != nil {
+				room.reject(client, msg.ClientSeq, err)
+				continue
+			}
+			if err := room.apply(client, msg, save); err == nil {
+				s.publishAppEvent(app, kEventAppSaved, "", userId)
+			}
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rtxu/luban-api/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// collabTestClient drives the collab protocol in tests
+type collabTestClient struct {
+	t     *testing.T
+	conn  *websocket.Conn
+	epoch string
+	seq   uint64
+}
+
+func dialCollab(t *testing.T, ts *httptest.Server, token string, appId uint32,
+	epoch string, seq uint64) *collabTestClient {
+	url := fmt.Sprintf("%s/currentUser/app/ws?appId=%d&jwt=%s",
+		strings.Replace(ts.URL, "http", "ws", 1), appId, token)
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &collabTestClient{t: t, conn: conn, epoch: epoch, seq: seq}
+	c.write(collabMsgT{Type: kCollabMsgHello, Epoch: epoch, Seq: seq})
+	return c
+}
+
+func (c *collabTestClient) write(msg collabMsgT) {
+	if err := c.conn.WriteJSON(msg); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *collabTestClient) sendOp(clientSeq uint64, path []string, value string) {
+	c.write(collabMsgT{
+		Type:      kCollabMsgOp,
+		ClientSeq: clientSeq,
+		Op:        &collabOpT{Path: path, Value: json.RawMessage(value)},
+	})
+}
+
+// read reads the next message and tracks epoch and seq like a real client
+func (c *collabTestClient) read() collabMsgT {
+	var msg collabMsgT
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := c.conn.ReadJSON(&msg); err != nil {
+		c.t.Fatal(err)
+	}
+	switch msg.Type {
+	case kCollabMsgSnapshot:
+		c.epoch, c.seq = msg.Epoch, msg.Seq
+	case kCollabMsgOp:
+		c.seq = msg.Seq
+	}
+	return msg
+}
+
+func (c *collabTestClient) close() {
+	c.conn.Close()
+}
+
+func TestHandleAppCollab(t *testing.T) {
+	assert := assert.New(t)
+	svr, token := newTestServer()
+	const kBobId = 1001
+	bobToken := addTestUser(svr, kBobId, "bob")
+	ts := httptest.NewServer(svr)
+	defer ts.Close()
+
+	createEntry(createRequest{
+		Dir:   "/",
+		Entry: EntryT{Name: "entry1", Type: App},
+	}, svr, token)
+	svr.appService.UpdateContent(0, kTestUserId, json.RawMessage(`{"widgets":{}}`))
+	svr.appACLService.Grant(0, kBobId, db.RoleEditor)
+	draft := func() string {
+		app, _ := svr.appService.Get(0)
+		return string(app.Content)
+	}
+
+	// viewers could not join
+	{
+		viewerToken := addTestUser(svr, 1002, "viewer")
+		svr.appACLService.Grant(0, 1002, db.RoleViewer)
+		url := fmt.Sprintf("%s/currentUser/app/ws?appId=0&jwt=%s",
+			strings.Replace(ts.URL, "http", "ws", 1), viewerToken)
+		_, _, err := websocket.DefaultDialer.Dial(url, nil)
+		assert.Error(err)
+	}
+
+	owner := dialCollab(t, ts, token, 0, "", 0)
+	snapshot := owner.read()
+	assert.Equal(kCollabMsgSnapshot, snapshot.Type)
+	assert.JSONEq(`{"widgets":{}}`, string(snapshot.Content))
+	bob := dialCollab(t, ts, bobToken, 0, "", 0)
+	assert.Equal(snapshot.Epoch, bob.read().Epoch)
+
+	// ops are broadcast to every editor in order and persisted
+	owner.sendOp(1, []string{"widgets", "w1"}, `{"type":"Button"}`)
+	for _, c := range []*collabTestClient{owner, bob} {
+		msg := c.read()
+		assert.Equal(uint64(1), msg.Seq)
+		assert.Equal(uint32(kTestUserId), msg.UserId)
+		assert.Equal(uint64(1), msg.ClientSeq)
+	}
+	bob.sendOp(1, []string{"widgets", "w2"}, `{"type":"Table"}`)
+	for _, c := range []*collabTestClient{owner, bob} {
+		msg := c.read()
+		assert.Equal(uint64(2), msg.Seq)
+		assert.Equal(uint32(kBobId), msg.UserId)
+	}
+	assert.JSONEq(`{"widgets":{"w1":{"type":"Button"},"w2":{"type":"Table"}}}`, draft())
+	app, _ := svr.appService.Get(0)
+	assert.Equal(uint32(kBobId), app.UpdatedBy)
+
+	// invalid op is rejected to the sender only
+	bob.sendOp(2, []string{"widgets", "w1", "type", "x"}, `1`)
+	errMsg := bob.read()
+	assert.Equal(kCollabMsgError, errMsg.Type)
+	assert.Equal(uint64(2), errMsg.ClientSeq)
+	assert.JSONEq(`{"widgets":{"w1":{"type":"Button"},"w2":{"type":"Table"}}}`, draft())
+
+	// bob drops, misses an op, and gets it replayed after reconnecting
+	bob.close()
+	owner.write(collabMsgT{
+		Type:      kCollabMsgOp,
+		ClientSeq: 2,
+		Op:        &collabOpT{Path: []string{"widgets", "w2"}, Delete: true},
+	})
+	assert.Equal(uint64(3), owner.read().Seq)
+	bob = dialCollab(t, ts, bobToken, 0, bob.epoch, bob.seq)
+	replayed := bob.read()
+	assert.Equal(kCollabMsgOp, replayed.Type)
+	assert.Equal(uint64(3), replayed.Seq)
+	assert.True(replayed.Op.Delete)
+
+	// reconnecting with an unknown epoch gets a snapshot
+	stale := dialCollab(t, ts, bobToken, 0, "stale", 3)
+	snapshot = stale.read()
+	assert.Equal(kCollabMsgSnapshot, snapshot.Type)
+	assert.Equal(uint64(3), snapshot.Seq)
+	assert.JSONEq(`{"widgets":{"w1":{"type":"Button"}}}`, string(snapshot.Content))
+
+	stale.close()
+
+	// ops are announced as saves of the app
+	events := subscribeEvents(t, ts, token)
+	defer events.close()
+	owner.sendOp(3, []string{"title"}, `"t"`)
+	assert.Equal(uint64(4), owner.read().Seq)
+	assert.Equal(uint64(4), bob.read().Seq)
+	event := events.next()
+	assert.Equal(kEventAppSaved, event.Type)
+	assert.Equal(kTestUserName, event.Operator)
+
+	// saves by the REST api replace the content of the room
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/app?appId=0&op="+kOpSave,
+		map[string]interface{}{"widgets": map[string]interface{}{}}, svr, bobToken))
+	for _, c := range []*collabTestClient{owner, bob} {
+		snapshot := c.read()
+		assert.Equal(kCollabMsgSnapshot, snapshot.Type)
+		assert.Equal(uint64(5), snapshot.Seq)
+		assert.JSONEq(`{"widgets":{}}`, string(snapshot.Content))
+	}
+	owner.sendOp(4, []string{"widgets", "w3"}, `{"type":"Text"}`)
+	assert.Equal(uint64(6), owner.read().Seq)
+	assert.JSONEq(`{"widgets":{"w3":{"type":"Text"}}}`, draft())
+
+	// the whole content is replaced by an object only
+	owner.sendOp(5, nil, `5`)
+	errMsg = owner.read()
+	assert.Equal(kCollabMsgError, errMsg.Type)
+	assert.Equal(uint64(5), errMsg.ClientSeq)
+	assert.JSONEq(`{"widgets":{"w3":{"type":"Text"}}}`, draft())
+
+	// the stubs of pages are not saved back
+	assertErrCode(t, success.Code, doRequest("POST", "/currentUser/app/page",
+		map[string]interface{}{"appId": 0, "name": "home"}, svr, token))
+	owner.sendOp(6, []string{"pages"}, `[{"id":0,"name":"home"}]`)
+	assert.Equal(uint64(7), owner.read().Seq)
+	assert.JSONEq(`{"widgets":{"w3":{"type":"Text"}}}`, draft())
+
+	// ops are rejected while others hold the edit lock
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/app/lock?appId=0", nil, svr, bobToken))
+	owner.sendOp(7, []string{"widgets", "w3"}, `{"type":"Button"}`)
+	errMsg = owner.read()
+	assert.Equal(kCollabMsgError, errMsg.Type)
+	assert.Equal(uint64(7), errMsg.ClientSeq)
+	assert.JSONEq(`{"widgets":{"w3":{"type":"Text"}}}`, draft())
+
+	assertErrCode(t, success.Code, doRequest("DELETE", "/currentUser/app/lock?appId=0", nil, svr, bobToken))
+
+	// editors removed from the app are disconnected
+	svr.appACLService.Revoke(0, kBobId)
+	bob.sendOp(2, []string{"widgets", "w3"}, `{"type":"Button"}`)
+	errMsg = bob.read()
+	for errMsg.Type == kCollabMsgOp {
+		// skips the ops broadcast before
+		errMsg = bob.read()
+	}
+	assert.Equal(kCollabMsgError, errMsg.Type)
+	assert.Equal(uint64(2), errMsg.ClientSeq)
+	bob.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := bob.conn.ReadMessage()
+	assert.Error(err)
+	assert.JSONEq(`{"widgets":{"w3":{"type":"Text"}}}`, draft())
+
+	// messages larger than the limit are refused
+	owner.sendOp(8, []string{"widgets", "w4"}, `"`+strings.Repeat("x", kCollabMaxMessageSize)+`"`)
+	owner.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = owner.conn.ReadMessage()
+	assert.Error(err)
+	assert.JSONEq(`{"widgets":{"w3":{"type":"Text"}}}`, draft())
+
+	bob.close()
+	owner.close()
+}
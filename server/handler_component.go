@@ -0,0 +1,467 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rtxu/luban-api/db"
+)
+
+const (
+	// an object with the key is a reference to a component, e.g.
+	//
+	//	{"$component": {"id": 1, "version": 2}}
+	//
+	// version 0 or absent tracks the latest version, otherwise the version is pinned
+	kComponentRefKey = "$component"
+	// components could reference each other, but not deeper than that
+	kComponentMaxDepth = 8
+)
+
+// ComponentT 是 workspace 中可被多个 app 复用的组件
+type ComponentT struct {
+	Id          uint32    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Version     uint32    `json:"version"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	UpdatedBy   string    `json:"updatedBy"`
+}
+
+func (s *server) newComponent(c db.Component, cache usernameCache) ComponentT {
+	return ComponentT{
+		Id:          c.ID,
+		Name:        c.Name,
+		Description: c.Description,
+		Version:     c.Version,
+		UpdatedAt:   c.UpdatedAt,
+		UpdatedBy:   s.username(cache, c.UpdatedBy),
+	}
+}
+
+// ComponentVersionT 是组件的一个版本
+type ComponentVersionT struct {
+	Version   uint32    `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	CreatedBy string    `json:"createdBy"`
+	// omitted when versions are listed
+	Content json.RawMessage `json:"content,omitempty"`
+}
+
+func (s *server) newComponentVersion(v db.ComponentVersion, cache usernameCache) ComponentVersionT {
+	return ComponentVersionT{
+		Version:   v.Version,
+		CreatedAt: v.CreatedAt,
+		CreatedBy: s.username(cache, v.CreatedBy),
+		Content:   v.Content,
+	}
+}
+
+// findComponent finds the component in the workspace
+func (s *server) findComponent(ws workspace, id uint32) (db.Component, error) {
+	c, err := s.componentService.Find(id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return c, fmt.Errorf("%w: componentId is %d", errComponentNotFound, id)
+		}
+		panic(err)
+	}
+	if !ws.owns(c.OwnerID, c.OrgID) {
+		return c, fmt.Errorf("%w: componentId is %d", errComponentNotFound, id)
+	}
+	return c, nil
+}
+
+// findComponentByQuery is the same as findComponent but reads id from the query string
+func (s *server) findComponentByQuery(r *http.Request) (workspace, db.Component, error) {
+	ws, err := s.getWorkspace(r)
+	if err != nil {
+		return ws, db.Component{}, err
+	}
+	idStr := r.URL.Query().Get("id")
+	u64, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		return ws, db.Component{}, fmt.Errorf("%w: id(%s) is not a number, err: %v",
+			errBadRequest, idStr, err)
+	}
+	c, err := s.findComponent(ws, uint32(u64))
+	return ws, c, err
+}
+
+// checkComponentName checks that name is not empty and not used by others in the workspace,
+// self is the component being updated, nil for a new one
+func (s *server) checkComponentName(ws workspace, name string, self *db.Component) error {
+	if name == "" {
+		return fmt.Errorf("%w: empty component name", errInvalidParam)
+	}
+	c, err := s.componentService.FindByName(ws.user.ID, ws.orgId(), name)
+	if err == nil && (self == nil || c.ID != self.ID) {
+		return fmt.Errorf("%w: component(%s) already exists", errInvalidParam, name)
+	}
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		panic(err)
+	}
+	return nil
+}
+
+func checkComponentContent(content json.RawMessage) error {
+	if content == nil || string(content) == "null" {
+		return fmt.Errorf("%w: content of component is required", errInvalidParam)
+	}
+	return nil
+}
+
+// componentResolver replaces references to components with their contents
+type componentResolver struct {
+	s   *server
+	app db.App
+	// components being resolved, to find the cycles
+	stack []uint32
+	// resolved contents by component id and version
+	cache map[[2]uint32]interface{}
+	// versions of the components referenced without a version, i.e. tracking the latest
+	tracked map[uint32]uint32
+}
+
+func decodeContent(content json.RawMessage) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	// keep numbers as they are
+	decoder.UseNumber()
+	var v interface{}
+	err := decoder.Decode(&v)
+	return v, err
+}
+
+func (cr *componentResolver) resolve(v interface{}) (interface{}, error) {
+	var err error
+	switch x := v.(type) {
+	case map[string]interface{}:
+		if ref, ok := x[kComponentRefKey]; ok {
+			return cr.resolveRef(ref)
+		}
+		for k, e := range x {
+			if x[k], err = cr.resolve(e); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for i, e := range x {
+			if x[i], err = cr.resolve(e); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+func (cr *componentResolver) resolveRef(v interface{}) (interface{}, error) {
+	var ref struct {
+		Id      uint32 `json:"id"`
+		Version uint32 `json:"version"`
+	}
+	refBytes, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	if err := json.Unmarshal(refBytes, &ref); err != nil {
+		return nil, fmt.Errorf("%w: invalid reference to component %s", errInvalidParam, refBytes)
+	}
+	for _, id := range cr.stack {
+		if id == ref.Id {
+			return nil, fmt.Errorf("%w: component(%d) references itself", errInvalidParam, ref.Id)
+		}
+	}
+	if len(cr.stack) >= kComponentMaxDepth {
+		return nil, fmt.Errorf("%w: components are nested deeper than %d", errInvalidParam, kComponentMaxDepth)
+	}
+
+	c, err := cr.s.componentService.Find(ref.Id)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		panic(err)
+	}
+	// components are shared in the workspace of the app only
+	if err != nil || c.OrgID != cr.app.OrgID || (c.OrgID == 0 && c.OwnerID != cr.app.OwnerID) {
+		return nil, fmt.Errorf("%w: componentId is %d", errComponentNotFound, ref.Id)
+	}
+	if ref.Version == 0 {
+		ref.Version = c.Version
+		cr.tracked[ref.Id] = c.Version
+	}
+	key := [2]uint32{ref.Id, ref.Version}
+	if resolved, ok := cr.cache[key]; ok {
+		return resolved, nil
+	}
+	cv, err := cr.s.componentService.FindVersion(ref.Id, ref.Version)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return nil, fmt.Errorf("%w: component(%d) has no version %d",
+				errComponentNotFound, ref.Id, ref.Version)
+		}
+		panic(err)
+	}
+	content, err := decodeContent(cv.Content)
+	if err != nil {
+		panic(err)
+	}
+
+	cr.stack = append(cr.stack, ref.Id)
+	resolved, err := cr.resolve(content)
+	cr.stack = cr.stack[:len(cr.stack)-1]
+	if err != nil {
+		return nil, err
+	}
+	cr.cache[key] = resolved
+	return resolved, nil
+}
+
+// resolveComponents makes content of app self-contained by replacing the references to components,
+// so that the published app is not affected by later changes of components
+func (s *server) resolveComponents(app db.App, content json.RawMessage) (json.RawMessage, error) {
+	resolved, _, err := s.resolveTrackedComponents(app, content)
+	return resolved, err
+}
+
+// resolveTrackedComponents is resolveComponents, which returns the versions of the components
+// tracking the latest as well, see draftMarkerT
+func (s *server) resolveTrackedComponents(app db.App, content json.RawMessage) (json.RawMessage, map[uint32]uint32, error) {
+	if !bytes.Contains(content, []byte(strconv.Quote(kComponentRefKey))) {
+		return content, nil, nil
+	}
+	v, err := decodeContent(content)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: content is not valid JSON, err: %v", errInvalidParam, err)
+	}
+	cr := &componentResolver{
+		s:       s,
+		app:     app,
+		cache:   make(map[[2]uint32]interface{}),
+		tracked: make(map[uint32]uint32),
+	}
+	if v, err = cr.resolve(v); err != nil {
+		return nil, nil, err
+	}
+	resolved, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return resolved, cr.tracked, nil
+}
+
+func (s *server) handleComponentList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ws, err := s.getWorkspace(r)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		cs, err := s.componentService.ListByWorkspace(ws.user.ID, ws.orgId())
+		if err != nil {
+			panic(err)
+		}
+		cache := make(usernameCache)
+		data := make([]ComponentT, 0, len(cs))
+		for _, c := range cs {
+			data = append(data, s.newComponent(c, cache))
+		}
+		s.respond(w, r, defaultResponse{Data: data}, http.StatusOK)
+	}
+}
+
+// handleComponentCreate 将 app 内容的一个片段保存为组件，作为其第 1 个版本
+func (s *server) handleComponentCreate() http.HandlerFunc {
+	type request struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description"`
+		Content     json.RawMessage `json:"content"`
+	}
+	type dataT struct {
+		Id      uint32 `json:"id"`
+		Version uint32 `json:"version"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		param.Name = strings.TrimSpace(param.Name)
+
+		ws, err := s.getWorkspace(r)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := ws.checkRole(db.RoleEditor); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := s.checkComponentName(ws, param.Name, nil); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := checkComponentContent(param.Content); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		c := db.Component{
+			OwnerID:     ws.user.ID,
+			OrgID:       ws.orgId(),
+			Name:        param.Name,
+			Description: param.Description,
+			UpdatedBy:   ws.user.ID,
+		}
+		if err := s.componentService.NewComponent(&c, param.Content); err != nil {
+			panic(err)
+		}
+		s.respond(w, r, defaultResponse{Data: dataT{Id: c.ID, Version: c.Version}}, http.StatusOK)
+	}
+}
+
+// handleComponentUpdate 修改组件的描述，或保存组件的新版本
+func (s *server) handleComponentUpdate() http.HandlerFunc {
+	type request struct {
+		Id          uint32          `json:"id"`
+		Description *string         `json:"description"`
+		Content     json.RawMessage `json:"content"`
+	}
+	type dataT struct {
+		Version uint32 `json:"version"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		ws, err := s.getWorkspace(r)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := ws.checkRole(db.RoleEditor); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		c, err := s.findComponent(ws, param.Id)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		if param.Description != nil {
+			err := s.componentService.Update(c.ID, map[string]interface{}{
+				"description": *param.Description,
+			})
+			if err != nil {
+				panic(err)
+			}
+		}
+		version := c.Version
+		if param.Content != nil {
+			if err := checkComponentContent(param.Content); err != nil {
+				s.respond(w, r, err, http.StatusOK)
+				return
+			}
+			version, err = s.componentService.AddVersion(c.ID, ws.user.ID, param.Content)
+			if err != nil {
+				panic(err)
+			}
+		}
+		s.respond(w, r, defaultResponse{Data: dataT{Version: version}}, http.StatusOK)
+	}
+}
+
+// handleComponentDelete 删除组件及其全部版本，已发布的 app 不受影响
+func (s *server) handleComponentDelete() http.HandlerFunc {
+	type request struct {
+		Id uint32 `json:"id"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		ws, err := s.getWorkspace(r)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := ws.checkRole(db.RoleEditor); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		c, err := s.findComponent(ws, param.Id)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		if err := s.componentService.Delete(c.ID); err != nil {
+			panic(err)
+		}
+		s.respond(w, r, success, http.StatusOK)
+	}
+}
+
+func (s *server) handleComponentVersionList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, c, err := s.findComponentByQuery(r)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		vs, err := s.componentService.ListVersions(c.ID)
+		if err != nil {
+			panic(err)
+		}
+		cache := make(usernameCache)
+		data := make([]ComponentVersionT, 0, len(vs))
+		for _, v := range vs {
+			v.Content = nil
+			data = append(data, s.newComponentVersion(v, cache))
+		}
+		s.respond(w, r, defaultResponse{Data: data}, http.StatusOK)
+	}
+}
+
+// handleComponentVersionGet 加载组件某个版本的内容，version 为空时是最新版本
+func (s *server) handleComponentVersionGet() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, c, err := s.findComponentByQuery(r)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		version := c.Version
+		if versionStr := r.URL.Query().Get("version"); versionStr != "" {
+			u64, err := strconv.ParseUint(versionStr, 10, 32)
+			if err != nil {
+				s.respond(w, r, fmt.Errorf("%w: version(%s) is not a number, err: %v",
+					errBadRequest, versionStr, err), http.StatusOK)
+				return
+			}
+			version = uint32(u64)
+		}
+
+		v, err := s.componentService.FindVersion(c.ID, version)
+		if err != nil {
+			if errors.Is(err, db.ErrNotFound) {
+				s.respond(w, r, fmt.Errorf("%w: component(%d) has no version %d",
+					errComponentNotFound, c.ID, version), http.StatusOK)
+				return
+			}
+			panic(err)
+		}
+		s.respond(w, r, defaultResponse{
+			Data: s.newComponentVersion(v, make(usernameCache)),
+		}, http.StatusOK)
+	}
+}
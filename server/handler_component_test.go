@@ -0,0 +1,106 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleComponent(t *testing.T) {
+	assert := assert.New(t)
+	svr, token := newTestServer()
+	otherToken := addTestUser(svr, 1001, "bob")
+	createEntry(createRequest{
+		Dir:   "/",
+		Entry: EntryT{Name: "entry1", Type: App},
+	}, svr, token)
+
+	header := map[string]interface{}{"type": "Header", "title": "v1"}
+	resp := assertErrCode(t, success.Code, doRequest("POST", "/currentUser/component",
+		map[string]interface{}{"name": "header", "content": header}, svr, token))
+	data := resp.Data.(map[string]interface{})
+	assert.Equal(float64(1), data["version"])
+	headerId := data["id"]
+	assertErrCode(t, errCodeMap[errInvalidParam], doRequest("POST", "/currentUser/component",
+		map[string]interface{}{"name": "header", "content": header}, svr, token))
+	assertErrCode(t, errCodeMap[errInvalidParam], doRequest("POST", "/currentUser/component",
+		map[string]interface{}{"name": "empty"}, svr, token))
+	assertErrCode(t, errCodeMap[errComponentNotFound], doRequest("PUT", "/currentUser/component",
+		map[string]interface{}{"id": headerId, "content": header}, svr, otherToken))
+
+	// new versions
+	resp = assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/component",
+		map[string]interface{}{"id": headerId, "content": map[string]interface{}{"type": "Header", "title": "v2"}},
+		svr, token))
+	assert.Equal(float64(2), resp.Data.(map[string]interface{})["version"])
+	resp = assertErrCode(t, success.Code, doRequest("GET", "/currentUser/component/version?id=0", nil, svr, token))
+	assert.Len(resp.Data, 2)
+	resp = assertErrCode(t, success.Code, doRequest("GET", "/currentUser/component/version/content?id=0&version=1",
+		nil, svr, token))
+	assert.Equal(header, resp.Data.(map[string]interface{})["content"])
+
+	// publish resolves the references, pinned or tracking the latest
+	publish := func(content map[string]interface{}) *http.Response {
+		return doRequest("PUT", "/currentUser/app?appId=0&op="+kOpPublish, content, svr, token)
+	}
+	hasUnpublishedChanges := func() bool {
+		resp := assertErrCode(t, success.Code, doRequest("GET", "/currentUser/app/meta?appId=0", nil, svr, token))
+		return resp.Data.(map[string]interface{})["hasUnpublishedChanges"].(bool)
+	}
+	draft := map[string]interface{}{
+		"pinned": map[string]interface{}{kComponentRefKey: map[string]interface{}{"id": headerId, "version": 1}},
+		"latest": []interface{}{map[string]interface{}{kComponentRefKey: map[string]interface{}{"id": headerId}}},
+		"size":   12345678901,
+	}
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/app?appId=0&op="+kOpSave, draft, svr, token))
+	assert.True(hasUnpublishedChanges())
+	assertErrCode(t, success.Code, publish(draft))
+	assert.False(hasUnpublishedChanges())
+	resp = assertErrCode(t, success.Code, doRequest("GET", "/currentUser/app?appId=0&loadType=view", nil, svr, token))
+	assert.Equal(map[string]interface{}{
+		"pinned": header,
+		"latest": []interface{}{map[string]interface{}{"type": "Header", "title": "v2"}},
+		"size":   float64(12345678901),
+	}, resp.Data)
+
+	// a new version is an unpublished change of the apps tracking the latest
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/component",
+		map[string]interface{}{"id": headerId, "content": header}, svr, token))
+	assert.True(hasUnpublishedChanges())
+
+	// components referencing each other
+	resp = assertErrCode(t, success.Code, doRequest("POST", "/currentUser/component", map[string]interface{}{
+		"name": "page", "content": map[string]interface{}{kComponentRefKey: map[string]interface{}{"id": headerId}},
+	}, svr, token))
+	pageId := resp.Data.(map[string]interface{})["id"]
+	assertErrCode(t, success.Code, publish(map[string]interface{}{
+		"w": map[string]interface{}{kComponentRefKey: map[string]interface{}{"id": pageId}},
+	}))
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/component", map[string]interface{}{
+		"id": headerId, "content": map[string]interface{}{kComponentRefKey: map[string]interface{}{"id": pageId}},
+	}, svr, token))
+	assertErrCode(t, errCodeMap[errInvalidParam], publish(map[string]interface{}{
+		"w": map[string]interface{}{kComponentRefKey: map[string]interface{}{"id": pageId}},
+	}))
+	assertErrCode(t, errCodeMap[errInvalidParam], publish(map[string]interface{}{
+		"w": map[string]interface{}{kComponentRefKey: "header"},
+	}))
+
+	// components of other workspaces, missing versions and deleted components
+	resp = assertErrCode(t, success.Code, doRequest("POST", "/currentUser/component",
+		map[string]interface{}{"name": "bob", "content": header}, svr, otherToken))
+	assertErrCode(t, errCodeMap[errComponentNotFound], publish(map[string]interface{}{
+		"w": map[string]interface{}{kComponentRefKey: map[string]interface{}{"id": resp.Data.(map[string]interface{})["id"]}},
+	}))
+	assertErrCode(t, errCodeMap[errComponentNotFound], publish(map[string]interface{}{
+		"w": map[string]interface{}{kComponentRefKey: map[string]interface{}{"id": pageId, "version": 9}},
+	}))
+	assertErrCode(t, success.Code, doRequest("DELETE", "/currentUser/component",
+		map[string]interface{}{"id": pageId}, svr, token))
+	assertErrCode(t, errCodeMap[errComponentNotFound], publish(map[string]interface{}{
+		"w": map[string]interface{}{kComponentRefKey: map[string]interface{}{"id": pageId}},
+	}))
+	resp = assertErrCode(t, success.Code, doRequest("GET", "/currentUser/component", nil, svr, token))
+	assert.Len(resp.Data, 1)
+}
@@ -0,0 +1,393 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/rtxu/luban-api/db"
+)
+
+// DataSourceT 代表 workspace 中注册的一个外部 REST 服务或 SQL 数据库，
+// header 的值及 DSN 可能包含密钥，不返回给前端
+type DataSourceT struct {
+	Id   uint32 `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	BaseUrl     string            `json:"baseUrl,omitempty"`
+	HeaderNames []string          `json:"headerNames,omitempty"`
+	Params      map[string]string `json:"params,omitempty"`
+
+	Driver       string `json:"driver,omitempty"`
+	ReadOnly     bool   `json:"readOnly,omitempty"`
+	MaxRows      uint32 `json:"maxRows,omitempty"`
+	QueryTimeout uint32 `json:"queryTimeout,omitempty"`
+
+	// set when the secrets could not be decrypted, they should be entered again
+	Error string `json:"error,omitempty"`
+}
+
+func decodeStringMap(raw json.RawMessage) map[string]string {
+	m := make(map[string]string)
+	if raw != nil {
+		if err := json.Unmarshal(raw, &m); err != nil {
+			panic(err)
+		}
+	}
+	return m
+}
+
+func encodeStringMap(m map[string]string) json.RawMessage {
+	if m == nil {
+		m = make(map[string]string)
+	}
+	bytes, _ := json.Marshal(m)
+	return bytes
+}
+
+// newDataSource takes the decrypted secrets to list the header names
+func newDataSource(ds db.DataSource, secrets dataSourceSecrets) DataSourceT {
+	if ds.Type == db.DataSourceTypeSQL {
+		return DataSourceT{
+			Id:           ds.ID,
+			Name:         ds.Name,
+			Type:         ds.Type,
+			Driver:       ds.Driver,
+			ReadOnly:     ds.ReadOnly,
+			MaxRows:      ds.MaxRows,
+			QueryTimeout: ds.QueryTimeout,
+		}
+	}
+	headerNames := make([]string, 0)
+	for name := range secrets.Headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+	return DataSourceT{
+		Id:          ds.ID,
+		Name:        ds.Name,
+		Type:        ds.Type,
+		BaseUrl:     ds.BaseURL,
+		HeaderNames: headerNames,
+		Params:      decodeStringMap(ds.Params),
+	}
+}
+
+func checkBaseURL(baseURL string) error {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: baseUrl(%s) should be an absolute http(s) url", errInvalidParam, baseURL)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("%w: baseUrl(%s) should not have query or fragment, use params instead",
+			errInvalidParam, baseURL)
+	}
+	return nil
+}
+
+// checkDataSourceName checks that name is not empty and not used by others in the workspace,
+// self is the data source being renamed, nil for a new one
+func (s *server) checkDataSourceName(ws workspace, name string, self *db.DataSource) error {
+	if name == "" {
+		return fmt.Errorf("%w: empty data source name", errInvalidParam)
+	}
+	ds, err := s.dataSourceService.FindByName(ws.user.ID, ws.orgId(), name)
+	if err == nil && (self == nil || ds.ID != self.ID) {
+		return fmt.Errorf("%w: data source(%s) already exists", errInvalidParam, name)
+	}
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		panic(err)
+	}
+	return nil
+}
+
+// findDataSource finds the data source in the workspace
+func (s *server) findDataSource(ws workspace, id uint32) (db.DataSource, error) {
+	ds, err := s.dataSourceService.Find(id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return ds, fmt.Errorf("%w: dataSourceId is %d", errDataSourceNotFound, id)
+		}
+		panic(err)
+	}
+	if !ws.owns(ds.OwnerID, ds.OrgID) {
+		return ds, fmt.Errorf("%w: dataSourceId is %d", errDataSourceNotFound, id)
+	}
+	return ds, nil
+}
+
+func (s *server) handleDataSourceList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ws, err := s.getWorkspace(r)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		dss, err := s.dataSourceService.ListByWorkspace(ws.user.ID, ws.orgId())
+		if err != nil {
+			panic(err)
+		}
+		data := make([]DataSourceT, 0, len(dss))
+		for _, ds := range dss {
+			secrets, err := s.openSecrets(ds)
+			item := newDataSource(ds, secrets)
+			if err != nil {
+				// the others are listed still
+				item.Error = err.Error()
+			}
+			data = append(data, item)
+		}
+		s.respond(w, r, defaultResponse{Data: data}, http.StatusOK)
+	}
+}
+
+// 数据源的密钥由 workspace 的 admin 管理
+func (s *server) handleDataSourceCreate() http.HandlerFunc {
+	type request struct {
+		Name string `json:"name"`
+		// rest by default
+		Type string `json:"type"`
+
+		BaseUrl string            `json:"baseUrl"`
+		Headers map[string]string `json:"headers"`
+		Params  map[string]string `json:"params"`
+
+		Driver       string `json:"driver"`
+		Dsn          string `json:"dsn"`
+		ReadOnly     bool   `json:"readOnly"`
+		MaxRows      uint32 `json:"maxRows"`
+		QueryTimeout uint32 `json:"queryTimeout"`
+	}
+	type dataT struct {
+		Id uint32 `json:"id"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		param.Name = strings.TrimSpace(param.Name)
+		var err error
+		switch param.Type {
+		case "", db.DataSourceTypeREST:
+			param.Type = db.DataSourceTypeREST
+			err = checkBaseURL(param.BaseUrl)
+		case db.DataSourceTypeSQL:
+			param.Dsn, err = sanitizeDSN(param.Driver, param.Dsn)
+		default:
+			err = fmt.Errorf("%w: unrecognized data source type(%s)", errInvalidParam, param.Type)
+		}
+		if err == nil {
+			err = checkTemplateMap(param.Headers)
+		}
+		if err == nil {
+			err = checkTemplateMap(param.Params)
+		}
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		ws, err := s.getWorkspace(r)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := ws.checkRole(db.RoleAdmin); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := s.checkDataSourceName(ws, param.Name, nil); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		ds := db.DataSource{
+			OwnerID: ws.user.ID,
+			OrgID:   ws.orgId(),
+			Name:    param.Name,
+			Type:    param.Type,
+		}
+		if ds.Type == db.DataSourceTypeSQL {
+			ds.Driver = param.Driver
+			ds.Secrets = s.sealSecrets(dataSourceSecrets{DSN: param.Dsn})
+			ds.ReadOnly = param.ReadOnly
+			ds.MaxRows = param.MaxRows
+			ds.QueryTimeout = param.QueryTimeout
+		} else {
+			ds.BaseURL = param.BaseUrl
+			ds.Secrets = s.sealSecrets(dataSourceSecrets{Headers: param.Headers})
+			ds.Params = encodeStringMap(param.Params)
+		}
+		if err := s.dataSourceService.NewDataSource(&ds); err != nil {
+			panic(err)
+		}
+		s.respond(w, r, defaultResponse{Data: dataT{Id: ds.ID}}, http.StatusOK)
+	}
+}
+
+func (s *server) handleDataSourceUpdate() http.HandlerFunc {
+	type request struct {
+		Id      uint32  `json:"id"`
+		Name    *string `json:"name"`
+		BaseUrl *string `json:"baseUrl"`
+		// headers and dsn are kept when absent, as they are never returned to the browser
+		Headers map[string]string `json:"headers"`
+		Params  map[string]string `json:"params"`
+
+		Driver       *string `json:"driver"`
+		Dsn          *string `json:"dsn"`
+		ReadOnly     *bool   `json:"readOnly"`
+		MaxRows      *uint32 `json:"maxRows"`
+		QueryTimeout *uint32 `json:"queryTimeout"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+
+		ws, err := s.getWorkspace(r)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := ws.checkRole(db.RoleAdmin); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		ds, err := s.findDataSource(ws, param.Id)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		toUpdate := make(map[string]interface{})
+		if param.Name != nil {
+			name := strings.TrimSpace(*param.Name)
+			if err := s.checkDataSourceName(ws, name, &ds); err != nil {
+				s.respond(w, r, err, http.StatusOK)
+				return
+			}
+			toUpdate["name"] = name
+		}
+		if param.BaseUrl != nil {
+			if err := checkBaseURL(*param.BaseUrl); err != nil {
+				s.respond(w, r, err, http.StatusOK)
+				return
+			}
+			toUpdate["base_url"] = *param.BaseUrl
+		}
+		secrets, err := s.openSecrets(ds)
+		if err != nil {
+			// unreadable secrets could only be replaced as a whole
+			replaced := param.Headers != nil
+			if ds.Type == db.DataSourceTypeSQL {
+				replaced = param.Dsn != nil
+			}
+			if !replaced {
+				s.respond(w, r, err, http.StatusOK)
+				return
+			}
+		}
+		secretsChanged := false
+		if err := checkTemplateMap(param.Headers); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := checkTemplateMap(param.Params); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if param.Headers != nil {
+			secrets.Headers = param.Headers
+			secretsChanged = true
+		}
+		if param.Params != nil {
+			toUpdate["params"] = encodeStringMap(param.Params)
+		}
+		if param.Driver != nil {
+			if err := checkSQLDriver(*param.Driver); err != nil {
+				s.respond(w, r, err, http.StatusOK)
+				return
+			}
+			toUpdate["driver"] = *param.Driver
+		}
+		if param.Dsn != nil {
+			driver := ds.Driver
+			if param.Driver != nil {
+				driver = *param.Driver
+			}
+			dsn, err := sanitizeDSN(driver, *param.Dsn)
+			if err != nil {
+				s.respond(w, r, err, http.StatusOK)
+				return
+			}
+			secrets.DSN = dsn
+			secretsChanged = true
+		}
+		if secretsChanged {
+			toUpdate["secrets"] = s.sealSecrets(secrets)
+		}
+		if param.ReadOnly != nil {
+			toUpdate["read_only"] = *param.ReadOnly
+		}
+		if param.MaxRows != nil {
+			toUpdate["max_rows"] = *param.MaxRows
+		}
+		if param.QueryTimeout != nil {
+			toUpdate["query_timeout"] = *param.QueryTimeout
+		}
+		if len(toUpdate) > 0 {
+			if err := s.dataSourceService.Update(ds.ID, toUpdate); err != nil {
+				panic(err)
+			}
+			s.sqlPool.close(ds.ID)
+			s.queryCache.invalidateDataSource(ds.ID)
+		}
+		s.respond(w, r, success, http.StatusOK)
+	}
+}
+
+func (s *server) handleDataSourceDelete() http.HandlerFunc {
+	type request struct {
+		Id uint32 `json:"id"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+
+		ws, err := s.getWorkspace(r)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := ws.checkRole(db.RoleAdmin); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		ds, err := s.findDataSource(ws, param.Id)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		// queries on it fail with errDataSourceNotFound from now on
+		if err := s.dataSourceService.Delete(ds.ID); err != nil {
+			panic(err)
+		}
+		s.sqlPool.close(ds.ID)
+		s.queryCache.invalidateDataSource(ds.ID)
+		s.respond(w, r, success, http.StatusOK)
+	}
+}
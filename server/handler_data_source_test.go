@@ -0,0 +1,385 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	// SQLite is the local stand-in of MySQL/Postgres
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/rtxu/luban-api/db"
+	"github.com/rtxu/luban-api/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	// SQLite is only allowed in tests
+	sqlDrivers["sqlite3"] = func(dsn string) (string, error) { return dsn, nil }
+}
+
+func TestHandleDataSource(t *testing.T) {
+	assert := assert.New(t)
+	svr, token := newTestServer()
+	otherToken := addTestUser(svr, 1001, "bob")
+
+	// invalid
+	assertErrCode(t, errCodeMap[errInvalidParam], doRequest("POST", "/currentUser/dataSource",
+		map[string]interface{}{"name": "api", "baseUrl": "ftp://example.com"}, svr, token))
+	assertErrCode(t, errCodeMap[errInvalidParam], doRequest("POST", "/currentUser/dataSource",
+		map[string]interface{}{"name": "", "baseUrl": "https://example.com"}, svr, token))
+
+	resp := assertErrCode(t, success.Code, doRequest("POST", "/currentUser/dataSource", map[string]interface{}{
+		"name":    "api",
+		"baseUrl": "https://example.com/v1",
+		"headers": map[string]string{"Authorization": "token secret"},
+		"params":  map[string]string{"lang": "en"},
+	}, svr, token))
+	id := resp.Data.(map[string]interface{})["id"].(float64)
+	assertErrCode(t, errCodeMap[errInvalidParam], doRequest("POST", "/currentUser/dataSource",
+		map[string]interface{}{"name": "api", "baseUrl": "https://example.com"}, svr, token))
+
+	// secrets are never returned
+	list := func(token string) []interface{} {
+		resp := assertErrCode(t, success.Code, doRequest("GET", "/currentUser/dataSource", nil, svr, token))
+		return resp.Data.([]interface{})
+	}
+	assert.Equal([]interface{}{map[string]interface{}{
+		"id":          float64(0),
+		"name":        "api",
+		"type":        db.DataSourceTypeREST,
+		"baseUrl":     "https://example.com/v1",
+		"headerNames": []interface{}{"Authorization"},
+		"params":      map[string]interface{}{"lang": "en"},
+	}}, list(token))
+	assert.Len(list(otherToken), 0)
+
+	// update keeps headers when absent
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/dataSource",
+		map[string]interface{}{"id": id, "name": "github"}, svr, token))
+	ds, _ := svr.dataSourceService.Find(0)
+	assert.Equal("github", ds.Name)
+	secrets, err := svr.openSecrets(ds)
+	assert.NoError(err)
+	assert.Equal(map[string]string{"Authorization": "token secret"}, secrets.Headers)
+	// secrets are encrypted at rest
+	assert.NotContains(ds.Secrets, "token secret")
+	assertErrCode(t, errCodeMap[errDataSourceNotFound], doRequest("PUT", "/currentUser/dataSource",
+		map[string]interface{}{"id": id, "name": "stolen"}, svr, otherToken))
+
+	// delete
+	assertErrCode(t, errCodeMap[errDataSourceNotFound], doRequest("DELETE", "/currentUser/dataSource",
+		map[string]interface{}{"id": id}, svr, otherToken))
+	assertErrCode(t, success.Code, doRequest("DELETE", "/currentUser/dataSource",
+		map[string]interface{}{"id": id}, svr, token))
+	assert.Len(list(token), 0)
+}
+
+func TestHandleQuery(t *testing.T) {
+	assert := assert.New(t)
+	svr, token := newTestServer()
+	const kBobId = 1001
+	bobToken := addTestUser(svr, kBobId, "bob")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`bad credentials`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"method": r.Method,
+			"path":   r.URL.Path,
+			"query":  r.URL.Query(),
+		})
+	}))
+	defer ts.Close()
+
+	createEntry(createRequest{
+		Dir:   "/",
+		Entry: EntryT{Name: "entry1", Type: App},
+	}, svr, token)
+	svr.appACLService.Grant(0, kBobId, db.RoleViewer)
+	assertErrCode(t, success.Code, doRequest("POST", "/currentUser/dataSource", map[string]interface{}{
+		"name":    "api",
+		"baseUrl": ts.URL + "/v1/",
+		"headers": map[string]string{"Authorization": "token secret"},
+		"params":  map[string]string{"lang": "en", "page": "1"},
+	}, svr, token))
+	saveQuery := func(query map[string]interface{}, token string) *http.Response {
+		query["appId"] = 0
+		return doRequest("PUT", "/currentUser/app/query", query, svr, token)
+	}
+	run := func(name string, params map[string]string, token string) defaultResponse {
+		resp := doRequest("POST", "/currentUser/app/query/run",
+			map[string]interface{}{"appId": 0, "name": name, "params": params}, svr, token)
+		var jsonResponse defaultResponse
+		json.NewDecoder(resp.Body).Decode(&jsonResponse)
+		return jsonResponse
+	}
+
+	// only editors could define queries, and path could not escape the base url
+	assertErrCode(t, errCodeMap[errPermissionDenied], saveQuery(map[string]interface{}{
+		"name": "users", "dataSourceId": 0, "path": "users"}, bobToken))
+	assertErrCode(t, errCodeMap[errInvalidParam], saveQuery(map[string]interface{}{
+		"name": "users", "dataSourceId": 0, "path": "../admin"}, token))
+	assertErrCode(t, errCodeMap[errDataSourceNotFound], saveQuery(map[string]interface{}{
+		"name": "users", "dataSourceId": 100, "path": "users"}, token))
+	assertErrCode(t, success.Code, saveQuery(map[string]interface{}{
+		"name": "users", "dataSourceId": 0, "path": "/users", "params": map[string]string{"page": "2"}}, token))
+	resp := assertErrCode(t, success.Code, doRequest("GET", "/currentUser/app/query?appId=0", nil, svr, token))
+	assert.Len(resp.Data, 1)
+
+	// viewers run queries by name, params are merged
+	result := run("users", map[string]string{"q": "x"}, bobToken)
+	assert.Equal(success.Code, result.Code)
+	assert.Equal(map[string]interface{}{
+		"status": float64(http.StatusOK),
+		"data": map[string]interface{}{
+			"method": "GET",
+			"path":   "/v1/users",
+			"query": map[string]interface{}{
+				"lang": []interface{}{"en"},
+				"page": []interface{}{"2"},
+				"q":    []interface{}{"x"},
+			},
+		},
+	}, result.Data)
+	assert.Equal(errCodeMap[errQueryNotFound], run("not_exist", nil, bobToken).Code)
+
+	// errors of the data source
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/dataSource",
+		map[string]interface{}{"id": 0, "headers": map[string]string{}}, svr, token))
+	failed := run("users", nil, bobToken)
+	assert.Equal(errCodeMap[errQueryFailed], failed.Code)
+	assert.Contains(failed.Msg, "bad credentials")
+
+	// delete
+	assertErrCode(t, success.Code, doRequest("DELETE", "/currentUser/app/query",
+		map[string]interface{}{"appId": 0, "name": "users"}, svr, token))
+	assert.Equal(errCodeMap[errQueryNotFound], run("users", nil, bobToken).Code)
+}
+
+func TestHandleSQLQuery(t *testing.T) {
+	assert := assert.New(t)
+	svr, token := newTestServer()
+	const kBobId = 1001
+	bobToken := addTestUser(svr, kBobId, "bob")
+
+	dir, err := ioutil.TempDir("", "sql_query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dsn := filepath.Join(dir, "test.db")
+	conn, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, stmt := range []string{
+		`CREATE TABLE user (id INTEGER PRIMARY KEY, name VARCHAR(32), team TEXT)`,
+		`INSERT INTO user VALUES (1, 'alice', 'dev'), (2, 'bob', 'dev'), (3, 'carol', 'ops')`,
+	} {
+		if _, err := conn.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	createEntry(createRequest{
+		Dir:   "/",
+		Entry: EntryT{Name: "entry1", Type: App},
+	}, svr, token)
+	svr.appACLService.Grant(0, kBobId, db.RoleViewer)
+
+	// invalid
+	assertErrCode(t, errCodeMap[errInvalidParam], doRequest("POST", "/currentUser/dataSource", map[string]interface{}{
+		"name": "db", "type": db.DataSourceTypeSQL, "driver": "oracle", "dsn": dsn}, svr, token))
+	assertErrCode(t, errCodeMap[errInvalidParam], doRequest("POST", "/currentUser/dataSource", map[string]interface{}{
+		"name": "db", "type": db.DataSourceTypeSQL, "driver": "sqlite3"}, svr, token))
+
+	assertErrCode(t, success.Code, doRequest("POST", "/currentUser/dataSource", map[string]interface{}{
+		"name": "db", "type": db.DataSourceTypeSQL, "driver": "sqlite3", "dsn": dsn,
+		"readOnly": true, "maxRows": 1,
+	}, svr, token))
+	resp := assertErrCode(t, success.Code, doRequest("GET", "/currentUser/dataSource", nil, svr, token))
+	assert.Equal([]interface{}{map[string]interface{}{
+		"id": float64(0), "name": "db", "type": db.DataSourceTypeSQL,
+		"driver": "sqlite3", "readOnly": true, "maxRows": float64(1),
+	}}, resp.Data)
+
+	saveQuery := func(name, sql string, params map[string]string) *http.Response {
+		return doRequest("PUT", "/currentUser/app/query", map[string]interface{}{
+			"appId": 0, "name": name, "dataSourceId": 0, "sql": sql, "params": params,
+		}, svr, token)
+	}
+	run := func(name string, params map[string]string) defaultResponse {
+		resp := doRequest("POST", "/currentUser/app/query/run",
+			map[string]interface{}{"appId": 0, "name": name, "params": params}, svr, bobToken)
+		var jsonResponse defaultResponse
+		json.NewDecoder(resp.Body).Decode(&jsonResponse)
+		return jsonResponse
+	}
+	assertErrCode(t, errCodeMap[errInvalidParam], saveQuery("bad", "SELECT 1; DROP TABLE user", nil))
+	assertErrCode(t, errCodeMap[errInvalidParam], saveQuery("bad", "SELECT ':name", nil))
+	assertErrCode(t, success.Code, saveQuery("byTeam",
+		"SELECT id, name FROM user WHERE team = :team AND name <> ':team' ORDER BY id",
+		map[string]string{"team": "ops"}))
+	assertErrCode(t, success.Code, saveQuery("rename", "UPDATE user SET name = :name WHERE id = :id", nil))
+
+	// params are bound, and rows are limited
+	result := run("byTeam", map[string]string{"team": "dev"})
+	assert.Equal(success.Code, result.Code)
+	assert.Equal(map[string]interface{}{
+		"columns": []interface{}{
+			map[string]interface{}{"name": "id", "type": "INTEGER"},
+			map[string]interface{}{"name": "name", "type": "VARCHAR(32)"},
+		},
+		"rows":         []interface{}{[]interface{}{float64(1), "alice"}},
+		"truncated":    true,
+		"rowsAffected": float64(0),
+	}, result.Data)
+	result = run("byTeam", nil)
+	assert.Equal([]interface{}{[]interface{}{float64(3), "carol"}},
+		result.Data.(map[string]interface{})["rows"])
+
+	// writes are rejected by read-only data sources
+	assert.Equal(errCodeMap[errPermissionDenied], run("rename", map[string]string{"id": "1", "name": "x"}).Code)
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/dataSource",
+		map[string]interface{}{"id": 0, "readOnly": false}, svr, token))
+	assert.Equal(errCodeMap[errInvalidParam], run("rename", map[string]string{"id": "1"}).Code)
+	result = run("rename", map[string]string{"id": "1", "name": "alex"})
+	assert.Equal(success.Code, result.Code)
+	assert.Equal(float64(1), result.Data.(map[string]interface{})["rowsAffected"])
+
+	// errors of the database
+	assertErrCode(t, success.Code, saveQuery("broken", "SELECT * FROM not_exist", nil))
+	assert.Equal(errCodeMap[errQueryFailed], run("broken", nil).Code)
+}
+
+func TestBindSQLParams(t *testing.T) {
+	assert := assert.New(t)
+	params := map[string]string{"a": "1", "b": "2"}
+
+	query, args, err := bindSQLParams("SELECT :a, ':a', \"x:b\", id::text FROM t WHERE b = :b;", "postgres", params)
+	assert.NoError(err)
+	assert.Equal("SELECT $1, ':a', \"x:b\", id::text FROM t WHERE b = $2", query)
+	assert.Equal([]interface{}{"1", "2"}, args)
+
+	query, _, err = bindSQLParams("SELECT :a, :b", "mysql", params)
+	assert.NoError(err)
+	assert.Equal("SELECT ?, ?", query)
+
+	_, _, err = bindSQLParams("SELECT :c", "mysql", params)
+	assert.Error(err)
+
+	assert.True(isReadStatement("  (SELECT 1)"))
+	assert.True(isReadStatement("with t as (select 1) select * from t"))
+	assert.False(isReadStatement("DELETE FROM t"))
+}
+
+func TestSanitizeDSN(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := sanitizeDSN("sqlserver", "sqlserver://sa@localhost")
+	assert.True(errors.Is(err, errInvalidParam))
+	_, err = sanitizeDSN("mysql", "")
+	assert.True(errors.Is(err, errInvalidParam))
+	_, err = sanitizeDSN("mysql", "root@tcp(db:3306")
+	assert.True(errors.Is(err, errInvalidParam))
+
+	dsn, err := sanitizeDSN("mysql", "root:pw@tcp(db:3306)/app?allowAllFiles=true&parseTime=true")
+	assert.NoError(err)
+	assert.Equal("root:pw@tcp(db:3306)/app?parseTime=true", dsn)
+
+	dsn, err = sanitizeDSN("postgres", "postgres://bob:secret@db:5432/app?sslmode=verify-full&sslrootcert=/etc/passwd")
+	assert.NoError(err)
+	assert.Equal("dbname='app' host='db' password='secret' port='5432' sslmode='verify-full' user='bob'", dsn)
+	dsn, err = sanitizeDSN("postgres", `host=db password='it\'s' sslkey=/root/.ssh/id_rsa passfile = /etc/shadow`)
+	assert.NoError(err)
+	assert.Equal(`host='db' password='it\'s'`, dsn)
+	_, err = sanitizeDSN("postgres", "host=db password='secret")
+	assert.True(errors.Is(err, errInvalidParam))
+}
+
+func TestDataSourceNetGuard(t *testing.T) {
+	assert := assert.New(t)
+	svr, token := newTestServer()
+	svr.queryClient = newQueryClient()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	createEntry(createRequest{
+		Dir:   "/",
+		Entry: EntryT{Name: "entry1", Type: App},
+	}, svr, token)
+	assertErrCode(t, success.Code, doRequest("POST", "/currentUser/dataSource",
+		map[string]interface{}{"name": "api", "baseUrl": ts.URL}, svr, token))
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/app/query",
+		map[string]interface{}{"appId": 0, "name": "q", "dataSourceId": 0}, svr, token))
+	resp := assertErrCode(t, errCodeMap[errQueryFailed], doRequest("POST", "/currentUser/app/query/run",
+		map[string]interface{}{"appId": 0, "name": "q"}, svr, token))
+	assert.Contains(resp.Msg, errBlockedAddress.Error())
+
+	for _, address := range []string{"127.0.0.1:80", "10.1.2.3:80", "169.254.169.254:80", "[::1]:80",
+		"[::ffff:192.168.0.1]:80", "[fe80::1]:80"} {
+		assert.True(errors.Is(checkDialAddress("tcp4", address, nil), errBlockedAddress), address)
+	}
+	assert.NoError(checkDialAddress("tcp4", "93.184.216.34:443", nil))
+	assert.NoError(checkDialAddress("tcp6", "[2606:2800:220:1::]:443", nil))
+	assert.Error(checkDialAddress("unix", "/var/run/mysqld/mysqld.sock", nil))
+
+	// SQL data sources
+	for _, c := range []struct{ driver, dsn string }{
+		{"mysql", "root@tcp(127.0.0.1:3306)/app"},
+		{"mysql", "root@unix(/var/run/mysqld/mysqld.sock)/app"},
+		{"postgres", "postgres://bob@169.254.169.254/app?sslmode=disable"},
+		{"postgres", "host=/var/run/postgresql dbname=app sslmode=disable"},
+	} {
+		conn, err := openSQL(c.driver, c.dsn)
+		if err == nil {
+			err = conn.Ping()
+			conn.Close()
+		}
+		// the drivers do not wrap the errors of dialing
+		assert.Contains(fmt.Sprint(err), errBlockedAddress.Error(), c.dsn)
+	}
+}
+
+func TestQueryURL(t *testing.T) {
+	assert := assert.New(t)
+	u, err := queryURL("https://example.com/v1/", "/users/1..2")
+	assert.NoError(err)
+	assert.Equal("https://example.com/v1/users/1..2", u)
+	for _, path := range []string{"../admin", "users/../../admin", "%2e%2e/admin", "%2E%2E%2fadmin",
+		"%252e%252e/admin", `..\admin`, "users?admin=1", "http://internal"} {
+		_, err := queryURL("https://example.com/v1/", path)
+		assert.True(errors.Is(err, errInvalidParam), path)
+	}
+}
+
+func TestRESTQueryLimits(t *testing.T) {
+	assert := assert.New(t)
+	svr, _ := newTestServer()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("x"), kRESTMaxResponseSize+1))
+	}))
+	defer ts.Close()
+
+	_, err := svr.runRESTQuery(context.Background(), restQueryT{Method: http.MethodGet, URL: ts.URL}, nil)
+	assert.True(errors.Is(err, errQueryFailed))
+	assert.Contains(err.Error(), "exceeds")
+
+	// a hanging data source is given up
+	assert.Equal(request.DefaultTimeout, newQueryClient().Timeout)
+}
@@ -6,8 +6,6 @@ import (
 	"net/http"
 	"strings"
 
-	"github.com/go-chi/jwtauth"
-
 	"github.com/rtxu/luban-api/db"
 )
 
@@ -59,6 +57,8 @@ type EntryT struct {
 
 	// when Type="app"
 	AppId uint32 `json:"appId"`
+	// filled when responding the tree, never persisted
+	Meta *AppMetaT `json:"meta,omitempty"`
 
 	// when Type="directory"
 	Children DirectoryT `json:"children"`
@@ -80,10 +80,34 @@ func (s *server) getCurrentUserAndRootDirFromDB(username string) (db.User, Direc
 	return user, rootDir
 }
 
-func (s *server) getCurrentUserAndRootDir(r *http.Request) (db.User, DirectoryT) {
-	_, claims, _ := jwtauth.FromContext(r.Context())
-	var username = claims[kTokenClaimUserName].(string)
-	return s.getCurrentUserAndRootDirFromDB(username)
+// fillAppMeta fills the meta of every app entry in the tree
+func (s *server) fillAppMeta(rootDir DirectoryT) {
+	appEntries := make(map[uint32]*EntryT)
+	var walk func(dir DirectoryT)
+	walk = func(dir DirectoryT) {
+		for _, entry := range dir {
+			switch entry.Type {
+			case App:
+				appEntries[entry.AppId] = entry
+			case Directory:
+				walk(entry.Children)
+			}
+		}
+	}
+	walk(rootDir)
+
+	appIds := make([]uint32, 0, len(appEntries))
+	for appId := range appEntries {
+		appIds = append(appIds, appId)
+	}
+	apps, err := s.appService.GetMulti(appIds)
+	if err != nil {
+		panic(err)
+	}
+	cache, versions := make(usernameCache), make(componentVersions)
+	for _, app := range apps {
+		appEntries[app.ID].Meta = s.newAppMeta(app, cache, versions)
+	}
 }
 
 func findDir(targetDirName string, rootDir *DirectoryT) (*DirectoryT, error) {
@@ -143,6 +167,8 @@ func (s *server) handleEntryCreate() http.HandlerFunc {
 	type request struct {
 		Dir   string `json:"dir"`
 		Entry EntryT `json:"entry"`
+		// when Entry.Type="app", the draft of new app is seeded from the template
+		TemplateId string `json:"templateId"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		var param request
@@ -155,7 +181,15 @@ func (s *server) handleEntryCreate() http.HandlerFunc {
 			return
 		}
 
-		user, rootDir := s.getCurrentUserAndRootDir(r)
+		ws, rootDir, err := s.getWorkspaceAndRootDir(r)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := ws.checkRole(db.RoleEditor); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
 		pTargetDir, err := findDir(param.Dir, &rootDir)
 		if err != nil {
 			s.respond(w, r, err, http.StatusOK)
@@ -172,18 +206,39 @@ func (s *server) handleEntryCreate() http.HandlerFunc {
 			}
 		}
 
+		param.Entry.Meta = nil
 		if param.Entry.Type == Directory {
 			param.Entry.Children = make(DirectoryT, 0)
 		} else {
-			app := db.NewApp(user.ID)
+			app := db.NewApp(ws.user.ID)
+			app.OrgID = ws.orgId()
+			if param.TemplateId != "" {
+				tpl, err := s.findTemplate(ws, param.TemplateId)
+				if err != nil {
+					s.respond(w, r, err, http.StatusOK)
+					return
+				}
+				app.Content = tpl.content
+			}
+			// templates saved from apps with pages, see appSnapshot
+			var pages []pageSnapshotT
+			app.Content, pages = splitPages(app.Content)
 			err := s.appService.NewApp(app)
 			if err != nil {
 				panic(err)
 			}
+			if len(pages) > 0 {
+				s.newAppPages(app.ID, ws.user.ID, pages)
+				// the pages are part of the draft, which is unpublished
+				if err := s.appService.TouchDraft(app.ID, ws.user.ID); err != nil {
+					panic(err)
+				}
+			}
 			param.Entry.AppId = app.ID
 		}
 		(*pTargetDir) = append((*pTargetDir), &param.Entry)
-		s.syncRootDirToDB(user.UserName, rootDir)
+		s.syncWorkspaceRootDirToDB(ws, rootDir)
+		s.publishEntryEvent(ws, kEventEntryCreated, param.Dir, &param.Entry)
 		s.respond(w, r, success, http.StatusOK)
 	}
 }
@@ -204,13 +259,22 @@ func (s *server) handleEntryDelete() http.HandlerFunc {
 			return
 		}
 
-		user, rootDir := s.getCurrentUserAndRootDir(r)
+		ws, rootDir, err := s.getWorkspaceAndRootDir(r)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := ws.checkRole(db.RoleEditor); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
 		pTargetDir, err := findDir(param.Dir, &rootDir)
 		if err != nil {
 			s.respond(w, r, err, http.StatusOK)
 			return
 		}
 
+		var deleted *EntryT
 		newTargetDir := make(DirectoryT, 0, len((*pTargetDir))-1)
 		for i := 0; i < len((*pTargetDir)); i++ {
 			entry := (*pTargetDir)[i]
@@ -221,12 +285,16 @@ func (s *server) handleEntryDelete() http.HandlerFunc {
 						http.StatusOK)
 					return
 				}
+				deleted = entry
 			} else {
 				newTargetDir = append(newTargetDir, entry)
 			}
 		}
 		(*pTargetDir) = newTargetDir
-		s.syncRootDirToDB(user.UserName, rootDir)
+		s.syncWorkspaceRootDirToDB(ws, rootDir)
+		if deleted != nil {
+			s.publishEntryEvent(ws, kEventEntryDeleted, param.Dir, deleted)
+		}
 		s.respond(w, r, success, http.StatusOK)
 	}
 }
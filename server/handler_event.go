@@ -0,0 +1,54 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// proxies tend to close idle connections, keep the stream alive by comments
+const kEventKeepAliveInterval = 30 * time.Second
+
+// handleEventStream 通过 Server-Sent Events 推送当前用户可见的目录树及 app 变更，
+// 事件格式见 EventT
+func (s *server) handleEventStream() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			panic("streaming is unsupported by the response writer")
+		}
+		sub := s.eventBus.subscribe(currentUserId(r))
+		defer s.eventBus.unsubscribe(sub)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		// disable the response buffering of nginx
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(kEventKeepAliveInterval)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			case event, ok := <-sub.events:
+				if !ok {
+					// could not keep up, the client will reconnect and reload
+					return
+				}
+				data, err := json.Marshal(event)
+				if err != nil {
+					panic(err)
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rtxu/luban-api/db"
+	"github.com/stretchr/testify/assert"
+)
+
+type eventTestClient struct {
+	t      *testing.T
+	resp   *http.Response
+	events chan EventT
+}
+
+func subscribeEvents(t *testing.T, ts *httptest.Server, token string) *eventTestClient {
+	resp, err := http.Get(ts.URL + "/currentUser/event?jwt=" + token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &eventTestClient{t: t, resp: resp, events: make(chan EventT, 16)}
+	go func() {
+		defer close(c.events)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.HasPrefix(line, "data: ") {
+				var event EventT
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event)
+				c.events <- event
+			}
+		}
+	}()
+	return c
+}
+
+func (c *eventTestClient) next() EventT {
+	select {
+	case event := <-c.events:
+		return event
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("no event received")
+	}
+	return EventT{}
+}
+
+func (c *eventTestClient) close() {
+	c.resp.Body.Close()
+}
+
+func TestHandleEventStream(t *testing.T) {
+	assert := assert.New(t)
+	svr, token := newTestServer()
+	const kBobId = 1001
+	bobToken := addTestUser(svr, kBobId, "bob")
+	ts := httptest.NewServer(svr)
+	defer ts.Close()
+
+	owner := subscribeEvents(t, ts, token)
+	defer owner.close()
+	bob := subscribeEvents(t, ts, bobToken)
+	defer bob.close()
+	assert.Equal("text/event-stream", owner.resp.Header.Get("Content-Type"))
+
+	// entry events are visible in the workspace only
+	createEntry(createRequest{
+		Dir:   "/",
+		Entry: EntryT{Name: "entry1", Type: App},
+	}, svr, token)
+	assert.Equal(EventT{
+		Type:      kEventEntryCreated,
+		Dir:       "/",
+		EntryName: "entry1",
+		AppId:     0,
+		Operator:  kTestUserName,
+	}, owner.next())
+
+	// app events are visible to collaborators as well
+	svr.appACLService.Grant(0, kBobId, db.RoleEditor)
+	assertErrCode(t, success.Code, doRequest("PUT",
+		"/currentUser/app?appId=0&op="+kOpSave, map[string]interface{}{}, svr, bobToken))
+	for _, c := range []*eventTestClient{owner, bob} {
+		event := c.next()
+		assert.Equal(kEventAppSaved, event.Type)
+		assert.Equal("bob", event.Operator)
+	}
+	assertErrCode(t, success.Code, doRequest("PUT",
+		"/currentUser/app?appId=0&op="+kOpPublish+"&channel="+kChannelStaging, map[string]interface{}{}, svr, token))
+	for _, c := range []*eventTestClient{owner, bob} {
+		event := c.next()
+		assert.Equal(kEventAppPublished, event.Type)
+		assert.Equal(kChannelStaging, event.Channel)
+	}
+
+	doRequest("DELETE", "/currentUser/entry",
+		map[string]interface{}{"dir": "/", "entryName": "entry1"}, svr, token)
+	assert.Equal(kEventEntryDeleted, owner.next().Type)
+	select {
+	case event := <-bob.events:
+		t.Errorf("unexpected event: %+v", event)
+	default:
+	}
+
+	// unauthorized
+	resp, err := http.Get(ts.URL + "/currentUser/event")
+	assert.NoError(err)
+	assert.Equal(http.StatusUnauthorized, resp.StatusCode)
+	resp.Body.Close()
+}
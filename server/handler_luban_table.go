@@ -0,0 +1,645 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rtxu/luban-api/db"
+)
+
+const (
+	kLubanTableMaxColumns = 64
+	// in bytes of the JSON object
+	kLubanRowMaxSize       = 64 * 1024
+	kLubanRowDefaultLimit  = 50
+	kLubanRowMaxLimit      = 500
+	kLubanRowMaxConditions = 8
+)
+
+// ColumnT 是 luban table 的一列，name 同时是 row 中的字段名
+type ColumnT struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Required bool   `json:"required"`
+}
+
+// TableT 代表 workspace 中的一个 luban table，供 app 保存表单提交等少量数据，
+// workspace 的 viewer 可以查询、添加 row，editor 可以修改 schema 及修改、删除 row
+type TableT struct {
+	Id      uint32    `json:"id"`
+	Name    string    `json:"name"`
+	Columns []ColumnT `json:"columns"`
+}
+
+// RowT 是 luban table 中的一行，data 只包含 schema 中现有的列
+type RowT struct {
+	Id        uint32                 `json:"id"`
+	Data      map[string]interface{} `json:"data"`
+	CreatedAt time.Time              `json:"createdAt"`
+	UpdatedAt time.Time              `json:"updatedAt"`
+}
+
+var columnNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+var columnTypes = []string{
+	db.ColumnTypeText, db.ColumnTypeNumber, db.ColumnTypeBool, db.ColumnTypeDate, db.ColumnTypeJSON,
+}
+
+func decodeColumns(raw json.RawMessage) []ColumnT {
+	var columns []ColumnT
+	if err := json.Unmarshal(raw, &columns); err != nil {
+		panic(err)
+	}
+	return columns
+}
+
+func encodeColumns(columns []ColumnT) json.RawMessage {
+	bytes, _ := json.Marshal(columns)
+	return bytes
+}
+
+func newTable(t db.LubanTable) TableT {
+	return TableT{
+		Id:      t.ID,
+		Name:    t.Name,
+		Columns: decodeColumns(t.Columns),
+	}
+}
+
+func checkColumns(columns []ColumnT) error {
+	if len(columns) == 0 || len(columns) > kLubanTableMaxColumns {
+		return fmt.Errorf("%w: a table should have 1 to %d columns", errInvalidParam, kLubanTableMaxColumns)
+	}
+	names := make(map[string]bool)
+	for _, c := range columns {
+		if !columnNameRegexp.MatchString(c.Name) {
+			return fmt.Errorf("%w: column name(%s) should be an identifier", errInvalidParam, c.Name)
+		}
+		// id is reserved for the row id
+		if names[c.Name] || c.Name == "id" {
+			return fmt.Errorf("%w: duplicate column(%s)", errInvalidParam, c.Name)
+		}
+		names[c.Name] = true
+		known := false
+		for _, typ := range columnTypes {
+			known = known || typ == c.Type
+		}
+		if !known {
+			return fmt.Errorf("%w: unknown type(%s) of column(%s)", errInvalidParam, c.Type, c.Name)
+		}
+	}
+	return nil
+}
+
+// checkColumnsChange checks that the type of an existing column is not changed,
+// as the saved values would not match. Columns could be added or removed.
+func checkColumnsChange(old, new []ColumnT) error {
+	for _, o := range old {
+		for _, n := range new {
+			if o.Name == n.Name && o.Type != n.Type {
+				return fmt.Errorf("%w: type of column(%s) could not be changed", errInvalidParam, o.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// removedColumns returns the names of the columns in old but not in new
+func removedColumns(old, new []ColumnT) []string {
+	var removed []string
+	for _, o := range old {
+		if _, err := findColumn(new, o.Name); err != nil {
+			removed = append(removed, o.Name)
+		}
+	}
+	return removed
+}
+
+func findColumn(columns []ColumnT, name string) (ColumnT, error) {
+	for _, c := range columns {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return ColumnT{}, fmt.Errorf("%w: unknown column(%s)", errInvalidParam, name)
+}
+
+// columnValue checks raw against the column type and normalizes it, nil for JSON null
+func columnValue(c ColumnT, raw json.RawMessage) (interface{}, error) {
+	if raw == nil || string(raw) == "null" {
+		if c.Required {
+			return nil, fmt.Errorf("%w: column(%s) is required", errInvalidParam, c.Name)
+		}
+		return nil, nil
+	}
+	mismatch := fmt.Errorf("%w: column(%s) should be %s", errInvalidParam, c.Name, c.Type)
+	switch c.Type {
+	case db.ColumnTypeText:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, mismatch
+		}
+		return s, nil
+	case db.ColumnTypeNumber:
+		var f float64
+		if err := json.Unmarshal(raw, &f); err != nil {
+			return nil, mismatch
+		}
+		return f, nil
+	case db.ColumnTypeBool:
+		var b bool
+		if err := json.Unmarshal(raw, &b); err != nil {
+			return nil, mismatch
+		}
+		return b, nil
+	case db.ColumnTypeDate:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, mismatch
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			if t, err = time.Parse("2006-01-02", s); err != nil {
+				return nil, fmt.Errorf("%w: column(%s) should be an RFC3339 time or a date like 2006-01-02",
+					errInvalidParam, c.Name)
+			}
+		}
+		return t.UTC().Format(time.RFC3339), nil
+	default:
+		var v interface{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, mismatch
+		}
+		return v, nil
+	}
+}
+
+// rowData checks data against the columns and returns the normalized JSON object,
+// data is merged into old when updating, and required columns are checked on the result
+func rowData(columns []ColumnT, old map[string]interface{}, data map[string]json.RawMessage) (json.RawMessage, error) {
+	merged := make(map[string]interface{})
+	for k, v := range old {
+		merged[k] = v
+	}
+	for name, raw := range data {
+		c, err := findColumn(columns, name)
+		if err != nil {
+			return nil, err
+		}
+		v, err := columnValue(c, raw)
+		if err != nil {
+			return nil, err
+		}
+		merged[name] = v
+	}
+	for _, c := range columns {
+		if c.Required && merged[c.Name] == nil {
+			return nil, fmt.Errorf("%w: column(%s) is required", errInvalidParam, c.Name)
+		}
+	}
+	bytes, err := json.Marshal(merged)
+	if err != nil {
+		panic(err)
+	}
+	if len(bytes) > kLubanRowMaxSize {
+		return nil, fmt.Errorf("%w: row should be at most %d bytes", errInvalidParam, kLubanRowMaxSize)
+	}
+	return bytes, nil
+}
+
+func decodeRowData(raw json.RawMessage) map[string]interface{} {
+	data := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	// keeps numbers as they are
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func newRow(columns []ColumnT, row db.LubanRow) RowT {
+	saved := decodeRowData(row.Data)
+	data := make(map[string]interface{}, len(columns))
+	for _, c := range columns {
+		// values of the removed columns are hidden
+		data[c.Name] = saved[c.Name]
+	}
+	return RowT{
+		Id:        row.ID,
+		Data:      data,
+		CreatedAt: row.CreatedAt,
+		UpdatedAt: row.UpdatedAt,
+	}
+}
+
+func (s *server) checkTableName(ws workspace, name string, self *db.LubanTable) error {
+	if name == "" {
+		return fmt.Errorf("%w: empty table name", errInvalidParam)
+	}
+	t, err := s.lubanTableService.FindByName(ws.user.ID, ws.orgId(), name)
+	if err == nil && (self == nil || t.ID != self.ID) {
+		return fmt.Errorf("%w: table(%s) already exists", errInvalidParam, name)
+	}
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		panic(err)
+	}
+	return nil
+}
+
+// findTable finds the table in the active workspace, and checks the role of current user in it
+func (s *server) findTable(r *http.Request, id uint32, needRole db.Role) (workspace, db.LubanTable, error) {
+	var t db.LubanTable
+	ws, err := s.getWorkspace(r)
+	if err != nil {
+		return ws, t, err
+	}
+	if err := ws.checkRole(needRole); err != nil {
+		return ws, t, err
+	}
+	t, err = s.lubanTableService.Find(id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return ws, t, fmt.Errorf("%w: tableId is %d", errTableNotFound, id)
+		}
+		panic(err)
+	}
+	if !ws.owns(t.OwnerID, t.OrgID) {
+		return ws, t, fmt.Errorf("%w: tableId is %d", errTableNotFound, id)
+	}
+	return ws, t, nil
+}
+
+func (s *server) findRow(t db.LubanTable, id uint32) (db.LubanRow, error) {
+	row, err := s.lubanRowService.Find(t.ID, id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return row, fmt.Errorf("%w: row(%d) of table(%d)", errRowNotFound, id, t.ID)
+		}
+		panic(err)
+	}
+	return row, nil
+}
+
+func (s *server) handleTableList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ws, err := s.getWorkspace(r)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		ts, err := s.lubanTableService.ListByWorkspace(ws.user.ID, ws.orgId())
+		if err != nil {
+			panic(err)
+		}
+		data := make([]TableT, 0, len(ts))
+		for _, t := range ts {
+			data = append(data, newTable(t))
+		}
+		s.respond(w, r, defaultResponse{Data: data}, http.StatusOK)
+	}
+}
+
+func (s *server) handleTableCreate() http.HandlerFunc {
+	type request struct {
+		Name    string    `json:"name"`
+		Columns []ColumnT `json:"columns"`
+	}
+	type dataT struct {
+		Id uint32 `json:"id"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		param.Name = strings.TrimSpace(param.Name)
+		if err := checkColumns(param.Columns); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		ws, err := s.getWorkspace(r)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := ws.checkRole(db.RoleEditor); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := s.checkTableName(ws, param.Name, nil); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		t := db.LubanTable{
+			OwnerID: ws.user.ID,
+			OrgID:   ws.orgId(),
+			Name:    param.Name,
+			Columns: encodeColumns(param.Columns),
+		}
+		if err := s.lubanTableService.NewTable(&t); err != nil {
+			panic(err)
+		}
+		s.respond(w, r, defaultResponse{Data: dataT{Id: t.ID}}, http.StatusOK)
+	}
+}
+
+func (s *server) handleTableUpdate() http.HandlerFunc {
+	type request struct {
+		Id   uint32  `json:"id"`
+		Name *string `json:"name"`
+		// replaces all the columns
+		Columns []ColumnT `json:"columns"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		ws, t, err := s.findTable(r, param.Id, db.RoleEditor)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		toUpdate := make(map[string]interface{})
+		var removed []string
+		if param.Name != nil {
+			name := strings.TrimSpace(*param.Name)
+			if err := s.checkTableName(ws, name, &t); err != nil {
+				s.respond(w, r, err, http.StatusOK)
+				return
+			}
+			toUpdate["name"] = name
+		}
+		if param.Columns != nil {
+			if err := checkColumns(param.Columns); err != nil {
+				s.respond(w, r, err, http.StatusOK)
+				return
+			}
+			if err := checkColumnsChange(decodeColumns(t.Columns), param.Columns); err != nil {
+				s.respond(w, r, err, http.StatusOK)
+				return
+			}
+			toUpdate["columns"] = encodeColumns(param.Columns)
+			removed = removedColumns(decodeColumns(t.Columns), param.Columns)
+		}
+		if len(toUpdate) > 0 {
+			if err := s.lubanTableService.Update(t.ID, toUpdate); err != nil {
+				panic(err)
+			}
+		}
+		// values of the removed columns are dropped, so that a column added later
+		// by the same name, maybe of another type, starts empty
+		if len(removed) > 0 {
+			if err := s.lubanRowService.RemoveColumns(t.ID, removed); err != nil {
+				panic(err)
+			}
+		}
+		s.respond(w, r, success, http.StatusOK)
+	}
+}
+
+func (s *server) handleTableDelete() http.HandlerFunc {
+	type request struct {
+		Id uint32 `json:"id"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		_, t, err := s.findTable(r, param.Id, db.RoleEditor)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := s.lubanRowService.DeleteByTable(t.ID); err != nil {
+			panic(err)
+		}
+		if err := s.lubanTableService.Delete(t.ID); err != nil {
+			panic(err)
+		}
+		s.respond(w, r, success, http.StatusOK)
+	}
+}
+
+// FilterT compares a column with value, e.g. {column: "age", op: "gte", value: 18}
+type FilterT struct {
+	Column string          `json:"column"`
+	Op     string          `json:"op"`
+	Value  json.RawMessage `json:"value"`
+}
+
+type SortT struct {
+	Column string `json:"column"`
+	Desc   bool   `json:"desc"`
+}
+
+func rowFilter(columns []ColumnT, f FilterT) (db.RowFilter, error) {
+	c, err := findColumn(columns, f.Column)
+	if err != nil {
+		return db.RowFilter{}, err
+	}
+	if c.Type == db.ColumnTypeJSON {
+		return db.RowFilter{}, fmt.Errorf("%w: json column(%s) could not be filtered", errInvalidParam, c.Name)
+	}
+	switch f.Op {
+	case db.RowOpEq, db.RowOpNe, db.RowOpLt, db.RowOpLte, db.RowOpGt, db.RowOpGte:
+	case db.RowOpContains:
+		if c.Type != db.ColumnTypeText {
+			return db.RowFilter{}, fmt.Errorf("%w: contains is only for text columns", errInvalidParam)
+		}
+	default:
+		return db.RowFilter{}, fmt.Errorf("%w: unsupported op(%s)", errInvalidParam, f.Op)
+	}
+	// the value is checked as a required value of the column
+	c.Required = true
+	value, err := columnValue(c, f.Value)
+	if err != nil {
+		return db.RowFilter{}, err
+	}
+	return db.RowFilter{Column: c.Name, Type: c.Type, Op: f.Op, Value: value}, nil
+}
+
+// rowQuery checks filters and sorts against the columns, the page is left to the caller
+func rowQuery(columns []ColumnT, filters []FilterT, sorts []SortT) (db.RowQuery, error) {
+	var q db.RowQuery
+	if len(filters) > kLubanRowMaxConditions || len(sorts) > kLubanRowMaxConditions {
+		return q, fmt.Errorf("%w: at most %d filters and sorts", errInvalidParam, kLubanRowMaxConditions)
+	}
+	for _, f := range filters {
+		filter, err := rowFilter(columns, f)
+		if err != nil {
+			return q, err
+		}
+		q.Filters = append(q.Filters, filter)
+	}
+	for _, sort := range sorts {
+		c, err := findColumn(columns, sort.Column)
+		if err == nil && c.Type == db.ColumnTypeJSON {
+			err = fmt.Errorf("%w: json column(%s) could not be sorted", errInvalidParam, c.Name)
+		}
+		if err != nil {
+			return q, err
+		}
+		q.Sort = append(q.Sort, db.RowSort{Column: c.Name, Type: c.Type, Desc: sort.Desc})
+	}
+	return q, nil
+}
+
+// handleRowQuery 按 filters（与关系）、sort 分页查询 rows，返回 {rows, total}
+func (s *server) handleRowQuery() http.HandlerFunc {
+	type request struct {
+		TableId uint32    `json:"tableId"`
+		Filters []FilterT `json:"filters"`
+		Sort    []SortT   `json:"sort"`
+		Offset  uint      `json:"offset"`
+		// kLubanRowDefaultLimit by default
+		Limit uint `json:"limit"`
+	}
+	type dataT struct {
+		Rows  []RowT `json:"rows"`
+		Total uint64 `json:"total"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		_, t, err := s.findTable(r, param.TableId, db.RoleViewer)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		columns := decodeColumns(t.Columns)
+		q, err := rowQuery(columns, param.Filters, param.Sort)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		q.Offset, q.Limit = param.Offset, param.Limit
+		if q.Limit == 0 {
+			q.Limit = kLubanRowDefaultLimit
+		}
+		if q.Limit > kLubanRowMaxLimit {
+			q.Limit = kLubanRowMaxLimit
+		}
+
+		rows, total, err := s.lubanRowService.Query(t.ID, q)
+		if err != nil {
+			panic(err)
+		}
+		data := dataT{Rows: make([]RowT, 0, len(rows)), Total: total}
+		for _, row := range rows {
+			data.Rows = append(data.Rows, newRow(columns, row))
+		}
+		s.respond(w, r, defaultResponse{Data: data}, http.StatusOK)
+	}
+}
+
+// handleRowInsert 供 app 保存表单提交等，workspace 的 viewer 即可添加
+func (s *server) handleRowInsert() http.HandlerFunc {
+	type request struct {
+		TableId uint32                     `json:"tableId"`
+		Data    map[string]json.RawMessage `json:"data"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		_, t, err := s.findTable(r, param.TableId, db.RoleViewer)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		columns := decodeColumns(t.Columns)
+		data, err := rowData(columns, nil, param.Data)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		row := db.LubanRow{TableID: t.ID, Data: data}
+		if err := s.lubanRowService.Insert(&row); err != nil {
+			panic(err)
+		}
+		s.respond(w, r, defaultResponse{Data: newRow(columns, row)}, http.StatusOK)
+	}
+}
+
+func (s *server) handleRowUpdate() http.HandlerFunc {
+	type request struct {
+		TableId uint32 `json:"tableId"`
+		Id      uint32 `json:"id"`
+		// absent columns are kept, null clears a column
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		_, t, err := s.findTable(r, param.TableId, db.RoleEditor)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		row, err := s.findRow(t, param.Id)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		columns := decodeColumns(t.Columns)
+		data, err := rowData(columns, decodeRowData(row.Data), param.Data)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := s.lubanRowService.Update(t.ID, row.ID, data); err != nil {
+			panic(err)
+		}
+		s.respond(w, r, success, http.StatusOK)
+	}
+}
+
+func (s *server) handleRowDelete() http.HandlerFunc {
+	type request struct {
+		TableId uint32 `json:"tableId"`
+		Id      uint32 `json:"id"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		_, t, err := s.findTable(r, param.TableId, db.RoleEditor)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if _, err := s.findRow(t, param.Id); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := s.lubanRowService.Delete(t.ID, param.Id); err != nil {
+			panic(err)
+		}
+		s.respond(w, r, success, http.StatusOK)
+	}
+}
@@ -0,0 +1,361 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rtxu/luban-api/db"
+)
+
+const (
+	kCSVImportMaxSize = 10 << 20
+	kCSVImportMaxRows = 10000
+	// the rest of the errors are counted but not reported
+	kCSVImportMaxErrors = 100
+)
+
+// layouts of date cells besides RFC3339, in the order of trying
+var csvDateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	"2006/01/02",
+	"2006/1/2",
+	"2006/01/02 15:04:05",
+}
+
+// csvValue coerces a cell to the JSON value of the column, empty cells are null
+func csvValue(c ColumnT, cell string) (json.RawMessage, error) {
+	trimmed := strings.TrimSpace(cell)
+	if trimmed == "" {
+		return nil, nil
+	}
+	mismatch := fmt.Errorf("%w: column(%s) should be %s, got %q", errInvalidParam, c.Name, c.Type, cell)
+	var v interface{}
+	switch c.Type {
+	case db.ColumnTypeText:
+		v = cell
+	case db.ColumnTypeNumber:
+		f, err := strconv.ParseFloat(trimmed, 64)
+		if err != nil {
+			return nil, mismatch
+		}
+		v = f
+	case db.ColumnTypeBool:
+		switch strings.ToLower(trimmed) {
+		case "true", "yes", "y", "1":
+			v = true
+		case "false", "no", "n", "0":
+			v = false
+		default:
+			return nil, mismatch
+		}
+	case db.ColumnTypeDate:
+		t, err := time.Parse(time.RFC3339, trimmed)
+		for _, layout := range csvDateLayouts {
+			if err == nil {
+				break
+			}
+			t, err = time.Parse(layout, trimmed)
+		}
+		if err != nil {
+			return nil, mismatch
+		}
+		v = t.UTC().Format(time.RFC3339)
+	default:
+		if !json.Valid([]byte(trimmed)) {
+			return nil, mismatch
+		}
+		return json.RawMessage(trimmed), nil
+	}
+	bytes, _ := json.Marshal(v)
+	return bytes, nil
+}
+
+// csvCell formats a value of the column. Text starting with formula characters is prefixed
+// with a quote, otherwise spreadsheets would run it, i.e. CSV injection.
+func csvCell(c ColumnT, v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	switch c.Type {
+	case db.ColumnTypeText, db.ColumnTypeDate:
+		s := fmt.Sprint(v)
+		if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+			s = "'" + s
+		}
+		return s
+	case db.ColumnTypeJSON:
+		bytes, _ := json.Marshal(v)
+		return string(bytes)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// csvColumns maps the headers to the columns, by mapping {header: column} when given,
+// otherwise by the column names case-insensitively. Unmapped headers are ignored.
+func csvColumns(columns []ColumnT, headers []string, mapping map[string]string) ([]*ColumnT, []string, error) {
+	mapped := make([]*ColumnT, len(headers))
+	var ignored []string
+	for header, name := range mapping {
+		found := false
+		for _, h := range headers {
+			found = found || h == header
+		}
+		if !found {
+			return nil, nil, fmt.Errorf("%w: header(%s) not found in csv", errInvalidParam, header)
+		}
+		if _, err := findColumn(columns, name); err != nil {
+			return nil, nil, err
+		}
+	}
+	used := make(map[string]bool)
+	for i, header := range headers {
+		var c ColumnT
+		var err error
+		if mapping != nil {
+			name, ok := mapping[header]
+			if !ok {
+				ignored = append(ignored, header)
+				continue
+			}
+			c, err = findColumn(columns, name)
+		} else {
+			err = fmt.Errorf("%w: unknown column(%s)", errInvalidParam, header)
+			for _, column := range columns {
+				if strings.EqualFold(column.Name, strings.TrimSpace(header)) {
+					c, err = column, nil
+				}
+			}
+			if err != nil {
+				ignored = append(ignored, header)
+				continue
+			}
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if used[c.Name] {
+			return nil, nil, fmt.Errorf("%w: column(%s) is mapped by more than one header", errInvalidParam, c.Name)
+		}
+		used[c.Name] = true
+		mapped[i] = &c
+	}
+	return mapped, ignored, nil
+}
+
+// CSVRowErrorT reports a row which is not imported
+type CSVRowErrorT struct {
+	// row number in spreadsheets, i.e. the header is row 1
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// handleRowImport 导入 CSV 到 luban table，请求为 multipart/form-data：
+//   - tableId
+//   - file: CSV 文件，首行为 header
+//   - mapping: 可选，JSON 对象 {header: column}，缺省时按列名匹配 header
+//   - dryRun: 可选，为 true 时只校验不导入
+//
+// 合法的行会被导入，不合法的行跳过并在结果中逐行报告原因
+func (s *server) handleRowImport() http.HandlerFunc {
+	type dataT struct {
+		Total          int            `json:"total"`
+		Imported       int            `json:"imported"`
+		Failed         int            `json:"failed"`
+		Errors         []CSVRowErrorT `json:"errors"`
+		IgnoredHeaders []string       `json:"ignoredHeaders"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, kCSVImportMaxSize)
+		if err := r.ParseMultipartForm(kCSVImportMaxSize); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errBadRequest, err), http.StatusOK)
+			return
+		}
+		tableId, err := strconv.ParseUint(r.FormValue("tableId"), 10, 32)
+		if err != nil {
+			s.respond(w, r, fmt.Errorf("%w: tableId(%s) is not a number", errInvalidParam,
+				r.FormValue("tableId")), http.StatusOK)
+			return
+		}
+		var mapping map[string]string
+		if raw := r.FormValue("mapping"); raw != "" {
+			if err := json.Unmarshal([]byte(raw), &mapping); err != nil {
+				s.respond(w, r, fmt.Errorf("%w: mapping, err: %v", errJsonDecode, err), http.StatusOK)
+				return
+			}
+		}
+		dryRun := r.FormValue("dryRun") == "true"
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			s.respond(w, r, fmt.Errorf("%w: file, err: %v", errBadRequest, err), http.StatusOK)
+			return
+		}
+		defer file.Close()
+
+		_, t, err := s.findTable(r, uint32(tableId), db.RoleEditor)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		columns := decodeColumns(t.Columns)
+
+		reader := csv.NewReader(file)
+		// rows with a wrong number of cells are reported one by one
+		reader.FieldsPerRecord = -1
+		headers, err := reader.Read()
+		if err != nil {
+			s.respond(w, r, fmt.Errorf("%w: read csv header, err: %v", errInvalidParam, err), http.StatusOK)
+			return
+		}
+		if len(headers) > 0 {
+			// written by Excel
+			headers[0] = strings.TrimPrefix(headers[0], "\ufeff")
+		}
+		mapped, ignored, err := csvColumns(columns, headers, mapping)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		// all the rows are checked before importing, so a broken file imports nothing
+		data := dataT{Errors: make([]CSVRowErrorT, 0), IgnoredHeaders: ignored}
+		var rows []json.RawMessage
+		report := func(row int, err error) {
+			data.Failed++
+			if len(data.Errors) < kCSVImportMaxErrors {
+				msg := strings.TrimPrefix(err.Error(), errInvalidParam.Error()+": ")
+				data.Errors = append(data.Errors, CSVRowErrorT{Row: row, Error: msg})
+			}
+		}
+		for row := 2; ; row++ {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				s.respond(w, r, fmt.Errorf("%w: read csv, err: %v", errInvalidParam, err), http.StatusOK)
+				return
+			}
+			data.Total++
+			if data.Total > kCSVImportMaxRows {
+				s.respond(w, r, fmt.Errorf("%w: at most %d rows could be imported at once",
+					errInvalidParam, kCSVImportMaxRows), http.StatusOK)
+				return
+			}
+			if len(record) != len(headers) {
+				report(row, fmt.Errorf("%d cells, but %d headers", len(record), len(headers)))
+				continue
+			}
+			values := make(map[string]json.RawMessage)
+			for i, cell := range record {
+				if mapped[i] == nil {
+					continue
+				}
+				values[mapped[i].Name], err = csvValue(*mapped[i], cell)
+				if err != nil {
+					break
+				}
+			}
+			var bytes json.RawMessage
+			if err == nil {
+				bytes, err = rowData(columns, nil, values)
+			}
+			if err != nil {
+				report(row, err)
+				continue
+			}
+			rows = append(rows, bytes)
+		}
+
+		if !dryRun {
+			for _, bytes := range rows {
+				if err := s.lubanRowService.Insert(&db.LubanRow{TableID: t.ID, Data: bytes}); err != nil {
+					panic(err)
+				}
+			}
+			data.Imported = len(rows)
+		}
+		s.respond(w, r, defaultResponse{Data: data}, http.StatusOK)
+	}
+}
+
+// handleRowExport 以 CSV 流式导出查询结果，query string 中 filters、sort 为 JSON，
+// 含义同 handleRowQuery。错误仍以 JSON 返回，只有开始导出后才是 CSV
+func (s *server) handleRowExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		tableId, err := strconv.ParseUint(query.Get("tableId"), 10, 32)
+		if err != nil {
+			s.respond(w, r, fmt.Errorf("%w: tableId(%s) is not a number", errInvalidParam,
+				query.Get("tableId")), http.StatusOK)
+			return
+		}
+		var filters []FilterT
+		var sorts []SortT
+		for name, v := range map[string]interface{}{"filters": &filters, "sort": &sorts} {
+			if raw := query.Get(name); raw != "" {
+				if err := json.Unmarshal([]byte(raw), v); err != nil {
+					s.respond(w, r, fmt.Errorf("%w: %s, err: %v", errJsonDecode, name, err), http.StatusOK)
+					return
+				}
+			}
+		}
+		_, t, err := s.findTable(r, uint32(tableId), db.RoleViewer)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		columns := decodeColumns(t.Columns)
+		q, err := rowQuery(columns, filters, sorts)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition",
+			mime.FormatMediaType("attachment", map[string]string{"filename": t.Name + ".csv"}))
+		writer := csv.NewWriter(w)
+		headers := make([]string, 0, len(columns))
+		for _, c := range columns {
+			headers = append(headers, c.Name)
+		}
+		writer.Write(headers)
+
+		q.Limit = kLubanRowMaxLimit
+		for {
+			rows, _, err := s.lubanRowService.Query(t.ID, q)
+			if err != nil {
+				panic(err)
+			}
+			for _, row := range rows {
+				data := decodeRowData(row.Data)
+				record := make([]string, 0, len(columns))
+				for _, c := range columns {
+					record = append(record, csvCell(c, data[c.Name]))
+				}
+				writer.Write(record)
+			}
+			writer.Flush()
+			if err := writer.Error(); err != nil {
+				// the client has gone, nothing could be responded
+				return
+			}
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+			if uint(len(rows)) < q.Limit {
+				return
+			}
+			q.Offset += q.Limit
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/rtxu/luban-api/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSVValue(t *testing.T) {
+	assert := assert.New(t)
+	for _, tc := range []struct {
+		typ, cell, expected string
+	}{
+		{db.ColumnTypeText, " a b ", `" a b "`},
+		{db.ColumnTypeText, " ", ``},
+		{db.ColumnTypeNumber, " 1.5 ", `1.5`},
+		{db.ColumnTypeBool, "Yes", `true`},
+		{db.ColumnTypeBool, "0", `false`},
+		{db.ColumnTypeDate, "2020-01-02", `"2020-01-02T00:00:00Z"`},
+		{db.ColumnTypeDate, "2020/1/2", `"2020-01-02T00:00:00Z"`},
+		{db.ColumnTypeDate, "2020-01-02T08:00:00+08:00", `"2020-01-02T00:00:00Z"`},
+		{db.ColumnTypeJSON, `{"a": 1}`, `{"a": 1}`},
+	} {
+		v, err := csvValue(ColumnT{Name: "c", Type: tc.typ}, tc.cell)
+		assert.NoError(err, tc.cell)
+		assert.Equal(tc.expected, string(v), tc.cell)
+	}
+	for _, tc := range []struct {
+		typ, cell string
+	}{
+		{db.ColumnTypeNumber, "one"},
+		{db.ColumnTypeBool, "maybe"},
+		{db.ColumnTypeDate, "yesterday"},
+		{db.ColumnTypeJSON, "{"},
+	} {
+		_, err := csvValue(ColumnT{Name: "c", Type: tc.typ}, tc.cell)
+		assert.Error(err, tc.cell)
+	}
+
+	assert.Equal("'=SUM(A1:A2)", csvCell(ColumnT{Type: db.ColumnTypeText}, "=SUM(A1:A2)"))
+	assert.Equal("'-1", csvCell(ColumnT{Type: db.ColumnTypeText}, "-1"))
+	assert.Equal("-1", csvCell(ColumnT{Type: db.ColumnTypeNumber}, -1))
+	assert.Equal("", csvCell(ColumnT{Type: db.ColumnTypeText}, nil))
+}
+
+func TestHandleTableCSV(t *testing.T) {
+	assert := assert.New(t)
+	svr, token := newTestServer()
+	otherToken := addTestUser(svr, 1001, "bob")
+	resp := assertErrCode(t, success.Code, doRequest("POST", "/currentUser/table", map[string]interface{}{
+		"name": "users",
+		"columns": []map[string]interface{}{
+			{"name": "name", "type": db.ColumnTypeText, "required": true},
+			{"name": "age", "type": db.ColumnTypeNumber},
+			{"name": "vip", "type": db.ColumnTypeBool},
+			{"name": "joinedAt", "type": db.ColumnTypeDate},
+		},
+	}, svr, token))
+	tableId := fmt.Sprint(resp.Data.(map[string]interface{})["id"])
+
+	importCSV := func(fields map[string]string, csv, token string) *http.Response {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		for k, v := range fields {
+			writer.WriteField(k, v)
+		}
+		part, _ := writer.CreateFormFile("file", "users.csv")
+		part.Write([]byte(csv))
+		writer.Close()
+		httpReq := httptest.NewRequest("POST", "/currentUser/table/row/import", &body)
+		httpReq.Header.Add("Content-Type", writer.FormDataContentType())
+		httpReq.Header.Add("Authorization", fmt.Sprintf("BEARER %s", token))
+		return handleRequest(httpReq, svr)
+	}
+	csv := "\ufeffName,AGE,vip,joinedAt,note\n" +
+		"alice,30,yes,2020-01-02,x\n" +
+		",1,,,\n" +
+		"bob,old,,,\n" +
+		"carol,,n,2020/3/1,\n" +
+		"dave,41\n" +
+		"\"=cmd|' /C calc'!A0\",,,,\n"
+
+	assertErrCode(t, errCodeMap[errTableNotFound], importCSV(map[string]string{"tableId": tableId}, csv, otherToken))
+	assertErrCode(t, errCodeMap[errInvalidParam], importCSV(map[string]string{"tableId": tableId,
+		"mapping": `{"missing": "name"}`}, csv, token))
+	assertErrCode(t, errCodeMap[errInvalidParam], importCSV(map[string]string{"tableId": tableId,
+		"mapping": `{"note": "unknown"}`}, csv, token))
+	assertErrCode(t, errCodeMap[errInvalidParam], importCSV(map[string]string{"tableId": tableId},
+		"name\n\"broken\n", token))
+
+	expected := map[string]interface{}{
+		"total":    float64(6),
+		"imported": float64(0),
+		"failed":   float64(3),
+		"errors": []interface{}{
+			map[string]interface{}{"row": float64(3), "error": "column(name) is required"},
+			map[string]interface{}{"row": float64(4), "error": `column(age) should be number, got "old"`},
+			map[string]interface{}{"row": float64(6), "error": "2 cells, but 5 headers"},
+		},
+		"ignoredHeaders": []interface{}{"note"},
+	}
+	resp = assertErrCode(t, success.Code, importCSV(map[string]string{"tableId": tableId, "dryRun": "true"}, csv, token))
+	assert.Equal(expected, resp.Data)
+	_, total, _ := svr.lubanRowService.Query(0, db.RowQuery{Limit: 10})
+	assert.Equal(uint64(0), total)
+
+	resp = assertErrCode(t, success.Code, importCSV(map[string]string{"tableId": tableId}, csv, token))
+	expected["imported"] = float64(3)
+	assert.Equal(expected, resp.Data)
+
+	// headers are mapped explicitly
+	resp = assertErrCode(t, success.Code, importCSV(map[string]string{"tableId": tableId,
+		"mapping": `{"姓名": "name", "年龄": "age"}`}, "姓名,年龄,备注\nerin,22,-\n", token))
+	assert.Equal(float64(1), resp.Data.(map[string]interface{})["imported"])
+
+	export := func(query url.Values, token string) *http.Response {
+		query.Set("tableId", tableId)
+		httpReq := httptest.NewRequest("GET", "/currentUser/table/row/export?"+query.Encode(), nil)
+		httpReq.Header.Add("Authorization", fmt.Sprintf("BEARER %s", token))
+		return handleRequest(httpReq, svr)
+	}
+	httpResp := export(url.Values{}, token)
+	assert.Equal("text/csv; charset=utf-8", httpResp.Header.Get("Content-Type"))
+	assert.Equal(`attachment; filename=users.csv`, httpResp.Header.Get("Content-Disposition"))
+	body, _ := ioutil.ReadAll(httpResp.Body)
+	assert.Equal("name,age,vip,joinedAt\n"+
+		"alice,30,true,2020-01-02T00:00:00Z\n"+
+		"carol,,false,2020-03-01T00:00:00Z\n"+
+		"'=cmd|' /C calc'!A0,,,\n"+
+		"erin,22,,\n", string(body))
+
+	httpResp = export(url.Values{
+		"filters": {`[{"column": "age", "op": "gt", "value": 25}]`},
+		"sort":    {`[{"column": "name", "desc": true}]`},
+	}, token)
+	body, _ = ioutil.ReadAll(httpResp.Body)
+	assert.Equal("name,age,vip,joinedAt\nalice,30,true,2020-01-02T00:00:00Z\n", string(body))
+
+	assertErrCode(t, errCodeMap[errInvalidParam], export(url.Values{
+		"filters": {`[{"column": "unknown", "op": "eq", "value": 1}]`}}, token))
+	assertErrCode(t, errCodeMap[errTableNotFound], export(url.Values{}, otherToken))
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rtxu/luban-api/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleTable(t *testing.T) {
+	assert := assert.New(t)
+	svr, token := newTestServer()
+	otherToken := addTestUser(svr, 1001, "bob")
+
+	columns := []map[string]interface{}{
+		{"name": "name", "type": db.ColumnTypeText, "required": true},
+		{"name": "age", "type": db.ColumnTypeNumber},
+	}
+	// invalid
+	for _, cs := range [][]map[string]interface{}{
+		nil,
+		{{"name": "1st", "type": db.ColumnTypeText}},
+		{{"name": "id", "type": db.ColumnTypeText}},
+		{{"name": "a", "type": "blob"}},
+		{{"name": "a", "type": db.ColumnTypeText}, {"name": "a", "type": db.ColumnTypeBool}},
+	} {
+		assertErrCode(t, errCodeMap[errInvalidParam], doRequest("POST", "/currentUser/table",
+			map[string]interface{}{"name": "users", "columns": cs}, svr, token))
+	}
+	resp := assertErrCode(t, success.Code, doRequest("POST", "/currentUser/table",
+		map[string]interface{}{"name": "users", "columns": columns}, svr, token))
+	id := resp.Data.(map[string]interface{})["id"]
+	assertErrCode(t, errCodeMap[errInvalidParam], doRequest("POST", "/currentUser/table",
+		map[string]interface{}{"name": "users", "columns": columns}, svr, token))
+
+	list := func(token string) []interface{} {
+		resp := assertErrCode(t, success.Code, doRequest("GET", "/currentUser/table", nil, svr, token))
+		return resp.Data.([]interface{})
+	}
+	assert.Equal([]interface{}{map[string]interface{}{
+		"id":   float64(0),
+		"name": "users",
+		"columns": []interface{}{
+			map[string]interface{}{"name": "name", "type": "text", "required": true},
+			map[string]interface{}{"name": "age", "type": "number", "required": false},
+		},
+	}}, list(token))
+	assert.Len(list(otherToken), 0)
+
+	// columns could be added or removed, but their types could not be changed
+	assertErrCode(t, errCodeMap[errInvalidParam], doRequest("PUT", "/currentUser/table", map[string]interface{}{
+		"id": id, "columns": []map[string]interface{}{{"name": "name", "type": db.ColumnTypeNumber}},
+	}, svr, token))
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/table", map[string]interface{}{
+		"id": id, "name": "members",
+		"columns": append(columns, map[string]interface{}{"name": "vip", "type": db.ColumnTypeBool}),
+	}, svr, token))
+	tbl, _ := svr.lubanTableService.Find(0)
+	assert.Equal("members", tbl.Name)
+	assertErrCode(t, errCodeMap[errTableNotFound], doRequest("PUT", "/currentUser/table",
+		map[string]interface{}{"id": id, "name": "stolen"}, svr, otherToken))
+
+	// values of the removed columns do not come back with a column of the same name
+	assertErrCode(t, success.Code, doRequest("POST", "/currentUser/table/row",
+		map[string]interface{}{"tableId": id, "data": map[string]interface{}{"name": "bob", "age": 30}}, svr, token))
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/table", map[string]interface{}{
+		"id": id, "columns": columns[:1],
+	}, svr, token))
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/table", map[string]interface{}{
+		"id": id, "columns": []map[string]interface{}{columns[0], {"name": "age", "type": db.ColumnTypeText}},
+	}, svr, token))
+	row, _ := svr.lubanRowService.Find(0, 0)
+	assert.Equal(map[string]interface{}{"name": "bob"}, decodeRowData(row.Data))
+
+	// delete drops the rows
+	assertErrCode(t, success.Code, doRequest("POST", "/currentUser/table/row",
+		map[string]interface{}{"tableId": id, "data": map[string]interface{}{"name": "alice"}}, svr, token))
+	assertErrCode(t, errCodeMap[errTableNotFound], doRequest("DELETE", "/currentUser/table",
+		map[string]interface{}{"id": id}, svr, otherToken))
+	assertErrCode(t, success.Code, doRequest("DELETE", "/currentUser/table",
+		map[string]interface{}{"id": id}, svr, token))
+	assert.Len(list(token), 0)
+	_, total, _ := svr.lubanRowService.Query(0, db.RowQuery{Limit: 10})
+	assert.Equal(uint64(0), total)
+}
+
+func TestHandleTableRow(t *testing.T) {
+	assert := assert.New(t)
+	svr, token := newTestServer()
+	bobToken := addTestUser(svr, 1001, "bob")
+	resp := assertErrCode(t, success.Code, doRequest("POST", "/org",
+		map[string]interface{}{"name": "team"}, svr, token))
+	orgId := resp.Data.(map[string]interface{})["id"]
+	assertErrCode(t, success.Code, doRequest("PUT", "/org/member",
+		map[string]interface{}{"orgId": orgId, "username": "bob", "role": db.RoleViewer}, svr, token))
+	// request in the org workspace
+	do := func(method, target string, body interface{}, token string) *http.Response {
+		reqBodyBytes, _ := json.Marshal(body)
+		httpReq := httptest.NewRequest(method, target, bytes.NewReader(reqBodyBytes))
+		httpReq.Header.Add("Authorization", fmt.Sprintf("BEARER %s", token))
+		httpReq.Header.Add(kWorkspaceHeader, fmt.Sprintf("%v", orgId))
+		return handleRequest(httpReq, svr)
+	}
+
+	resp = assertErrCode(t, success.Code, do("POST", "/currentUser/table", map[string]interface{}{
+		"name": "signup",
+		"columns": []map[string]interface{}{
+			{"name": "name", "type": db.ColumnTypeText, "required": true},
+			{"name": "age", "type": db.ColumnTypeNumber},
+			{"name": "vip", "type": db.ColumnTypeBool},
+			{"name": "joinedAt", "type": db.ColumnTypeDate},
+			{"name": "extra", "type": db.ColumnTypeJSON},
+		},
+	}, token))
+	tableId := resp.Data.(map[string]interface{})["id"]
+	// viewers could not change the schema
+	assertErrCode(t, errCodeMap[errPermissionDenied], do("PUT", "/currentUser/table",
+		map[string]interface{}{"id": tableId, "name": "x"}, bobToken))
+
+	insert := func(data map[string]interface{}, token string) *http.Response {
+		return do("POST", "/currentUser/table/row", map[string]interface{}{"tableId": tableId, "data": data}, token)
+	}
+	// checked against the schema
+	for _, data := range []map[string]interface{}{
+		{"age": 1},
+		{"name": nil},
+		{"name": 1},
+		{"name": "x", "age": "1"},
+		{"name": "x", "vip": "yes"},
+		{"name": "x", "joinedAt": "yesterday"},
+		{"name": "x", "unknown": 1},
+	} {
+		assertErrCode(t, errCodeMap[errInvalidParam], insert(data, token))
+	}
+	// viewers could insert, e.g. submitting a form
+	resp = assertErrCode(t, success.Code, insert(map[string]interface{}{
+		"name": "alice", "age": 30, "vip": true, "joinedAt": "2020-01-02", "extra": map[string]int{"a": 1},
+	}, bobToken))
+	row := resp.Data.(map[string]interface{})
+	assert.Equal(map[string]interface{}{
+		"name": "alice", "age": float64(30), "vip": true, "joinedAt": "2020-01-02T00:00:00Z",
+		"extra": map[string]interface{}{"a": float64(1)},
+	}, row["data"])
+	assertErrCode(t, success.Code, insert(map[string]interface{}{
+		"name": "bob", "age": 25, "joinedAt": "2020-03-01T08:00:00+08:00"}, token))
+	assertErrCode(t, success.Code, insert(map[string]interface{}{"name": "carol", "vip": false}, token))
+	assertErrCode(t, success.Code, insert(map[string]interface{}{"name": "dave", "age": 41, "vip": true}, token))
+
+	query := func(param map[string]interface{}) ([]string, float64) {
+		param["tableId"] = tableId
+		resp := assertErrCode(t, success.Code, do("POST", "/currentUser/table/row/query", param, bobToken))
+		data := resp.Data.(map[string]interface{})
+		var names []string
+		for _, row := range data["rows"].([]interface{}) {
+			names = append(names, row.(map[string]interface{})["data"].(map[string]interface{})["name"].(string))
+		}
+		return names, data["total"].(float64)
+	}
+	names, total := query(map[string]interface{}{})
+	assert.Equal([]string{"alice", "bob", "carol", "dave"}, names)
+	assert.Equal(float64(4), total)
+
+	// filters, sorting and pagination
+	names, total = query(map[string]interface{}{
+		"filters": []map[string]interface{}{{"column": "age", "op": "gte", "value": 25}},
+		"sort":    []map[string]interface{}{{"column": "age", "desc": true}},
+		"limit":   2,
+	})
+	assert.Equal([]string{"dave", "alice"}, names)
+	assert.Equal(float64(3), total)
+	names, _ = query(map[string]interface{}{
+		"filters": []map[string]interface{}{{"column": "age", "op": "gte", "value": 25}},
+		"sort":    []map[string]interface{}{{"column": "age", "desc": true}},
+		"offset":  2,
+		"limit":   2,
+	})
+	assert.Equal([]string{"bob"}, names)
+	names, _ = query(map[string]interface{}{
+		"filters": []map[string]interface{}{{"column": "vip", "op": "eq", "value": true}},
+	})
+	assert.Equal([]string{"alice", "dave"}, names)
+	names, _ = query(map[string]interface{}{
+		"filters": []map[string]interface{}{
+			{"column": "joinedAt", "op": "gt", "value": "2020-02-01"},
+			{"column": "name", "op": "contains", "value": "o"},
+		},
+	})
+	assert.Equal([]string{"bob"}, names)
+	// rows missing the column are sorted first
+	names, _ = query(map[string]interface{}{"sort": []map[string]interface{}{{"column": "age"}}})
+	assert.Equal([]string{"carol", "bob", "alice", "dave"}, names)
+	for _, param := range []map[string]interface{}{
+		{"filters": []map[string]interface{}{{"column": "age", "op": "like", "value": 1}}},
+		{"filters": []map[string]interface{}{{"column": "age", "op": "contains", "value": 1}}},
+		{"filters": []map[string]interface{}{{"column": "age", "op": "eq", "value": "x"}}},
+		{"filters": []map[string]interface{}{{"column": "extra", "op": "eq", "value": 1}}},
+		{"sort": []map[string]interface{}{{"column": "unknown"}}},
+	} {
+		param["tableId"] = tableId
+		assertErrCode(t, errCodeMap[errInvalidParam], do("POST", "/currentUser/table/row/query", param, bobToken))
+	}
+
+	// only editors could update or delete rows, absent columns are kept
+	update := map[string]interface{}{"tableId": tableId, "id": row["id"], "data": map[string]interface{}{"age": 31}}
+	assertErrCode(t, errCodeMap[errPermissionDenied], do("PUT", "/currentUser/table/row", update, bobToken))
+	assertErrCode(t, success.Code, do("PUT", "/currentUser/table/row", update, token))
+	update["data"] = map[string]interface{}{"name": nil}
+	assertErrCode(t, errCodeMap[errInvalidParam], do("PUT", "/currentUser/table/row", update, token))
+	names, _ = query(map[string]interface{}{
+		"filters": []map[string]interface{}{{"column": "age", "op": "eq", "value": 31}},
+	})
+	assert.Equal([]string{"alice"}, names)
+
+	assertErrCode(t, errCodeMap[errRowNotFound], do("DELETE", "/currentUser/table/row",
+		map[string]interface{}{"tableId": tableId, "id": 100}, token))
+	assertErrCode(t, success.Code, do("DELETE", "/currentUser/table/row",
+		map[string]interface{}{"tableId": tableId, "id": row["id"]}, token))
+	_, total = query(map[string]interface{}{})
+	assert.Equal(float64(3), total)
+
+	// tables of other workspaces are not found
+	assertErrCode(t, errCodeMap[errTableNotFound], doRequest("POST", "/currentUser/table/row/query",
+		map[string]interface{}{"tableId": tableId}, svr, token))
+}
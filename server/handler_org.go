@@ -0,0 +1,208 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rtxu/luban-api/db"
+)
+
+// OrgMemberT 代表 org 的一个成员
+type OrgMemberT struct {
+	Username  string  `json:"username"`
+	AvatarUrl *string `json:"avatarUrl"`
+	Role      db.Role `json:"role"`
+}
+
+func (s *server) handleOrgList() http.HandlerFunc {
+	type orgT struct {
+		Id   uint32  `json:"id"`
+		Name string  `json:"name"`
+		Role db.Role `json:"role"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		members, err := s.orgMemberService.ListByUser(currentUserId(r))
+		if err != nil {
+			panic(err)
+		}
+		data := make([]orgT, 0, len(members))
+		for _, member := range members {
+			org, err := s.orgService.Find(member.OrgID)
+			if err != nil {
+				panic(err)
+			}
+			data = append(data, orgT{
+				Id:   org.ID,
+				Name: org.Name,
+				Role: member.Role,
+			})
+		}
+		s.respond(w, r, defaultResponse{Data: data}, http.StatusOK)
+	}
+}
+
+func (s *server) handleOrgCreate() http.HandlerFunc {
+	type request struct {
+		Name string `json:"name"`
+	}
+	type dataT struct {
+		Id uint32 `json:"id"`
+	}
+	defaultRootDir := json.RawMessage("[]")
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		param.Name = strings.TrimSpace(param.Name)
+		if param.Name == "" {
+			s.respond(w, r, fmt.Errorf("%w: empty org name", errInvalidParam), http.StatusOK)
+			return
+		}
+
+		org := db.Org{
+			Name:    param.Name,
+			RootDir: defaultRootDir,
+		}
+		if err := s.orgService.NewOrg(&org); err != nil {
+			panic(err)
+		}
+		if err := s.orgMemberService.Grant(org.ID, currentUserId(r), db.RoleAdmin); err != nil {
+			panic(err)
+		}
+		s.respond(w, r, defaultResponse{Data: dataT{Id: org.ID}}, http.StatusOK)
+	}
+}
+
+func (s *server) handleOrgMemberList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orgIdStr := r.URL.Query().Get("orgId")
+		u64, err := strconv.ParseUint(orgIdStr, 10, 32)
+		if err != nil {
+			s.respond(w, r, fmt.Errorf("%w: orgId(%s) is not a number, err: %v",
+				errBadRequest, orgIdStr, err), http.StatusOK)
+			return
+		}
+		org, _, err := s.findOrgWithRole(r, uint32(u64), db.RoleViewer)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		members, err := s.orgMemberService.ListByOrg(org.ID)
+		if err != nil {
+			panic(err)
+		}
+		data := make([]OrgMemberT, 0, len(members))
+		for _, member := range members {
+			user := s.findUserById(member.UserID)
+			data = append(data, OrgMemberT{
+				Username:  user.UserName,
+				AvatarUrl: user.AvatarUrl,
+				Role:      member.Role,
+			})
+		}
+		s.respond(w, r, defaultResponse{Data: data}, http.StatusOK)
+	}
+}
+
+// countOrgAdminsExcept returns how many admins remain in org when user is excluded
+func (s *server) countOrgAdminsExcept(orgId, userId uint32) int {
+	members, err := s.orgMemberService.ListByOrg(orgId)
+	if err != nil {
+		panic(err)
+	}
+	count := 0
+	for _, member := range members {
+		if member.UserID != userId && member.Role == db.RoleAdmin {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *server) handleOrgMemberGrant() http.HandlerFunc {
+	type request struct {
+		OrgId    uint32  `json:"orgId"`
+		Username string  `json:"username"`
+		Role     db.Role `json:"role"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		if !param.Role.Valid() {
+			s.respond(w, r, fmt.Errorf("%w: unrecognized role(%s)",
+				errInvalidParam, param.Role), http.StatusOK)
+			return
+		}
+
+		org, _, err := s.findOrgWithRole(r, param.OrgId, db.RoleAdmin)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		user, err := s.findUserByName(param.Username)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if param.Role != db.RoleAdmin && s.orgRole(user.ID, org.ID) == db.RoleAdmin &&
+			s.countOrgAdminsExcept(org.ID, user.ID) == 0 {
+			s.respond(w, r, fmt.Errorf("%w: %s is the last admin", errLastOrgAdmin, user.UserName),
+				http.StatusOK)
+			return
+		}
+
+		if err := s.orgMemberService.Grant(org.ID, user.ID, param.Role); err != nil {
+			panic(err)
+		}
+		s.respond(w, r, success, http.StatusOK)
+	}
+}
+
+func (s *server) handleOrgMemberRemove() http.HandlerFunc {
+	type request struct {
+		OrgId    uint32 `json:"orgId"`
+		Username string `json:"username"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+
+		user, err := s.findUserByName(param.Username)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		// anyone could leave an org, otherwise admin is required
+		needRole := db.RoleAdmin
+		if user.ID == currentUserId(r) {
+			needRole = db.RoleViewer
+		}
+		org, _, err := s.findOrgWithRole(r, param.OrgId, needRole)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if s.orgRole(user.ID, org.ID) == db.RoleAdmin && s.countOrgAdminsExcept(org.ID, user.ID) == 0 {
+			s.respond(w, r, fmt.Errorf("%w: %s is the last admin", errLastOrgAdmin, user.UserName),
+				http.StatusOK)
+			return
+		}
+
+		if err := s.orgMemberService.Remove(org.ID, user.ID); err != nil {
+			panic(err)
+		}
+		s.respond(w, r, success, http.StatusOK)
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rtxu/luban-api/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleOrg(t *testing.T) {
+	assert := assert.New(t)
+	svr, ownerToken := newTestServer()
+	const kBobId, kAliceId = 1001, 1002
+	bobToken := addTestUser(svr, kBobId, "bob")
+	aliceToken := addTestUser(svr, kAliceId, "alice")
+
+	// request in the org workspace
+	doOrgRequest := func(method, target string, body interface{}, token string, orgId uint32) *http.Response {
+		reqBodyBytes, _ := json.Marshal(body)
+		httpReq := httptest.NewRequest(method, target, bytes.NewReader(reqBodyBytes))
+		httpReq.Header.Add("Authorization", fmt.Sprintf("BEARER %s", token))
+		httpReq.Header.Add(kWorkspaceHeader, fmt.Sprintf("%d", orgId))
+		return handleRequest(httpReq, svr)
+	}
+	grant := func(token string, orgId uint32, username string, role db.Role) *http.Response {
+		return doRequest("PUT", "/org/member",
+			map[string]interface{}{"orgId": orgId, "username": username, "role": role},
+			svr, token)
+	}
+
+	// CREATE
+	assertErrCode(t, errCodeMap[errInvalidParam], doRequest("POST", "/org",
+		map[string]interface{}{"name": " "}, svr, ownerToken))
+	resp := assertErrCode(t, success.Code, doRequest("POST", "/org",
+		map[string]interface{}{"name": "team"}, svr, ownerToken))
+	orgId := uint32(resp.Data.(map[string]interface{})["id"].(float64))
+
+	// strangers know nothing about the org
+	assertErrCode(t, errCodeMap[errOrgNotFound], doOrgRequest("GET", "/currentUser", nil, bobToken, orgId))
+	assertErrCode(t, errCodeMap[errOrgNotFound], grant(bobToken, orgId, "bob", db.RoleAdmin))
+
+	// MEMBERSHIP
+	assertErrCode(t, success.Code, grant(ownerToken, orgId, "bob", db.RoleViewer))
+	assertErrCode(t, success.Code, grant(ownerToken, orgId, "alice", db.RoleEditor))
+	assertErrCode(t, errCodeMap[errPermissionDenied], grant(aliceToken, orgId, "bob", db.RoleEditor))
+	assertErrCode(t, errCodeMap[errLastOrgAdmin], grant(ownerToken, orgId, kTestUserName, db.RoleEditor))
+	{
+		resp := assertErrCode(t, success.Code,
+			doRequest("GET", fmt.Sprintf("/org/member?orgId=%d", orgId), nil, svr, bobToken))
+		assert.Len(resp.Data, 3)
+
+		resp = assertErrCode(t, success.Code, doRequest("GET", "/org", nil, svr, aliceToken))
+		assert.Equal([]interface{}{map[string]interface{}{
+			"id":   float64(orgId),
+			"name": "team",
+			"role": string(db.RoleEditor),
+		}}, resp.Data)
+	}
+
+	// ENTRY in the org workspace
+	createOrgEntry := func(token string, name string) *http.Response {
+		return doOrgRequest("POST", "/currentUser/entry", createRequest{
+			Dir:   "/",
+			Entry: EntryT{Name: name, Type: App},
+		}, token, orgId)
+	}
+	assertErrCode(t, errCodeMap[errPermissionDenied], createOrgEntry(bobToken, "app1"))
+	assertErrCode(t, success.Code, createOrgEntry(aliceToken, "app1"))
+	var appId uint32
+	{
+		// the org tree is shared by members, the personal tree stays untouched
+		resp := assertErrCode(t, success.Code, doOrgRequest("GET", "/currentUser", nil, bobToken, orgId))
+		rootDir := resp.Data.(map[string]interface{})["rootDir"].([]interface{})
+		assert.Len(rootDir, 1)
+		entry := rootDir[0].(map[string]interface{})
+		assert.Equal("app1", entry["name"])
+
+		appId = uint32(entry["appId"].(float64))
+		app, err := svr.appService.Get(appId)
+		assert.NoError(err)
+		assert.Equal(orgId, app.OrgID)
+		assert.Equal(uint32(kAliceId), app.OwnerID)
+
+		_, personalRootDir := svr.getCurrentUserAndRootDirFromDB("alice")
+		assert.Len(personalRootDir, 0)
+
+		// org members have access to the org apps according to their org role
+		viewUrl := fmt.Sprintf("/currentUser/app?appId=%d&loadType=%s", appId, kLTView)
+		saveUrl := fmt.Sprintf("/currentUser/app?appId=%d&op=%s", appId, kOpSave)
+		assertErrCode(t, success.Code, doRequest("GET", viewUrl, nil, svr, bobToken))
+		assertErrCode(t, errCodeMap[errPermissionDenied], doRequest("PUT", saveUrl, map[string]interface{}{}, svr, bobToken))
+		assertErrCode(t, success.Code, doRequest("PUT", saveUrl, map[string]interface{}{}, svr, ownerToken))
+	}
+
+	// LEAVE
+	assertErrCode(t, errCodeMap[errLastOrgAdmin], doRequest("DELETE", "/org/member",
+		map[string]interface{}{"orgId": orgId, "username": kTestUserName}, svr, ownerToken))
+	assertErrCode(t, errCodeMap[errPermissionDenied], doRequest("DELETE", "/org/member",
+		map[string]interface{}{"orgId": orgId, "username": "alice"}, svr, bobToken))
+	assertErrCode(t, success.Code, doRequest("DELETE", "/org/member",
+		map[string]interface{}{"orgId": orgId, "username": "bob"}, svr, bobToken))
+	assertErrCode(t, errCodeMap[errOrgNotFound], doOrgRequest("GET", "/currentUser", nil, bobToken, orgId))
+
+	// owners of the org apps lose them once removed from the org
+	editUrl := fmt.Sprintf("/currentUser/app?appId=%d&loadType=%s", appId, kLTEdit)
+	assertErrCode(t, success.Code, doRequest("GET", editUrl, nil, svr, aliceToken))
+	assertErrCode(t, success.Code, doRequest("DELETE", "/org/member",
+		map[string]interface{}{"orgId": orgId, "username": "alice"}, svr, ownerToken))
+	assertErrCode(t, errCodeMap[errEntryNotFound], doRequest("GET", editUrl, nil, svr, aliceToken))
+}
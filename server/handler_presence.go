@@ -0,0 +1,113 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rtxu/luban-api/db"
+)
+
+// PresenceT 代表 app 当前的编辑者及编辑锁
+type PresenceT struct {
+	Editors []EditorT `json:"editors"`
+	// nil when unlocked
+	Lock *EditLockT `json:"lock"`
+}
+
+type EditorT struct {
+	Username string    `json:"username"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+type EditLockT struct {
+	Username string    `json:"username"`
+	ExpireAt time.Time `json:"expireAt"`
+}
+
+func (s *server) newPresence(appId uint32) PresenceT {
+	cache := make(usernameCache)
+	editors := s.presence.activeEditors(appId)
+	data := PresenceT{
+		Editors: make([]EditorT, 0, len(editors)),
+	}
+	for _, editor := range editors {
+		data.Editors = append(data.Editors, EditorT{
+			Username: s.username(cache, editor.userId),
+			LastSeen: editor.lastSeen,
+		})
+	}
+	if lock, ok := s.presence.lock(appId); ok {
+		data.Lock = &EditLockT{
+			Username: s.username(cache, lock.userId),
+			ExpireAt: lock.expireAt,
+		}
+	}
+	return data
+}
+
+// checkEditLock returns errAppLocked if the app is locked by others than the current user
+func (s *server) checkEditLock(r *http.Request, appId uint32) error {
+	if lock, ok := s.presence.lockedByOthers(appId, currentUserId(r)); ok {
+		return s.lockedError(lock)
+	}
+	return nil
+}
+
+func (s *server) lockedError(lock editLock) error {
+	return fmt.Errorf("%w: by %s until %s", errAppLocked,
+		s.findUserById(lock.userId).UserName, lock.expireAt.Format(time.RFC3339))
+}
+
+func (s *server) handlePresenceGet() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app, err := s.findAppWithRoleByQuery(r, db.RoleViewer)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		s.respond(w, r, defaultResponse{Data: s.newPresence(app.ID)}, http.StatusOK)
+	}
+}
+
+// handlePresenceHeartbeat 由打开 app 的编辑器定时调用，间隔应小于 kPresenceTTL
+func (s *server) handlePresenceHeartbeat() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app, err := s.findAppWithRoleByQuery(r, db.RoleEditor)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		s.presence.heartbeat(app.ID, currentUserId(r))
+		s.respond(w, r, defaultResponse{Data: s.newPresence(app.ID)}, http.StatusOK)
+	}
+}
+
+func (s *server) handleEditLockAcquire() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app, err := s.findAppWithRoleByQuery(r, db.RoleEditor)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		force := r.URL.Query().Get("force") == "true"
+		// the lock returned is the one held by others, which may expire right after
+		if lock, ok := s.presence.acquireLock(app.ID, currentUserId(r), force); !ok {
+			s.respond(w, r, s.lockedError(lock), http.StatusOK)
+			return
+		}
+		s.respond(w, r, defaultResponse{Data: s.newPresence(app.ID)}, http.StatusOK)
+	}
+}
+
+func (s *server) handleEditLockRelease() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app, err := s.findAppWithRoleByQuery(r, db.RoleEditor)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		s.presence.releaseLock(app.ID, currentUserId(r))
+		s.respond(w, r, success, http.StatusOK)
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rtxu/luban-api/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlePresence(t *testing.T) {
+	assert := assert.New(t)
+	svr, token := newTestServer()
+	const kBobId = 1001
+	bobToken := addTestUser(svr, kBobId, "bob")
+	now := time.Now()
+	svr.presence.now = func() time.Time { return now }
+
+	createEntry(createRequest{
+		Dir:   "/",
+		Entry: EntryT{Name: "entry1", Type: App},
+	}, svr, token)
+	svr.appACLService.Grant(0, kBobId, db.RoleEditor)
+	presence := func() map[string]interface{} {
+		resp := assertErrCode(t, success.Code, doRequest("GET", "/currentUser/app/presence?appId=0", nil, svr, token))
+		return resp.Data.(map[string]interface{})
+	}
+	save := func(token string) {
+		assertErrCode(t, success.Code, doRequest("PUT",
+			"/currentUser/app?appId=0&op="+kOpSave, map[string]interface{}{}, svr, token))
+	}
+
+	// heartbeats
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/app/presence?appId=0", nil, svr, token))
+	now = now.Add(time.Second)
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/app/presence?appId=0", nil, svr, bobToken))
+	editors := presence()["editors"].([]interface{})
+	assert.Len(editors, 2)
+	assert.Equal("bob", editors[0].(map[string]interface{})["username"])
+	assert.Nil(presence()["lock"])
+
+	// the owner is gone without heartbeat
+	now = now.Add(kPresenceTTL)
+	editors = presence()["editors"].([]interface{})
+	assert.Len(editors, 1)
+	assert.Equal("bob", editors[0].(map[string]interface{})["username"])
+
+	// bob locks the app, the owner could neither lock nor save it
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/app/lock?appId=0", nil, svr, bobToken))
+	assert.Equal("bob", presence()["lock"].(map[string]interface{})["username"])
+	assertErrCode(t, errCodeMap[errAppLocked], doRequest("PUT", "/currentUser/app/lock?appId=0", nil, svr, token))
+	assertErrCode(t, errCodeMap[errAppLocked], doRequest("PUT",
+		"/currentUser/app?appId=0&op="+kOpSave, map[string]interface{}{}, svr, token))
+	assertErrCode(t, errCodeMap[errAppLocked], doRequest("PUT",
+		"/currentUser/app?appId=0&op="+kOpPublish, map[string]interface{}{}, svr, token))
+	save(bobToken)
+
+	// heartbeats of bob renew the lock, until it expires without heartbeat
+	now = now.Add(kEditLockTTL - time.Second)
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/app/presence?appId=0", nil, svr, bobToken))
+	now = now.Add(kEditLockTTL - time.Second)
+	assertErrCode(t, errCodeMap[errAppLocked], doRequest("PUT",
+		"/currentUser/app?appId=0&op="+kOpSave, map[string]interface{}{}, svr, token))
+	now = now.Add(time.Second)
+	assert.Nil(presence()["lock"])
+	save(token)
+
+	// forced takeover
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/app/lock?appId=0", nil, svr, bobToken))
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/app/lock?appId=0&force=true", nil, svr, token))
+	assertErrCode(t, errCodeMap[errAppLocked], doRequest("PUT",
+		"/currentUser/app?appId=0&op="+kOpSave, map[string]interface{}{}, svr, bobToken))
+
+	// only the holder could release the lock
+	assertErrCode(t, success.Code, doRequest("DELETE", "/currentUser/app/lock?appId=0", nil, svr, bobToken))
+	assert.Equal(kTestUserName, presence()["lock"].(map[string]interface{})["username"])
+	assertErrCode(t, success.Code, doRequest("DELETE", "/currentUser/app/lock?appId=0", nil, svr, token))
+	assert.Nil(presence()["lock"])
+	save(bobToken)
+
+	// viewers could see the presence but not lock the app
+	viewerToken := addTestUser(svr, 1002, "viewer")
+	svr.appACLService.Grant(0, 1002, db.RoleViewer)
+	assertErrCode(t, success.Code, doRequest("GET", "/currentUser/app/presence?appId=0", nil, svr, viewerToken))
+	assertErrCode(t, errCodeMap[errPermissionDenied], doRequest("PUT", "/currentUser/app/lock?appId=0", nil, svr, viewerToken))
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rtxu/luban-api/db"
+)
+
+// drafts are not versioned, so an earlier revision could not be scheduled,
+// a release is prepared by scheduling a snapshot of the draft once it's ready
+const (
+	// publish the draft as it is at the scheduled time
+	kScheduleSourceDraft = "draft"
+	// publish the draft as it is when scheduling
+	kScheduleSourceSnapshot = "snapshot"
+)
+
+// ScheduleT 代表一次待执行的定时发布
+type ScheduleT struct {
+	Id        uint32    `json:"id"`
+	PublishAt time.Time `json:"publishAt"`
+	Channel   string    `json:"channel"`
+	Source    string    `json:"source"`
+	CreatedBy string    `json:"createdBy"`
+}
+
+func (s *server) handleScheduleList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app, err := s.findAppWithRoleByQuery(r, db.RoleViewer)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		schedules, err := s.publishScheduleService.ListPendingByApp(app.ID)
+		if err != nil {
+			panic(err)
+		}
+		cache := make(usernameCache)
+		data := make([]ScheduleT, 0, len(schedules))
+		for _, schedule := range schedules {
+			source := kScheduleSourceDraft
+			if schedule.Content != nil {
+				source = kScheduleSourceSnapshot
+			}
+			data = append(data, ScheduleT{
+				Id:        schedule.ID,
+				PublishAt: schedule.PublishAt,
+				Channel:   schedule.Channel,
+				Source:    source,
+				CreatedBy: s.username(cache, schedule.CreatedBy),
+			})
+		}
+		s.respond(w, r, defaultResponse{Data: data}, http.StatusOK)
+	}
+}
+
+// handleScheduleCreate 定时发布当前草稿，source 为 draft 时发布届时的草稿，为 snapshot 时发布此刻的草稿。
+// 草稿没有历史版本，不支持选择更早的版本
+func (s *server) handleScheduleCreate() http.HandlerFunc {
+	type request struct {
+		AppId     uint32    `json:"appId"`
+		PublishAt time.Time `json:"publishAt"`
+		Channel   string    `json:"channel"`
+		Source    string    `json:"source"`
+	}
+	type dataT struct {
+		Id uint32 `json:"id"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		if !param.PublishAt.After(time.Now()) {
+			s.respond(w, r, fmt.Errorf("%w: publishAt(%s) should be in the future",
+				errInvalidParam, param.PublishAt), http.StatusOK)
+			return
+		}
+		channel, err := checkChannel(param.Channel)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		app, err := s.findAppWithRole(r, param.AppId, db.RoleEditor)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		schedule := db.PublishSchedule{
+			AppID:     app.ID,
+			CreatedBy: currentUserId(r),
+			PublishAt: param.PublishAt,
+			Channel:   channel,
+		}
+		switch param.Source {
+		case kScheduleSourceDraft:
+		case kScheduleSourceSnapshot:
+			// components are frozen along with the draft
+			schedule.Content, schedule.Draft, err = s.resolveDraft(app)
+			if err != nil {
+				s.respond(w, r, err, http.StatusOK)
+				return
+			}
+			// the snapshot could never be published otherwise
+			if _, err := contentForms(schedule.Content); err != nil {
+				s.respond(w, r, err, http.StatusOK)
+				return
+			}
+		default:
+			s.respond(w, r, fmt.Errorf("%w: unrecognized source(%s)",
+				errInvalidParam, param.Source), http.StatusOK)
+			return
+		}
+		if err := s.publishScheduleService.NewSchedule(&schedule); err != nil {
+			panic(err)
+		}
+		s.respond(w, r, defaultResponse{Data: dataT{Id: schedule.ID}}, http.StatusOK)
+	}
+}
+
+func (s *server) handleScheduleCancel() http.HandlerFunc {
+	type request struct {
+		ScheduleId uint32 `json:"scheduleId"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		schedule, err := s.publishScheduleService.Find(param.ScheduleId)
+		if err != nil {
+			if errors.Is(err, db.ErrNotFound) {
+				s.respond(w, r, fmt.Errorf("%w: scheduleId is %d",
+					errScheduleNotFound, param.ScheduleId), http.StatusOK)
+				return
+			}
+			panic(err)
+		}
+		if _, err := s.findAppWithRole(r, schedule.AppID, db.RoleEditor); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		canceled, err := s.publishScheduleService.Cancel(schedule.ID)
+		if err != nil {
+			panic(err)
+		}
+		if !canceled {
+			s.respond(w, r, fmt.Errorf("%w: schedule(%d) is %s",
+				errScheduleNotFound, schedule.ID, schedule.Status), http.StatusOK)
+			return
+		}
+		s.respond(w, r, success, http.StatusOK)
+	}
+}
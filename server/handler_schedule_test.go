@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rtxu/luban-api/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleSchedule(t *testing.T) {
+	assert := assert.New(t)
+	svr, token := newTestServer()
+
+	createEntry(createRequest{
+		Dir:   "/",
+		Entry: EntryT{Name: "entry1", Type: App},
+	}, svr, token)
+	save := func(content string) {
+		svr.appService.UpdateContent(0, kTestUserId, json.RawMessage(content))
+	}
+	schedule := func(publishAt time.Time, source string) defaultResponse {
+		resp := doRequest("POST", "/currentUser/app/schedule", map[string]interface{}{
+			"appId": 0, "publishAt": publishAt, "source": source,
+		}, svr, token)
+		var jsonResponse defaultResponse
+		json.NewDecoder(resp.Body).Decode(&jsonResponse)
+		return jsonResponse
+	}
+	scheduleId := func(resp defaultResponse) uint32 {
+		return uint32(resp.Data.(map[string]interface{})["id"].(float64))
+	}
+	published := func() string {
+		app, _ := svr.appService.Get(0)
+		return string(app.LastPublishedContent)
+	}
+	status := func(id uint32) string {
+		schedule, _ := svr.publishScheduleService.Find(id)
+		return schedule.Status
+	}
+	now := time.Now()
+
+	// invalid
+	assert.Equal(errCodeMap[errInvalidParam], schedule(now.Add(-time.Minute), kScheduleSourceDraft).Code)
+	assert.Equal(errCodeMap[errInvalidParam], schedule(now.Add(time.Hour), "revision").Code)
+
+	save(`{"v":1}`)
+	snapshotId := scheduleId(schedule(now.Add(time.Hour), kScheduleSourceSnapshot))
+	save(`{"v":2}`)
+	draftId := scheduleId(schedule(now.Add(2*time.Hour), kScheduleSourceDraft))
+	canceledId := scheduleId(schedule(now.Add(3*time.Hour), kScheduleSourceDraft))
+	save(`{"v":3}`)
+
+	// list
+	resp := assertErrCode(t, success.Code, doRequest("GET", "/currentUser/app/schedule?appId=0", nil, svr, token))
+	schedules := resp.Data.([]interface{})
+	assert.Len(schedules, 3)
+	assert.Equal(kScheduleSourceSnapshot, schedules[0].(map[string]interface{})["source"])
+	assert.Equal(kTestUserName, schedules[0].(map[string]interface{})["createdBy"])
+
+	// cancel
+	cancel := func(id uint32) *defaultResponse {
+		resp := doRequest("DELETE", "/currentUser/app/schedule",
+			map[string]interface{}{"scheduleId": id}, svr, token)
+		var jsonResponse defaultResponse
+		json.NewDecoder(resp.Body).Decode(&jsonResponse)
+		return &jsonResponse
+	}
+	assert.Equal(success.Code, cancel(canceledId).Code)
+	assert.Equal(errCodeMap[errScheduleNotFound], cancel(canceledId).Code)
+	assert.Equal(errCodeMap[errScheduleNotFound], cancel(100).Code)
+
+	// not due yet
+	svr.runDuePublishes(now)
+	assert.Equal("{}", published())
+
+	// the snapshot is published at its time
+	svr.runDuePublishes(now.Add(time.Hour))
+	assert.Equal(`{"v":1}`, published())
+	assert.Equal(db.ScheduleStatusDone, status(snapshotId))
+	assert.Equal(db.ScheduleStatusPending, status(draftId))
+
+	// the draft is published as it is at its time
+	svr.runDuePublishes(now.Add(4 * time.Hour))
+	assert.Equal(`{"v":3}`, published())
+	assert.Equal(db.ScheduleStatusDone, status(draftId))
+	assert.Equal(db.ScheduleStatusCanceled, status(canceledId))
+
+	// a schedule claimed by a crashed scheduler is retried after the lease
+	save(`{"v":4}`)
+	crashedId := scheduleId(schedule(now.Add(5*time.Hour), kScheduleSourceDraft))
+	won, _ := svr.publishScheduleService.Claim(crashedId, now.Add(5*time.Hour), kScheduleLease)
+	assert.True(won)
+	svr.runDuePublishes(now.Add(5*time.Hour + time.Minute))
+	assert.Equal(`{"v":3}`, published())
+	svr.runDuePublishes(now.Add(5*time.Hour + kScheduleLease + time.Minute))
+	assert.Equal(`{"v":4}`, published())
+	assert.Equal(db.ScheduleStatusDone, status(crashedId))
+
+	// invalid forms are never published, the same as handleAppSave
+	save(`{"forms":{"1st":{}}}`)
+	assert.Equal(errCodeMap[errInvalidParam], schedule(now.Add(6*time.Hour), kScheduleSourceSnapshot).Code)
+	invalidId := scheduleId(schedule(now.Add(6*time.Hour), kScheduleSourceDraft))
+	svr.runDuePublishes(now.Add(6 * time.Hour))
+	assert.Equal(`{"v":4}`, published())
+	assert.Equal(db.ScheduleStatusFailed, status(invalidId))
+
+	// a schedule which panics is finished as failed, rather than retried after every lease
+	svr.appPageService.NewPage(&db.AppPage{AppID: 0, Name: "home", Content: json.RawMessage(`{}`)})
+	save(`5`)
+	panickedId := scheduleId(schedule(now.Add(7*time.Hour), kScheduleSourceDraft))
+	svr.runDuePublishes(now.Add(7 * time.Hour))
+	assert.Equal(db.ScheduleStatusFailed, status(panickedId))
+	failed, _ := svr.publishScheduleService.Find(panickedId)
+	assert.Contains(failed.Error, "panic")
+	svr.runDuePublishes(now.Add(7*time.Hour + kScheduleLease + time.Minute))
+	assert.Equal(db.ScheduleStatusFailed, status(panickedId))
+	assert.Equal(`{"v":4}`, published())
+
+	// others could not see nor cancel the schedules
+	otherToken := addTestUser(svr, 1001, "bob")
+	pendingId := scheduleId(schedule(now.Add(time.Hour), kScheduleSourceDraft))
+	assertErrCode(t, errCodeMap[errEntryNotFound], doRequest("DELETE", "/currentUser/app/schedule",
+		map[string]interface{}{"scheduleId": pendingId}, svr, otherToken))
+	assertErrCode(t, errCodeMap[errEntryNotFound], doRequest("GET",
+		fmt.Sprintf("/currentUser/app/schedule?appId=%d", 0), nil, svr, otherToken))
+}
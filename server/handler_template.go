@@ -0,0 +1,171 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rtxu/luban-api/db"
+)
+
+// TemplateT 代表一个应用模板，可以是内置模板，也可以是用户从已有 app 保存的模板
+type TemplateT struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	BuiltIn     bool   `json:"builtIn"`
+
+	content json.RawMessage
+}
+
+func newUserTemplate(tpl db.AppTemplate) TemplateT {
+	return TemplateT{
+		Id:          strconv.FormatUint(uint64(tpl.ID), 10),
+		Name:        tpl.Name,
+		Description: tpl.Description,
+		content:     tpl.Content,
+	}
+}
+
+// findUserTemplate finds a user-saved template in the workspace
+func (s *server) findUserTemplate(ws workspace, id string) (db.AppTemplate, error) {
+	u64, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return db.AppTemplate{}, fmt.Errorf("%w: templateId is %s", errTemplateNotFound, id)
+	}
+	tpl, err := s.appTemplateService.Find(uint32(u64))
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return tpl, fmt.Errorf("%w: templateId is %s", errTemplateNotFound, id)
+		}
+		panic(err)
+	}
+	if !ws.owns(tpl.OwnerID, tpl.OrgID) {
+		return tpl, fmt.Errorf("%w: templateId is %s", errTemplateNotFound, id)
+	}
+	return tpl, nil
+}
+
+// findTemplate finds a builtin template or a user-saved template in the workspace
+func (s *server) findTemplate(ws workspace, id string) (TemplateT, error) {
+	if tpl, ok := findBuiltinTemplate(id); ok {
+		return tpl, nil
+	}
+	tpl, err := s.findUserTemplate(ws, id)
+	if err != nil {
+		return TemplateT{}, err
+	}
+	return newUserTemplate(tpl), nil
+}
+
+func (s *server) handleTemplateList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ws, err := s.getWorkspace(r)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		tpls, err := s.appTemplateService.ListByWorkspace(ws.user.ID, ws.orgId())
+		if err != nil {
+			panic(err)
+		}
+		data := make([]TemplateT, 0, len(builtinTemplates)+len(tpls))
+		data = append(data, builtinTemplates...)
+		for _, tpl := range tpls {
+			data = append(data, newUserTemplate(tpl))
+		}
+		s.respond(w, r, defaultResponse{Data: data}, http.StatusOK)
+	}
+}
+
+func (s *server) handleTemplateSave() http.HandlerFunc {
+	type request struct {
+		AppId       uint32 `json:"appId"`
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	type dataT struct {
+		Id string `json:"id"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		param.Name = strings.TrimSpace(param.Name)
+		if param.Name == "" {
+			s.respond(w, r, fmt.Errorf("%w: empty template name", errInvalidParam), http.StatusOK)
+			return
+		}
+
+		ws, err := s.getWorkspace(r)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := ws.checkRole(db.RoleEditor); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		// the draft is visible to editors only
+		app, err := s.findAppWithRole(r, param.AppId, db.RoleEditor)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		tpl := db.AppTemplate{
+			OwnerID:     ws.user.ID,
+			OrgID:       ws.orgId(),
+			Name:        param.Name,
+			Description: param.Description,
+			Content:     s.appSnapshot(app),
+		}
+		if err := s.appTemplateService.NewTemplate(&tpl); err != nil {
+			panic(err)
+		}
+		s.respond(w, r, defaultResponse{Data: dataT{Id: newUserTemplate(tpl).Id}}, http.StatusOK)
+	}
+}
+
+func (s *server) handleTemplateDelete() http.HandlerFunc {
+	type request struct {
+		TemplateId string `json:"templateId"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var param request
+		if err := s.decode(w, r, &param); err != nil {
+			s.respond(w, r, fmt.Errorf("%w: %v", errJsonDecode, err), http.StatusOK)
+			return
+		}
+		if _, ok := findBuiltinTemplate(param.TemplateId); ok {
+			s.respond(w, r, fmt.Errorf("%w: builtin template(%s) could not be deleted",
+				errInvalidParam, param.TemplateId), http.StatusOK)
+			return
+		}
+
+		ws, err := s.getWorkspace(r)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		if err := ws.checkRole(db.RoleEditor); err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+		tpl, err := s.findUserTemplate(ws, param.TemplateId)
+		if err != nil {
+			s.respond(w, r, err, http.StatusOK)
+			return
+		}
+
+		if err := s.appTemplateService.Delete(tpl.ID); err != nil {
+			panic(err)
+		}
+		s.respond(w, r, success, http.StatusOK)
+	}
+}
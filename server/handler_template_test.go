@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleTemplate(t *testing.T) {
+	assert := assert.New(t)
+	svr, token := newTestServer()
+	otherToken := addTestUser(svr, 1001, "bob")
+
+	loadDraft := func(appId uint32) interface{} {
+		resp := assertErrCode(t, success.Code, doRequest("GET",
+			fmt.Sprintf("/currentUser/app?appId=%d&loadType=%s", appId, kLTEdit), nil, svr, token))
+		return resp.Data
+	}
+	createFromTemplate := func(name, templateId, token string) int {
+		resp := createEntry(createRequest{
+			Dir:        "/",
+			Entry:      EntryT{Name: name, Type: App},
+			TemplateId: templateId,
+		}, svr, token)
+		var jsonResponse defaultResponse
+		json.NewDecoder(resp.Body).Decode(&jsonResponse)
+		return jsonResponse.Code
+	}
+
+	// create from builtin template
+	assert.Equal(success.Code, createFromTemplate("form", "form", token))
+	tpl, _ := findBuiltinTemplate("form")
+	var builtinContent interface{}
+	json.Unmarshal(tpl.content, &builtinContent)
+	assert.Equal(builtinContent, loadDraft(0))
+
+	// create from not exist template
+	assert.Equal(errCodeMap[errTemplateNotFound], createFromTemplate("bad", "not_exist", token))
+
+	// save an existing app as template
+	saveContent := map[string]interface{}{"widgets": map[string]interface{}{"w1": map[string]interface{}{}}}
+	assertErrCode(t, success.Code, doRequest("PUT",
+		fmt.Sprintf("/currentUser/app?appId=0&op=%s", kOpSave), saveContent, svr, token))
+	assertErrCode(t, errCodeMap[errInvalidParam], doRequest("POST", "/currentUser/template",
+		map[string]interface{}{"appId": 0, "name": ""}, svr, token))
+	assertErrCode(t, errCodeMap[errEntryNotFound], doRequest("POST", "/currentUser/template",
+		map[string]interface{}{"appId": 0, "name": "stolen"}, svr, otherToken))
+	resp := assertErrCode(t, success.Code, doRequest("POST", "/currentUser/template",
+		map[string]interface{}{"appId": 0, "name": "my form", "description": "desc"}, svr, token))
+	templateId := resp.Data.(map[string]interface{})["id"].(string)
+
+	// list
+	resp = assertErrCode(t, success.Code, doRequest("GET", "/currentUser/template", nil, svr, token))
+	templates := resp.Data.([]interface{})
+	assert.Len(templates, len(builtinTemplates)+1)
+	assert.Equal(map[string]interface{}{
+		"id":          templateId,
+		"name":        "my form",
+		"description": "desc",
+		"builtIn":     false,
+	}, templates[len(templates)-1])
+	resp = assertErrCode(t, success.Code, doRequest("GET", "/currentUser/template", nil, svr, otherToken))
+	assert.Len(resp.Data, len(builtinTemplates))
+
+	// create from user template, which is invisible to others
+	assert.Equal(success.Code, createFromTemplate("copy", templateId, token))
+	assert.Equal(saveContent, loadDraft(1))
+	assert.Equal(errCodeMap[errTemplateNotFound], createFromTemplate("copy", templateId, otherToken))
+
+	// delete
+	assertErrCode(t, errCodeMap[errInvalidParam], doRequest("DELETE", "/currentUser/template",
+		map[string]interface{}{"templateId": "blank"}, svr, token))
+	assertErrCode(t, errCodeMap[errTemplateNotFound], doRequest("DELETE", "/currentUser/template",
+		map[string]interface{}{"templateId": templateId}, svr, otherToken))
+	assertErrCode(t, success.Code, doRequest("DELETE", "/currentUser/template",
+		map[string]interface{}{"templateId": templateId}, svr, token))
+	assert.Equal(errCodeMap[errTemplateNotFound], createFromTemplate("copy2", templateId, token))
+}
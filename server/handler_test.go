@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/rtxu/luban-api/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleGithubLogin(t *testing.T) {
+	assert := assert.New(t)
+	svr, _ := newTestServer()
+	svr.conf.AppRoot = "https://luban.example.com"
+	svr.conf.GithubOAuth.ClientID = "test_client_id"
+	svr.conf.GithubOAuth.ClientSecret = "test_client_secret"
+	login := func(code string) *url.URL {
+		rec, err := request.NewRecorder("testdata/github_login.json", request.ModeReplay)
+		if err != nil {
+			t.Fatal(err)
+		}
+		svr.httpClient = rec.Client()
+		resp := doRequest("GET", "/callback/github/login?code="+code, nil, svr, "")
+		assert.Equal(http.StatusSeeOther, resp.StatusCode)
+		location, _ := resp.Location()
+		return location
+	}
+
+	// sign up
+	location := login("test_code")
+	assert.Equal("/login-success", location.Path)
+	assert.Contains(location.Query().Get("access_token"), "Bearer ")
+	user, err := svr.userService.FindByGithubUserName("octocat")
+	assert.NoError(err)
+	assert.Equal("https://avatars.githubusercontent.com/u/583231", *user.AvatarUrl)
+
+	// log in again
+	assert.Equal("/login-success", login("test_code").Path)
+
+	// GitHub rejects the code with 200 and an error
+	location = login("bad_code")
+	assert.Equal("/login", location.Path)
+	assert.NotEmpty(location.Query().Get("loginError"))
+}
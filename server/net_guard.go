@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/rtxu/luban-api/request"
+)
+
+// blockedNetworks are the networks which data sources could not connect to, otherwise
+// an editor could reach the services next to luban-api, e.g. the metadata of the cloud
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	// link-local, including the metadata service 169.254.169.254
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+var errBlockedAddress = errors.New("address is not allowed")
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// checkDialAddress is the Control of the dialer of data sources, it's called with the resolved
+// address right before connecting, so redirects and DNS rebinding are checked as well
+func checkDialAddress(network, address string, _ syscall.RawConn) error {
+	if network != "tcp4" && network != "tcp6" {
+		return fmt.Errorf("%w: network %s", errBlockedAddress, network)
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || isBlockedIP(ip) {
+		return fmt.Errorf("%w: %s", errBlockedAddress, address)
+	}
+	return nil
+}
+
+func isBlockedIP(ip net.IP) bool {
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// dataSourceDialer connects to the hosts of data sources, REST or SQL
+var dataSourceDialer = &net.Dialer{
+	Timeout:   30 * time.Second,
+	KeepAlive: 30 * time.Second,
+	Control:   checkDialAddress,
+}
+
+// newQueryClient returns the client sending the queries of REST data sources,
+// proxies are not used as they would connect to the hosts instead
+func newQueryClient() *http.Client {
+	return &http.Client{
+		// the same as request.DefaultClient, a hanging data source does not hold the handler
+		Timeout: request.DefaultTimeout,
+		Transport: &http.Transport{
+			DialContext:           dataSourceDialer.DialContext,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: request.DefaultTimeout,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+	}
+}
+
+// pqDialer adapts dataSourceDialer to lib/pq
+type pqDialer struct{}
+
+func (pqDialer) Dial(network, address string) (net.Conn, error) {
+	return dataSourceDialer.Dial(network, address)
+}
+
+func (pqDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return dataSourceDialer.DialContext(ctx, network, address)
+}
+
+func (pqDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return dataSourceDialer.DialContext(ctx, network, address)
+}
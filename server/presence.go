@@ -0,0 +1,125 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// an editor without heartbeat for kPresenceTTL is considered gone
+	kPresenceTTL = 30 * time.Second
+	// heartbeats of the holder renew the lock
+	kEditLockTTL = 2 * time.Minute
+)
+
+// editLock is an advisory lock on an app, saves from others are rejected until it expires
+type editLock struct {
+	userId   uint32
+	expireAt time.Time
+}
+
+type presenceT struct {
+	userId   uint32
+	lastSeen time.Time
+}
+
+// presenceTracker 记录每个 app 当前的编辑者及编辑锁，仅保存在内存中，
+// 多实例部署时需要改为集中存储
+type presenceTracker struct {
+	mu sync.Mutex
+	// replaced under unit-test enviroment
+	now        func() time.Time
+	heartbeats map[uint32]map[uint32]time.Time
+	locks      map[uint32]editLock
+}
+
+func newPresenceTracker() *presenceTracker {
+	return &presenceTracker{
+		now:        time.Now,
+		heartbeats: make(map[uint32]map[uint32]time.Time),
+		locks:      make(map[uint32]editLock),
+	}
+}
+
+func (p *presenceTracker) heartbeat(appId, userId uint32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	if _, ok := p.heartbeats[appId]; !ok {
+		p.heartbeats[appId] = make(map[uint32]time.Time)
+	}
+	p.heartbeats[appId][userId] = now
+	if lock, ok := p.lockLocked(appId); ok && lock.userId == userId {
+		p.locks[appId] = editLock{userId: userId, expireAt: now.Add(kEditLockTTL)}
+	}
+}
+
+// activeEditors lists editors of app in the order of their last heartbeat, the latest first
+func (p *presenceTracker) activeEditors(appId uint32) []presenceT {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	var editors []presenceT
+	for userId, lastSeen := range p.heartbeats[appId] {
+		if now.Sub(lastSeen) > kPresenceTTL {
+			delete(p.heartbeats[appId], userId)
+			continue
+		}
+		editors = append(editors, presenceT{userId: userId, lastSeen: lastSeen})
+	}
+	if len(p.heartbeats[appId]) == 0 {
+		delete(p.heartbeats, appId)
+	}
+	sort.Slice(editors, func(i, j int) bool {
+		return editors[i].lastSeen.After(editors[j].lastSeen)
+	})
+	return editors
+}
+
+// lockLocked returns the unexpired lock of app
+func (p *presenceTracker) lockLocked(appId uint32) (editLock, bool) {
+	lock, ok := p.locks[appId]
+	if !ok {
+		return lock, false
+	}
+	if !p.now().Before(lock.expireAt) {
+		delete(p.locks, appId)
+		return lock, false
+	}
+	return lock, true
+}
+
+func (p *presenceTracker) lock(appId uint32) (editLock, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lockLocked(appId)
+}
+
+// acquireLock acquires or renews the lock of app for user, force takes over the lock from others.
+// It returns the current lock and whether user holds it.
+func (p *presenceTracker) acquireLock(appId, userId uint32, force bool) (editLock, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if lock, ok := p.lockLocked(appId); ok && lock.userId != userId && !force {
+		return lock, false
+	}
+	lock := editLock{userId: userId, expireAt: p.now().Add(kEditLockTTL)}
+	p.locks[appId] = lock
+	return lock, true
+}
+
+// releaseLock releases the lock of app if user holds it
+func (p *presenceTracker) releaseLock(appId, userId uint32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if lock, ok := p.lockLocked(appId); ok && lock.userId == userId {
+		delete(p.locks, appId)
+	}
+}
+
+// lockedByOthers returns the lock of app if it is held by others than user
+func (p *presenceTracker) lockedByOthers(appId, userId uint32) (editLock, bool) {
+	lock, ok := p.lock(appId)
+	return lock, ok && lock.userId != userId
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	// tells whether the result is cached, HIT or MISS
+	kQueryCacheHeader = "X-Query-Cache"
+	kQueryCacheMaxTTL = time.Hour
+	// entries beyond are not cached until the expired ones are purged
+	kQueryCacheMaxEntries = 10000
+)
+
+type queryCacheEntry struct {
+	appId        uint32
+	name         string
+	dataSourceId uint32
+	value        interface{}
+	expireAt     time.Time
+}
+
+// queryCache 缓存数据查询的结果，key 由 query 及求值后的请求决定，仅保存在内存中，
+// 多实例部署时各实例分别缓存
+type queryCache struct {
+	mu sync.Mutex
+	// replaced under unit-test enviroment
+	now     func() time.Time
+	entries map[string]queryCacheEntry
+}
+
+func newQueryCache() *queryCache {
+	return &queryCache{
+		now:     time.Now,
+		entries: make(map[string]queryCacheEntry),
+	}
+}
+
+// queryCacheKey hashes everything deciding the result, i.e. the query and the request
+// rendered with the input and viewer, so results of different viewers are never mixed up
+func queryCacheKey(appId uint32, name string, request ...interface{}) string {
+	bytes, err := json.Marshal(append([]interface{}{appId, name}, request...))
+	if err != nil {
+		panic(err)
+	}
+	sum := sha256.Sum256(bytes)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *queryCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !c.now().Before(entry.expireAt) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *queryCache) set(key string, entry queryCacheEntry, ttl time.Duration) {
+	if ttl > kQueryCacheMaxTTL {
+		ttl = kQueryCacheMaxTTL
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if len(c.entries) >= kQueryCacheMaxEntries {
+		for k, e := range c.entries {
+			if !now.Before(e.expireAt) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= kQueryCacheMaxEntries {
+			return
+		}
+	}
+	entry.expireAt = now.Add(ttl)
+	c.entries[key] = entry
+}
+
+func (c *queryCache) invalidate(match func(e queryCacheEntry) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for k, e := range c.entries {
+		if match(e) {
+			delete(c.entries, k)
+			n++
+		}
+	}
+	return n
+}
+
+// invalidateQuery drops the results of a query, which is changed or deleted
+func (c *queryCache) invalidateQuery(appId uint32, name string) int {
+	return c.invalidate(func(e queryCacheEntry) bool {
+		return e.appId == appId && e.name == name
+	})
+}
+
+// invalidateDataSource drops the results of all queries on a data source
+func (c *queryCache) invalidateDataSource(id uint32) int {
+	return c.invalidate(func(e queryCacheEntry) bool {
+		return e.dataSourceId == id
+	})
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/jwtauth"
+)
+
+// 数据查询的 path、params、body 及数据源的 header 支持模板，如 {{ input.page }}、{{ viewer.user_id }}，
+// 由服务端在执行查询时求值。模板只能引用白名单内的变量：
+//   - input.<name>: 执行查询时页面传入的 params
+//   - viewer.user_id, viewer.username: 来自当前用户的 JWT claims
+// SQL 中不支持模板，只能通过 :name 引用 params，其值总是作为参数绑定，不会拼接进 SQL
+
+const (
+	kTemplateInput  = "input"
+	kTemplateViewer = "viewer"
+)
+
+// viewer fields are the JWT claims of the same name
+var templateViewerFields = []string{kTokenClaimUserId, kTokenClaimUserName}
+
+var templateVarRegexp = regexp.MustCompile(`^([a-z]+)\.([A-Za-z_][A-Za-z0-9_]*)$`)
+
+type templatePart struct {
+	literal string
+	// namespace and name of the variable, empty for a literal
+	namespace string
+	name      string
+}
+
+func parseTemplate(tmpl string) ([]templatePart, error) {
+	var parts []templatePart
+	for {
+		start := strings.Index(tmpl, "{{")
+		if start < 0 {
+			if strings.Contains(tmpl, "}}") {
+				return nil, fmt.Errorf("%w: unmatched '}}' in template", errInvalidParam)
+			}
+			if tmpl != "" {
+				parts = append(parts, templatePart{literal: tmpl})
+			}
+			return parts, nil
+		}
+		end := strings.Index(tmpl[start:], "}}")
+		if end < 0 || strings.Contains(tmpl[:start], "}}") {
+			return nil, fmt.Errorf("%w: unmatched '{{' in template", errInvalidParam)
+		}
+		end += start
+		if start > 0 {
+			parts = append(parts, templatePart{literal: tmpl[:start]})
+		}
+		variable := strings.TrimSpace(tmpl[start+2 : end])
+		m := templateVarRegexp.FindStringSubmatch(variable)
+		if m == nil {
+			return nil, fmt.Errorf("%w: invalid template variable(%s)", errInvalidParam, variable)
+		}
+		switch m[1] {
+		case kTemplateInput:
+		case kTemplateViewer:
+			known := false
+			for _, field := range templateViewerFields {
+				known = known || field == m[2]
+			}
+			if !known {
+				return nil, fmt.Errorf("%w: unknown template variable(%s)", errInvalidParam, variable)
+			}
+		default:
+			return nil, fmt.Errorf("%w: unknown template variable(%s)", errInvalidParam, variable)
+		}
+		parts = append(parts, templatePart{namespace: m[1], name: m[2]})
+		tmpl = tmpl[end+2:]
+	}
+}
+
+func hasTemplate(s string) bool {
+	return strings.Contains(s, "{{")
+}
+
+// checkTemplate checks the syntax of tmpl when saving
+func checkTemplate(tmpl string) error {
+	_, err := parseTemplate(tmpl)
+	return err
+}
+
+func checkTemplateMap(m map[string]string) error {
+	for _, v := range m {
+		if err := checkTemplate(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkTemplateJSON checks the templates in the string values of body
+func checkTemplateJSON(body json.RawMessage) error {
+	if body == nil {
+		return nil
+	}
+	_, err := renderTemplateJSON(body, nil)
+	return err
+}
+
+// templateContext 是模板求值时可以引用的变量
+type templateContext struct {
+	input  map[string]string
+	viewer map[string]string
+}
+
+func newTemplateContext(r *http.Request, input map[string]string) *templateContext {
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	viewer := make(map[string]string)
+	for _, field := range templateViewerFields {
+		switch v := claims[field].(type) {
+		case string:
+			viewer[field] = v
+		case float64:
+			viewer[field] = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+	if input == nil {
+		input = make(map[string]string)
+	}
+	return &templateContext{input: input, viewer: viewer}
+}
+
+func (c *templateContext) lookup(part templatePart) (string, error) {
+	vars := c.input
+	if part.namespace == kTemplateViewer {
+		vars = c.viewer
+	}
+	v, ok := vars[part.name]
+	if !ok {
+		return "", fmt.Errorf("%w: unbound template variable(%s.%s)", errInvalidParam, part.namespace, part.name)
+	}
+	return v, nil
+}
+
+// render evaluates tmpl, escape is applied to the values of variables, nil for no escaping.
+// c is nil when only checking the syntax.
+func (c *templateContext) render(tmpl string, escape func(string) string) (string, error) {
+	parts, err := parseTemplate(tmpl)
+	if err != nil || c == nil {
+		return tmpl, err
+	}
+	var b strings.Builder
+	for _, part := range parts {
+		if part.namespace == "" {
+			b.WriteString(part.literal)
+			continue
+		}
+		v, err := c.lookup(part)
+		if err != nil {
+			return "", err
+		}
+		if escape != nil {
+			v = escape(v)
+		}
+		b.WriteString(v)
+	}
+	return b.String(), nil
+}
+
+// renderPath evaluates the path of a query, values are escaped as path segments
+func (c *templateContext) renderPath(path string) (string, error) {
+	return c.render(path, url.PathEscape)
+}
+
+// renderHeader evaluates a header value, which could not be split into more headers
+func (c *templateContext) renderHeader(value string) (string, error) {
+	v, err := c.render(value, nil)
+	if err != nil {
+		return "", err
+	}
+	if strings.ContainsAny(v, "\r\n") {
+		return "", fmt.Errorf("%w: header value contains line breaks", errInvalidParam)
+	}
+	return v, nil
+}
+
+// renderTemplateJSON evaluates the templates in the string values of body, the values of
+// variables are JSON-encoded as a part of the strings, so they could not change the structure
+func renderTemplateJSON(body json.RawMessage, c *templateContext) (json.RawMessage, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	// keeps numbers as they are
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, fmt.Errorf("%w: invalid body, err: %v", errInvalidParam, err)
+	}
+	v, err := c.renderValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+func (c *templateContext) renderValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return c.render(v, nil)
+	case []interface{}:
+		for i := range v {
+			var err error
+			if v[i], err = c.renderValue(v[i]); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]interface{}:
+		for k := range v {
+			var err error
+			if v[k], err = c.renderValue(v[k]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+// mergeQueryParams merges the params of the data source, the query and the input in order.
+// Templates in the former two are evaluated, and such params could not be overridden by
+// the input, otherwise a viewer could escape e.g. a filter bound to viewer.user_id.
+func (c *templateContext) mergeQueryParams(dsParams, queryParams map[string]string) (map[string]string, error) {
+	params := make(map[string]string)
+	for _, m := range []map[string]string{dsParams, queryParams} {
+		for k, v := range m {
+			params[k] = v
+		}
+	}
+	for k := range c.input {
+		if hasTemplate(params[k]) {
+			return nil, fmt.Errorf("%w: param(%s) is bound by template", errInvalidParam, k)
+		}
+	}
+	for k, v := range params {
+		rendered, err := c.render(v, nil)
+		if err != nil {
+			return nil, err
+		}
+		params[k] = rendered
+	}
+	for k, v := range c.input {
+		params[k] = v
+	}
+	return params, nil
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rtxu/luban-api/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	assert := assert.New(t)
+	c := &templateContext{
+		input:  map[string]string{"id": "a/b", "q": `"x"`},
+		viewer: map[string]string{"user_id": "9527", "username": "test_user"},
+	}
+
+	v, err := c.render("users/{{ viewer.user_id }}/{{input.q}}", nil)
+	assert.NoError(err)
+	assert.Equal(`users/9527/"x"`, v)
+	v, err = c.renderPath("items/{{input.id}}")
+	assert.NoError(err)
+	assert.Equal("items/a%2Fb", v)
+
+	// syntax errors, unknown and unbound variables
+	for _, tmpl := range []string{
+		"{{input.id", "input.id}}", "{{}}", "{{ input }}", "{{env.HOME}}", "{{viewer.password}}",
+		"{{input.id | upper}}",
+	} {
+		assert.Error(checkTemplate(tmpl), tmpl)
+	}
+	_, err = c.render("{{input.page}}", nil)
+	assert.Error(err)
+
+	// values could not change the structure of body
+	body, err := renderTemplateJSON(json.RawMessage(`{"q":"{{input.q}}","n":1.50,"tags":["{{viewer.username}}"]}`), c)
+	assert.NoError(err)
+	assert.JSONEq(`{"q":"\"x\"","n":1.50,"tags":["test_user"]}`, string(body))
+	assert.Contains(string(body), "1.50")
+
+	// line breaks could not inject headers
+	c.input["name"] = "x\r\nX-Admin: 1"
+	_, err = c.renderHeader("{{input.name}}")
+	assert.Error(err)
+}
+
+func TestHandleTemplatedQuery(t *testing.T) {
+	assert := assert.New(t)
+	svr, token := newTestServer()
+	const kBobId = 1001
+	bobToken := addTestUser(svr, kBobId, "bob")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"path":  r.URL.EscapedPath(),
+			"query": r.URL.Query(),
+			"user":  r.Header.Get("X-User"),
+			"body":  string(body),
+		})
+	}))
+	defer ts.Close()
+
+	createEntry(createRequest{
+		Dir:   "/",
+		Entry: EntryT{Name: "entry1", Type: App},
+	}, svr, token)
+	svr.appACLService.Grant(0, kBobId, db.RoleViewer)
+	assertErrCode(t, errCodeMap[errInvalidParam], doRequest("POST", "/currentUser/dataSource", map[string]interface{}{
+		"name": "api", "baseUrl": ts.URL, "headers": map[string]string{"X-User": "{{env.USER}}"},
+	}, svr, token))
+	assertErrCode(t, success.Code, doRequest("POST", "/currentUser/dataSource", map[string]interface{}{
+		"name": "api", "baseUrl": ts.URL, "headers": map[string]string{"X-User": "{{viewer.username}}"},
+	}, svr, token))
+	saveQuery := func(query map[string]interface{}) *http.Response {
+		query["appId"] = 0
+		query["dataSourceId"] = 0
+		return doRequest("PUT", "/currentUser/app/query", query, svr, token)
+	}
+	run := func(name string, params map[string]string) defaultResponse {
+		resp := doRequest("POST", "/currentUser/app/query/run",
+			map[string]interface{}{"appId": 0, "name": name, "params": params}, svr, bobToken)
+		var jsonResponse defaultResponse
+		json.NewDecoder(resp.Body).Decode(&jsonResponse)
+		return jsonResponse
+	}
+
+	assertErrCode(t, errCodeMap[errInvalidParam], saveQuery(map[string]interface{}{
+		"name": "bad", "path": "orders/{{input.id"}))
+	assertErrCode(t, errCodeMap[errInvalidParam], saveQuery(map[string]interface{}{
+		"name": "bad", "path": "orders", "body": map[string]string{"q": "{{secret.key}}"}}))
+	assertErrCode(t, success.Code, saveQuery(map[string]interface{}{
+		"name":   "orders",
+		"method": "POST",
+		"path":   "users/{{viewer.user_id}}/orders/{{input.id}}",
+		"params": map[string]string{"owner": "{{viewer.username}}", "page": "1"},
+		"body":   map[string]string{"note": "by {{viewer.username}}: {{input.note}}"},
+	}))
+
+	result := run("orders", map[string]string{"id": "a/b", "note": `"hi"`, "page": "2"})
+	assert.Equal(success.Code, result.Code)
+	data := result.Data.(map[string]interface{})["data"].(map[string]interface{})
+	assert.Equal("/users/1001/orders/a%2Fb", data["path"])
+	assert.Equal("bob", data["user"])
+	assert.JSONEq(`{"note":"by bob: \"hi\""}`, data["body"].(string))
+	query := data["query"].(map[string]interface{})
+	assert.Equal([]interface{}{"bob"}, query["owner"])
+	assert.Equal([]interface{}{"2"}, query["page"])
+
+	// unbound variables, params bound by templates, and paths escaping the base url
+	assert.Equal(errCodeMap[errInvalidParam], run("orders", map[string]string{"note": "x"}).Code)
+	assert.Equal(errCodeMap[errInvalidParam], run("orders",
+		map[string]string{"id": "1", "note": "x", "owner": "alice"}).Code)
+	assert.Equal(errCodeMap[errInvalidParam], run("orders", map[string]string{"id": "..", "note": "x"}).Code)
+}
+
+func TestHandleTemplatedSQLQuery(t *testing.T) {
+	assert := assert.New(t)
+	svr, token := newTestServer()
+	const kBobId = 1001
+	bobToken := addTestUser(svr, kBobId, "bob")
+
+	dir, err := ioutil.TempDir("", "sql_query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dsn := filepath.Join(dir, "test.db")
+	conn, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, stmt := range []string{
+		`CREATE TABLE todo (id INTEGER PRIMARY KEY, owner TEXT, title TEXT)`,
+		`INSERT INTO todo VALUES (1, 'bob', 'a'), (2, 'alice', 'b')`,
+	} {
+		if _, err := conn.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	createEntry(createRequest{
+		Dir:   "/",
+		Entry: EntryT{Name: "entry1", Type: App},
+	}, svr, token)
+	svr.appACLService.Grant(0, kBobId, db.RoleViewer)
+	assertErrCode(t, success.Code, doRequest("POST", "/currentUser/dataSource", map[string]interface{}{
+		"name": "db", "type": db.DataSourceTypeSQL, "driver": "sqlite3", "dsn": dsn,
+	}, svr, token))
+	saveQuery := func(sql string, params map[string]string) *http.Response {
+		return doRequest("PUT", "/currentUser/app/query", map[string]interface{}{
+			"appId": 0, "name": "mine", "dataSourceId": 0, "sql": sql, "params": params,
+		}, svr, token)
+	}
+	run := func(params map[string]string) defaultResponse {
+		resp := doRequest("POST", "/currentUser/app/query/run",
+			map[string]interface{}{"appId": 0, "name": "mine", "params": params}, svr, bobToken)
+		var jsonResponse defaultResponse
+		json.NewDecoder(resp.Body).Decode(&jsonResponse)
+		return jsonResponse
+	}
+
+	// templates are never concatenated into sql
+	assertErrCode(t, errCodeMap[errInvalidParam], saveQuery(
+		"SELECT id FROM todo WHERE owner = '{{viewer.username}}'", nil))
+	assertErrCode(t, success.Code, saveQuery("SELECT id FROM todo WHERE owner = :owner",
+		map[string]string{"owner": "{{viewer.username}}"}))
+
+	result := run(nil)
+	assert.Equal(success.Code, result.Code)
+	assert.Equal([]interface{}{[]interface{}{float64(1)}}, result.Data.(map[string]interface{})["rows"])
+	assert.Equal(errCodeMap[errInvalidParam], run(map[string]string{"owner": "alice"}).Code)
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TransformT 描述如何裁剪 REST 查询的响应，前端只拿到需要的部分。依次执行：
+//  1. Pagination 不为空时，先从完整响应中取出分页信息
+//  2. 按 Path 取出响应的一部分
+//  3. 按 Rename 重命名取出的对象（或数组中每个对象）的字段
+type TransformT struct {
+	// Path is a subset of JSONPath, e.g. $.data.items[*].user, data.items[0],
+	// [*] projects the rest of the path on every element of an array
+	Path string `json:"path,omitempty"`
+	// Rename maps old field names to new ones
+	Rename map[string]string `json:"rename,omitempty"`
+	// Pagination normalizes the result into {items, total, nextCursor, hasMore}
+	Pagination *PaginationT `json:"pagination,omitempty"`
+}
+
+// PaginationT 中的 path 均相对于完整响应，为空时对应的字段为 null
+type PaginationT struct {
+	Total      string `json:"total,omitempty"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+type pageT struct {
+	Items      interface{} `json:"items"`
+	Total      interface{} `json:"total"`
+	NextCursor interface{} `json:"nextCursor"`
+	HasMore    bool        `json:"hasMore"`
+}
+
+// jsonPathSegment is one of .name, [n] and [*]
+type jsonPathSegment struct {
+	field    string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+func parseJSONPath(path string) ([]jsonPathSegment, error) {
+	invalid := fmt.Errorf("%w: invalid path(%s)", errInvalidParam, path)
+	p := strings.TrimPrefix(path, "$")
+	var segs []jsonPathSegment
+	for p != "" {
+		switch {
+		case p[0] == '[':
+			end := strings.IndexByte(p, ']')
+			if end < 0 {
+				return nil, invalid
+			}
+			inner := p[1:end]
+			if inner == "*" {
+				segs = append(segs, jsonPathSegment{wildcard: true})
+			} else {
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, invalid
+				}
+				segs = append(segs, jsonPathSegment{index: index, isIndex: true})
+			}
+			p = p[end+1:]
+		default:
+			// the leading dot could be omitted
+			if p[0] == '.' {
+				p = p[1:]
+			} else if len(segs) > 0 {
+				return nil, invalid
+			}
+			end := strings.IndexAny(p, ".[")
+			if end < 0 {
+				end = len(p)
+			}
+			if end == 0 {
+				return nil, invalid
+			}
+			segs = append(segs, jsonPathSegment{field: p[:end]})
+			p = p[end:]
+		}
+	}
+	return segs, nil
+}
+
+// evalJSONPath returns nil when path does not match, like JMESPath
+func evalJSONPath(v interface{}, segs []jsonPathSegment) interface{} {
+	for i, seg := range segs {
+		switch {
+		case seg.wildcard:
+			arr, ok := v.([]interface{})
+			if !ok {
+				return nil
+			}
+			projected := make([]interface{}, 0, len(arr))
+			for _, elem := range arr {
+				if x := evalJSONPath(elem, segs[i+1:]); x != nil {
+					projected = append(projected, x)
+				}
+			}
+			return projected
+		case seg.isIndex:
+			arr, ok := v.([]interface{})
+			if !ok {
+				return nil
+			}
+			index := seg.index
+			if index < 0 {
+				index += len(arr)
+			}
+			if index < 0 || index >= len(arr) {
+				return nil
+			}
+			v = arr[index]
+		default:
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			v = obj[seg.field]
+		}
+	}
+	return v
+}
+
+func renameFields(v interface{}, rename map[string]string) interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		for i := range v {
+			v[i] = renameFields(v[i], rename)
+		}
+	case map[string]interface{}:
+		renamed := make(map[string]interface{}, len(v))
+		for k, x := range v {
+			if to, ok := rename[k]; ok {
+				k = to
+			}
+			renamed[k] = x
+		}
+		return renamed
+	}
+	return v
+}
+
+func (t *TransformT) check() error {
+	paths := []string{t.Path}
+	if t.Pagination != nil {
+		paths = append(paths, t.Pagination.Total, t.Pagination.NextCursor)
+	}
+	for _, path := range paths {
+		if _, err := parseJSONPath(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func evalOptionalPath(v interface{}, path string) interface{} {
+	if path == "" {
+		return nil
+	}
+	segs, _ := parseJSONPath(path)
+	return evalJSONPath(v, segs)
+}
+
+// apply reshapes body, which should be JSON
+func (t *TransformT) apply(body []byte) (json.RawMessage, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	// keeps numbers as they are
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, fmt.Errorf("%w: could not transform a non-JSON response", errQueryFailed)
+	}
+	segs, err := parseJSONPath(t.Path)
+	if err != nil {
+		return nil, err
+	}
+	result := renameFields(evalJSONPath(v, segs), t.Rename)
+	if t.Pagination != nil {
+		if result == nil {
+			result = make([]interface{}, 0)
+		}
+		page := pageT{
+			Items:      result,
+			Total:      evalOptionalPath(v, t.Pagination.Total),
+			NextCursor: evalOptionalPath(v, t.Pagination.NextCursor),
+		}
+		page.HasMore = page.NextCursor != nil && page.NextCursor != "" && page.NextCursor != false
+		return json.Marshal(page)
+	}
+	return json.Marshal(result)
+}
+
+func decodeTransform(raw json.RawMessage) *TransformT {
+	if raw == nil {
+		return nil
+	}
+	var t TransformT
+	if err := json.Unmarshal(raw, &t); err != nil {
+		panic(err)
+	}
+	return &t
+}
+
+func encodeTransform(t *TransformT) json.RawMessage {
+	if t == nil {
+		return nil
+	}
+	bytes, _ := json.Marshal(t)
+	return bytes
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rtxu/luban-api/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransform(t *testing.T) {
+	assert := assert.New(t)
+	body := []byte(`{
+		"data": {"items": [{"id": 1, "user": {"login": "alice"}}, {"id": 2.50, "user": {"login": "bob"}}, {"id": 3}]},
+		"meta": {"total": 3, "next": "abc"}
+	}`)
+	for _, c := range []struct {
+		transform TransformT
+		expected  string
+	}{
+		{TransformT{}, string(body)},
+		{TransformT{Path: "$.data.items[*].user.login"}, `["alice","bob"]`},
+		{TransformT{Path: "data.items[-1]"}, `{"id":3}`},
+		{TransformT{Path: "data.items[5]"}, `null`},
+		{TransformT{Path: "$.meta.total.x"}, `null`},
+		{TransformT{Path: "data.items[1]", Rename: map[string]string{"id": "key"}},
+			`{"key":2.50,"user":{"login":"bob"}}`},
+		{TransformT{
+			Path:       "data.items[*].user",
+			Rename:     map[string]string{"login": "name"},
+			Pagination: &PaginationT{Total: "meta.total", NextCursor: "meta.next"},
+		}, `{"items":[{"name":"alice"},{"name":"bob"}],"total":3,"nextCursor":"abc","hasMore":true}`},
+		{TransformT{Path: "not_exist", Pagination: &PaginationT{}},
+			`{"items":[],"total":null,"nextCursor":null,"hasMore":false}`},
+	} {
+		result, err := c.transform.apply(body)
+		assert.NoError(err)
+		assert.JSONEq(c.expected, string(result), c.transform.Path)
+	}
+	// numbers are kept as they are
+	result, _ := (&TransformT{Path: "data.items[1].id"}).apply(body)
+	assert.Equal("2.50", string(result))
+
+	for _, path := range []string{"data..items", "data[x]", "data[1", "[0]name", "$."} {
+		assert.Error((&TransformT{Path: path}).check(), path)
+	}
+	_, err := (&TransformT{}).apply([]byte("not json"))
+	assert.Error(err)
+}
+
+func TestHandleQueryCache(t *testing.T) {
+	assert := assert.New(t)
+	svr, token := newTestServer()
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&count, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"items": []map[string]interface{}{{"n": n, "q": r.URL.Query().Get("q")}},
+		})
+	}))
+	defer ts.Close()
+	now := time.Now()
+	svr.queryCache.now = func() time.Time { return now }
+
+	createEntry(createRequest{
+		Dir:   "/",
+		Entry: EntryT{Name: "entry1", Type: App},
+	}, svr, token)
+	assertErrCode(t, success.Code, doRequest("POST", "/currentUser/dataSource",
+		map[string]interface{}{"name": "api", "baseUrl": ts.URL}, svr, token))
+	saveQuery := func(query map[string]interface{}) *http.Response {
+		query["appId"] = 0
+		query["dataSourceId"] = 0
+		query["name"] = "items"
+		return doRequest("PUT", "/currentUser/app/query", query, svr, token)
+	}
+	run := func(q string) (defaultResponse, string) {
+		resp := doRequest("POST", "/currentUser/app/query/run",
+			map[string]interface{}{"appId": 0, "name": "items", "params": map[string]string{"q": q}}, svr, token)
+		var jsonResponse defaultResponse
+		json.NewDecoder(resp.Body).Decode(&jsonResponse)
+		return jsonResponse, resp.Header.Get(kQueryCacheHeader)
+	}
+
+	// only GET queries could be cached, and ttl is limited
+	assertErrCode(t, errCodeMap[errInvalidParam], saveQuery(map[string]interface{}{
+		"method": "POST", "cacheTtl": 60}))
+	assertErrCode(t, errCodeMap[errInvalidParam], saveQuery(map[string]interface{}{
+		"cacheTtl": 7200}))
+	assertErrCode(t, errCodeMap[errInvalidParam], saveQuery(map[string]interface{}{
+		"transform": map[string]interface{}{"path": "items[x]"}}))
+	assertErrCode(t, success.Code, saveQuery(map[string]interface{}{
+		"cacheTtl":  60,
+		"transform": map[string]interface{}{"path": "items[*].n"},
+	}))
+	resp := assertErrCode(t, success.Code, doRequest("GET", "/currentUser/app/query?appId=0", nil, svr, token))
+	assert.Equal(map[string]interface{}{"path": "items[*].n"},
+		resp.Data.([]interface{})[0].(map[string]interface{})["transform"])
+
+	// cached by the params
+	result, cache := run("a")
+	assert.Equal("MISS", cache)
+	assert.Equal(map[string]interface{}{"status": float64(http.StatusOK), "data": []interface{}{float64(1)}}, result.Data)
+	result, cache = run("a")
+	assert.Equal("HIT", cache)
+	assert.Equal([]interface{}{float64(1)}, result.Data.(map[string]interface{})["data"])
+	result, cache = run("b")
+	assert.Equal("MISS", cache)
+	assert.Equal([]interface{}{float64(2)}, result.Data.(map[string]interface{})["data"])
+
+	// expired
+	now = now.Add(time.Minute)
+	result, _ = run("a")
+	assert.Equal([]interface{}{float64(3)}, result.Data.(map[string]interface{})["data"])
+
+	// invalidated manually
+	resp = assertErrCode(t, success.Code, doRequest("DELETE", "/currentUser/app/query/cache",
+		map[string]interface{}{"appId": 0, "name": "items"}, svr, token))
+	assert.Equal(float64(2), resp.Data.(map[string]interface{})["invalidated"])
+	_, cache = run("a")
+	assert.Equal("MISS", cache)
+	assertErrCode(t, errCodeMap[errQueryNotFound], doRequest("DELETE", "/currentUser/app/query/cache",
+		map[string]interface{}{"appId": 0, "name": "not_exist"}, svr, token))
+
+	// invalidated when the data source changes
+	assertErrCode(t, success.Code, doRequest("PUT", "/currentUser/dataSource",
+		map[string]interface{}{"id": 0, "params": map[string]string{"lang": "en"}}, svr, token))
+	_, cache = run("a")
+	assert.Equal("MISS", cache)
+	assert.Equal(int32(5), atomic.LoadInt32(&count))
+}
+
+func TestHandleSQLQueryCacheRule(t *testing.T) {
+	svr, token := newTestServer()
+	createEntry(createRequest{
+		Dir:   "/",
+		Entry: EntryT{Name: "entry1", Type: App},
+	}, svr, token)
+	assertErrCode(t, success.Code, doRequest("POST", "/currentUser/dataSource", map[string]interface{}{
+		"name": "db", "type": db.DataSourceTypeSQL, "driver": "sqlite3", "dsn": ":memory:",
+	}, svr, token))
+	saveQuery := func(query map[string]interface{}) *http.Response {
+		query["appId"] = 0
+		query["dataSourceId"] = 0
+		query["name"] = "q"
+		return doRequest("PUT", "/currentUser/app/query", query, svr, token)
+	}
+	assertErrCode(t, errCodeMap[errInvalidParam], saveQuery(map[string]interface{}{
+		"sql": "DELETE FROM t", "cacheTtl": 60}))
+	assertErrCode(t, errCodeMap[errInvalidParam], saveQuery(map[string]interface{}{
+		"sql": "SELECT 1", "transform": map[string]interface{}{"path": "x"}}))
+	assertErrCode(t, success.Code, saveQuery(map[string]interface{}{
+		"sql": "SELECT 1", "cacheTtl": 60}))
+}
@@ -19,7 +19,7 @@ func (s *server) routes() {
 		AllowedOrigins: []string{"*"},
 		// AllowOriginFunc:  func(r *http.Request, origin string) bool { return true },
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", kWorkspaceHeader},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
@@ -36,6 +36,28 @@ func (s *server) routes() {
 		r.Get("/callback/github/signup", s.handleGithubLogin())
 	})
 
+	// Routes for both anonymous and logged-in users, handlers check the token by themselves
+	s.router.Group(func(r chi.Router) {
+		r.Use(jwtauth.Verifier(s.tokenAuth))
+
+		r.Post("/app/form/submit", s.handleFormSubmit())
+	})
+
+	s.router.Group(func(r chi.Router) {
+		r.Get("/asset/{hash}", s.handleAssetServe())
+	})
+
+	// Protected Routes, token could also be passed by query param `jwt`,
+	// as browsers could not set headers for WebSocket and EventSource
+	s.router.Group(func(r chi.Router) {
+		r.Use(jwtauth.Verify(s.tokenAuth,
+			jwtauth.TokenFromQuery, jwtauth.TokenFromHeader, jwtauth.TokenFromCookie))
+		r.Use(jwtauth.Authenticator)
+
+		r.Get("/currentUser/app/ws", s.handleAppCollab())
+		r.Get("/currentUser/event", s.handleEventStream())
+	})
+
 	// Protected Routes
 	s.router.Group(func(r chi.Router) {
 		// Seek, verify and validate JWT tokens
@@ -56,6 +78,98 @@ func (s *server) routes() {
 		r.Route("/currentUser/app", func(r chi.Router) {
 			r.Get("/", s.handleAppGet())
 			r.Put("/", s.handleAppSave())
+			r.Get("/meta", s.handleAppMetaGet())
+
+			r.Get("/page", s.handlePageList())
+			r.Post("/page", s.handlePageCreate())
+			r.Delete("/page", s.handlePageDelete())
+			r.Put("/page/name", s.handlePageRename())
+			r.Put("/page/order", s.handlePageReorder())
+			r.Get("/page/content", s.handlePageGet())
+			r.Put("/page/content", s.handlePageSave())
+
+			r.Get("/presence", s.handlePresenceGet())
+			r.Put("/presence", s.handlePresenceHeartbeat())
+			r.Put("/lock", s.handleEditLockAcquire())
+			r.Delete("/lock", s.handleEditLockRelease())
+
+			r.Get("/channel", s.handleChannelList())
+			r.Put("/promote", s.handleChannelPromote())
+
+			r.Get("/schedule", s.handleScheduleList())
+			r.Post("/schedule", s.handleScheduleCreate())
+			r.Delete("/schedule", s.handleScheduleCancel())
+
+			r.Get("/query", s.handleQueryList())
+			r.Put("/query", s.handleQuerySave())
+			r.Delete("/query", s.handleQueryDelete())
+			r.Post("/query/run", s.handleQueryRun())
+			r.Delete("/query/cache", s.handleQueryCacheInvalidate())
+
+			r.Get("/form/submission", s.handleFormSubmissionList())
+			r.Get("/form/submission/export", s.handleFormSubmissionExport())
+
+			r.Get("/kv", s.handleKVGet())
+			r.Put("/kv", s.handleKVSet())
+			r.Delete("/kv", s.handleKVDelete())
+			r.Post("/kv/incr", s.handleKVIncrement())
+
+			r.Get("/collaborator", s.handleAppCollaboratorList())
+			r.Put("/collaborator", s.handleAppCollaboratorGrant())
+			r.Delete("/collaborator", s.handleAppCollaboratorRevoke())
+		})
+		r.Get("/currentUser/sharedApp", s.handleSharedAppList())
+
+		r.Route("/currentUser/asset", func(r chi.Router) {
+			r.Get("/", s.handleAssetList())
+			r.Post("/", s.handleAssetUpload())
+			r.Delete("/", s.handleAssetDelete())
+		})
+
+		r.Route("/currentUser/template", func(r chi.Router) {
+			r.Get("/", s.handleTemplateList())
+			r.Post("/", s.handleTemplateSave())
+			r.Delete("/", s.handleTemplateDelete())
+		})
+
+		r.Route("/currentUser/component", func(r chi.Router) {
+			r.Get("/", s.handleComponentList())
+			r.Post("/", s.handleComponentCreate())
+			r.Put("/", s.handleComponentUpdate())
+			r.Delete("/", s.handleComponentDelete())
+
+			r.Get("/version", s.handleComponentVersionList())
+			r.Get("/version/content", s.handleComponentVersionGet())
+		})
+
+		r.Route("/currentUser/dataSource", func(r chi.Router) {
+			r.Get("/", s.handleDataSourceList())
+			r.Post("/", s.handleDataSourceCreate())
+			r.Put("/", s.handleDataSourceUpdate())
+			r.Delete("/", s.handleDataSourceDelete())
+		})
+
+		r.Route("/currentUser/table", func(r chi.Router) {
+			r.Get("/", s.handleTableList())
+			r.Post("/", s.handleTableCreate())
+			r.Put("/", s.handleTableUpdate())
+			r.Delete("/", s.handleTableDelete())
+
+			r.Post("/row/query", s.handleRowQuery())
+			r.Post("/row", s.handleRowInsert())
+			r.Put("/row", s.handleRowUpdate())
+			r.Delete("/row", s.handleRowDelete())
+			r.Post("/row/import", s.handleRowImport())
+			r.Get("/row/export", s.handleRowExport())
+		})
+
+		r.Route("/org", func(r chi.Router) {
+			r.Get("/", s.handleOrgList())
+			r.Post("/", s.handleOrgCreate())
+
+			r.Get("/member", s.handleOrgMemberList())
+			r.Put("/member", s.handleOrgMemberGrant())
+			r.Delete("/member", s.handleOrgMemberRemove())
 		})
 	})
 
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/rtxu/luban-api/db"
+)
+
+// a running schedule is considered crashed and will be retried after the lease
+const kScheduleLease = 5 * time.Minute
+
+// StartPublishScheduler executes due publish schedules every interval until ctx is done.
+// Schedules are persisted, those which became due while the server was down
+// are executed right after restart.
+func (s *server) StartPublishScheduler(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.runDuePublishes(time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (s *server) runDuePublishes(now time.Time) {
+	logger := logrus.WithField("component", "publish_scheduler")
+	// the scheduler should survive any failure of a single round
+	defer func() {
+		if v := recover(); v != nil {
+			logger.Errorf("panic: %+v", v)
+		}
+	}()
+
+	schedules, err := s.publishScheduleService.ListDue(now, kScheduleLease)
+	if err != nil {
+		logger.Errorln(err)
+		return
+	}
+	for _, schedule := range schedules {
+		won, err := s.publishScheduleService.Claim(schedule.ID, now, kScheduleLease)
+		if err != nil {
+			logger.Errorln(err)
+			continue
+		}
+		if !won {
+			// claimed or canceled by others
+			continue
+		}
+
+		status, errMsg := db.ScheduleStatusDone, ""
+		if err := s.runPublishSchedule(schedule); err != nil {
+			status, errMsg = db.ScheduleStatusFailed, err.Error()
+			logger.Warningf("schedule(%d) failed, err: %v", schedule.ID, err)
+		}
+		if err := s.publishScheduleService.Finish(schedule.ID, status, errMsg); err != nil {
+			logger.Errorln(err)
+		}
+	}
+}
+
+// runPublishSchedule executes schedule and reports its panic as an error, so that the schedule
+// is finished as failed rather than left running and retried after every lease
+func (s *server) runPublishSchedule(schedule db.PublishSchedule) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("panic: %v", v)
+		}
+	}()
+	return s.executePublishSchedule(schedule)
+}
+
+func (s *server) executePublishSchedule(schedule db.PublishSchedule) error {
+	app, err := s.appService.Get(schedule.AppID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return fmt.Errorf("app(%d) not found", schedule.AppID)
+		}
+		return err
+	}
+	// the creator may have lost the access since scheduling
+	if !s.appRole(schedule.CreatedBy, app).Covers(db.RoleEditor) {
+		return fmt.Errorf("user(%d) is no longer an editor of app(%d)", schedule.CreatedBy, app.ID)
+	}
+	channel, err := checkChannel(schedule.Channel)
+	if err != nil {
+		return err
+	}
+	content, draft := schedule.Content, schedule.Draft
+	if content == nil {
+		content, draft, err = s.resolveDraft(app)
+		if err != nil {
+			return err
+		}
+	}
+	// the same as handleAppSave, submissions are checked against the published forms
+	if _, err := contentForms(content); err != nil {
+		return err
+	}
+	// and content mismatching the schema is never published
+	schemaVersion, err := contentSchemaVersion(content)
+	if err != nil {
+		return err
+	}
+	errs, err := validateContent(schemaVersion, content, "")
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errors.New(invalidContentMsg(errs))
+	}
+	if err := s.publishToChannel(app.ID, channel, schedule.CreatedBy, content, draft); err != nil {
+		return err
+	}
+	s.publishAppEvent(app, kEventAppPublished, channel, schedule.CreatedBy)
+	return nil
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rtxu/luban-api/config"
+	"github.com/rtxu/luban-api/db"
+	"github.com/rtxu/luban-api/secret"
+)
+
+const kRedacted = "******"
+
+func newKeyring(confs []config.SecretKeyConf) (*secret.Keyring, error) {
+	keys := make([]secret.Key, 0, len(confs))
+	for _, conf := range confs {
+		key, err := secret.ParseKey(conf.ID, conf.Key)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return secret.NewKeyring(keys)
+}
+
+// dataSourceSecrets 是数据源中需要加密保存的字段，只在服务端解密使用
+type dataSourceSecrets struct {
+	// only for REST data sources
+	Headers map[string]string `json:"headers,omitempty"`
+	// only for SQL data sources
+	DSN string `json:"dsn,omitempty"`
+}
+
+// sealSecrets encrypts secrets by the primary master key
+func (s *server) sealSecrets(secrets dataSourceSecrets) string {
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		panic(err)
+	}
+	ciphertext, err := s.keyring.Encrypt(plaintext)
+	if err != nil {
+		panic(err)
+	}
+	return ciphertext
+}
+
+// openSecrets decrypts the secrets of ds, the error is reported for ds alone
+// as the others may be readable, see RotateSecrets
+func (s *server) openSecrets(ds db.DataSource) (dataSourceSecrets, error) {
+	var secrets dataSourceSecrets
+	if ds.Secrets == "" {
+		return secrets, nil
+	}
+	plaintext, err := s.keyring.Decrypt(ds.Secrets)
+	if err != nil {
+		return secrets, fmt.Errorf("%w: data source(%d), err: %v", errSecretsUnreadable, ds.ID, err)
+	}
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		panic(err)
+	}
+	return secrets, nil
+}
+
+// RotateSecrets re-encrypts the secrets encrypted by the old master keys with the primary one,
+// it should be called at startup after a new key is put in front of conf.SecretKeys.
+// It fails when any secrets could not be decrypted, e.g. their key is dropped too early.
+// It returns the number of re-encrypted data sources.
+func (s *server) RotateSecrets() (int, error) {
+	dss, err := s.dataSourceService.ListAll()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, ds := range dss {
+		if !s.keyring.NeedsRotation(ds.Secrets) {
+			if _, err := s.openSecrets(ds); err != nil {
+				return n, err
+			}
+			continue
+		}
+		ciphertext, err := s.keyring.Rotate(ds.Secrets)
+		if err != nil {
+			return n, fmt.Errorf("%w: data source(%d), err: %v", errSecretsUnreadable, ds.ID, err)
+		}
+		if err := s.dataSourceService.Update(ds.ID, map[string]interface{}{"secrets": ciphertext}); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+var (
+	// user:password@tcp(host)/dbname of mysql
+	mysqlPasswordRegexp = regexp.MustCompile(`^[^:@/]*:([^@]+)@`)
+	// password=secret or password='secret' of postgres
+	pgPasswordRegexp = regexp.MustCompile(`password='?([^'\s]+)`)
+)
+
+// values returns the secret values to redact, including the parts of them which
+// could show up in error messages alone, e.g. the token of "Bearer <token>"
+func (secrets dataSourceSecrets) values() []string {
+	var values []string
+	for _, v := range secrets.Headers {
+		values = append(values, v)
+		// skips the scheme, e.g. Bearer
+		if fields := strings.Fields(v); len(fields) > 1 {
+			values = append(values, fields[1:]...)
+		}
+	}
+	if dsn := secrets.DSN; dsn != "" {
+		values = append(values, dsn)
+		if u, err := url.Parse(dsn); err == nil && u.User != nil {
+			if password, ok := u.User.Password(); ok {
+				values = append(values, password)
+			}
+		}
+		if m := mysqlPasswordRegexp.FindStringSubmatch(dsn); m != nil {
+			values = append(values, m[1])
+		}
+		if m := pgPasswordRegexp.FindStringSubmatch(dsn); m != nil {
+			values = append(values, m[1])
+		}
+	}
+	return values
+}
+
+// redact replaces the secret values in msg
+func (secrets dataSourceSecrets) redact(msg string) string {
+	values := secrets.values()
+	// longer ones first, so a value containing another is replaced as a whole
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	for _, v := range values {
+		// too short to tell from the other words of msg
+		if len(v) < 4 {
+			continue
+		}
+		msg = strings.Replace(msg, v, kRedacted, -1)
+	}
+	return msg
+}
+
+// redactedError keeps the wrapped sentinel error, so that respond finds its code
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+// redactError redacts the secrets in err which is wrapped by fmt.Errorf("%w: ...")
+func (secrets dataSourceSecrets) redactError(err error) error {
+	msg := secrets.redact(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	if sentinel := errors.Unwrap(err); sentinel != nil {
+		return &redactedError{msg: msg, err: sentinel}
+	}
+	return errors.New(msg)
+}
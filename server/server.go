@@ -10,24 +10,81 @@ import (
 	"github.com/go-chi/jwtauth"
 	"github.com/rtxu/luban-api/config"
 	"github.com/rtxu/luban-api/db"
+	"github.com/rtxu/luban-api/request"
+	"github.com/rtxu/luban-api/secret"
+	"github.com/rtxu/luban-api/storage"
 	"upper.io/db.v3/lib/sqlbuilder"
 )
 
 // refactor based on [GopherCon 2019: Mat Ryer - How I Write HTTP Web Services after Eight Years](https://www.youtube.com/watch?v=rWBSMsLG8po)
 type server struct {
-	conf      config.AppConfig
-	router    chi.Router
-	tokenAuth *jwtauth.JWTAuth
+	conf       config.AppConfig
+	router     chi.Router
+	tokenAuth  *jwtauth.JWTAuth
+	collabHub  *collabHub
+	presence   *presenceTracker
+	eventBus   *eventBus
+	sqlPool    *sqlPool
+	queryCache *queryCache
+	// encrypts the secrets saved in db
+	keyring *secret.Keyring
+	// saves the content of assets
+	assetStorage   storage.Storage
+	assetHashLocks *hashLocks
+	// calls external services, replaced under unit-test enviroment
+	httpClient *http.Client
+	// sends the queries of REST data sources, which could not reach the private networks
+	queryClient *http.Client
 
-	appService  db.AppService
-	userService db.UserService
+	appService        db.AppService
+	appChannelService db.AppChannelService
+	appACLService     db.AppACLService
+	userService       db.UserService
+	orgService        db.OrgService
+	orgMemberService  db.OrgMemberService
+
+	appTemplateService     db.AppTemplateService
+	publishScheduleService db.PublishScheduleService
+	dataSourceService      db.DataSourceService
+	appQueryService        db.AppQueryService
+	lubanTableService      db.LubanTableService
+	lubanRowService        db.LubanRowService
+	formSubmissionService  db.FormSubmissionService
+	assetService           db.AssetService
+	appKVService           db.AppKVService
+	appPageService         db.AppPageService
+	componentService       db.ComponentService
 }
 
 func New(conf config.AppConfig) *server {
+	// the secrets of data sources could be neither saved nor read without keys
+	if len(conf.SecretKeys) == 0 {
+		panic("SecretKeys is required")
+	}
+	keyring, err := newKeyring(conf.SecretKeys)
+	if err != nil {
+		panic(fmt.Sprintf("invalid SecretKeys: %v", err))
+	}
+	assetStorage, err := newAssetStorage(conf.Asset)
+	if err != nil {
+		panic(fmt.Sprintf("invalid Asset: %v", err))
+	}
 	svr := &server{
-		conf:      conf,
-		router:    chi.NewRouter(),
-		tokenAuth: jwtauth.New("HS256", []byte(conf.JWTSecret), nil),
+		conf:       conf,
+		router:     chi.NewRouter(),
+		tokenAuth:  jwtauth.New("HS256", []byte(conf.JWTSecret), nil),
+		collabHub:  newCollabHub(),
+		presence:   newPresenceTracker(),
+		eventBus:   newEventBus(),
+		sqlPool:    newSQLPool(),
+		queryCache: newQueryCache(),
+		keyring:    keyring,
+
+		assetStorage:   assetStorage,
+		assetHashLocks: newHashLocks(),
+
+		httpClient:  request.DefaultClient,
+		queryClient: newQueryClient(),
 	}
 	svr.routes()
 	return svr
@@ -35,7 +92,22 @@ func New(conf config.AppConfig) *server {
 
 func (s *server) SetupDBService(dbConn sqlbuilder.Database) {
 	s.appService = db.NewAppService(dbConn)
+	s.appChannelService = db.NewAppChannelService(dbConn)
+	s.appACLService = db.NewAppACLService(dbConn)
 	s.userService = db.NewUserService(dbConn)
+	s.orgService = db.NewOrgService(dbConn)
+	s.orgMemberService = db.NewOrgMemberService(dbConn)
+	s.appTemplateService = db.NewAppTemplateService(dbConn)
+	s.publishScheduleService = db.NewPublishScheduleService(dbConn)
+	s.dataSourceService = db.NewDataSourceService(dbConn)
+	s.appQueryService = db.NewAppQueryService(dbConn)
+	s.lubanTableService = db.NewLubanTableService(dbConn)
+	s.lubanRowService = db.NewLubanRowService(dbConn)
+	s.formSubmissionService = db.NewFormSubmissionService(dbConn)
+	s.assetService = db.NewAssetService(dbConn)
+	s.appKVService = db.NewAppKVService(dbConn)
+	s.appPageService = db.NewAppPageService(dbConn)
+	s.componentService = db.NewComponentService(dbConn)
 }
 
 func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -86,6 +158,8 @@ type defaultResponse struct {
 	Code int         `json:"code"`
 	Msg  string      `json:"msg"`
 	Data interface{} `json:"data"`
+	// Data is kept as it is, e.g. the content of app, while Meta describes it
+	Meta interface{} `json:"meta,omitempty"`
 }
 
 var success = defaultResponse{}
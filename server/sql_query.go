@@ -0,0 +1,431 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	// drivers of SQL data sources
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+
+	"github.com/rtxu/luban-api/db"
+)
+
+const (
+	kSQLDefaultMaxRows = 1000
+	kSQLMaxRowsLimit   = 10000
+	kSQLDefaultTimeout = 10 * time.Second
+	kSQLTimeoutLimit   = 60 * time.Second
+)
+
+// SQLColumnT 是查询结果中一列的元信息
+type SQLColumnT struct {
+	Name string `json:"name"`
+	// type name of the database, e.g. VARCHAR, INT
+	Type string `json:"type"`
+}
+
+// SQLResultT 是 SQL 查询的结果，读语句返回 columns 和 rows，写语句返回 rowsAffected
+type SQLResultT struct {
+	Columns []SQLColumnT    `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+	// more rows than the limit of the data source are dropped
+	Truncated    bool  `json:"truncated"`
+	RowsAffected int64 `json:"rowsAffected"`
+}
+
+// sqlDrivers are the drivers allowed for SQL data sources, each sanitizes the dsn given by users
+// before it is saved or opened
+var sqlDrivers = map[string]func(dsn string) (string, error){
+	"mysql":    sanitizeMySQLDSN,
+	"postgres": sanitizePostgresDSN,
+}
+
+func checkSQLDriver(driver string) error {
+	if _, ok := sqlDrivers[driver]; !ok {
+		return fmt.Errorf("%w: unsupported driver(%s)", errInvalidParam, driver)
+	}
+	return nil
+}
+
+// sanitizeDSN checks dsn of driver and drops the options to access the files of the server
+func sanitizeDSN(driver, dsn string) (string, error) {
+	if err := checkSQLDriver(driver); err != nil {
+		return "", err
+	}
+	if dsn == "" {
+		return "", fmt.Errorf("%w: empty dsn", errInvalidParam)
+	}
+	return sqlDrivers[driver](dsn)
+}
+
+// sanitizeMySQLDSN disallows LOAD DATA LOCAL INFILE of any file
+func sanitizeMySQLDSN(dsn string) (string, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid dsn: %v", errInvalidParam, err)
+	}
+	cfg.AllowAllFiles = false
+	return cfg.FormatDSN(), nil
+}
+
+// pgFileOptions are the options of postgres dsn referring to the files of the server
+var pgFileOptions = map[string]bool{
+	"sslcert":     true,
+	"sslkey":      true,
+	"sslrootcert": true,
+	"sslinline":   true,
+	"passfile":    true,
+}
+
+// sanitizePostgresDSN drops pgFileOptions, dsn is either an url or key=value pairs
+func sanitizePostgresDSN(dsn string) (string, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		var err error
+		if dsn, err = pq.ParseURL(dsn); err != nil {
+			return "", fmt.Errorf("%w: invalid dsn: %v", errInvalidParam, err)
+		}
+	}
+	opts, err := parsePostgresOptions(dsn)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid dsn: %v", errInvalidParam, err)
+	}
+	keys := make([]string, 0, len(opts))
+	for k := range opts {
+		if !pgFileOptions[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	escaper := strings.NewReplacer(`'`, `\'`, `\`, `\\`)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"='"+escaper.Replace(opts[k])+"'")
+	}
+	return strings.Join(pairs, " "), nil
+}
+
+// parsePostgresOptions parses the key=value pairs as lib/pq does
+func parsePostgresOptions(dsn string) (map[string]string, error) {
+	opts := make(map[string]string)
+	s := []rune(dsn)
+	i := 0
+	skipSpaces := func() {
+		for i < len(s) && unicode.IsSpace(s[i]) {
+			i++
+		}
+	}
+	for {
+		skipSpaces()
+		if i >= len(s) {
+			return opts, nil
+		}
+		start := i
+		for i < len(s) && !unicode.IsSpace(s[i]) && s[i] != '=' {
+			i++
+		}
+		key := string(s[start:i])
+		skipSpaces()
+		if i >= len(s) || s[i] != '=' {
+			return nil, fmt.Errorf("missing \"=\" after %q", key)
+		}
+		i++
+		skipSpaces()
+		var value []rune
+		if i < len(s) && s[i] == '\'' {
+			for i++; ; i++ {
+				if i >= len(s) {
+					return nil, fmt.Errorf("unterminated quoted string")
+				}
+				if s[i] == '\'' {
+					i++
+					break
+				}
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value = append(value, s[i])
+			}
+		} else {
+			for ; i < len(s) && !unicode.IsSpace(s[i]); i++ {
+				if s[i] == '\\' {
+					if i++; i >= len(s) {
+						return nil, fmt.Errorf("missing character after backslash")
+					}
+				}
+				value = append(value, s[i])
+			}
+		}
+		opts[key] = string(value)
+	}
+}
+
+// sqlPool keeps a connection pool for every SQL data source in use
+type sqlPool struct {
+	mu  sync.Mutex
+	dbs map[uint32]*sqlPoolEntry
+}
+
+type sqlPoolEntry struct {
+	driver string
+	dsn    string
+	db     *sql.DB
+}
+
+func newSQLPool() *sqlPool {
+	return &sqlPool{
+		dbs: make(map[uint32]*sqlPoolEntry),
+	}
+}
+
+// get returns the pool of ds, dsn is decrypted from the secrets of ds
+func (p *sqlPool) get(ds db.DataSource, dsn string) (*sql.DB, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if entry, ok := p.dbs[ds.ID]; ok {
+		if entry.driver == ds.Driver && entry.dsn == dsn {
+			return entry.db, nil
+		}
+		entry.db.Close()
+		delete(p.dbs, ds.ID)
+	}
+	conn, err := openSQL(ds.Driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	p.dbs[ds.ID] = &sqlPoolEntry{driver: ds.Driver, dsn: dsn, db: conn}
+	return conn, nil
+}
+
+// kMySQLNet is the network of mysql connecting by dataSourceDialer
+const kMySQLNet = "datasource"
+
+func init() {
+	mysql.RegisterDialContext(kMySQLNet, func(ctx context.Context, addr string) (net.Conn, error) {
+		return dataSourceDialer.DialContext(ctx, "tcp", addr)
+	})
+}
+
+// openSQL connects to the database by dataSourceDialer, dsn saved before the drivers
+// were restricted is sanitized again
+func openSQL(driver, dsn string) (*sql.DB, error) {
+	dsn, err := sanitizeDSN(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	switch driver {
+	case "mysql":
+		cfg, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Net != "tcp" {
+			return nil, fmt.Errorf("%w: network %s", errBlockedAddress, cfg.Net)
+		}
+		cfg.Net = kMySQLNet
+		connector, err := mysql.NewConnector(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return sql.OpenDB(connector), nil
+	case "postgres":
+		connector, err := pq.NewConnector(dsn)
+		if err != nil {
+			return nil, err
+		}
+		connector.Dialer(pqDialer{})
+		return sql.OpenDB(connector), nil
+	}
+	// drivers only allowed in tests
+	return sql.Open(driver, dsn)
+}
+
+// close closes the pool of a data source which is updated or deleted
+func (p *sqlPool) close(id uint32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if entry, ok := p.dbs[id]; ok {
+		entry.db.Close()
+		delete(p.dbs, id)
+	}
+}
+
+func isSQLNameChar(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// bindSQLParams replaces the named params (:name) in query with the placeholders of driver,
+// and returns the args in order. Quoted strings and identifiers, and postgres casts (::type)
+// are left untouched. A param missing from params is an error when params is not nil.
+func bindSQLParams(query, driver string, params map[string]string) (string, []interface{}, error) {
+	var b strings.Builder
+	var args []interface{}
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			j := i + 1
+			for j < len(query) && query[j] != c {
+				if query[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(query) {
+				return "", nil, fmt.Errorf("%w: unterminated quote in sql", errInvalidParam)
+			}
+			b.WriteString(query[i : j+1])
+			i = j
+		case c == ';':
+			if strings.TrimSpace(query[i+1:]) != "" {
+				return "", nil, fmt.Errorf("%w: multiple statements are not allowed", errInvalidParam)
+			}
+			i = len(query)
+		case c == ':' && i+1 < len(query) && query[i+1] == ':':
+			b.WriteString("::")
+			i++
+		case c == ':' && i+1 < len(query) && isSQLNameChar(query[i+1]):
+			j := i + 1
+			for j < len(query) && isSQLNameChar(query[j]) {
+				j++
+			}
+			name := query[i+1 : j]
+			value, ok := params[name]
+			if !ok && params != nil {
+				return "", nil, fmt.Errorf("%w: missing sql param(%s)", errInvalidParam, name)
+			}
+			args = append(args, value)
+			if driver == "postgres" {
+				fmt.Fprintf(&b, "$%d", len(args))
+			} else {
+				b.WriteByte('?')
+			}
+			i = j - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), args, nil
+}
+
+// isReadStatement reports whether query returns rows instead of changing data
+func isReadStatement(query string) bool {
+	query = strings.TrimLeft(query, " \t\r\n(")
+	end := strings.IndexAny(query, " \t\r\n(")
+	if end < 0 {
+		end = len(query)
+	}
+	switch strings.ToUpper(query[:end]) {
+	case "SELECT", "WITH", "SHOW", "EXPLAIN", "DESCRIBE", "DESC", "VALUES":
+		return true
+	}
+	return false
+}
+
+func sqlLimits(ds db.DataSource) (int, time.Duration) {
+	maxRows := kSQLDefaultMaxRows
+	if ds.MaxRows > 0 {
+		maxRows = int(ds.MaxRows)
+	}
+	if maxRows > kSQLMaxRowsLimit {
+		maxRows = kSQLMaxRowsLimit
+	}
+	timeout := kSQLDefaultTimeout
+	if ds.QueryTimeout > 0 {
+		timeout = time.Duration(ds.QueryTimeout) * time.Second
+	}
+	if timeout > kSQLTimeoutLimit {
+		timeout = kSQLTimeoutLimit
+	}
+	return maxRows, timeout
+}
+
+// runSQLQuery runs q on ds, errors of the database are reported as errQueryFailed,
+// they may contain the DSN and should be redacted before responding
+func (s *server) runSQLQuery(ctx context.Context, ds db.DataSource, dsn string, q db.AppQuery,
+	params map[string]string) (SQLResultT, error) {
+	var result SQLResultT
+	query, args, err := bindSQLParams(q.SQL, ds.Driver, params)
+	if err != nil {
+		return result, err
+	}
+	isRead := isReadStatement(query)
+	if ds.ReadOnly && !isRead {
+		return result, fmt.Errorf("%w: data source(%s) is read-only", errPermissionDenied, ds.Name)
+	}
+	conn, err := s.sqlPool.get(ds, dsn)
+	if err != nil {
+		return result, fmt.Errorf("%w: %v", errQueryFailed, err)
+	}
+	maxRows, timeout := sqlLimits(ds)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if !isRead {
+		res, err := conn.ExecContext(ctx, query, args...)
+		if err != nil {
+			return result, fmt.Errorf("%w: %v", errQueryFailed, err)
+		}
+		result.RowsAffected, _ = res.RowsAffected()
+		return result, nil
+	}
+
+	var rows *sql.Rows
+	if ds.ReadOnly {
+		tx, err := conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			return result, fmt.Errorf("%w: %v", errQueryFailed, err)
+		}
+		// nothing to commit
+		defer tx.Rollback()
+		rows, err = tx.QueryContext(ctx, query, args...)
+	} else {
+		rows, err = conn.QueryContext(ctx, query, args...)
+	}
+	if err != nil {
+		return result, fmt.Errorf("%w: %v", errQueryFailed, err)
+	}
+	defer rows.Close()
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return result, fmt.Errorf("%w: %v", errQueryFailed, err)
+	}
+	result.Columns = make([]SQLColumnT, 0, len(columnTypes))
+	for _, ct := range columnTypes {
+		result.Columns = append(result.Columns, SQLColumnT{Name: ct.Name(), Type: ct.DatabaseTypeName()})
+	}
+	result.Rows = make([][]interface{}, 0)
+	for rows.Next() {
+		if len(result.Rows) == maxRows {
+			result.Truncated = true
+			break
+		}
+		values := make([]interface{}, len(columnTypes))
+		ptrs := make([]interface{}, len(columnTypes))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return result, fmt.Errorf("%w: %v", errQueryFailed, err)
+		}
+		for i, v := range values {
+			// text columns are scanned as bytes by some drivers
+			if bytes, ok := v.([]byte); ok {
+				values[i] = string(bytes)
+			}
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	if err := rows.Err(); err != nil {
+		return result, fmt.Errorf("%w: %v", errQueryFailed, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,48 @@
+package server
+
+import "encoding/json"
+
+// 随 server 发布的内置模板，id 均不是数字，以区别于用户保存的模板
+var builtinTemplates = []TemplateT{
+	{
+		Id:          "blank",
+		Name:        "空白应用",
+		Description: "从零开始搭建",
+		BuiltIn:     true,
+		content:     json.RawMessage(`{}`),
+	},
+	{
+		Id:          "form",
+		Name:        "表单",
+		Description: "收集用户输入的单页表单",
+		BuiltIn:     true,
+		content: json.RawMessage(`{
+			"widgets": {
+				"form1": {"type": "Form", "children": ["input1", "submit1"]},
+				"input1": {"type": "TextInput", "label": "名称"},
+				"submit1": {"type": "Button", "text": "提交"}
+			}
+		}`),
+	},
+	{
+		Id:          "table",
+		Name:        "数据表格",
+		Description: "带搜索框的数据列表",
+		BuiltIn:     true,
+		content: json.RawMessage(`{
+			"widgets": {
+				"search1": {"type": "TextInput", "label": "搜索"},
+				"table1": {"type": "Table", "columns": []}
+			}
+		}`),
+	},
+}
+
+func findBuiltinTemplate(id string) (TemplateT, bool) {
+	for _, tpl := range builtinTemplates {
+		if tpl.Id == id {
+			return tpl, true
+		}
+	}
+	return TemplateT{}, false
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/rtxu/luban-api/db"
+)
+
+// 前端通过该 header 切换当前的 workspace，值为 org id，为空时表示个人空间
+const kWorkspaceHeader = "X-Luban-Workspace"
+
+// workspace owns a directory tree and the apps in it,
+// it's either the personal space of current user or an org
+type workspace struct {
+	user db.User
+	// nil for the personal space
+	org *db.Org
+	// role of current user in the workspace
+	role db.Role
+}
+
+// orgId returns 0 for the personal space
+func (ws workspace) orgId() uint32 {
+	if ws.org == nil {
+		return 0
+	}
+	return ws.org.ID
+}
+
+// owns reports whether a resource owned by (ownerId, orgId) belongs to the workspace
+func (ws workspace) owns(ownerId, orgId uint32) bool {
+	if orgId != ws.orgId() {
+		return false
+	}
+	return orgId != 0 || ownerId == ws.user.ID
+}
+
+func (ws workspace) checkRole(needRole db.Role) error {
+	if !ws.role.Covers(needRole) {
+		return fmt.Errorf("%w: %s role is required in workspace", errPermissionDenied, needRole)
+	}
+	return nil
+}
+
+func (s *server) orgRole(userId, orgId uint32) db.Role {
+	member, err := s.orgMemberService.Find(orgId, userId)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return db.RoleNone
+		}
+		panic(err)
+	}
+	return member.Role
+}
+
+// findOrgWithRole finds the org and checks that the current user has at least needRole in it.
+// Orgs the current user is not a member of are reported as not found.
+func (s *server) findOrgWithRole(r *http.Request, orgId uint32, needRole db.Role) (db.Org, db.Role, error) {
+	org, err := s.orgService.Find(orgId)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return org, db.RoleNone, fmt.Errorf("%w: orgId is %d", errOrgNotFound, orgId)
+		}
+		panic(err)
+	}
+	role := s.orgRole(currentUserId(r), org.ID)
+	if role == db.RoleNone {
+		return org, role, fmt.Errorf("%w: orgId is %d", errOrgNotFound, orgId)
+	}
+	if !role.Covers(needRole) {
+		return org, role, fmt.Errorf("%w: %s role is required in org(%d)",
+			errPermissionDenied, needRole, orgId)
+	}
+	return org, role, nil
+}
+
+// getWorkspace returns the active workspace of the request
+func (s *server) getWorkspace(r *http.Request) (workspace, error) {
+	user, err := s.userService.FindByID(currentUserId(r))
+	if err != nil {
+		panic(err)
+	}
+	orgIdStr := r.Header.Get(kWorkspaceHeader)
+	if orgIdStr == "" {
+		return workspace{user: user, role: db.RoleAdmin}, nil
+	}
+
+	u64, err := strconv.ParseUint(orgIdStr, 10, 32)
+	if err != nil {
+		return workspace{}, fmt.Errorf("%w: %s(%s) is not a number, err: %v",
+			errBadRequest, kWorkspaceHeader, orgIdStr, err)
+	}
+	org, role, err := s.findOrgWithRole(r, uint32(u64), db.RoleViewer)
+	if err != nil {
+		return workspace{}, err
+	}
+	return workspace{user: user, org: &org, role: role}, nil
+}
+
+func (s *server) getWorkspaceAndRootDir(r *http.Request) (workspace, DirectoryT, error) {
+	ws, err := s.getWorkspace(r)
+	if err != nil {
+		return ws, nil, err
+	}
+	rawRootDir := ws.user.RootDir
+	if ws.org != nil {
+		rawRootDir = ws.org.RootDir
+	}
+	var rootDir DirectoryT
+	if rawRootDir != nil {
+		if err := json.Unmarshal(rawRootDir, &rootDir); err != nil {
+			panic(err)
+		}
+	}
+	return ws, rootDir, nil
+}
+
+func (s *server) syncWorkspaceRootDirToDB(ws workspace, rootDir DirectoryT) {
+	if ws.org == nil {
+		s.syncRootDirToDB(ws.user.UserName, rootDir)
+		return
+	}
+	bytes, _ := json.Marshal(rootDir)
+	if err := s.orgService.UpdateRootDir(ws.org.ID, bytes); err != nil {
+		panic(err)
+	}
+}
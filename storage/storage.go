@@ -0,0 +1,155 @@
+// 保存上传的文件等二进制对象，接口与 S3 兼容：对象以 key 寻址、整体写入、不可部分修改，
+// 以便后续接入 S3 及兼容的对象存储，目前实现了本地文件系统
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrNotFound   = errors.New("object not found")
+	ErrInvalidKey = errors.New("invalid key")
+)
+
+// Storage is a flat namespace of objects like an S3 bucket, keys are slash-separated paths
+type Storage interface {
+	// Put saves the object of size bytes, overwriting the existing one
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get returns the content of the object, ErrNotFound when missing
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete deletes the object, missing objects are ignored
+	Delete(ctx context.Context, key string) error
+}
+
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+	}
+	return nil
+}
+
+type localStorage struct {
+	dir string
+}
+
+// NewLocal saves the objects as files under dir, which is created on the first Put
+func NewLocal(dir string) Storage {
+	return &localStorage{dir: dir}
+}
+
+func (s *localStorage) path(key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+func (s *localStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// readers never see a partially written file
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	n, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("object(%s) should be %d bytes, got %d", key, size, n)
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (s *localStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return f, err
+}
+
+func (s *localStorage) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+type memStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+// Used under unit-test enviroment
+func NewMem() Storage {
+	return &memStorage{
+		objects: make(map[string][]byte),
+	}
+}
+
+func (s *memStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if int64(len(content)) != size {
+		return fmt.Errorf("object(%s) should be %d bytes, got %d", key, size, len(content))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = content
+	return nil
+}
+
+func (s *memStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	content, ok := s.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return ioutil.NopCloser(bytes.NewReader(content)), nil
+}
+
+func (s *memStorage) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, s := range map[string]Storage{"local": NewLocal(dir), "mem": NewMem()} {
+		assert := assert.New(t)
+		ctx := context.Background()
+
+		_, err := s.Get(ctx, "ab/abc")
+		assert.True(errors.Is(err, ErrNotFound), name)
+		assert.NoError(s.Put(ctx, "ab/abc", strings.NewReader("hello"), 5, "text/plain"), name)
+		// overwritten
+		assert.NoError(s.Put(ctx, "ab/abc", strings.NewReader("world"), 5, "text/plain"), name)
+		rc, err := s.Get(ctx, "ab/abc")
+		assert.NoError(err, name)
+		content, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		assert.Equal("world", string(content), name)
+
+		// a truncated upload is not saved
+		assert.Error(s.Put(ctx, "ab/def", strings.NewReader("hel"), 5, "text/plain"), name)
+		_, err = s.Get(ctx, "ab/def")
+		assert.True(errors.Is(err, ErrNotFound), name)
+
+		assert.NoError(s.Delete(ctx, "ab/abc"), name)
+		assert.NoError(s.Delete(ctx, "ab/abc"), name)
+		_, err = s.Get(ctx, "ab/abc")
+		assert.True(errors.Is(err, ErrNotFound), name)
+
+		for _, key := range []string{"", "/etc/passwd", "../x", "a/../../x", "a//b", `a\b`} {
+			err := s.Put(ctx, key, strings.NewReader(""), 0, "")
+			assert.True(errors.Is(err, ErrInvalidKey), name+key)
+		}
+	}
+}